@@ -0,0 +1,190 @@
+package audit
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/constants"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+// Actions recorded in the audit log.
+const (
+	ActionAccountCreated             = "account.created"
+	ActionAccountVerified            = "account.verified"
+	ActionAccountUpdated             = "account.updated"
+	ActionAccountDeleted             = "account.deleted"
+	ActionPasswordChanged            = "account.password_changed"
+	ActionPasswordReset              = "account.password_reset"
+	ActionEmailChangeRequested       = "account.email_change_requested"
+	ActionPhoneVerificationRequested = "account.phone_verification_requested"
+	ActionPhoneVerified              = "account.phone_verified"
+	ActionPhoneRemoved               = "account.phone_removed"
+	ActionAccountLocked              = "account.locked"
+	ActionTwoFactorEnabled           = "account.two_factor_enabled"
+	ActionTwoFactorDisabled          = "account.two_factor_disabled"
+	ActionAccountSuspended           = "account.suspended"
+	ActionAccountReactivated         = "account.reactivated"
+	ActionAccountImpersonated        = "account.impersonated"
+	ActionAccountAccessChanged       = "account.access_changed"
+	ActionIdentityLinked             = "account.identity_linked"
+	ActionPreferencesUpdated         = "account.notification_preferences_updated"
+	ActionNotificationSent           = "account.notification_sent"
+	ActionSensorCreated              = "sensor.created"
+	ActionSensorUpdated              = "sensor.updated"
+	ActionSensorDeleted              = "sensor.deleted"
+	ActionDeviceRegistered           = "device.registered"
+	ActionDeviceUnregistered         = "device.unregistered"
+	ActionApiKeyCreated              = "api_key.created"
+	ActionApiKeyRevoked              = "api_key.revoked"
+	ActionOrganisationCreated        = "organisation.created"
+	ActionOrganisationUpdated        = "organisation.updated"
+	ActionWebhookCreated             = "webhook.created"
+	ActionWebhookDeleted             = "webhook.deleted"
+	ActionWebhookRedelivered         = "webhook.redelivered"
+	ActionDeadLetterRequeued         = "dead_letter.requeued"
+)
+
+// Target types recorded in the audit log.
+const (
+	TargetAccount      = "account"
+	TargetSensor       = "sensor"
+	TargetDevice       = "device"
+	TargetApiKey       = "api_key"
+	TargetOrganisation = "organisation"
+	TargetWebhook      = "webhook"
+	TargetDeadLetter   = "dead_letter"
+)
+
+type (
+	// Log writes audit entries, it has no way to change or remove an entry once written.
+	Log struct {
+		repo *repository.Repository[models.AuditEntry]
+	}
+
+	// Entry describes a change to record. Before and After are the target's state either side
+	// of the change and are diffed field by field, either may be nil.
+	Entry struct {
+		Action     string
+		TargetType string
+		TargetId   string
+		Before     interface{}
+		After      interface{}
+		Metadata   map[string]string
+
+		// Actor overrides the account taken from the request context, for changes made
+		// before the caller is authenticated or from the command line.
+		Actor *models.AccountInfo
+	}
+
+	// Actor is the authenticated caller making a request.
+	Actor struct {
+		Account        models.AccountInfo
+		ImpersonatedBy string
+	}
+
+	// RequestInfo describes where a request came from.
+	RequestInfo struct {
+		IpAddress string
+		UserAgent string
+	}
+
+	Filters struct {
+		ActorId    string
+		Action     string
+		TargetType string
+		TargetId   string
+		From       *time.Time
+		To         *time.Time
+	}
+)
+
+func NewLog(repo *repository.Repository[models.AuditEntry]) *Log {
+	return &Log{
+		repo: repo,
+	}
+}
+
+// Record writes an entry to the audit log. The change it describes has already happened,
+// so a failed write is logged rather than returned to the caller.
+func (l *Log) Record(ctx context.Context, entry Entry) {
+	if l == nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	record := models.AuditEntry{
+		Shared: models.Shared{
+			ID:        primitive.NewObjectID(),
+			CreatedAt: &now,
+		},
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetId:   entry.TargetId,
+		Changes:    Diff(entry.Before, entry.After),
+		Metadata:   entry.Metadata,
+	}
+
+	if actor, ok := ActorFromContext(ctx); ok {
+		record.Actor = actor.Account
+		record.ImpersonatedBy = actor.ImpersonatedBy
+	}
+	if entry.Actor != nil {
+		record.Actor = *entry.Actor
+	}
+
+	if info, ok := RequestInfoFromContext(ctx); ok {
+		record.IpAddress = info.IpAddress
+		record.UserAgent = info.UserAgent
+	}
+
+	_, err := l.repo.Create(ctx, record)
+	if err != nil {
+		log.Println("failed to write audit entry " + entry.Action + ": " + err.Error())
+	}
+}
+
+func ActorFromContext(ctx context.Context) (Actor, bool) {
+	actor, ok := ctx.Value(string(constants.ContextKeyAuditActor)).(Actor)
+	return actor, ok
+}
+
+func RequestInfoFromContext(ctx context.Context) (RequestInfo, bool) {
+	info, ok := ctx.Value(string(constants.ContextKeyRequestInfo)).(RequestInfo)
+	return info, ok
+}
+
+// QueryFilter builds the repository filter matching f, newest entries first is the default sort.
+func (f Filters) QueryFilter() *repository.QueryFilter {
+	filter := repository.NewQueryFilter()
+
+	if f.ActorId != "" {
+		filter.AddFilter(models.FieldAuditActorId, f.ActorId)
+	}
+	if f.Action != "" {
+		filter.AddFilter(models.FieldAuditAction, f.Action)
+	}
+	if f.TargetType != "" {
+		filter.AddFilter(models.FieldAuditTargetType, f.TargetType)
+	}
+	if f.TargetId != "" {
+		filter.AddFilter(models.FieldAuditTargetId, f.TargetId)
+	}
+
+	createdAt := map[string]interface{}{}
+	if f.From != nil {
+		createdAt["$gte"] = *f.From
+	}
+	if f.To != nil {
+		createdAt["$lt"] = *f.To
+	}
+	if len(createdAt) > 0 {
+		filter.AddFilter("created_at", createdAt)
+	}
+
+	return filter
+}
@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tejiriaustin/narx_api/constants"
+	"github.com/tejiriaustin/narx_api/database"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+func TestRecord(t *testing.T) {
+	admin := models.AccountInfo{Id: "admin", Email: "admin@example.com"}
+	user := models.AccountInfo{Id: "user", Email: "ada@example.com"}
+
+	ctx := context.WithValue(context.Background(), string(constants.ContextKeyAuditActor), Actor{Account: user, ImpersonatedBy: admin.Id})
+	ctx = context.WithValue(ctx, string(constants.ContextKeyRequestInfo), RequestInfo{IpAddress: "203.0.113.7", UserAgent: "curl"})
+
+	tests := []struct {
+		name               string
+		ctx                context.Context
+		actor              *models.AccountInfo
+		wantActor          string
+		wantImpersonatedBy string
+		wantIpAddress      string
+	}{
+		{name: "no request", ctx: context.Background()},
+		{name: "caller from the request", ctx: ctx, wantActor: user.Id, wantImpersonatedBy: admin.Id, wantIpAddress: "203.0.113.7"},
+		{name: "actor given", ctx: context.Background(), actor: &user, wantActor: user.Id},
+		{name: "actor given overrides the request", ctx: ctx, actor: &admin, wantActor: admin.Id, wantImpersonatedBy: admin.Id, wantIpAddress: "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := repository.NewRepository[models.AuditEntry](database.NewMemoryCollection())
+
+			NewLog(repo).Record(tt.ctx, Entry{
+				Action:     ActionAccountUpdated,
+				TargetType: TargetAccount,
+				TargetId:   "target",
+				Before:     models.Account{FirstName: "Ada"},
+				After:      models.Account{FirstName: "Adaeze"},
+				Actor:      tt.actor,
+			})
+
+			entries, err := repo.Find(context.Background(), repository.NewQueryFilter(), nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("%d entries recorded, want 1", len(entries))
+			}
+
+			entry := entries[0]
+			if entry.Action != ActionAccountUpdated || entry.TargetType != TargetAccount || entry.TargetId != "target" {
+				t.Errorf("recorded %s of %s %s, want %s of %s target", entry.Action, entry.TargetType, entry.TargetId, ActionAccountUpdated, TargetAccount)
+			}
+			if len(entry.Changes) != 1 || entry.Changes[0].Field != "first_name" {
+				t.Errorf("recorded changes %+v, want only first_name", entry.Changes)
+			}
+			if entry.Actor.Id != tt.wantActor || entry.ImpersonatedBy != tt.wantImpersonatedBy {
+				t.Errorf("recorded actor %q impersonated by %q, want %q impersonated by %q", entry.Actor.Id, entry.ImpersonatedBy, tt.wantActor, tt.wantImpersonatedBy)
+			}
+			if entry.IpAddress != tt.wantIpAddress {
+				t.Errorf("recorded ip address %q, want %q", entry.IpAddress, tt.wantIpAddress)
+			}
+		})
+	}
+}
+
+func TestRecordWithoutLog(t *testing.T) {
+	// services built without an audit log still record, to nowhere
+	var l *Log
+	l.Record(context.Background(), Entry{Action: ActionAccountUpdated})
+}
@@ -0,0 +1,133 @@
+package audit
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/models"
+)
+
+const redacted = "[redacted]"
+
+// sensitiveFields are recorded as changed without their values.
+var sensitiveFields = map[string]bool{
+	"password":           true,
+	"password_reset":     true,
+	"phone_verification": true,
+	"two_factor":         true,
+	"key_hash":           true,
+	"client_secret":      true,
+	"secret":             true,
+}
+
+// ignoredFields change on every write and would only add noise.
+var ignoredFields = map[string]bool{
+	"created_at":      true,
+	"updated_at":      true,
+	"session_version": true,
+	"last_used_at":    true,
+}
+
+// Diff compares the stored form of before and after and returns the fields that differ.
+func Diff(before, after interface{}) []models.AuditChange {
+	beforeFields := flatten("", toDocument(before))
+	afterFields := flatten("", toDocument(after))
+
+	keys := map[string]bool{}
+	for k := range beforeFields {
+		keys[k] = true
+	}
+	for k := range afterFields {
+		keys[k] = true
+	}
+
+	var changes []models.AuditChange
+	for k := range keys {
+		b, a := beforeFields[k], afterFields[k]
+		if reflect.DeepEqual(b, a) {
+			continue
+		}
+		if isSensitive(k) {
+			b, a = redactValue(b), redactValue(a)
+		}
+		changes = append(changes, models.AuditChange{Field: k, Before: b, After: a})
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+func toDocument(v interface{}) bson.M {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return bson.M{}
+	}
+
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return bson.M{}
+	}
+
+	doc := bson.M{}
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return bson.M{}
+	}
+	return doc
+}
+
+// flatten turns nested documents into dotted paths so that a change to one nested field
+// doesn't record the whole parent document.
+func flatten(prefix string, doc bson.M) map[string]interface{} {
+	fields := map[string]interface{}{}
+
+	for k, v := range doc {
+		// the target id is recorded on the entry itself
+		if ignoredFields[k] || (prefix == "" && k == "_id") {
+			continue
+		}
+
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		switch nested := v.(type) {
+		case primitive.M:
+			for nk, nv := range flatten(key, bson.M(nested)) {
+				fields[nk] = nv
+			}
+		case primitive.D:
+			m := bson.M{}
+			for _, e := range nested {
+				m[e.Key] = e.Value
+			}
+			for nk, nv := range flatten(key, m) {
+				fields[nk] = nv
+			}
+		default:
+			fields[key] = v
+		}
+	}
+	return fields
+}
+
+func isSensitive(field string) bool {
+	for _, part := range strings.Split(field, ".") {
+		if sensitiveFields[part] {
+			return true
+		}
+	}
+	return false
+}
+
+func redactValue(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return redacted
+}
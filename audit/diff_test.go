@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tejiriaustin/narx_api/models"
+)
+
+func TestDiff(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	account := models.Account{FirstName: "Ada", Email: "ada@example.com", Password: "hash", SessionVersion: 1}
+
+	renamed := account
+	renamed.FirstName = "Adaeze"
+
+	suspended := account
+	suspended.Suspension = &models.Suspension{Reason: "chargeback", SuspendedAt: now, SuspendedBy: models.AccountInfo{Id: "admin"}}
+
+	reasoned := suspended
+	reasoned.Suspension = &models.Suspension{Reason: "fraud", SuspendedAt: now, SuspendedBy: models.AccountInfo{Id: "admin"}}
+
+	repassworded := account
+	repassworded.Password = "new hash"
+	repassworded.SessionVersion = 2
+
+	resetting := account
+	resetting.PasswordReset = &models.PasswordReset{CodeHash: "code hash", ExpiresAt: now}
+
+	tests := []struct {
+		name   string
+		before interface{}
+		after  interface{}
+		want   []models.AuditChange
+	}{
+		{name: "unchanged", before: account, after: account},
+		{name: "changed field", before: account, after: renamed, want: []models.AuditChange{
+			{Field: "first_name", Before: "Ada", After: "Adaeze"},
+		}},
+		{name: "only the nested field that changed", before: suspended, after: reasoned, want: []models.AuditChange{
+			{Field: "suspension.reason", Before: "chargeback", After: "fraud"},
+		}},
+		{name: "sensitive values are redacted and the session version ignored", before: account, after: repassworded, want: []models.AuditChange{
+			{Field: "password", Before: redacted, After: redacted},
+		}},
+		{name: "nested sensitive values are redacted", before: account, after: resetting, want: []models.AuditChange{
+			{Field: "password_reset.attempts", After: redacted},
+			{Field: "password_reset.code_hash", After: redacted},
+			{Field: "password_reset.expires_at", After: redacted},
+		}},
+		{name: "removed target", before: renamed, after: nil, want: []models.AuditChange{
+			{Field: "email", Before: "ada@example.com"},
+			{Field: "first_name", Before: "Adaeze"},
+			{Field: "full_name", Before: ""},
+			{Field: "last_name", Before: ""},
+			{Field: "password", Before: redacted},
+			{Field: "status", Before: ""},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Diff(tt.before, tt.after)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
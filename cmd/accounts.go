@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+// accountsCmd represents the accounts command
+var accountsCmd = &cobra.Command{
+	Use:   "accounts",
+	Short: "Manages accounts",
+}
+
+var setAdminCmd = &cobra.Command{
+	Use:   "set-admin",
+	Short: "Grants or revokes admin access for an account",
+	Run:   setAdmin,
+}
+
+func init() {
+	setAdminCmd.Flags().String("email", "", "email of the account")
+	setAdminCmd.Flags().Bool("revoke", false, "revoke admin access instead of granting it")
+	_ = setAdminCmd.MarkFlagRequired("email")
+
+	accountsCmd.AddCommand(setAdminCmd)
+	rootCmd.AddCommand(accountsCmd)
+}
+
+func setAdmin(cmd *cobra.Command, args []string) {
+	ctx := context.Background()
+
+	email, _ := cmd.Flags().GetString("email")
+	revoke, _ := cmd.Flags().GetBool("revoke")
+
+	dbConn, rc := connectRepositories()
+	defer func() {
+		_ = dbConn.Disconnect(context.TODO())
+	}()
+
+	filter := repository.NewQueryFilter().AddFilter(models.FieldAccountEmail, email)
+	account, err := rc.AccountsRepo.FindOne(ctx, filter, nil, nil)
+	if err != nil {
+		fmt.Println("account not found: " + email)
+		return
+	}
+	before := account
+
+	account.Kind = models.AdminAccountKind
+	if revoke {
+		account.Kind = models.EmployeeAccountKind
+	}
+	// existing sessions pick up the new access level on their next login
+	account.SessionVersion++
+
+	_, err = rc.AccountsRepo.Update(ctx, account)
+	if err != nil {
+		fmt.Println("failed to update account: " + err.Error())
+		return
+	}
+
+	recordCommandLineChange(ctx, audit.NewLog(rc.AuditLogRepo), audit.Entry{
+		Action:     audit.ActionAccountAccessChanged,
+		TargetType: audit.TargetAccount,
+		TargetId:   account.GetId(),
+		Before:     before,
+		After:      account,
+	})
+
+	fmt.Println("updated access for " + account.Email)
+}
@@ -7,8 +7,12 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/consumer"
 	"github.com/tejiriaustin/narx_api/database"
 	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/limiter"
+	"github.com/tejiriaustin/narx_api/models"
 	"github.com/tejiriaustin/narx_api/repository"
 	"github.com/tejiriaustin/narx_api/server"
 	"github.com/tejiriaustin/narx_api/services"
@@ -41,25 +45,116 @@ func startApi(cmd *cobra.Command, args []string) {
 
 	rc := repository.NewRepositoryContainer(dbConn)
 
-	sc := services.NewService(&config)
-	sc.PushNotifications = messaging.NewFirebaseMessaging(&config)
-	sc.Publisher = publisher.NewPublisher(dbConn.GetCollection("notifications"))
+	sc := newServiceContainer(&config, rc)
+	sc.Publisher, sc.EventQueue = newEventQueue(config, dbConn)
 
 	server.Start(ctx, sc, rc, &config)
 }
 
-func setApiEnvironment() env.Environment {
-	staticEnvironment := env.NewEnvironment()
+func newServiceContainer(config *env.Environment, rc *repository.Container) *services.Container {
+	sc := services.NewService(config, audit.NewLog(rc.AuditLogRepo))
+	sc.PushNotifications = newPusher(config, rc.DevicesRepo)
 
-	staticEnvironment.
-		SetEnv(env.Port, env.GetEnv(env.Port, "8080")).
+	limiterStore := newLimiterStore(*config)
+	sc.LoginLimiter = limiter.NewLoginLimiter(limiterStore)
+	sc.ResetLimiter = limiter.NewRateLimiter(
+		limiterStore,
+		"password_reset",
+		int64(config.GetAsInt(env.PasswordResetRateLimit)),
+		config.GetAsDuration(env.PasswordResetRateWindow),
+	)
+	return sc
+}
+
+// newEventQueue picks where events are published, and where dead letters are requeued to.
+// EVENT_QUEUE=redis appends them to the EVENT_STREAM stream, trimmed to about EVENT_STREAM_MAX_LEN
+// entries, instead of the notifications collection.
+func newEventQueue(config env.Environment, dbConn database.Database) (publisher.PublishInterface, consumer.Requeuer) {
+	switch config.GetAsString(env.EventQueue) {
+	case "mongo":
+		collection := dbConn.GetCollection("notifications")
+		return publisher.NewPublisher(collection), consumer.NewCollectionRequeuer(collection)
+	case "redis":
+		rdb := newEventStreamClient(config).Cmdable()
+		stream := config.GetAsString(env.EventStream)
+		maxLen := int64(config.GetAsInt(env.EventStreamMaxLen))
+		return publisher.NewRedisPublisher(rdb, stream, maxLen), consumer.NewStreamRequeuer(rdb, stream, maxLen)
+	default:
+		panic("Unknown event queue: " + config.GetAsString(env.EventQueue))
+	}
+}
+
+// newPusher picks the push notification sender. PUSH_PROVIDER=log logs notifications instead of
+// sending them, for local development without firebase credentials.
+func newPusher(config *env.Environment, devicesRepo *repository.Repository[models.Devices]) messaging.Messaging {
+	switch config.GetAsString(env.PushProvider) {
+	case "firebase":
+		return messaging.NewFirebaseMessaging(config, devicesRepo)
+	case "log":
+		return messaging.NewLogSink()
+	default:
+		panic("Unknown push provider: " + config.GetAsString(env.PushProvider))
+	}
+}
+
+func newEventStreamClient(config env.Environment) *database.RedisClient {
+	if config.GetAsString(env.RedisDsn) == "" {
+		panic("EVENT_QUEUE=redis needs REDIS_DSN")
+	}
+
+	redisConn, err := database.NewRedisClient(config.GetAsString(env.RedisDsn), config.GetAsString(env.RedisPassword), "")
+	if err != nil {
+		panic("Couldn't connect to redis dsn: " + err.Error())
+	}
+	return redisConn
+}
+
+// newLimiterStore uses redis when it is configured so limits are shared between replicas,
+// and falls back to process memory otherwise.
+func newLimiterStore(config env.Environment) limiter.Store {
+	memoryStore := limiter.NewMemoryStore()
+
+	if config.GetAsString(env.RedisDsn) == "" {
+		return memoryStore
+	}
+
+	redisConn, err := database.NewRedisClient(config.GetAsString(env.RedisDsn), config.GetAsString(env.RedisPassword), "")
+	if err != nil {
+		panic("Couldn't connect to redis dsn: " + err.Error())
+	}
+
+	return limiter.NewFallbackStore(limiter.NewRedisStore(redisConn), memoryStore)
+}
+
+func setApiEnvironment() env.Environment {
+	return apiEnvironment().
 		SetEnv(env.MongoDsn, env.MustGetEnv(env.MongoDsn)).
 		SetEnv(env.MongoDbName, env.MustGetEnv(env.MongoDbName)).
 		SetEnv(env.JwtSecret, env.MustGetEnv(env.JwtSecret)).
 		SetEnv(env.FrontendUrl, env.MustGetEnv(env.FrontendUrl)).
+		SetEnv(env.EventSecretKey, env.MustGetEnv(env.EventSecretKey)).
 		SetEnv(env.FirebaseAuthKey, env.MustGetEnv(env.FirebaseAuthKey)).
-		SetEnv(env.FirebaseRegistrationToken, env.MustGetEnv(env.FirebaseRegistrationToken)).
 		SetEnv(env.FirebaseServiceAccountKey, env.MustGetEnv(env.FirebaseServiceAccountKey))
+}
+
+// apiEnvironment is the api's configuration that has a default.
+func apiEnvironment() env.Environment {
+	staticEnvironment := env.NewEnvironment()
+
+	staticEnvironment.
+		SetEnv(env.Port, env.GetEnv(env.Port, "8080")).
+		SetEnv(env.RedisDsn, env.GetEnv(env.RedisDsn, "")).
+		SetEnv(env.RedisPassword, env.GetEnv(env.RedisPassword, "")).
+		SetEnv(env.ApiUrl, env.GetEnv(env.ApiUrl, "http://localhost:8080")).
+		SetEnv(env.PushProvider, env.GetEnv(env.PushProvider, "firebase")).
+		SetEnv(env.EventQueue, env.GetEnv(env.EventQueue, "mongo")).
+		SetEnv(env.EventStream, env.GetEnv(env.EventStream, "narx:events")).
+		SetEnv(env.EventStreamMaxLen, env.GetEnv(env.EventStreamMaxLen, "100000")).
+		SetEnv(env.TrustedProxies, env.GetEnv(env.TrustedProxies, "")).
+		SetEnv(env.PasswordResetRateLimit, env.GetEnv(env.PasswordResetRateLimit, "10")).
+		SetEnv(env.PasswordResetRateWindow, env.GetEnv(env.PasswordResetRateWindow, "1h")).
+		SetEnv(env.TotpIssuer, env.GetEnv(env.TotpIssuer, "Narx")).
+		SetEnv(env.WebhookAllowLocalhost, env.GetEnv(env.WebhookAllowLocalhost, "false"))
 
 	return staticEnvironment
 }
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Reads the audit log",
+}
+
+var exportAuditCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports audit entries as JSON lines, oldest first",
+	Run:   exportAudit,
+}
+
+func init() {
+	exportAuditCmd.Flags().String("from", "", "only export entries at or after this RFC 3339 time")
+	exportAuditCmd.Flags().String("to", "", "only export entries before this RFC 3339 time")
+	exportAuditCmd.Flags().String("actor-id", "", "only export entries made by this account")
+	exportAuditCmd.Flags().String("action", "", "only export entries with this action, eg. sensor.deleted")
+	exportAuditCmd.Flags().String("target-type", "", "only export entries for this type of target, eg. sensor")
+	exportAuditCmd.Flags().String("target-id", "", "only export entries for this target")
+	exportAuditCmd.Flags().String("out", "", "file to write to, defaults to stdout")
+
+	auditCmd.AddCommand(exportAuditCmd)
+	rootCmd.AddCommand(auditCmd)
+}
+
+func exportAudit(cmd *cobra.Command, args []string) {
+	ctx := context.Background()
+
+	filters := audit.Filters{}
+	filters.ActorId, _ = cmd.Flags().GetString("actor-id")
+	filters.Action, _ = cmd.Flags().GetString("action")
+	filters.TargetType, _ = cmd.Flags().GetString("target-type")
+	filters.TargetId, _ = cmd.Flags().GetString("target-id")
+
+	for flag, target := range map[string]**time.Time{"from": &filters.From, "to": &filters.To} {
+		value, _ := cmd.Flags().GetString(flag)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			fmt.Println("--" + flag + " must be an RFC 3339 timestamp")
+			return
+		}
+		*target = &t
+	}
+
+	var w io.Writer = os.Stdout
+	if out, _ := cmd.Flags().GetString("out"); out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			fmt.Println("failed to create output file: " + err.Error())
+			return
+		}
+		defer f.Close()
+		w = f
+	}
+
+	dbConn, rc := connectRepositories()
+	defer func() {
+		_ = dbConn.Disconnect(context.TODO())
+	}()
+
+	oldestFirst, _ := repository.NewQuerySort().AddSort(models.FieldId, 1)
+
+	entries, err := rc.AuditLogRepo.Find(ctx, filters.QueryFilter(), nil, oldestFirst)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to read audit log: "+err.Error())
+		return
+	}
+
+	encoder := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err = encoder.Encode(entry); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to write audit entry: "+err.Error())
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d audit entries\n", len(entries))
+}
+
+// recordCommandLineChange writes an audit entry for a change made directly against the database.
+func recordCommandLineChange(ctx context.Context, auditLog *audit.Log, entry audit.Entry) {
+	entry.Actor = &models.AccountInfo{}
+	if entry.Metadata == nil {
+		entry.Metadata = map[string]string{}
+	}
+	entry.Metadata["source"] = "command_line"
+	if user := os.Getenv("USER"); user != "" {
+		entry.Metadata["os_user"] = user
+	}
+
+	auditLog.Record(ctx, entry)
+}
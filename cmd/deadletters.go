@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/services"
+)
+
+// deadLettersCmd represents the dead-letters command
+var deadLettersCmd = &cobra.Command{
+	Use:   "dead-letters",
+	Short: "Inspects and requeues events that ran out of attempts",
+}
+
+var listDeadLettersCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists dead-lettered events, newest last",
+	Run:   listDeadLetters,
+}
+
+var requeueDeadLetterCmd = &cobra.Command{
+	Use:   "requeue [dead letter id]",
+	Short: "Gives a dead-lettered event a fresh set of attempts",
+	Args:  cobra.ExactArgs(1),
+	Run:   requeueDeadLetter,
+}
+
+func init() {
+	listDeadLettersCmd.Flags().String("key", "", "only list events with this key, eg. NOTIFICATION.USER")
+	listDeadLettersCmd.Flags().String("group", "", "only list events dead-lettered by this consumer group")
+	listDeadLettersCmd.Flags().Bool("all", false, "include events that have already been requeued")
+	listDeadLettersCmd.Flags().Int64("page", 1, "page to list")
+
+	deadLettersCmd.AddCommand(listDeadLettersCmd, requeueDeadLetterCmd)
+	rootCmd.AddCommand(deadLettersCmd)
+}
+
+func listDeadLetters(cmd *cobra.Command, args []string) {
+	ctx := context.Background()
+
+	input := services.ListDeadLettersInput{
+		Pager: services.Pager{PerPage: 50},
+	}
+	input.EventKey, _ = cmd.Flags().GetString("key")
+	input.Group, _ = cmd.Flags().GetString("group")
+	input.IncludeRequeued, _ = cmd.Flags().GetBool("all")
+	input.Page, _ = cmd.Flags().GetInt64("page")
+
+	dbConn, rc := connectRepositories()
+	defer func() {
+		_ = dbConn.Disconnect(context.TODO())
+	}()
+
+	service := services.NewDeadLetterService(nil, audit.NewLog(rc.AuditLogRepo))
+
+	deadLetters, _, err := service.ListDeadLetters(ctx, input, rc.DeadLettersRepo)
+	if err != nil {
+		fmt.Println("failed to list dead letters: " + err.Error())
+		return
+	}
+
+	for _, d := range deadLetters {
+		status := "dead"
+		if d.IsRequeued() {
+			status = "requeued"
+		}
+		fmt.Printf("%s  %-8s  %-32s  %-12s  %d attempts  %s\n", d.GetId(), status, d.EventKey, d.Group, d.Attempts, d.LastError)
+	}
+}
+
+func requeueDeadLetter(cmd *cobra.Command, args []string) {
+	ctx := context.Background()
+
+	dbConn, rc := connectRepositories()
+	defer func() {
+		_ = dbConn.Disconnect(context.TODO())
+	}()
+
+	service := services.NewDeadLetterService(nil, audit.NewLog(rc.AuditLogRepo))
+
+	// requeue to the queue the api publishes to
+	_, eventQueue := newEventQueue(setEventQueueEnvironment(), dbConn)
+
+	deadLetter, err := service.RequeueDeadLetter(ctx, services.RequeueDeadLetterInput{
+		Actor:        &models.AccountInfo{},
+		DeadLetterId: args[0],
+	}, rc.DeadLettersRepo, eventQueue)
+	if err != nil {
+		fmt.Println("failed to requeue dead letter: " + err.Error())
+		return
+	}
+
+	fmt.Printf("requeued %s event %s for %s\n", deadLetter.EventKey, deadLetter.EventId, deadLetter.Group)
+}
+
+func setEventQueueEnvironment() env.Environment {
+	staticEnvironment := env.NewEnvironment()
+
+	staticEnvironment.
+		SetEnv(env.RedisDsn, env.GetEnv(env.RedisDsn, "")).
+		SetEnv(env.RedisPassword, env.GetEnv(env.RedisPassword, "")).
+		SetEnv(env.EventQueue, env.GetEnv(env.EventQueue, "mongo")).
+		SetEnv(env.EventStream, env.GetEnv(env.EventStream, "narx:events")).
+		SetEnv(env.EventStreamMaxLen, env.GetEnv(env.EventStreamMaxLen, "100000"))
+
+	return staticEnvironment
+}
@@ -2,14 +2,26 @@ package cmd
 
 import (
 	"context"
+	"log"
+	"net/mail"
+	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/tejiriaustin/narx_api/audit"
 	"github.com/tejiriaustin/narx_api/consumer"
 	"github.com/tejiriaustin/narx_api/database"
+	"github.com/tejiriaustin/narx_api/digest"
 	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/events"
 	"github.com/tejiriaustin/narx_api/events/notifications"
+	"github.com/tejiriaustin/narx_api/limiter"
 	"github.com/tejiriaustin/narx_api/messaging"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/publisher"
+	"github.com/tejiriaustin/narx_api/repository"
+	"github.com/tejiriaustin/narx_api/services"
+	"github.com/tejiriaustin/narx_api/webhooks"
 )
 
 // apiCmd represents the api command
@@ -20,6 +32,25 @@ var listenerCmd = &cobra.Command{
 	Run:   startListener,
 }
 
+// deviceExpiryInterval is how often stale devices are looked for.
+const deviceExpiryInterval = time.Hour
+
+var (
+	// passwordResetRetryPolicy gives up on a reset email well inside the 15 minutes its code lasts.
+	passwordResetRetryPolicy = consumer.RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   30 * time.Second,
+		MaxDelay:    2 * time.Minute,
+	}
+
+	// phoneVerificationRetryPolicy gives up on a verification text well inside the 10 minutes its code lasts.
+	phoneVerificationRetryPolicy = consumer.RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   15 * time.Second,
+		MaxDelay:    time.Minute,
+	}
+)
+
 func init() {
 	rootCmd.AddCommand(listenerCmd)
 }
@@ -37,33 +68,232 @@ func startListener(cmd *cobra.Command, args []string) {
 		_ = dbConn.Disconnect(context.TODO())
 	}()
 
-	mailer := messaging.NewSMTP(
-		config.GetAsString(env.SmtpPassword),
-		config.GetAsString(env.SmtpSender),
-		config.GetAsString(env.SmtpHost),
-		config.GetAsString(env.SmtpPort),
-	)
+	runListener(ctx, config, dbConn, nil)
+}
+
+// runListener handles events until ctx is cancelled. Events come from queue when it is set, and
+// from the queue picked by EVENT_QUEUE otherwise.
+func runListener(ctx context.Context, config env.Environment, dbConn database.Database, queue *consumer.MemoryQueue) {
+	mailer := newMailer(config)
 	db := dbConn.GetCollection("notifications")
 
-	listeners := consumer.NewConsumer(consumer.WithUpdater(db)).
-		SetHandler(notifications.ForgotPasswordNotification, notifications.ForgotPasswordNotificationEventHandler(mailer))
+	sealer, err := events.NewSealer(config.GetAsString(env.EventSecretKey))
+	if err != nil {
+		panic("Couldn't open sealed events: " + err.Error())
+	}
+
+	rc := repository.NewRepositoryContainer(dbConn)
+	deviceService := services.NewDeviceService(&config, audit.NewLog(rc.AuditLogRepo))
+	pusher := newPusher(&config, rc.DevicesRepo)
+	sms := newSMS(config)
+
+	hooks := webhooks.NewDispatcher(rc.WebhooksRepo, rc.DeliveriesRepo,
+		webhooks.WithLoopbackAllowed(config.GetAsString(env.WebhookAllowLocalhost) == "true"),
+	)
+	go hooks.Run(ctx, config.GetAsDuration(env.WebhookRetryInterval))
+
+	var eventQueue publisher.PublishInterface = queue
+	if queue == nil {
+		eventQueue, _ = newEventQueue(config, dbConn)
+	}
+
+	digests := digest.NewJob(rc.AccountsRepo, rc.PreferencesRepo, rc.SensorRepo, rc.InboxRepo, eventQueue,
+		digest.WithProductionSource(digest.NewReadingsSource(rc.ReadingsRepo)))
+	go digests.Run(ctx, config.GetAsDuration(env.DigestInterval))
+
+	go expireDevices(ctx, deviceService, rc.DevicesRepo, config.GetAsDuration(env.DeviceExpiryPeriod))
+
+	notifier := notifications.NewAccountNotifier(mailer, pusher, sms, hooks, rc.AccountsRepo, rc.PreferencesRepo, rc.InboxRepo)
+
+	listeners := newConsumer(config, dbConn).
+		SetHandler(notifications.ForgotPasswordNotification, notifications.ForgotPasswordNotificationEventHandler(mailer, sealer, rc.PreferencesRepo)).
+		SetHandler(notifications.AccountCreatedNotification, notifications.AccountCreatedNotificationEventHandler(mailer, rc.PreferencesRepo)).
+		SetHandler(notifications.AccountLockedNotification, notifications.AccountLockedNotificationEventHandler(mailer, rc.PreferencesRepo)).
+		SetHandler(notifications.PhoneVerificationNotification, notifications.PhoneVerificationNotificationEventHandler(sms)).
+		SetHandler(notifications.DigestNotification, notifications.DigestNotificationEventHandler(mailer)).
+		SetHandler(notifications.UserNotification, notifications.UserNotificationEventHandler(notifier)).
+		SetHandler(notifications.FaultNotification, notifications.FaultNotificationEventHandler(notifier)).
+		SetHandler(notifications.AlertNotification, notifications.AlertNotificationEventHandler(notifier)).
+		SetHandler(notifications.SensorOfflineNotification, notifications.SensorOfflineNotificationEventHandler(notifier)).
+		SetHandler(notifications.TicketAssignedNotification, notifications.TicketAssignedNotificationEventHandler(notifier)).
+		SetRetryPolicy(notifications.ForgotPasswordNotification, passwordResetRetryPolicy).
+		SetRetryPolicy(notifications.PhoneVerificationNotification, phoneVerificationRetryPolicy)
+
+	if queue != nil {
+		listeners.ListenAndServeMemory(ctx, queue)
+		return
+	}
+
+	if config.GetAsString(env.EventQueue) == "redis" {
+		listeners.ListenAndServeStream(ctx,
+			newEventStreamClient(config).Cmdable(),
+			config.GetAsString(env.EventStream),
+			int64(config.GetAsInt(env.EventStreamMaxLen)),
+		)
+		return
+	}
 
 	listeners.ListenAndServe(ctx, db)
 }
 
-func setListenerEnvironment() env.Environment {
-	staticEnvironment := env.NewEnvironment()
+// expireDevices periodically removes devices that have stopped checking in, so pushes aren't
+// sent to app installs that are long gone.
+func expireDevices(ctx context.Context,
+	deviceService services.DeviceServiceInterface,
+	devicesRepo *repository.Repository[models.Devices],
+	olderThan time.Duration,
+) {
+	ticker := time.NewTicker(deviceExpiryInterval)
+	defer ticker.Stop()
 
-	staticEnvironment.
+	for {
+		err := deviceService.ExpireDevices(ctx, olderThan, devicesRepo)
+		if err != nil {
+			log.Printf("failed to expire devices: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// newMailer picks the email transport. MAIL_TRANSPORT=file writes emails to MAIL_SINK_DIR instead of
+// sending them, for local development.
+func newMailer(config env.Environment) messaging.Messaging {
+	from := mail.Address{
+		Name:    config.GetAsString(env.SmtpSenderName),
+		Address: config.GetAsString(env.SmtpSender),
+	}
+
+	switch config.GetAsString(env.MailTransport) {
+	case "file":
+		sink, err := messaging.NewFileSink(config.GetAsString(env.MailSinkDir), from)
+		if err != nil {
+			panic("Couldn't create mail sink: " + err.Error())
+		}
+		return sink
+	case "smtp":
+	default:
+		panic("Unknown mail transport: " + config.GetAsString(env.MailTransport))
+	}
+
+	smtpConfig := messaging.SMTPConfig{
+		Host:           config.GetAsString(env.SmtpHost),
+		Port:           config.GetAsString(env.SmtpPort),
+		Username:       config.GetAsString(env.SmtpUsername),
+		Password:       config.GetAsString(env.SmtpPassword),
+		From:           from.Address,
+		FromName:       from.Name,
+		TLSMode:        messaging.TLSMode(config.GetAsString(env.SmtpTlsMode)),
+		Auth:           messaging.AuthMechanism(config.GetAsString(env.SmtpAuth)),
+		DialTimeout:    config.GetAsDuration(env.SmtpTimeout),
+		SendTimeout:    config.GetAsDuration(env.SmtpTimeout),
+		MaxConnections: config.GetAsInt(env.SmtpMaxConnections),
+	}
+	if err := smtpConfig.Validate(); err != nil {
+		panic("Invalid smtp configuration: " + err.Error())
+	}
+
+	return messaging.NewSMTP(smtpConfig)
+}
+
+// newConsumer sets up the listener's consumer. CONSUMER_MODE=change_stream claims events as soon as
+// they are published, falling back to polling on deployments without a replica set, and
+// CONSUMER_MODE=poll only polls.
+func newConsumer(config env.Environment, dbConn database.Database) *consumer.Consumer {
+	db := dbConn.GetCollection("notifications")
+
+	opts := []consumer.Options{
+		consumer.WithUpdater(db),
+		consumer.WithGroup(config.GetAsString(env.ConsumerGroup)),
+		consumer.WithLease(config.GetAsDuration(env.ConsumerLease)),
+		consumer.WithDeadLetters(dbConn.GetCollection("dead_letters")),
+	}
+
+	switch config.GetAsString(env.ConsumerMode) {
+	case "change_stream":
+		opts = append(opts, consumer.WithChangeStream(db, dbConn.GetCollection("resume_tokens")))
+	case "poll":
+	default:
+		panic("Unknown consumer mode: " + config.GetAsString(env.ConsumerMode))
+	}
+
+	return consumer.NewConsumer(opts...)
+}
+
+// newSMS picks the SMS provider. SMS_PROVIDER=stub logs messages instead of sending them, for local
+// development. Each number gets at most SMS_RATE_LIMIT messages per SMS_RATE_WINDOW.
+func newSMS(config env.Environment) messaging.Messaging {
+	var provider messaging.SMSProvider
+
+	switch config.GetAsString(env.SmsProvider) {
+	case "stub":
+		provider = messaging.NewStubSMSProvider()
+	case "twilio":
+		provider = messaging.NewTwilioProvider(
+			env.MustGetEnv(env.TwilioAccountSid),
+			env.MustGetEnv(env.TwilioAuthToken),
+			env.MustGetEnv(env.SmsSender),
+		)
+	default:
+		panic("Unknown sms provider: " + config.GetAsString(env.SmsProvider))
+	}
+
+	rateLimiter := limiter.NewRateLimiter(
+		newLimiterStore(config),
+		"sms",
+		int64(config.GetAsInt(env.SmsRateLimit)),
+		config.GetAsDuration(env.SmsRateWindow),
+	)
+
+	return messaging.NewSMS(provider, rateLimiter)
+}
+
+func setListenerEnvironment() env.Environment {
+	return listenerEnvironment().
 		SetEnv(env.MongoDsn, env.MustGetEnv(env.MongoDsn)).
 		SetEnv(env.MongoDbName, env.MustGetEnv(env.MongoDbName)).
-		SetEnv(env.SmtpHost, env.MustGetEnv(env.SmtpHost)).
-		SetEnv(env.SmtpSender, env.MustGetEnv(env.SmtpSender)).
-		SetEnv(env.SmtpPort, env.MustGetEnv(env.SmtpPort)).
-		SetEnv(env.SmtpPassword, env.MustGetEnv(env.SmtpPassword)).
+		SetEnv(env.EventSecretKey, env.MustGetEnv(env.EventSecretKey)).
 		SetEnv(env.FirebaseAuthKey, env.MustGetEnv(env.FirebaseAuthKey)).
-		SetEnv(env.FirebaseRegistrationToken, env.MustGetEnv(env.FirebaseRegistrationToken)).
 		SetEnv(env.FirebaseServiceAccountKey, env.MustGetEnv(env.FirebaseServiceAccountKey))
+}
+
+// listenerEnvironment is the listener's configuration that has a default.
+func listenerEnvironment() env.Environment {
+	staticEnvironment := env.NewEnvironment()
+
+	staticEnvironment.
+		SetEnv(env.RedisDsn, env.GetEnv(env.RedisDsn, "")).
+		SetEnv(env.RedisPassword, env.GetEnv(env.RedisPassword, "")).
+		SetEnv(env.MailTransport, env.GetEnv(env.MailTransport, "smtp")).
+		SetEnv(env.MailSinkDir, env.GetEnv(env.MailSinkDir, "./mail")).
+		SetEnv(env.SmtpHost, env.GetEnv(env.SmtpHost, "")).
+		SetEnv(env.SmtpPort, env.GetEnv(env.SmtpPort, "587")).
+		SetEnv(env.SmtpSender, env.GetEnv(env.SmtpSender, "")).
+		SetEnv(env.SmtpSenderName, env.GetEnv(env.SmtpSenderName, "Narx")).
+		SetEnv(env.SmtpUsername, env.GetEnv(env.SmtpUsername, "")).
+		SetEnv(env.SmtpPassword, env.GetEnv(env.SmtpPassword, "")).
+		SetEnv(env.SmtpTlsMode, env.GetEnv(env.SmtpTlsMode, string(messaging.TLSModeStartTLS))).
+		SetEnv(env.SmtpAuth, env.GetEnv(env.SmtpAuth, string(messaging.AuthPlain))).
+		SetEnv(env.SmtpTimeout, env.GetEnv(env.SmtpTimeout, "30s")).
+		SetEnv(env.SmtpMaxConnections, env.GetEnv(env.SmtpMaxConnections, "2")).
+		SetEnv(env.SmsProvider, env.GetEnv(env.SmsProvider, "stub")).
+		SetEnv(env.SmsRateLimit, env.GetEnv(env.SmsRateLimit, "10")).
+		SetEnv(env.SmsRateWindow, env.GetEnv(env.SmsRateWindow, "1h")).
+		SetEnv(env.PushProvider, env.GetEnv(env.PushProvider, "firebase")).
+		SetEnv(env.DeviceExpiryPeriod, env.GetEnv(env.DeviceExpiryPeriod, "2160h")).
+		SetEnv(env.WebhookRetryInterval, env.GetEnv(env.WebhookRetryInterval, "15s")).
+		SetEnv(env.WebhookAllowLocalhost, env.GetEnv(env.WebhookAllowLocalhost, "false")).
+		SetEnv(env.DigestInterval, env.GetEnv(env.DigestInterval, "15m")).
+		SetEnv(env.ConsumerGroup, env.GetEnv(env.ConsumerGroup, "listener")).
+		SetEnv(env.ConsumerLease, env.GetEnv(env.ConsumerLease, "1m")).
+		SetEnv(env.ConsumerMode, env.GetEnv(env.ConsumerMode, "change_stream")).
+		SetEnv(env.EventQueue, env.GetEnv(env.EventQueue, "mongo")).
+		SetEnv(env.EventStream, env.GetEnv(env.EventStream, "narx:events")).
+		SetEnv(env.EventStreamMaxLen, env.GetEnv(env.EventStreamMaxLen, "100000"))
 
 	return staticEnvironment
 }
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tejiriaustin/narx_api/oidc"
+)
+
+// mockOIDCCmd represents the mock-oidc command
+var mockOIDCCmd = &cobra.Command{
+	Use:   "mock-oidc",
+	Short: "Starts a local OpenID Connect provider for trying out single sign-on",
+	Long: `Starts a local OpenID Connect provider that signs every request in as the configured user.
+Point an organisation at it with:
+
+  narx_api organisations set-oidc --slug <org> --issuer http://localhost:9000 --client-id narx-local --jit`,
+	Run: startMockOIDC,
+}
+
+func init() {
+	mockOIDCCmd.Flags().String("addr", ":9000", "address to listen on")
+	mockOIDCCmd.Flags().String("issuer", "http://localhost:9000", "issuer url the provider is reachable at")
+	mockOIDCCmd.Flags().String("client-id", "narx-local", "client id to accept")
+	mockOIDCCmd.Flags().String("subject", "mock-user", "subject of the signed in user")
+	mockOIDCCmd.Flags().String("email", "jane@example.com", "email of the signed in user")
+	mockOIDCCmd.Flags().String("first-name", "Jane", "given name of the signed in user")
+	mockOIDCCmd.Flags().String("last-name", "Doe", "family name of the signed in user")
+	rootCmd.AddCommand(mockOIDCCmd)
+}
+
+func startMockOIDC(cmd *cobra.Command, args []string) {
+	addr, _ := cmd.Flags().GetString("addr")
+	issuer, _ := cmd.Flags().GetString("issuer")
+	clientId, _ := cmd.Flags().GetString("client-id")
+
+	identity := oidc.IdentityClaims{EmailVerified: true}
+	identity.Subject, _ = cmd.Flags().GetString("subject")
+	identity.Email, _ = cmd.Flags().GetString("email")
+	identity.GivenName, _ = cmd.Flags().GetString("first-name")
+	identity.FamilyName, _ = cmd.Flags().GetString("last-name")
+
+	provider, err := oidc.NewMockProvider(issuer, clientId, identity)
+	if err != nil {
+		panic("failed to start mock oidc provider: " + err.Error())
+	}
+
+	log.Println("mock oidc provider listening on " + addr)
+	log.Fatal(http.ListenAndServe(addr, provider.Handler()))
+}
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/database"
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+// organisationsCmd represents the organisations command
+var organisationsCmd = &cobra.Command{
+	Use:   "organisations",
+	Short: "Manages organisations and their single sign-on configuration",
+}
+
+var createOrganisationCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Creates an organisation",
+	Run:   createOrganisation,
+}
+
+var setOrganisationOIDCCmd = &cobra.Command{
+	Use:   "set-oidc",
+	Short: "Configures an organisation's OpenID Connect provider",
+	Run:   setOrganisationOIDC,
+}
+
+func init() {
+	createOrganisationCmd.Flags().String("name", "", "organisation name")
+	createOrganisationCmd.Flags().String("slug", "", "url friendly organisation identifier")
+	_ = createOrganisationCmd.MarkFlagRequired("name")
+	_ = createOrganisationCmd.MarkFlagRequired("slug")
+
+	setOrganisationOIDCCmd.Flags().String("slug", "", "organisation slug")
+	setOrganisationOIDCCmd.Flags().String("issuer", "", "identity provider issuer url")
+	setOrganisationOIDCCmd.Flags().String("client-id", "", "client id registered with the identity provider")
+	setOrganisationOIDCCmd.Flags().String("client-secret", "", "client secret registered with the identity provider")
+	setOrganisationOIDCCmd.Flags().String("scopes", "openid,email,profile", "comma separated scopes to request")
+	setOrganisationOIDCCmd.Flags().Bool("jit", false, "create accounts for users signing in for the first time")
+	setOrganisationOIDCCmd.Flags().String("domains", "", "comma separated email domains the organisation owns, whose accounts are linked on first sign in")
+	setOrganisationOIDCCmd.Flags().Bool("disable", false, "disable single sign-on for the organisation")
+	_ = setOrganisationOIDCCmd.MarkFlagRequired("slug")
+
+	organisationsCmd.AddCommand(createOrganisationCmd, setOrganisationOIDCCmd)
+	rootCmd.AddCommand(organisationsCmd)
+}
+
+func createOrganisation(cmd *cobra.Command, args []string) {
+	ctx := context.Background()
+
+	name, _ := cmd.Flags().GetString("name")
+	slug, _ := cmd.Flags().GetString("slug")
+
+	dbConn, rc := connectRepositories()
+	defer func() {
+		_ = dbConn.Disconnect(context.TODO())
+	}()
+
+	_, err := rc.OrganisationsRepo.FindOne(ctx, repository.NewQueryFilter().AddFilter(models.FieldOrganisationSlug, slug), nil, nil)
+	if err == nil {
+		fmt.Println("an organisation with this slug already exists")
+		return
+	}
+
+	now := time.Now().UTC()
+	org, err := rc.OrganisationsRepo.Create(ctx, models.Organisation{
+		Shared: models.Shared{
+			ID:        primitive.NewObjectID(),
+			CreatedAt: &now,
+		},
+		Name: name,
+		Slug: slug,
+	})
+	if err != nil {
+		fmt.Println("failed to create organisation: " + err.Error())
+		return
+	}
+
+	recordCommandLineChange(ctx, audit.NewLog(rc.AuditLogRepo), audit.Entry{
+		Action:     audit.ActionOrganisationCreated,
+		TargetType: audit.TargetOrganisation,
+		TargetId:   org.GetId(),
+		After:      org,
+	})
+
+	fmt.Println("created organisation " + org.Slug)
+}
+
+func setOrganisationOIDC(cmd *cobra.Command, args []string) {
+	ctx := context.Background()
+
+	slug, _ := cmd.Flags().GetString("slug")
+	issuer, _ := cmd.Flags().GetString("issuer")
+	clientId, _ := cmd.Flags().GetString("client-id")
+	clientSecret, _ := cmd.Flags().GetString("client-secret")
+	scopes, _ := cmd.Flags().GetString("scopes")
+	jit, _ := cmd.Flags().GetBool("jit")
+	domains, _ := cmd.Flags().GetString("domains")
+	disable, _ := cmd.Flags().GetBool("disable")
+
+	dbConn, rc := connectRepositories()
+	defer func() {
+		_ = dbConn.Disconnect(context.TODO())
+	}()
+
+	org, err := rc.OrganisationsRepo.FindOne(ctx, repository.NewQueryFilter().AddFilter(models.FieldOrganisationSlug, slug), nil, nil)
+	if err != nil {
+		fmt.Println("organisation not found: " + slug)
+		return
+	}
+	before := org
+	if org.OIDC != nil {
+		oidcConfig := *org.OIDC
+		before.OIDC = &oidcConfig
+	}
+
+	if disable {
+		if org.OIDC != nil {
+			org.OIDC.Enabled = false
+		}
+	} else {
+		if issuer == "" || clientId == "" {
+			fmt.Println("--issuer and --client-id are required")
+			return
+		}
+		org.OIDC = &models.OIDCConfig{
+			Enabled:              true,
+			Issuer:               issuer,
+			ClientId:             clientId,
+			ClientSecret:         clientSecret,
+			Scopes:               strings.Split(scopes, ","),
+			AllowJitProvisioning: jit,
+		}
+		if domains != "" {
+			org.OIDC.VerifiedDomains = strings.Split(domains, ",")
+		}
+	}
+
+	_, err = rc.OrganisationsRepo.Update(ctx, org)
+	if err != nil {
+		fmt.Println("failed to update organisation: " + err.Error())
+		return
+	}
+
+	recordCommandLineChange(ctx, audit.NewLog(rc.AuditLogRepo), audit.Entry{
+		Action:     audit.ActionOrganisationUpdated,
+		TargetType: audit.TargetOrganisation,
+		TargetId:   org.GetId(),
+		Before:     before,
+		After:      org,
+	})
+
+	fmt.Println("updated single sign-on for " + org.Slug)
+}
+
+// connectRepositories is used by commands that work on the database directly.
+func connectRepositories() (*database.Client, *repository.Container) {
+	dbConn, err := database.NewMongoDbClient().Connect(env.MustGetEnv(env.MongoDsn), env.MustGetEnv(env.MongoDbName))
+	if err != nil {
+		panic("Couldn't connect to mongo dsn: " + err.Error())
+	}
+
+	return dbConn, repository.NewRepositoryContainer(dbConn)
+}
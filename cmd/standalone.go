@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tejiriaustin/narx_api/consumer"
+	"github.com/tejiriaustin/narx_api/database"
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/repository"
+	"github.com/tejiriaustin/narx_api/server"
+	"github.com/tejiriaustin/narx_api/utils"
+)
+
+// standaloneCmd represents the standalone command
+var standaloneCmd = &cobra.Command{
+	Use:   "standalone",
+	Short: "Starts the api and listener in one process, with everything kept in memory",
+	Long: `Starts the api and listener in one process for local development, with nothing to connect
+to. Data and events are kept in memory, so they are lost when the process stops. Emails are
+written to MAIL_SINK_DIR, texts and push notifications are logged.`,
+	Run: startStandalone,
+}
+
+func init() {
+	rootCmd.AddCommand(standaloneCmd)
+}
+
+func startStandalone(cmd *cobra.Command, args []string) {
+	runStandalone(context.Background(), setStandaloneEnvironment())
+}
+
+// runStandalone serves the api and runs the listener until ctx is cancelled or the process is interrupted.
+func runStandalone(ctx context.Context, config env.Environment) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	db := database.NewMemoryDatabase()
+	queue := consumer.NewMemoryQueue()
+
+	listenerDone := make(chan struct{})
+	go func() {
+		defer close(listenerDone)
+		runListener(ctx, config, db, queue)
+	}()
+
+	rc := repository.NewRepositoryContainer(db)
+
+	sc := newServiceContainer(&config, rc)
+	sc.Publisher, sc.EventQueue = queue, consumer.NewCollectionRequeuer(queue)
+
+	server.Start(ctx, sc, rc, &config)
+
+	cancel()
+	<-listenerDone
+}
+
+// setStandaloneEnvironment needs nothing to be set. Without a JWT_SECRET_KEY or EVENT_SECRET_KEY
+// random ones are used, so sessions end when the process does.
+func setStandaloneEnvironment() env.Environment {
+	config := apiEnvironment()
+	for key, value := range listenerEnvironment() {
+		if _, ok := config[key]; !ok {
+			config.SetEnv(key, value)
+		}
+	}
+
+	jwtSecret, err := utils.RandomToken(32)
+	if err != nil {
+		panic("Couldn't generate a jwt secret: " + err.Error())
+	}
+	eventSecret, err := utils.RandomToken(32)
+	if err != nil {
+		panic("Couldn't generate an event secret: " + err.Error())
+	}
+
+	return config.
+		SetEnv(env.RedisDsn, "").
+		SetEnv(env.JwtSecret, env.GetEnv(env.JwtSecret, jwtSecret)).
+		SetEnv(env.EventSecretKey, env.GetEnv(env.EventSecretKey, eventSecret)).
+		SetEnv(env.FrontendUrl, env.GetEnv(env.FrontendUrl, "http://localhost:3000")).
+		SetEnv(env.MailTransport, env.GetEnv(env.MailTransport, "file")).
+		SetEnv(env.PushProvider, env.GetEnv(env.PushProvider, "log"))
+}
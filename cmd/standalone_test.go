@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tejiriaustin/narx_api/env"
+)
+
+// standaloneEnv is everything that could point standalone at a database, queue, cache or provider.
+var standaloneEnv = []string{
+	env.MongoDsn, env.MongoDbName, env.RedisDsn, env.RedisPassword, env.JwtSecret, env.EventSecretKey, env.FrontendUrl, env.ApiUrl,
+	env.MailTransport, env.MailSinkDir, env.SmtpHost, env.SmsProvider, env.PushProvider,
+	env.FirebaseAuthKey, env.FirebaseServiceAccountKey, env.EventQueue, env.ConsumerMode,
+}
+
+func TestStandaloneBootsWithEmptyEnvironment(t *testing.T) {
+	for _, key := range standaloneEnv {
+		t.Setenv(key, "")
+	}
+	// the only setting is a free port, so the test doesn't clash with anything already listening
+	port := freePort(t)
+	t.Setenv(env.Port, port)
+
+	// emails land in ./mail
+	chdir(t, t.TempDir())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runStandalone(ctx, setStandaloneEnvironment())
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	baseUrl := "http://localhost:" + port + "/v1"
+	eventually(t, func() bool {
+		res, err := http.Get(baseUrl + "/health")
+		if err != nil {
+			return false
+		}
+		_ = res.Body.Close()
+		return res.StatusCode == http.StatusOK
+	})
+
+	body := `{"firstName":"Ada","lastName":"Obi","email":"ada@example.com","password":"correct horse battery staple"}`
+	res, err := http.Post(baseUrl+"/user/sign-up", "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("sign up: %v", err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("sign up status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	// the listener picks the sign-up event off the in-memory queue and writes the verification email
+	eventually(t, func() bool {
+		emails, _ := filepath.Glob(filepath.Join("mail", "*ada@example.com.eml"))
+		if len(emails) == 0 {
+			return false
+		}
+		email, err := os.ReadFile(emails[0])
+		return err == nil && strings.Contains(string(email), "/v1/user/verify?token=")
+	})
+}
+
+func freePort(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	return strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+}
+
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+	})
+}
+
+func eventually(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tejiriaustin/narx_api/templates"
+)
+
+// templatesCmd represents the templates command
+var templatesCmd = &cobra.Command{
+	Use:   "templates",
+	Short: "Lists and previews notification templates",
+}
+
+var listTemplatesCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the templates and the locales they are translated into",
+	Run:   listTemplates,
+}
+
+var previewTemplateCmd = &cobra.Command{
+	Use:   "preview <template>",
+	Short: "Renders a template with sample data",
+	Args:  cobra.ExactArgs(1),
+	Run:   previewTemplate,
+}
+
+func init() {
+	previewTemplateCmd.Flags().String("locale", templates.DefaultLocale, "locale to render, eg. fr")
+	previewTemplateCmd.Flags().String("format", "html", "part to render: html or text, text messages only have text")
+	previewTemplateCmd.Flags().String("out", "", "file to write to, defaults to stdout")
+
+	templatesCmd.AddCommand(listTemplatesCmd)
+	templatesCmd.AddCommand(previewTemplateCmd)
+	rootCmd.AddCommand(templatesCmd)
+}
+
+func listTemplates(cmd *cobra.Command, args []string) {
+	fmt.Println("locales: " + strings.Join(templates.Locales(), ", "))
+	for _, key := range templates.Keys() {
+		fmt.Println(key)
+	}
+}
+
+func previewTemplate(cmd *cobra.Command, args []string) {
+	locale, _ := cmd.Flags().GetString("locale")
+	format, _ := cmd.Flags().GetString("format")
+
+	rendered, err := templates.Preview(strings.ToUpper(args[0]), locale)
+	if err != nil {
+		fmt.Println("failed to render template: " + err.Error())
+		return
+	}
+
+	var body string
+	switch format {
+	case "html":
+		body = rendered.HTML
+	case "text":
+		body = "Subject: " + rendered.Subject + "\n\n" + rendered.Text
+		if rendered.Subject == "" {
+			// text messages have no subject
+			body = rendered.Text
+		}
+	default:
+		fmt.Println("--format must be html or text")
+		return
+	}
+
+	var w io.Writer = os.Stdout
+	if out, _ := cmd.Flags().GetString("out"); out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			fmt.Println("failed to create output file: " + err.Error())
+			return
+		}
+		defer f.Close()
+		w = f
+	}
+
+	_, _ = io.WriteString(w, body)
+}
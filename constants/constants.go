@@ -8,4 +8,10 @@ const (
 
 	// ContextKeyPerPageLimit is the key used to set pagination per_page value in context
 	ContextKeyPerPageLimit contextKey = "_foundation.ctx.middlewares.per-page-limit_"
+
+	// ContextKeyAuditActor is the key used to set the authenticated account in context for the audit log
+	ContextKeyAuditActor contextKey = "_foundation.ctx.middlewares.audit-actor_"
+
+	// ContextKeyRequestInfo is the key used to set the caller's ip address and user agent in context
+	ContextKeyRequestInfo contextKey = "_foundation.ctx.middlewares.request-info_"
 )
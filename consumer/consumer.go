@@ -2,24 +2,36 @@ package consumer
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/tejiriaustin/narx_api/events"
 	"log"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
 
+	"github.com/tejiriaustin/narx_api/events"
 	"github.com/tejiriaustin/narx_api/repository"
 )
 
 const (
 	defaultMaxWorkers uint = 10
+	defaultGroup           = "listener"
+	defaultLease           = time.Minute
 )
 
+var ErrLeaseLost = errors.New("lease on event was lost")
+
+// errNoEvents tells serve there is nothing to handle yet.
+var errNoEvents = errors.New("no events to claim")
+
 type (
 	Fetcher interface {
 		Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
@@ -27,16 +39,39 @@ type (
 	Updater interface {
 		UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
 	}
+	// Claimer atomically takes the next available event for a consumer.
+	Claimer interface {
+		FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult
+	}
 
+	// Consumer claims events for its group and hands them to handlers. A claimed event is leased to
+	// the consumer, which renews the lease while the handler runs, so each event is handled by one
+	// consumer per group. Events whose lease runs out, because a listener died mid-handler, are
+	// claimed again by the next consumer to poll. Failed events are retried with backoff under their
+	// key's RetryPolicy, and dead-lettered once they run out of attempts.
 	Consumer struct {
 		maxWorkerChans uint
 		refreshTime    time.Duration
 		handlers       map[string]Handler
 		updater        Updater
+		group          string
+		owner          string
+		lease          time.Duration
+
+		defaultRetryPolicy RetryPolicy
+		retryPolicies      map[string]RetryPolicy
+		deadLetters        Inserter
+
+		watcher Watcher
+		tokens  TokenStore
+		wake    chan struct{}
 	}
 
 	Handler func(ctx context.Context, msg events.Event) error
 
+	// task handles one claimed event.
+	task func(ctx context.Context)
+
 	Options func(*Consumer)
 )
 
@@ -45,6 +80,14 @@ func newConsumer() *Consumer {
 		maxWorkerChans: defaultMaxWorkers,
 		refreshTime:    15 * time.Second,
 		handlers:       make(map[string]Handler),
+		group:          defaultGroup,
+		owner:          defaultOwner(),
+		lease:          defaultLease,
+
+		defaultRetryPolicy: DefaultRetryPolicy,
+		retryPolicies:      make(map[string]RetryPolicy),
+
+		wake: make(chan struct{}, 1),
 	}
 }
 
@@ -58,56 +101,197 @@ func NewConsumer(opts ...Options) *Consumer {
 }
 
 func (l *Consumer) SetHandler(key string, handler Handler) *Consumer {
-	l.handlers[strings.ToUpper(key)] = handler
+	l.handlers[normaliseKey(key)] = handler
 	return l
 }
 
-func (l *Consumer) ListenAndServe(ctx context.Context, pubSub Fetcher) {
+// ListenAndServe claims and handles events until ctx is cancelled, then waits for the handlers
+// still running. It polls again as soon as it claims an event and every refreshTime otherwise,
+// or straight away when its change stream sees an event published.
+func (l *Consumer) ListenAndServe(ctx context.Context, pubSub Claimer) {
 	log.Print("initializing  Consumer...")
 
-	workerChannels := make(chan events.Event, l.maxWorkerChans)
-	defer func() {
-		close(workerChannels)
-	}()
-
-	log.Print("starting workers\n", "maxWorkers: ", l.maxWorkerChans)
+	if l.watcher != nil && l.tokens != nil {
+		var wg sync.WaitGroup
+		defer wg.Wait()
 
-	for i := uint(0); i < l.maxWorkerChans; i++ {
-		go l.worker(ctx, workerChannels)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.watch(ctx)
+		}()
 	}
 
-	for {
-		zap.L().Info("pulling messages...")
-		cursor, err := pubSub.Find(ctx, repository.NewQueryFilter().AddFilter("processed", false))
+	l.serve(ctx, func(ctx context.Context) (task, error) {
+		message, err := l.claim(ctx, pubSub)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errNoEvents
+		}
 		if err != nil {
-			zap.L().Error("failed to receive message", zap.Error(err))
+			return nil, err
+		}
+
+		return func(ctx context.Context) {
+			if err := l.handle(ctx, message); err != nil {
+				zap.L().Error(err.Error(), zap.String("message", message.EventKey))
+			}
+		}, nil
+	})
+}
+
+// serve runs the tasks next hands out on up to maxWorkerChans workers until ctx is cancelled. Once
+// next has nothing to hand out it waits refreshTime, or until woken, before asking again. A nil
+// task with no error means next has already waited, so it is asked again straight away.
+func (l *Consumer) serve(ctx context.Context, next func(ctx context.Context) (task, error)) {
+	log.Print("starting workers\n", "maxWorkers: ", l.maxWorkerChans, " group: ", l.group, " owner: ", l.owner)
+
+	workerSlots := make(chan struct{}, l.maxWorkerChans)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case workerSlots <- struct{}{}:
 		}
 
-		for cursor.Next(ctx) {
-			var message events.Event
+		run, err := next(ctx)
+		if err != nil {
+			<-workerSlots
+
+			if !errors.Is(err, errNoEvents) && ctx.Err() == nil {
+				zap.L().Error("failed to claim message", zap.Error(err))
+			}
 
-			if err = cursor.Decode(&message); err != nil {
-				log.Fatal(err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(l.refreshTime):
+			case <-l.wake:
 			}
-			workerChannels <- message
+			continue
 		}
-		if err = cursor.Err(); err != nil {
-			log.Fatal(err)
+		if run == nil {
+			<-workerSlots
+			continue
 		}
 
-		time.Sleep(l.refreshTime)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-workerSlots }()
+
+			run(ctx)
+		}()
 	}
 }
 
-// worker listens on the msgChan for incoming messages.
-// as messages become available over the channel, it looks over the map of configured handlers and routes messages by the key.
-// If no handlers are configured and a default handler has been set, the message is sent there.
-// else it logs and continues.
-func (l *Consumer) worker(ctx context.Context, msgChan <-chan events.Event) {
-	for msg := range msgChan {
-		err := l.dispatcher(ctx, msg)
+// claim leases the oldest event the group hasn't handled, is due to retry, or whose lease has expired,
+// to this consumer and counts the attempt. Only events with a handler are claimed, so listeners
+// handling different keys can share a group. Events a listener marked processed before consumer
+// groups existed are never claimed, or upgrading would send all of them again.
+func (l *Consumer) claim(ctx context.Context, pubSub Claimer) (events.Event, error) {
+	var message events.Event
+
+	now := time.Now().UTC()
+	filter := repository.NewQueryFilter().
+		AddFilter("event_key", map[string]interface{}{"$in": l.keys()}).
+		AddFilter("$or", []map[string]interface{}{
+			{
+				l.field(""): map[string]interface{}{"$exists": false},
+				// events handled before deliveries were tracked per group are marked processed
+				"processed": map[string]interface{}{"$ne": true},
+			},
+			{
+				l.field("status"):          events.DeliveryPending,
+				l.field("next_attempt_at"): map[string]interface{}{"$lte": now},
+			},
+			{
+				l.field("status"):           events.DeliveryInProgress,
+				l.field("lease_expires_at"): map[string]interface{}{"$lt": now},
+			},
+		})
+
+	update := map[string]interface{}{
+		"$set": map[string]interface{}{
+			l.field("status"):           events.DeliveryInProgress,
+			l.field("owner"):            l.owner,
+			l.field("claimed_at"):       now,
+			l.field("lease_expires_at"): now.Add(l.lease),
+		},
+		"$inc": map[string]interface{}{
+			l.field("attempts"): 1,
+		},
+		"$unset": map[string]interface{}{
+			l.field("next_attempt_at"): "",
+		},
+	}
+
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	err := pubSub.FindOneAndUpdate(ctx, filter.GetFilters(), update, opts).Decode(&message)
+	return message, err
+}
+
+// handle runs the event's handler while renewing its lease. If the lease is lost the handler's
+// context is cancelled, since another consumer may already be handling the event.
+func (l *Consumer) handle(ctx context.Context, message events.Event) error {
+	// attempts are counted when claimed, so an event that keeps taking the listener down with it
+	// runs out of attempts without its handler ever returning
+	attempts := message.Deliveries[l.group].Attempts
+	if max := l.retryPolicy(message.EventKey).MaxAttempts; attempts > max {
+		return l.deadLetter(ctx, message, fmt.Errorf("handler did not finish in %d attempts", max))
+	}
+
+	handlerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		l.renewLease(handlerCtx, cancel, message)
+	}()
+
+	err := l.dispatcher(handlerCtx, message)
+
+	cancel()
+	<-renewed
+
+	if err != nil {
+		return l.fail(ctx, message, err)
+	}
+	return l.complete(ctx, message)
+}
+
+func (l *Consumer) renewLease(ctx context.Context, cancel context.CancelFunc, message events.Event) {
+	ticker := time.NewTicker(l.lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		res, err := l.updater.UpdateOne(ctx, l.ownedBy(message), map[string]interface{}{
+			"$set": map[string]interface{}{
+				l.field("lease_expires_at"): time.Now().UTC().Add(l.lease),
+			},
+		})
 		if err != nil {
-			zap.L().Error(err.Error(), zap.String("message", msg.EventKind))
+			// the lease is still ours until it expires, try again on the next tick
+			zap.L().Warn("failed to renew lease", zap.Error(err), zap.String("message", message.EventKey))
+			continue
+		}
+		if res.MatchedCount == 0 {
+			zap.L().Warn("lease lost, cancelling handler", zap.String("event", message.ID.Hex()), zap.String("message", message.EventKey))
+			cancel()
+			return
 		}
 	}
 }
@@ -119,23 +303,73 @@ func (l *Consumer) dispatcher(ctx context.Context, message events.Event) error {
 		zap.L().Error("handler func is nil", zap.String("message", message.EventKind))
 		return errors.New("handler func is nil")
 	}
-	fmt.Println("handler func")
 
 	if err := handlerFunc(ctx, message); err != nil {
 		zap.L().Error("failed to handle message", zap.Error(err), zap.String("message", message.EventKind))
 		return err
 	}
+	return nil
+}
 
-	updates := map[string]interface{}{
-		"$sets": map[string]interface{}{
-			"processed": true,
+// complete marks the event handled for the group, provided this consumer still holds the lease.
+func (l *Consumer) complete(ctx context.Context, message events.Event) error {
+	res, err := l.updater.UpdateOne(ctx, l.ownedBy(message), map[string]interface{}{
+		"$set": map[string]interface{}{
+			l.field("status"):       events.DeliveryDone,
+			l.field("completed_at"): time.Now().UTC(),
 		},
-	}
-
-	_, err := l.updater.UpdateOne(ctx, repository.NewQueryFilter().AddFilter("_id", message.ID), updates)
+		"$unset": map[string]interface{}{
+			l.field("lease_expires_at"): "",
+		},
+	})
 	if err != nil {
 		zap.L().Error("failed to update message processed", zap.Error(err), zap.String("message", message.EventKind))
 		return err
 	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("%w before %s was completed", ErrLeaseLost, message.ID.Hex())
+	}
 	return nil
 }
+
+// ownedBy matches the event while this consumer holds its lease.
+func (l *Consumer) ownedBy(message events.Event) bson.D {
+	return repository.NewQueryFilter().
+		AddFilter("_id", message.ID).
+		AddFilter(l.field("status"), events.DeliveryInProgress).
+		AddFilter(l.field("owner"), l.owner).
+		GetFilters()
+}
+
+// field returns the path of a field of the group's delivery, or of the delivery itself if name is empty.
+func (l *Consumer) field(name string) string {
+	if name == "" {
+		return "deliveries." + l.group
+	}
+	return "deliveries." + l.group + "." + name
+}
+
+func (l *Consumer) keys() []string {
+	keys := make([]string, 0, len(l.handlers))
+	for key := range l.handlers {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func normaliseKey(key string) string {
+	return strings.ToUpper(key)
+}
+
+// defaultOwner identifies this process among the group's consumers.
+func defaultOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "listener"
+	}
+
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
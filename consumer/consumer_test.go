@@ -0,0 +1,189 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/tejiriaustin/narx_api/events"
+)
+
+// leaseStore answers the consumer's updates as if it held the lease until lost is set.
+type leaseStore struct {
+	mu      sync.Mutex
+	lost    bool
+	filters []interface{}
+	updates []map[string]interface{}
+
+	// renewals counts the updates that only extend the lease
+	renewals int
+}
+
+func (s *leaseStore) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.filters = append(s.filters, filter)
+	if _, unsets := update.(map[string]interface{})["$unset"]; !unsets {
+		s.renewals++
+	}
+
+	if s.lost {
+		return &mongo.UpdateResult{}, nil
+	}
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+func (s *leaseStore) loseLease() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lost = true
+}
+
+func (s *leaseStore) renewed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.renewals
+}
+
+// claimRecorder keeps the filter and update of the last claim and returns event as claimed.
+type claimRecorder struct {
+	event  events.Event
+	filter interface{}
+	update map[string]interface{}
+}
+
+func (c *claimRecorder) FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	c.filter = filter
+	c.update = update.(map[string]interface{})
+	return mongo.NewSingleResultFromDocument(c.event, nil, nil)
+}
+
+func TestClaim(t *testing.T) {
+	claimed := events.Event{ID: primitive.NewObjectID(), EventKey: testKey, MsgBody: map[string]interface{}{"id": "a"}}
+	recorder := &claimRecorder{event: claimed}
+
+	listener := NewConsumer(WithGroup("mailer"), WithOwner("listener-1"), WithLease(time.Minute)).
+		SetHandler(testKey, func(ctx context.Context, msg events.Event) error { return nil })
+
+	before := time.Now().UTC()
+	message, err := listener.claim(context.Background(), recorder)
+	if err != nil {
+		t.Fatalf("claim() error = %v", err)
+	}
+	if message.ID != claimed.ID {
+		t.Errorf("claimed %s, want %s", message.ID.Hex(), claimed.ID.Hex())
+	}
+
+	set := recorder.update["$set"].(map[string]interface{})
+	if set["deliveries.mailer.status"] != events.DeliveryInProgress || set["deliveries.mailer.owner"] != "listener-1" {
+		t.Errorf("claim set %v, want the event in progress for listener-1", set)
+	}
+	leaseExpiresAt, _ := set["deliveries.mailer.lease_expires_at"].(time.Time)
+	if leaseExpiresAt.Before(before.Add(time.Minute)) || leaseExpiresAt.After(time.Now().UTC().Add(time.Minute)) {
+		t.Errorf("lease expires at %s, want a minute from the claim", leaseExpiresAt)
+	}
+	if inc := recorder.update["$inc"].(map[string]interface{}); inc["deliveries.mailer.attempts"] != 1 {
+		t.Errorf("claim incremented %v, want the attempts", inc)
+	}
+
+	// the event is claimable when new to the group, due to retry or its lease has run out
+	branches, _ := recorder.filter.(bson.D).Map()["$or"].([]map[string]interface{})
+	if len(branches) != 3 {
+		t.Fatalf("claim filter has %d branches, want 3: %v", len(branches), recorder.filter)
+	}
+	if branches[0]["deliveries.mailer"] == nil || branches[0]["processed"] == nil {
+		t.Errorf("new events branch %v doesn't skip events processed before consumer groups", branches[0])
+	}
+	if branches[1]["deliveries.mailer.status"] != events.DeliveryPending || branches[1]["deliveries.mailer.next_attempt_at"] == nil {
+		t.Errorf("retry branch %v doesn't wait for the next attempt", branches[1])
+	}
+	if branches[2]["deliveries.mailer.status"] != events.DeliveryInProgress || branches[2]["deliveries.mailer.lease_expires_at"] == nil {
+		t.Errorf("reclaim branch %v doesn't wait for the lease to expire", branches[2])
+	}
+}
+
+func TestHandleLease(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// handlerTime is how long the handler runs, unless its context is cancelled first
+		handlerTime time.Duration
+		loseLease   bool
+
+		wantErr       error
+		wantCancelled bool
+
+		// wantRenewals is whether the lease was renewed, or found lost trying
+		wantRenewals bool
+	}{
+		{name: "completed while held", handlerTime: 0},
+		{name: "renewed while the handler runs", handlerTime: 50 * time.Millisecond, wantRenewals: true},
+		{name: "handler cancelled once the lease is lost", handlerTime: time.Second, loseLease: true, wantErr: context.Canceled, wantCancelled: true, wantRenewals: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &leaseStore{}
+			if tt.loseLease {
+				store.loseLease()
+			}
+
+			cancelled := false
+			listener := NewConsumer(WithUpdater(store), WithGroup("mailer"), WithOwner("listener-1"), WithLease(30*time.Millisecond)).
+				SetHandler(testKey, func(ctx context.Context, msg events.Event) error {
+					select {
+					case <-time.After(tt.handlerTime):
+						return nil
+					case <-ctx.Done():
+						cancelled = true
+						return ctx.Err()
+					}
+				})
+
+			message := events.Event{
+				ID:         primitive.NewObjectID(),
+				EventKey:   testKey,
+				Deliveries: map[string]events.Delivery{"mailer": {Status: events.DeliveryInProgress, Owner: "listener-1", Attempts: 1}},
+			}
+
+			err := listener.handle(context.Background(), message)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("handle() error = %v, want %v", err, tt.wantErr)
+			}
+			if cancelled != tt.wantCancelled {
+				t.Errorf("handler cancelled = %v, want %v", cancelled, tt.wantCancelled)
+			}
+			if renewed := store.renewed() > 0; renewed != tt.wantRenewals {
+				t.Errorf("lease renewed = %v, want %v", renewed, tt.wantRenewals)
+			}
+
+			// every write is conditional on still holding the lease
+			for _, filter := range store.filters {
+				owner := filter.(bson.D).Map()["deliveries.mailer.owner"]
+				if owner != "listener-1" {
+					t.Errorf("update filter %v isn't limited to the lease holder", filter)
+				}
+			}
+		})
+	}
+}
+
+func TestCompleteAfterLeaseLost(t *testing.T) {
+	store := &leaseStore{}
+	store.loseLease()
+
+	listener := NewConsumer(WithUpdater(store), WithOwner("listener-1"))
+
+	err := listener.complete(context.Background(), events.Event{ID: primitive.NewObjectID(), EventKey: testKey})
+	if !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("complete() error = %v, want %v", err, ErrLeaseLost)
+	}
+}
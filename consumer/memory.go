@@ -0,0 +1,280 @@
+package consumer
+
+import (
+	"context"
+	"log"
+	"maps"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/tejiriaustin/narx_api/database"
+	"github.com/tejiriaustin/narx_api/events"
+)
+
+// accountIdFields are the body fields events name the account they are about with.
+var accountIdFields = []string{"account_id", "id"}
+
+var (
+	_ Claimer = (*MemoryQueue)(nil)
+	_ Updater = (*MemoryQueue)(nil)
+	_ Fetcher = (*MemoryQueue)(nil)
+)
+
+type (
+	// MemoryQueue keeps events in process memory, for tests and for running the api and listener as
+	// one process with nothing to connect to. It publishes like publisher.Publisher and stands in for
+	// the events collection, so consumers claim, lease, retry and dead-letter its events exactly as
+	// they do those in Mongo. On top of that, events with the same key for the same account are
+	// handled one at a time in the order they were published. Events are dropped once every group
+	// listening has handled them, and lost if the process exits.
+	MemoryQueue struct {
+		mu     sync.Mutex
+		events *database.MemoryCollection
+		groups map[string]*memoryGroup
+	}
+
+	// memoryGroup is the consumers of a group listening on a MemoryQueue.
+	memoryGroup struct {
+		keys  map[string]bool
+		wakes []chan struct{}
+	}
+)
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{
+		events: database.NewMemoryCollection(),
+		groups: make(map[string]*memoryGroup),
+	}
+}
+
+func (q *MemoryQueue) Publish(ctx context.Context, key, kind string, message map[string]interface{}) error {
+	if err := events.Validate(key, message); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, err := q.events.InsertOne(ctx, events.Event{
+		ID:        primitive.NewObjectID(),
+		EventKind: kind,
+		EventKey:  key,
+		MsgBody:   maps.Clone(message),
+	})
+	if err != nil {
+		return err
+	}
+
+	q.notify()
+	return nil
+}
+
+// Published returns the events the queue still holds, oldest first.
+func (q *MemoryQueue) Published() []events.Event {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	published, err := q.all(context.Background())
+	if err != nil {
+		log.Printf("failed to read the memory queue: %s", err)
+	}
+	return published
+}
+
+// FindOneAndUpdate claims an event like the events collection does, except that a group is only
+// offered the oldest event it hasn't handled for each key and account.
+func (q *MemoryQueue) FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if group := claimingGroup(update); group != "" {
+		next, err := q.nextInOrder(ctx, group)
+		if err != nil {
+			return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+		}
+		filter = bson.D{{Key: "$and", Value: bson.A{filter, bson.M{"_id": bson.M{"$in": next}}}}}
+	}
+
+	return q.events.FindOneAndUpdate(ctx, filter, update, opts...)
+}
+
+// UpdateOne records a consumer's progress with an event, then drops the events every group has
+// handled and wakes the consumers, since the next event for the account may now be claimed.
+func (q *MemoryQueue) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	res, err := q.events.UpdateOne(ctx, filter, update, opts...)
+	if err != nil || res.ModifiedCount == 0 {
+		return res, err
+	}
+
+	if err = q.prune(ctx); err != nil {
+		log.Printf("failed to drop handled events from the memory queue: %s", err)
+	}
+	q.notify()
+	return res, nil
+}
+
+func (q *MemoryQueue) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	return q.events.Find(ctx, filter, opts...)
+}
+
+// ListenAndServeMemory handles events from an in-memory queue until ctx is cancelled, the same way
+// ListenAndServe does for the events collection. The queue wakes the consumer whenever an event is
+// published or settled, so there is no change stream to watch.
+func (l *Consumer) ListenAndServeMemory(ctx context.Context, q *MemoryQueue) {
+	q.subscribe(l.group, l.keys(), l.wake)
+
+	l.updater = q
+	l.watcher = nil
+
+	l.ListenAndServe(ctx, q)
+}
+
+func (q *MemoryQueue) subscribe(group string, keys []string, wake chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	g, ok := q.groups[group]
+	if !ok {
+		g = &memoryGroup{keys: make(map[string]bool)}
+		q.groups[group] = g
+	}
+	for _, key := range keys {
+		g.keys[key] = true
+	}
+	g.wakes = append(g.wakes, wake)
+}
+
+// nextInOrder returns the ids of the events the group may claim: for each key and account, the
+// oldest event the group hasn't finished with. Events that aren't about an account aren't ordered.
+// Callers must hold the lock.
+func (q *MemoryQueue) nextInOrder(ctx context.Context, group string) ([]primitive.ObjectID, error) {
+	published, err := q.all(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	next := []primitive.ObjectID{}
+	waiting := make(map[string]bool)
+	for _, e := range published {
+		if finished(e, group) {
+			continue
+		}
+		if key := orderingKey(e); key != "" {
+			if waiting[key] {
+				continue
+			}
+			waiting[key] = true
+		}
+		next = append(next, e.ID)
+	}
+	return next, nil
+}
+
+// prune drops the events every group listening has handled. Dead events are kept so they can be
+// requeued. Callers must hold the lock.
+func (q *MemoryQueue) prune(ctx context.Context) error {
+	if len(q.groups) == 0 {
+		return nil
+	}
+
+	published, err := q.all(ctx)
+	if err != nil {
+		return err
+	}
+
+	var handled []primitive.ObjectID
+	for _, e := range published {
+		if q.handled(e) {
+			handled = append(handled, e.ID)
+		}
+	}
+	if len(handled) == 0 {
+		return nil
+	}
+
+	_, err = q.events.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": handled}})
+	return err
+}
+
+// handled reports whether every group listening for the event's key has handled it.
+func (q *MemoryQueue) handled(e events.Event) bool {
+	for name, g := range q.groups {
+		if g.keys[e.EventKey] && e.Deliveries[name].Status != events.DeliveryDone {
+			return false
+		}
+	}
+	return true
+}
+
+// all returns every event the queue holds, oldest first. Callers must hold the lock.
+func (q *MemoryQueue) all(ctx context.Context) ([]events.Event, error) {
+	cur, err := q.events.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var published []events.Event
+	if err = cur.All(ctx, &published); err != nil {
+		return nil, err
+	}
+	return published, nil
+}
+
+func (q *MemoryQueue) notify() {
+	for _, g := range q.groups {
+		for _, wake := range g.wakes {
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// claimingGroup returns the group a claim is made for, read from the delivery owner it sets, or ""
+// if update isn't a claim.
+func claimingGroup(update interface{}) string {
+	operators, ok := update.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	fields, ok := operators["$set"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	for path := range fields {
+		group, ok := strings.CutPrefix(path, "deliveries.")
+		if !ok {
+			continue
+		}
+		if group, ok = strings.CutSuffix(group, ".owner"); ok {
+			return group
+		}
+	}
+	return ""
+}
+
+// orderingKey groups the events handled in order, those with the same key for the same account.
+// Events that aren't about an account aren't ordered.
+func orderingKey(e events.Event) string {
+	for _, field := range accountIdFields {
+		if id, _ := e.MsgBody[field].(string); id != "" {
+			return e.EventKey + ":" + id
+		}
+	}
+	return ""
+}
+
+func finished(e events.Event, group string) bool {
+	d, ok := e.Deliveries[group]
+	return ok && (d.Status == events.DeliveryDone || d.Status == events.DeliveryDead)
+}
@@ -0,0 +1,357 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/tejiriaustin/narx_api/events"
+	"github.com/tejiriaustin/narx_api/models"
+)
+
+const testKey = "TEST.EVENT"
+
+// deadLetterStore keeps the dead letters a consumer stores.
+type deadLetterStore struct {
+	mu          sync.Mutex
+	deadLetters []models.DeadLetter
+}
+
+func (s *deadLetterStore) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deadLetters = append(s.deadLetters, document.(models.DeadLetter))
+	return &mongo.InsertOneResult{}, nil
+}
+
+func (s *deadLetterStore) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.deadLetters)
+}
+
+// counter counts the calls to a handler.
+type counter struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (c *counter) inc() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	return c.calls
+}
+
+func (c *counter) get() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+func TestMemoryQueueDelivery(t *testing.T) {
+	tests := []struct {
+		name            string
+		failures        int
+		maxAttempts     int
+		wantCalls       int
+		wantDeadLetters int
+	}{
+		{name: "acknowledged once handled", failures: 0, maxAttempts: 3, wantCalls: 1, wantDeadLetters: 0},
+		{name: "retried until handled", failures: 2, maxAttempts: 3, wantCalls: 3, wantDeadLetters: 0},
+		{name: "dead-lettered once out of attempts", failures: 10, maxAttempts: 3, wantCalls: 3, wantDeadLetters: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := NewMemoryQueue()
+			deadLetters := &deadLetterStore{}
+			handled := &counter{}
+
+			listener := NewConsumer(
+				WithRefreshTime(5*time.Millisecond),
+				WithRetryPolicy(RetryPolicy{MaxAttempts: tt.maxAttempts, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}),
+				WithDeadLetters(deadLetters),
+			).SetHandler(testKey, func(ctx context.Context, msg events.Event) error {
+				if handled.inc() <= tt.failures {
+					return errors.New("handler failed")
+				}
+				return nil
+			})
+
+			stop := serveMemory(t, queue, listener)
+
+			if err := queue.Publish(context.Background(), testKey, "test", map[string]interface{}{"id": "a"}); err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+
+			// the queue drops an event once every group has handled it, and keeps it if it went dead
+			eventually(t, func() bool {
+				published := queue.Published()
+				return len(published) == 0 || published[0].Deliveries[defaultGroup].Status == events.DeliveryDead
+			})
+			stop()
+
+			if got := handled.get(); got != tt.wantCalls {
+				t.Errorf("handler called %d times, want %d", got, tt.wantCalls)
+			}
+			if got := deadLetters.count(); got != tt.wantDeadLetters {
+				t.Errorf("%d dead letters stored, want %d", got, tt.wantDeadLetters)
+			}
+			if got := len(queue.Published()); got != tt.wantDeadLetters {
+				t.Errorf("queue holds %d events, want %d", got, tt.wantDeadLetters)
+			}
+		})
+	}
+}
+
+func TestMemoryQueueKeyRetryPolicy(t *testing.T) {
+	const otherKey = "OTHER.EVENT"
+
+	queue := NewMemoryQueue()
+	deadLetters := &deadLetterStore{}
+	handled := map[string]*counter{testKey: {}, otherKey: {}}
+	failing := func(key string) Handler {
+		return func(ctx context.Context, msg events.Event) error {
+			handled[key].inc()
+			return errors.New("handler failed")
+		}
+	}
+
+	listener := NewConsumer(
+		WithRefreshTime(5*time.Millisecond),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}),
+		WithDeadLetters(deadLetters),
+	).
+		SetHandler(testKey, failing(testKey)).
+		SetHandler(otherKey, failing(otherKey)).
+		SetRetryPolicy(testKey, RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	serveMemory(t, queue, listener)
+
+	for _, key := range []string{testKey, otherKey} {
+		if err := queue.Publish(context.Background(), key, "test", map[string]interface{}{"id": key}); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+	eventually(t, func() bool { return deadLetters.count() == 2 })
+
+	deadLetters.mu.Lock()
+	defer deadLetters.mu.Unlock()
+
+	wantAttempts := map[string]int{testKey: 2, otherKey: 5}
+	for _, deadLetter := range deadLetters.deadLetters {
+		if deadLetter.Attempts != wantAttempts[deadLetter.EventKey] {
+			t.Errorf("%s dead-lettered after %d attempts, want %d", deadLetter.EventKey, deadLetter.Attempts, wantAttempts[deadLetter.EventKey])
+		}
+		if got := handled[deadLetter.EventKey].get(); got != wantAttempts[deadLetter.EventKey] {
+			t.Errorf("%s handled %d times, want %d", deadLetter.EventKey, got, wantAttempts[deadLetter.EventKey])
+		}
+	}
+}
+
+func TestMemoryQueueRequeue(t *testing.T) {
+	queue := NewMemoryQueue()
+	deadLetters := &deadLetterStore{}
+	handled := &counter{}
+
+	listener := NewConsumer(
+		WithRefreshTime(5*time.Millisecond),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}),
+		WithDeadLetters(deadLetters),
+	).SetHandler(testKey, func(ctx context.Context, msg events.Event) error {
+		if handled.inc() == 1 {
+			return errors.New("handler failed")
+		}
+		return nil
+	})
+	serveMemory(t, queue, listener)
+
+	if err := queue.Publish(context.Background(), testKey, "test", map[string]interface{}{"id": "a"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	eventually(t, func() bool { return deadLetters.count() == 1 })
+
+	deadLetters.mu.Lock()
+	deadLetter := deadLetters.deadLetters[0]
+	deadLetters.mu.Unlock()
+
+	if err := NewCollectionRequeuer(queue).Requeue(context.Background(), deadLetter); err != nil {
+		t.Fatalf("Requeue() error = %v", err)
+	}
+	eventually(t, func() bool { return len(queue.Published()) == 0 })
+
+	if got := handled.get(); got != 2 {
+		t.Errorf("handler called %d times, want 2", got)
+	}
+}
+
+func TestMemoryQueueGroups(t *testing.T) {
+	queue := NewMemoryQueue()
+
+	mailers, webhooks := &counter{}, &counter{}
+	handler := func(c *counter) Handler {
+		return func(ctx context.Context, msg events.Event) error {
+			c.inc()
+			return nil
+		}
+	}
+
+	// two consumers share the mailer group, one listens in a group of its own
+	stops := []func(){
+		serveMemory(t, queue, NewConsumer(WithGroup("mailer"), WithRefreshTime(5*time.Millisecond)).SetHandler(testKey, handler(mailers))),
+		serveMemory(t, queue, NewConsumer(WithGroup("mailer"), WithRefreshTime(5*time.Millisecond)).SetHandler(testKey, handler(mailers))),
+		serveMemory(t, queue, NewConsumer(WithGroup("webhooks"), WithRefreshTime(5*time.Millisecond)).SetHandler(testKey, handler(webhooks))),
+	}
+
+	// events are dropped once the groups listening have finished with them, so let both subscribe first
+	eventually(t, func() bool {
+		queue.mu.Lock()
+		defer queue.mu.Unlock()
+		return len(queue.groups) == 2
+	})
+
+	const published = 5
+	for i := 0; i < published; i++ {
+		if err := queue.Publish(context.Background(), testKey, "test", map[string]interface{}{}); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+
+	eventually(t, func() bool { return len(queue.Published()) == 0 })
+	for _, stop := range stops {
+		stop()
+	}
+
+	if got := mailers.get(); got != published {
+		t.Errorf("mailer group handled %d events, want %d", got, published)
+	}
+	if got := webhooks.get(); got != published {
+		t.Errorf("webhooks group handled %d events, want %d", got, published)
+	}
+}
+
+func TestMemoryQueueClaimOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		first  map[string]interface{}
+		second map[string]interface{}
+
+		// wantSecond is whether the second event can be claimed while the first is in progress
+		wantSecond bool
+	}{
+		{name: "same account waits", first: map[string]interface{}{"id": "a"}, second: map[string]interface{}{"id": "a"}, wantSecond: false},
+		{name: "same account by account_id waits", first: map[string]interface{}{"account_id": "a"}, second: map[string]interface{}{"account_id": "a"}, wantSecond: false},
+		{name: "other account goes ahead", first: map[string]interface{}{"id": "a"}, second: map[string]interface{}{"id": "b"}, wantSecond: true},
+		{name: "events without an account are not ordered", first: map[string]interface{}{}, second: map[string]interface{}{}, wantSecond: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			queue := NewMemoryQueue()
+			listener := NewConsumer(WithUpdater(queue), WithOwner("listener-1")).
+				SetHandler(testKey, func(ctx context.Context, msg events.Event) error { return nil })
+			queue.subscribe(listener.group, listener.keys(), listener.wake)
+
+			_ = queue.Publish(ctx, testKey, "test", tt.first)
+			_ = queue.Publish(ctx, testKey, "test", tt.second)
+
+			first, err := listener.claim(ctx, queue)
+			if err != nil {
+				t.Fatalf("first claim() error = %v", err)
+			}
+
+			second, err := listener.claim(ctx, queue)
+			if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+				t.Fatalf("second claim() error = %v", err)
+			}
+			if claimed := err == nil; claimed != tt.wantSecond {
+				t.Fatalf("second event claimed = %v, want %v", claimed, tt.wantSecond)
+			}
+			if err == nil && second.ID == first.ID {
+				t.Fatal("the same event was claimed twice")
+			}
+
+			if !tt.wantSecond {
+				if err = listener.complete(ctx, first); err != nil {
+					t.Fatalf("complete() error = %v", err)
+				}
+				if second, err = listener.claim(ctx, queue); err != nil || second.ID == first.ID {
+					t.Fatalf("second claim() once the first was done = %v, %v", second.ID, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryQueueOtherGroupsAreNotOrdered(t *testing.T) {
+	ctx := context.Background()
+	queue := NewMemoryQueue()
+	mailer := NewConsumer(WithUpdater(queue), WithGroup("mailer")).SetHandler(testKey, func(ctx context.Context, msg events.Event) error { return nil })
+	webhooks := NewConsumer(WithUpdater(queue), WithGroup("webhooks")).SetHandler(testKey, func(ctx context.Context, msg events.Event) error { return nil })
+	queue.subscribe(mailer.group, mailer.keys(), mailer.wake)
+	queue.subscribe(webhooks.group, webhooks.keys(), webhooks.wake)
+
+	_ = queue.Publish(ctx, testKey, "test", map[string]interface{}{"id": "a"})
+	_ = queue.Publish(ctx, testKey, "test", map[string]interface{}{"id": "a"})
+
+	// the mailer handling the first event doesn't hold back the webhooks group
+	first, err := mailer.claim(ctx, queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := webhooks.claim(ctx, queue)
+	if err != nil || claimed.ID != first.ID {
+		t.Fatalf("webhooks claim() = %v, %v, want the first event", claimed.ID, err)
+	}
+	if err = webhooks.complete(ctx, claimed); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, err = webhooks.claim(ctx, queue); err != nil || claimed.ID == first.ID {
+		t.Fatalf("webhooks claim() = %v, %v, want the second event", claimed.ID, err)
+	}
+
+	if got := len(queue.Published()); got != 2 {
+		t.Errorf("queue holds %d events, want both until the mailer handles them", got)
+	}
+}
+
+// serveMemory runs the consumer on queue until the returned func is called, which waits for it to stop.
+func serveMemory(t *testing.T, queue *MemoryQueue, listener *Consumer) func() {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		listener.ListenAndServeMemory(ctx, queue)
+	}()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+func eventually(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -1,7 +1,78 @@
 package consumer
 
+import (
+	"strings"
+	"time"
+)
+
 func WithUpdater(u Updater) Options {
 	return func(c *Consumer) {
 		c.updater = u
 	}
 }
+
+// WithGroup sets the consumer group. Every group handles each event once, so listeners doing the
+// same work share a group and listeners doing different work on the same events use their own.
+// Group names are used in field paths, so dots and dollars are replaced.
+func WithGroup(group string) Options {
+	return func(c *Consumer) {
+		if group != "" {
+			c.group = strings.NewReplacer(".", "_", "$", "_").Replace(group)
+		}
+	}
+}
+
+// WithOwner names this consumer within its group, it defaults to the host, process id and a random suffix.
+func WithOwner(owner string) Options {
+	return func(c *Consumer) {
+		c.owner = owner
+	}
+}
+
+// WithLease sets how long a claimed event is reserved for this consumer between renewals.
+func WithLease(lease time.Duration) Options {
+	return func(c *Consumer) {
+		if lease > 0 {
+			c.lease = lease
+		}
+	}
+}
+
+func WithRefreshTime(d time.Duration) Options {
+	return func(c *Consumer) {
+		if d > 0 {
+			c.refreshTime = d
+		}
+	}
+}
+
+func WithMaxWorkers(n uint) Options {
+	return func(c *Consumer) {
+		if n > 0 {
+			c.maxWorkerChans = n
+		}
+	}
+}
+
+// WithRetryPolicy sets the retry policy for event keys without one of their own.
+func WithRetryPolicy(policy RetryPolicy) Options {
+	return func(c *Consumer) {
+		c.defaultRetryPolicy = policy
+	}
+}
+
+// WithDeadLetters stores events that run out of attempts, so they can be inspected and requeued.
+func WithDeadLetters(i Inserter) Options {
+	return func(c *Consumer) {
+		c.deadLetters = i
+	}
+}
+
+// WithChangeStream has the consumer watch the events collection and claim new events as soon as
+// they are published, keeping the group's resume token in tokens.
+func WithChangeStream(w Watcher, tokens TokenStore) Options {
+	return func(c *Consumer) {
+		c.watcher = w
+		c.tokens = tokens
+	}
+}
@@ -0,0 +1,412 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+
+	"github.com/tejiriaustin/narx_api/events"
+	"github.com/tejiriaustin/narx_api/models"
+)
+
+// retryScheduleInterval is how often failed entries that are due are added back to the stream.
+const retryScheduleInterval = time.Second
+
+// Fields the consumer adds to an entry it adds back to the stream for a retry.
+const (
+	streamFieldGroup     = "group"
+	streamFieldAttempts  = "attempts"
+	streamFieldLastError = "last_error"
+)
+
+// retryScript moves due entries from a group's retry set back onto the stream, in one step so an
+// entry is neither lost nor added twice when listeners share the group.
+var retryScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 100)
+for _, member in ipairs(due) do
+	redis.call('ZREM', KEYS[1], member)
+
+	local args = {}
+	if tonumber(ARGV[2]) > 0 then
+		table.insert(args, 'MAXLEN')
+		table.insert(args, '~')
+		table.insert(args, ARGV[2])
+	end
+	table.insert(args, '*')
+	for field, value in pairs(cjson.decode(member)) do
+		table.insert(args, field)
+		table.insert(args, value)
+	end
+	redis.call('XADD', KEYS[2], unpack(args))
+end
+return #due
+`)
+
+// streamRequeuer adds dead-lettered events back to a Redis stream.
+type streamRequeuer struct {
+	rdb    redis.Cmdable
+	stream string
+	maxLen int64
+}
+
+// NewStreamRequeuer requeues dead letters of events published to stream. The stream's entries are
+// gone once acknowledged, so the event is added to it again, tagged with the group that gave up on
+// it so no other group handles it twice, and trimmed to about maxLen entries like the publisher's.
+func NewStreamRequeuer(rdb redis.Cmdable, stream string, maxLen int64) Requeuer {
+	return &streamRequeuer{
+		rdb:    rdb,
+		stream: stream,
+		maxLen: maxLen,
+	}
+}
+
+func (r *streamRequeuer) Requeue(ctx context.Context, deadLetter models.DeadLetter) error {
+	eventId, err := primitive.ObjectIDFromHex(deadLetter.EventId)
+	if err != nil || deadLetter.EventKey == "" {
+		// entries that couldn't be decoded are dead-lettered as they were read
+		return errors.New("dead letter is not a valid event and can't be requeued")
+	}
+
+	values, err := events.Event{
+		ID:        eventId,
+		EventKey:  deadLetter.EventKey,
+		EventKind: deadLetter.EventKind,
+		MsgBody:   deadLetter.MsgBody,
+	}.StreamValues()
+	if err != nil {
+		return err
+	}
+	values[streamFieldGroup] = deadLetter.Group
+
+	return r.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: r.stream,
+		MaxLen: r.maxLen,
+		Approx: true,
+		Values: values,
+	}).Err()
+}
+
+// streamConsumer reads a Redis stream for a Consumer.
+type streamConsumer struct {
+	consumer *Consumer
+	rdb      redis.Cmdable
+	stream   string
+	maxLen   int64
+
+	// cursor is where the next XAUTOCLAIM picks up scanning the group's pending entries.
+	cursor string
+}
+
+// ListenAndServeStream handles events from a Redis stream instead of the events collection, until
+// ctx is cancelled. The consumer's group is a consumer group on the stream and its owner the
+// consumer's name in it. An entry is leased to the consumer that read it while it is pending, and
+// the consumer renews the lease by reclaiming the entry while the handler runs. Entries left pending
+// for longer than the lease, by a listener that died mid-handler, are taken over with XAUTOCLAIM.
+// Failed entries are acknowledged and added back to the stream once their backoff has passed, and
+// dead-lettered when they run out of attempts. Entries for keys without a handler are acknowledged
+// and skipped, so every listener in a group must handle the same keys. Retries are added back to
+// the stream trimmed to about maxLen entries, like the publisher's.
+func (l *Consumer) ListenAndServeStream(ctx context.Context, rdb redis.Cmdable, stream string, maxLen int64) {
+	log.Print("initializing  Consumer on stream ", stream)
+
+	s := &streamConsumer{
+		consumer: l,
+		rdb:      rdb,
+		stream:   stream,
+		maxLen:   maxLen,
+		cursor:   "0-0",
+	}
+
+	err := rdb.XGroupCreateMkStream(ctx, stream, l.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		zap.L().Error("failed to create consumer group", zap.Error(err), zap.String("group", l.group))
+		return
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.scheduleRetries(ctx)
+	}()
+
+	l.serve(ctx, s.next)
+}
+
+func (s *streamConsumer) next(ctx context.Context) (task, error) {
+	entry, err := s.read(ctx)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context) {
+		if err := s.handle(ctx, *entry); err != nil {
+			zap.L().Error(err.Error(), zap.String("entry", entry.ID))
+		}
+	}, nil
+}
+
+// read reclaims an entry whose lease has run out, or else waits up to refreshTime for a new one.
+func (s *streamConsumer) read(ctx context.Context) (*redis.XMessage, error) {
+	l := s.consumer
+
+	claimed, cursor, err := s.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   s.stream,
+		Group:    l.group,
+		Consumer: l.owner,
+		MinIdle:  l.lease,
+		Start:    s.cursor,
+		Count:    1,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	s.cursor = cursor
+
+	if len(claimed) > 0 {
+		return &claimed[0], nil
+	}
+
+	streams, err := s.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    l.group,
+		Consumer: l.owner,
+		Streams:  []string{s.stream, ">"},
+		Count:    1,
+		Block:    l.refreshTime,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		// the read has already waited refreshTime
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stream := range streams {
+		if len(stream.Messages) > 0 {
+			return &stream.Messages[0], nil
+		}
+	}
+	return nil, nil
+}
+
+// handle runs the entry's handler while renewing its lease, like Consumer.handle does for events
+// in the collection.
+func (s *streamConsumer) handle(ctx context.Context, entry redis.XMessage) error {
+	l := s.consumer
+
+	if group, _ := entry.Values[streamFieldGroup].(string); group != "" && group != l.group {
+		// a retry meant for another group
+		return s.ack(ctx, entry.ID)
+	}
+
+	message, err := events.EventFromStream(entry.Values)
+	if err != nil {
+		// an entry that can't be decoded never will be
+		message.MsgBody = entry.Values
+		return errors.Join(err, s.deadLetter(ctx, entry, message, 0, err))
+	}
+
+	if l.handlers[message.EventKey] == nil {
+		return s.ack(ctx, entry.ID)
+	}
+
+	attempts, err := s.attempts(ctx, entry)
+	if err != nil {
+		return err
+	}
+
+	if max := l.retryPolicy(message.EventKey).MaxAttempts; attempts > max {
+		return s.deadLetter(ctx, entry, message, attempts, fmt.Errorf("handler did not finish in %d attempts", max))
+	}
+
+	handlerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		s.renewLease(handlerCtx, cancel, entry.ID)
+	}()
+
+	err = l.dispatcher(handlerCtx, message)
+
+	cancel()
+	<-renewed
+
+	if err != nil {
+		return s.fail(ctx, entry, message, attempts, err)
+	}
+	return s.complete(ctx, entry.ID)
+}
+
+// attempts counts this delivery of the entry along with those before it, including the attempts
+// of earlier entries for the same event that failed and were added back to the stream.
+func (s *streamConsumer) attempts(ctx context.Context, entry redis.XMessage) (int, error) {
+	pending, err := s.pending(ctx, entry.ID)
+	if err != nil {
+		return 0, err
+	}
+	if pending == nil || pending.Consumer != s.consumer.owner {
+		return 0, fmt.Errorf("%w before %s was started", ErrLeaseLost, entry.ID)
+	}
+
+	previous, _ := entry.Values[streamFieldAttempts].(string)
+	attempts, _ := strconv.Atoi(previous)
+
+	return attempts + int(pending.RetryCount), nil
+}
+
+// renewLease reclaims the entry for this consumer every third of the lease, which keeps it from
+// ever being idle long enough for another consumer to take it over.
+func (s *streamConsumer) renewLease(ctx context.Context, cancel context.CancelFunc, id string) {
+	l := s.consumer
+
+	ticker := time.NewTicker(l.lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pending, err := s.pending(ctx, id)
+		if err != nil {
+			// the lease is still ours until it expires, try again on the next tick
+			zap.L().Warn("failed to renew lease", zap.Error(err), zap.String("entry", id))
+			continue
+		}
+		if pending == nil || pending.Consumer != l.owner {
+			zap.L().Warn("lease lost, cancelling handler", zap.String("entry", id))
+			cancel()
+			return
+		}
+
+		err = s.rdb.XClaimJustID(ctx, &redis.XClaimArgs{
+			Stream:   s.stream,
+			Group:    l.group,
+			Consumer: l.owner,
+			Messages: []string{id},
+		}).Err()
+		if err != nil {
+			zap.L().Warn("failed to renew lease", zap.Error(err), zap.String("entry", id))
+		}
+	}
+}
+
+// pending returns the group's pending entry for id, or nil if it isn't pending.
+func (s *streamConsumer) pending(ctx context.Context, id string) (*redis.XPendingExt, error) {
+	pending, err := s.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: s.stream,
+		Group:  s.consumer.group,
+		Start:  id,
+		End:    id,
+		Count:  1,
+	}).Result()
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+	return &pending[0], nil
+}
+
+// complete acknowledges the entry, which fails if another consumer has already taken it over and
+// acknowledged it.
+func (s *streamConsumer) complete(ctx context.Context, id string) error {
+	acked, err := s.rdb.XAck(ctx, s.stream, s.consumer.group, id).Result()
+	if err != nil {
+		zap.L().Error("failed to acknowledge entry", zap.Error(err), zap.String("entry", id))
+		return err
+	}
+	if acked == 0 {
+		return fmt.Errorf("%w before %s was completed", ErrLeaseLost, id)
+	}
+	return nil
+}
+
+func (s *streamConsumer) ack(ctx context.Context, id string) error {
+	return s.rdb.XAck(ctx, s.stream, s.consumer.group, id).Err()
+}
+
+// fail puts the entry in the group's retry set until its backoff has passed, or dead-letters it once
+// it is out of attempts. If the entry can't be scheduled it is left pending, to be taken over
+// once its lease runs out.
+func (s *streamConsumer) fail(ctx context.Context, entry redis.XMessage, message events.Event, attempts int, cause error) error {
+	l := s.consumer
+	policy := l.retryPolicy(message.EventKey)
+
+	if attempts >= policy.MaxAttempts {
+		return errors.Join(cause, s.deadLetter(ctx, entry, message, attempts, cause))
+	}
+
+	retry := make(map[string]interface{}, len(entry.Values)+3)
+	for field, value := range entry.Values {
+		retry[field] = value
+	}
+	retry[streamFieldGroup] = l.group
+	retry[streamFieldAttempts] = strconv.Itoa(attempts)
+	retry[streamFieldLastError] = cause.Error()
+
+	member, err := json.Marshal(retry)
+	if err != nil {
+		return errors.Join(cause, err)
+	}
+
+	err = s.rdb.ZAdd(ctx, s.retryKey(), redis.Z{
+		Score:  float64(time.Now().Add(policy.Backoff(attempts)).UnixMilli()),
+		Member: string(member),
+	}).Err()
+	if err != nil {
+		return errors.Join(cause, err)
+	}
+
+	return errors.Join(cause, s.ack(ctx, entry.ID))
+}
+
+// deadLetter copies the event to the dead letter store, then acknowledges its entry so the group
+// stops reading it.
+func (s *streamConsumer) deadLetter(ctx context.Context, entry redis.XMessage, message events.Event, attempts int, cause error) error {
+	if err := s.consumer.storeDeadLetter(ctx, message, attempts, cause); err != nil {
+		// leave the entry pending so it is dead-lettered once taken over
+		return err
+	}
+	return s.ack(ctx, entry.ID)
+}
+
+// scheduleRetries adds failed entries back to the stream as they come due, until ctx is cancelled.
+func (s *streamConsumer) scheduleRetries(ctx context.Context) {
+	ticker := time.NewTicker(retryScheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+		err := retryScript.Run(ctx, s.rdb, []string{s.retryKey(), s.stream}, now, s.maxLen).Err()
+		if err != nil && ctx.Err() == nil {
+			zap.L().Error("failed to schedule retries", zap.Error(err), zap.String("group", s.consumer.group))
+		}
+	}
+}
+
+func (s *streamConsumer) retryKey() string {
+	return s.stream + ":retries:" + s.consumer.group
+}
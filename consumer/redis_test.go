@@ -0,0 +1,175 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/events"
+	"github.com/tejiriaustin/narx_api/models"
+)
+
+// streamStub answers the stream commands the consumer's lease handling uses. Any other command
+// panics on the nil embedded client.
+type streamStub struct {
+	redis.Cmdable
+
+	pending []redis.XPendingExt
+	acked   int64
+	added   []*redis.XAddArgs
+}
+
+func (s *streamStub) XPendingExt(ctx context.Context, a *redis.XPendingExtArgs) *redis.XPendingExtCmd {
+	cmd := redis.NewXPendingExtCmd(ctx)
+	cmd.SetVal(s.pending)
+	return cmd
+}
+
+func (s *streamStub) XAck(ctx context.Context, stream, group string, ids ...string) *redis.IntCmd {
+	return redis.NewIntResult(s.acked, nil)
+}
+
+func (s *streamStub) XAdd(ctx context.Context, a *redis.XAddArgs) *redis.StringCmd {
+	s.added = append(s.added, a)
+	return redis.NewStringResult("1700000000000-0", nil)
+}
+
+func TestStreamAttempts(t *testing.T) {
+	tests := []struct {
+		name     string
+		pending  []redis.XPendingExt
+		previous string
+		want     int
+		wantErr  error
+	}{
+		{name: "first delivery", pending: []redis.XPendingExt{{Consumer: "listener-1", RetryCount: 1}}, want: 1},
+		{name: "redelivered after a lease ran out", pending: []redis.XPendingExt{{Consumer: "listener-1", RetryCount: 2}}, want: 2},
+		{name: "counts attempts of earlier entries", pending: []redis.XPendingExt{{Consumer: "listener-1", RetryCount: 1}}, previous: "3", want: 4},
+		{name: "taken over by another consumer", pending: []redis.XPendingExt{{Consumer: "listener-2", RetryCount: 2}}, wantErr: ErrLeaseLost},
+		{name: "no longer pending", pending: nil, wantErr: ErrLeaseLost},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &streamConsumer{
+				consumer: NewConsumer(WithGroup("mailer"), WithOwner("listener-1")),
+				rdb:      &streamStub{pending: tt.pending},
+				stream:   "narx:events",
+			}
+
+			entry := redis.XMessage{ID: "1700000000000-0", Values: map[string]interface{}{}}
+			if tt.previous != "" {
+				entry.Values[streamFieldAttempts] = tt.previous
+			}
+
+			got, err := s.attempts(context.Background(), entry)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("attempts() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("attempts() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamComplete(t *testing.T) {
+	tests := []struct {
+		name    string
+		acked   int64
+		wantErr error
+	}{
+		{name: "acknowledged while held", acked: 1},
+		{name: "acknowledged by the consumer that took it over", acked: 0, wantErr: ErrLeaseLost},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &streamConsumer{
+				consumer: NewConsumer(WithGroup("mailer"), WithOwner("listener-1")),
+				rdb:      &streamStub{acked: tt.acked},
+				stream:   "narx:events",
+			}
+
+			if err := s.complete(context.Background(), "1700000000000-0"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("complete() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStreamRequeuer(t *testing.T) {
+	eventId := primitive.NewObjectID()
+
+	tests := []struct {
+		name       string
+		deadLetter models.DeadLetter
+		wantErr    bool
+	}{
+		{
+			name: "requeued for its group",
+			deadLetter: models.DeadLetter{
+				EventId:   eventId.Hex(),
+				EventKey:  "NOTIFICATION.USER",
+				EventKind: "notification",
+				Group:     "mailer",
+				MsgBody:   map[string]interface{}{"account_id": "a", "title": "Inverter fault"},
+			},
+		},
+		{
+			name:       "entry that couldn't be decoded",
+			deadLetter: models.DeadLetter{EventId: primitive.NilObjectID.Hex(), Group: "mailer", MsgBody: map[string]interface{}{"junk": "1"}},
+			wantErr:    true,
+		},
+		{
+			name:       "invalid event id",
+			deadLetter: models.DeadLetter{EventId: "not-an-id", EventKey: "NOTIFICATION.USER", Group: "mailer"},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &streamStub{}
+			requeuer := NewStreamRequeuer(stub, "narx:events", 1000)
+
+			err := requeuer.Requeue(context.Background(), tt.deadLetter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Requeue() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if len(stub.added) != 0 {
+					t.Error("an invalid dead letter was added to the stream")
+				}
+				return
+			}
+
+			if len(stub.added) != 1 {
+				t.Fatalf("added %d entries, want 1", len(stub.added))
+			}
+			added := stub.added[0]
+			if added.Stream != "narx:events" || added.MaxLen != 1000 || !added.Approx {
+				t.Errorf("added to %s trimmed to %d (approx %v), want narx:events trimmed to about 1000", added.Stream, added.MaxLen, added.Approx)
+			}
+
+			values := added.Values.(map[string]interface{})
+			if values[streamFieldGroup] != tt.deadLetter.Group {
+				t.Errorf("entry tagged for group %v, want %s", values[streamFieldGroup], tt.deadLetter.Group)
+			}
+			if _, ok := values[streamFieldAttempts]; ok {
+				t.Error("requeued entry carries the attempts it ran out of")
+			}
+
+			message, err := events.EventFromStream(values)
+			if err != nil {
+				t.Fatalf("requeued entry can't be read back: %v", err)
+			}
+			if message.ID != eventId || message.EventKey != tt.deadLetter.EventKey || message.MsgBody["title"] != "Inverter fault" {
+				t.Errorf("requeued %+v, want the dead-lettered event", message)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/tejiriaustin/narx_api/events"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+var ErrNotDeadLettered = errors.New("event is not dead-lettered for this group")
+
+// DefaultRetryPolicy is used for event keys without a policy of their own.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 8,
+	BaseDelay:   30 * time.Second,
+	MaxDelay:    time.Hour,
+}
+
+type (
+	// RetryPolicy decides how many times a failing event is tried and how long to wait in between.
+	RetryPolicy struct {
+		MaxAttempts int
+		BaseDelay   time.Duration
+		MaxDelay    time.Duration
+	}
+
+	// Inserter stores dead letters.
+	Inserter interface {
+		InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+	}
+
+	// Requeuer hands a dead-lettered event back to the group that gave up on it, on whichever queue
+	// the event came from.
+	Requeuer interface {
+		Requeue(ctx context.Context, deadLetter models.DeadLetter) error
+	}
+
+	collectionRequeuer struct {
+		updater Updater
+	}
+)
+
+// NewCollectionRequeuer requeues dead letters of events published to the events collection.
+func NewCollectionRequeuer(updater Updater) Requeuer {
+	return &collectionRequeuer{updater: updater}
+}
+
+func (r *collectionRequeuer) Requeue(ctx context.Context, deadLetter models.DeadLetter) error {
+	eventId, err := primitive.ObjectIDFromHex(deadLetter.EventId)
+	if err != nil {
+		return errors.New("dead letter has an invalid event id")
+	}
+	return Requeue(ctx, r.updater, deadLetter.Group, eventId)
+}
+
+// Backoff returns how long to wait after the given number of failed attempts. The wait doubles from
+// BaseDelay up to MaxDelay, and its upper half is random so events that failed together, say while
+// the mail server was down, don't all come back at once.
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay || delay <= 0 {
+		delay = p.MaxDelay
+	}
+
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// SetRetryPolicy overrides the retry policy for events with key.
+func (l *Consumer) SetRetryPolicy(key string, policy RetryPolicy) *Consumer {
+	l.retryPolicies[normaliseKey(key)] = policy
+	return l
+}
+
+func (l *Consumer) retryPolicy(key string) RetryPolicy {
+	if policy, ok := l.retryPolicies[key]; ok {
+		return policy
+	}
+	return l.defaultRetryPolicy
+}
+
+// fail schedules a failed event's next attempt, or dead-letters it once it is out of attempts.
+func (l *Consumer) fail(ctx context.Context, message events.Event, cause error) error {
+	attempts := message.Deliveries[l.group].Attempts
+	policy := l.retryPolicy(message.EventKey)
+
+	if attempts >= policy.MaxAttempts {
+		return errors.Join(cause, l.deadLetter(ctx, message, cause))
+	}
+
+	_, err := l.updater.UpdateOne(ctx, l.ownedBy(message), map[string]interface{}{
+		"$set": map[string]interface{}{
+			l.field("status"):          events.DeliveryPending,
+			l.field("last_error"):      cause.Error(),
+			l.field("next_attempt_at"): time.Now().UTC().Add(policy.Backoff(attempts)),
+		},
+		"$unset": map[string]interface{}{
+			l.field("owner"):            "",
+			l.field("lease_expires_at"): "",
+		},
+	})
+	if err != nil {
+		return errors.Join(cause, err)
+	}
+	return cause
+}
+
+// deadLetter copies the event to the dead letter store, then marks it dead so the group stops claiming it.
+func (l *Consumer) deadLetter(ctx context.Context, message events.Event, cause error) error {
+	if err := l.storeDeadLetter(ctx, message, message.Deliveries[l.group].Attempts, cause); err != nil {
+		// leave the lease to expire so the event is dead-lettered on a later attempt
+		return err
+	}
+
+	_, err := l.updater.UpdateOne(ctx, l.ownedBy(message), map[string]interface{}{
+		"$set": map[string]interface{}{
+			l.field("status"):     events.DeliveryDead,
+			l.field("last_error"): cause.Error(),
+		},
+		"$unset": map[string]interface{}{
+			l.field("owner"):            "",
+			l.field("lease_expires_at"): "",
+		},
+	})
+	return err
+}
+
+// storeDeadLetter keeps a copy of an event the group gave up on, if the consumer has somewhere to keep it.
+func (l *Consumer) storeDeadLetter(ctx context.Context, message events.Event, attempts int, cause error) error {
+	if l.deadLetters == nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	_, err := l.deadLetters.InsertOne(ctx, models.DeadLetter{
+		Shared: models.Shared{
+			ID:        primitive.NewObjectID(),
+			CreatedAt: &now,
+		},
+		EventId:   message.ID.Hex(),
+		EventKey:  message.EventKey,
+		EventKind: message.EventKind,
+		Group:     l.group,
+		MsgBody:   message.MsgBody,
+		Attempts:  attempts,
+		LastError: cause.Error(),
+	})
+	return err
+}
+
+// Requeue gives a dead-lettered event a fresh set of attempts in group, starting straight away.
+func Requeue(ctx context.Context, updater Updater, group string, eventId primitive.ObjectID) error {
+	prefix := "deliveries." + group + "."
+
+	filter := repository.NewQueryFilter().
+		AddFilter("_id", eventId).
+		AddFilter(prefix+"status", events.DeliveryDead)
+
+	res, err := updater.UpdateOne(ctx, filter.GetFilters(), map[string]interface{}{
+		"$set": map[string]interface{}{
+			prefix + "status":          events.DeliveryPending,
+			prefix + "attempts":        0,
+			prefix + "next_attempt_at": time.Now().UTC(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotDeadLettered
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package consumer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		attempts int
+
+		// the wait is random in the upper half of the delay
+		min, max time.Duration
+	}{
+		{name: "first retry waits about the base delay", policy: DefaultRetryPolicy, attempts: 1, min: 15 * time.Second, max: 30 * time.Second},
+		{name: "no attempts yet", policy: DefaultRetryPolicy, attempts: 0, min: 15 * time.Second, max: 30 * time.Second},
+		{name: "delay doubles", policy: DefaultRetryPolicy, attempts: 2, min: 30 * time.Second, max: time.Minute},
+		{name: "delay doubles again", policy: DefaultRetryPolicy, attempts: 3, min: time.Minute, max: 2 * time.Minute},
+		{name: "capped at the max delay", policy: DefaultRetryPolicy, attempts: 8, min: 30 * time.Minute, max: time.Hour},
+		{name: "stays capped", policy: DefaultRetryPolicy, attempts: 1000, min: 30 * time.Minute, max: time.Hour},
+		{
+			name:     "no base delay falls back to the max delay",
+			policy:   RetryPolicy{MaxAttempts: 3, MaxDelay: time.Minute},
+			attempts: 1,
+			min:      30 * time.Second,
+			max:      time.Minute,
+		},
+		{
+			name:     "delays too short to halve are exact",
+			policy:   RetryPolicy{MaxAttempts: 3, BaseDelay: time.Nanosecond, MaxDelay: time.Nanosecond},
+			attempts: 1,
+			min:      time.Nanosecond,
+			max:      time.Nanosecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the jitter is random, so sample it
+			for i := 0; i < 200; i++ {
+				got := tt.policy.Backoff(tt.attempts)
+				if got < tt.min || got > tt.max {
+					t.Fatalf("Backoff(%d) = %s, want between %s and %s", tt.attempts, got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
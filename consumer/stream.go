@@ -0,0 +1,167 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+// server error codes the change stream reacts to
+const (
+	errCodeNotReplicaSet     = 40573
+	errCodeInvalidResume     = 260
+	errCodeStreamFatal       = 280
+	errCodeStreamHistoryLost = 286
+)
+
+type (
+	// Watcher opens change streams on the events collection.
+	Watcher interface {
+		Watch(ctx context.Context, pipeline interface{}, opts ...*options.ChangeStreamOptions) (*mongo.ChangeStream, error)
+	}
+
+	// TokenStore keeps each group's change stream resume token.
+	TokenStore interface {
+		FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+		UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	}
+
+	resumeToken struct {
+		Group     string    `bson:"_id"`
+		Token     bson.Raw  `bson:"token,omitempty"`
+		UpdatedAt time.Time `bson:"updated_at"`
+	}
+)
+
+// watch tails the events collection and wakes the consumer as soon as an event it handles is
+// published, instead of leaving it until the next poll. Claims still go through the collection,
+// so the stream only decides when to look. Retries that come due and requeued events are still
+// picked up by polling. Deployments without a replica set can't open change streams, and the
+// consumer carries on polling alone.
+func (l *Consumer) watch(ctx context.Context) {
+	for {
+		err := l.stream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		switch {
+		case hasErrorCode(err, errCodeNotReplicaSet):
+			log.Print("change streams need a replica set, polling every ", l.refreshTime)
+			return
+		case hasErrorCode(err, errCodeInvalidResume, errCodeStreamFatal, errCodeStreamHistoryLost):
+			// the oplog has moved past the token, start afresh and let the first claim catch up
+			zap.L().Warn("change stream can't resume, starting a new one", zap.Error(err), zap.String("group", l.group))
+			if err = l.saveResumeToken(ctx, nil); err != nil {
+				zap.L().Error("failed to clear resume token", zap.Error(err))
+			}
+			continue
+		}
+
+		zap.L().Error("change stream closed", zap.Error(err), zap.String("group", l.group))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(l.refreshTime):
+		}
+	}
+}
+
+// stream follows the change stream from the group's saved resume token until it fails, saving the
+// token after every event so a restarted listener picks up where this one left off.
+func (l *Consumer) stream(ctx context.Context) error {
+	token, err := l.loadResumeToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.D{
+		{Key: "operationType", Value: "insert"},
+		{Key: "fullDocument.event_key", Value: bson.D{{Key: "$in", Value: l.keys()}}},
+	}}}}
+
+	opts := options.ChangeStream()
+	if token != nil {
+		opts.SetResumeAfter(token)
+	}
+
+	stream, err := l.watcher.Watch(ctx, pipeline, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = stream.Close(context.Background())
+	}()
+
+	// anything published while the stream was closed is waiting in the collection
+	l.notify()
+
+	for stream.Next(ctx) {
+		l.notify()
+
+		if err = l.saveResumeToken(ctx, stream.ResumeToken()); err != nil {
+			zap.L().Warn("failed to save resume token", zap.Error(err), zap.String("group", l.group))
+		}
+	}
+	return stream.Err()
+}
+
+// notify wakes the consumer if it is waiting to poll.
+func (l *Consumer) notify() {
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (l *Consumer) loadResumeToken(ctx context.Context) (bson.Raw, error) {
+	var saved resumeToken
+
+	err := l.tokens.FindOne(ctx, repository.NewQueryFilter().AddFilter("_id", l.group).GetFilters()).Decode(&saved)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return saved.Token, nil
+}
+
+// saveResumeToken records where the group's stream is up to, a nil token clears it.
+func (l *Consumer) saveResumeToken(ctx context.Context, token bson.Raw) error {
+	set := map[string]interface{}{"updated_at": time.Now().UTC()}
+	update := map[string]interface{}{"$set": set}
+	if token != nil {
+		set["token"] = token
+	} else {
+		update["$unset"] = map[string]interface{}{"token": ""}
+	}
+
+	_, err := l.tokens.UpdateOne(ctx,
+		repository.NewQueryFilter().AddFilter("_id", l.group).GetFilters(),
+		update,
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func hasErrorCode(err error, codes ...int) bool {
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	for _, code := range codes {
+		if serverErr.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// tokenStore keeps resume tokens by group, applying the $set and $unset of the consumer's upserts.
+type tokenStore struct {
+	tokens map[string]bson.Raw
+}
+
+func (s *tokenStore) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	group, _ := filter.(bson.D).Map()["_id"].(string)
+
+	token, ok := s.tokens[group]
+	if !ok {
+		return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+	}
+	return mongo.NewSingleResultFromDocument(resumeToken{Group: group, Token: token}, nil, nil)
+}
+
+func (s *tokenStore) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	group, _ := filter.(bson.D).Map()["_id"].(string)
+	changes := update.(map[string]interface{})
+
+	if set, ok := changes["$set"].(map[string]interface{}); ok {
+		if token, ok := set["token"].(bson.Raw); ok {
+			s.tokens[group] = token
+		} else if _, ok = s.tokens[group]; !ok {
+			// upserted without a token
+			s.tokens[group] = nil
+		}
+	}
+	if _, ok := changes["$unset"]; ok {
+		s.tokens[group] = nil
+	}
+	return &mongo.UpdateResult{MatchedCount: 1}, nil
+}
+
+func TestResumeTokens(t *testing.T) {
+	token, err := bson.Marshal(bson.M{"_data": "8263F0A1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		saves []bson.Raw
+		want  bson.Raw
+	}{
+		{name: "no token saved yet", saves: nil, want: nil},
+		{name: "saved token is resumed from", saves: []bson.Raw{token}, want: token},
+		{name: "cleared token starts afresh", saves: []bson.Raw{token, nil}, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &tokenStore{tokens: map[string]bson.Raw{}}
+			listener := NewConsumer(WithGroup("mailer"), WithChangeStream(nil, store))
+
+			for _, save := range tt.saves {
+				if err := listener.saveResumeToken(context.Background(), save); err != nil {
+					t.Fatalf("saveResumeToken() error = %v", err)
+				}
+			}
+
+			got, err := listener.loadResumeToken(context.Background())
+			if err != nil {
+				t.Fatalf("loadResumeToken() error = %v", err)
+			}
+			if string(got) != string(tt.want) {
+				t.Errorf("loadResumeToken() = %v, want %v", got, tt.want)
+			}
+
+			// another group's stream is tracked separately
+			other := NewConsumer(WithGroup("webhooks"), WithChangeStream(nil, store))
+			if got, _ = other.loadResumeToken(context.Background()); got != nil {
+				t.Errorf("webhooks group resumes from %v, want nothing", got)
+			}
+		})
+	}
+}
+
+func TestHasErrorCode(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		codes []int
+		want  bool
+	}{
+		{name: "not a replica set", err: mongo.CommandError{Code: errCodeNotReplicaSet}, codes: []int{errCodeNotReplicaSet}, want: true},
+		{name: "wrapped", err: fmt.Errorf("watch: %w", mongo.CommandError{Code: errCodeStreamHistoryLost}), codes: []int{errCodeInvalidResume, errCodeStreamHistoryLost}, want: true},
+		{name: "other code", err: mongo.CommandError{Code: 11000}, codes: []int{errCodeNotReplicaSet}, want: false},
+		{name: "not a server error", err: errors.New("connection reset"), codes: []int{errCodeNotReplicaSet}, want: false},
+		{name: "no error", err: nil, codes: []int{errCodeNotReplicaSet}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasErrorCode(tt.err, tt.codes...); got != tt.want {
+				t.Errorf("hasErrorCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
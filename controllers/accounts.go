@@ -6,6 +6,7 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/limiter"
 	"github.com/tejiriaustin/narx_api/models"
 	"github.com/tejiriaustin/narx_api/publisher"
 	"github.com/tejiriaustin/narx_api/repository"
@@ -29,6 +30,7 @@ func (c *AccountsController) SignUp(
 	passwordGen utils.StrGenFunc,
 	acctService services.AccountsServiceInterface,
 	accountsRepo *repository.Repository[models.Account],
+	publisher publisher.PublishInterface,
 ) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 
@@ -47,7 +49,7 @@ func (c *AccountsController) SignUp(
 			Password:  req.Password,
 		}
 
-		user, err := acctService.CreateUser(ctx, input, passwordGen, accountsRepo)
+		user, err := acctService.CreateUser(ctx, input, passwordGen, accountsRepo, publisher)
 		if err != nil {
 			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
 			return
@@ -57,9 +59,60 @@ func (c *AccountsController) SignUp(
 	}
 }
 
+func (c *AccountsController) VerifyEmail(
+	acctService services.AccountsServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		input := services.VerifyEmailInput{
+			Token: ctx.Query("token"),
+		}
+
+		_, err := acctService.VerifyEmail(ctx, input, accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "email verified", nil)
+	}
+}
+
+func (c *AccountsController) ResendVerification(
+	acctService services.AccountsServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+	publisher publisher.PublishInterface,
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		var req requests.ResendVerificationRequest
+
+		err := ctx.BindJSON(&req)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, "Bad Request", nil)
+			return
+		}
+
+		input := services.ResendVerificationInput{
+			Email: req.Email,
+		}
+
+		err = acctService.ResendVerification(ctx, input, accountsRepo, publisher)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "if an unverified account exists for this email, a verification link has been sent", nil)
+	}
+}
+
 func (c *AccountsController) Login(
 	acctService services.AccountsServiceInterface,
 	accountsRepo *repository.Repository[models.Account],
+	loginLimiter *limiter.LoginLimiter,
+	publisher publisher.PublishInterface,
 ) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 
@@ -72,13 +125,23 @@ func (c *AccountsController) Login(
 		}
 
 		input := services.LoginUserInput{
-			Email:    req.Email,
-			Password: req.Password,
+			Email:     req.Email,
+			Password:  req.Password,
+			IpAddress: ctx.ClientIP(),
 		}
 
-		user, err := acctService.LoginUser(ctx, input, accountsRepo)
+		user, err := acctService.LoginUser(ctx, input, accountsRepo, loginLimiter, publisher)
 		if err != nil {
-			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			switch err {
+			case services.ErrTooManyLoginAttempts:
+				response.FormatResponse(ctx, http.StatusTooManyRequests, err.Error(), nil)
+			case services.ErrAccountSuspended:
+				response.FormatResponse(ctx, http.StatusForbidden, err.Error(), nil)
+			case services.ErrInvalidCredentials:
+				response.FormatResponse(ctx, http.StatusUnauthorized, err.Error(), nil)
+			default:
+				response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			}
 			return
 		}
 
@@ -92,18 +155,30 @@ func (c *AccountsController) EditAccount(
 ) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 
-		var req requests.CreateUserRequest
+		accountInfo, err := GetAccountInfo(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
 
-		err := ctx.BindJSON(&req)
+		var req requests.EditAccountRequest
+
+		err = ctx.BindJSON(&req)
 		if err != nil {
 			response.FormatResponse(ctx, http.StatusBadRequest, "Bad Request", nil)
 			return
 		}
 
+		if req.Email != "" {
+			response.FormatResponse(ctx, http.StatusBadRequest, "use change-email to update your email address", nil)
+			return
+		}
+
 		input := services.EditAccountInput{
+			Id:        accountInfo.Id,
 			FirstName: req.FirstName,
 			LastName:  req.LastName,
-			Email:     req.Email,
+			Locale:    req.Locale,
 		}
 
 		user, err := acctService.EditAccount(ctx, input, accountsRepo)
@@ -116,33 +191,52 @@ func (c *AccountsController) EditAccount(
 	}
 }
 
-func (c *AccountsController) LogOut() gin.HandlerFunc {
+func (c *AccountsController) GetProfile(
+	accountsRepo *repository.Repository[models.Account],
+) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		ctx.SetCookie("auth", "", -1, "/", c.conf.GetAsString(env.FrontendUrl), false, true)
-		ctx.String(http.StatusOK, "Cookie has been deleted")
+
+		account, err := GetAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", response.SingleAccountResponse(account))
 	}
 }
 
-func (c *AccountsController) ForgotPassword(
+func (c *AccountsController) ChangePassword(
 	acctService services.AccountsServiceInterface,
 	accountsRepo *repository.Repository[models.Account],
-	publisher publisher.PublishInterface,
 ) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 
-		var req requests.ForgotPasswordRequest
+		account, err := GetAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
 
-		err := ctx.BindJSON(&req)
+		var req requests.ChangePasswordRequest
+
+		err = ctx.BindJSON(&req)
 		if err != nil {
-			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			response.FormatResponse(ctx, http.StatusBadRequest, "Bad Request", nil)
 			return
 		}
 
-		input := services.ForgotPasswordInput{
-			Email: req.Email,
+		input := services.ChangePasswordInput{
+			AccountId:   account.GetId(),
+			NewPassword: req.NewPassword,
+			Reauthentication: services.Reauthentication{
+				Password:           req.CurrentPassword,
+				TwoFactorCode:      req.TwoFactorCode,
+				SsoAuthenticatedAt: account.SsoAuthenticatedAt,
+			},
 		}
 
-		user, err := acctService.ForgotPassword(ctx, input, accountsRepo, publisher)
+		user, err := acctService.ChangePassword(ctx, input, accountsRepo)
 		if err != nil {
 			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
 			return
@@ -152,26 +246,109 @@ func (c *AccountsController) ForgotPassword(
 	}
 }
 
-func (c *AccountsController) ResetPassword(
+func (c *AccountsController) ChangeEmail(
 	acctService services.AccountsServiceInterface,
 	accountsRepo *repository.Repository[models.Account],
+	publisher publisher.PublishInterface,
 ) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 
-		var req requests.ResetPasswordRequest
+		account, err := GetAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
 
-		err := ctx.BindJSON(&req)
+		var req requests.ChangeEmailRequest
+
+		err = ctx.BindJSON(&req)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, "Bad Request", nil)
+			return
+		}
+
+		input := services.ChangeEmailInput{
+			AccountId: account.GetId(),
+			NewEmail:  req.NewEmail,
+			Reauthentication: services.Reauthentication{
+				Password:           req.Password,
+				TwoFactorCode:      req.TwoFactorCode,
+				SsoAuthenticatedAt: account.SsoAuthenticatedAt,
+			},
+		}
+
+		err = acctService.ChangeEmail(ctx, input, accountsRepo, publisher)
 		if err != nil {
 			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
 			return
 		}
 
-		input := services.ResetPasswordInput{
-			NewPassword: req.NewPassword,
-			ResetToken:  req.ResetCode,
+		response.FormatResponse(ctx, http.StatusOK, "a verification link has been sent to your new email address", nil)
+	}
+}
+
+func (c *AccountsController) StartPhoneVerification(
+	acctService services.AccountsServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+	publisher publisher.PublishInterface,
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		accountInfo, err := GetAccountInfo(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
 		}
 
-		user, err := acctService.ResetPassword(ctx, input, accountsRepo)
+		var req requests.StartPhoneVerificationRequest
+
+		err = ctx.BindJSON(&req)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, "Bad Request", nil)
+			return
+		}
+
+		input := services.StartPhoneVerificationInput{
+			AccountId: accountInfo.Id,
+			Phone:     req.Phone,
+		}
+
+		err = acctService.StartPhoneVerification(ctx, input, accountsRepo, publisher)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "a verification code has been sent to your phone", nil)
+	}
+}
+
+func (c *AccountsController) VerifyPhone(
+	acctService services.AccountsServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		accountInfo, err := GetAccountInfo(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
+
+		var req requests.VerifyPhoneRequest
+
+		err = ctx.BindJSON(&req)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, "Bad Request", nil)
+			return
+		}
+
+		input := services.VerifyPhoneInput{
+			AccountId: accountInfo.Id,
+			Code:      req.Code,
+		}
+
+		user, err := acctService.VerifyPhone(ctx, input, accountsRepo)
 		if err != nil {
 			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
 			return
@@ -181,48 +358,188 @@ func (c *AccountsController) ResetPassword(
 	}
 }
 
-// ---------------------------------------------------------------- Irrelevant ---------------------------------------------------------------- //
+func (c *AccountsController) RemovePhone(
+	acctService services.AccountsServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		accountInfo, err := GetAccountInfo(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
 
-func (c *AccountsController) ListAccounts(
+		input := services.RemovePhoneInput{
+			AccountId: accountInfo.Id,
+		}
+
+		user, err := acctService.RemovePhone(ctx, input, accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", response.SingleAccountResponse(user))
+	}
+}
+
+func (c *AccountsController) DeleteAccount(
 	acctService services.AccountsServiceInterface,
 	accountsRepo *repository.Repository[models.Account],
+	sensorRepo *repository.Repository[models.Sensor],
+	devicesRepo *repository.Repository[models.Devices],
+	apiKeysRepo *repository.Repository[models.ApiKey],
+	preferencesRepo *repository.Repository[models.NotificationPreferences],
+	inboxRepo *repository.Repository[models.InboxNotification],
+	webhooksRepo *repository.Repository[models.WebhookSubscription],
+	deliveriesRepo *repository.Repository[models.WebhookDelivery],
 ) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 
-		_, err := GetAccountInfo(ctx, c.conf.GetAsBytes(env.JwtSecret))
+		account, err := GetAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
 		if err != nil {
-			response.FormatResponse(ctx, http.StatusUnauthorized, "Unauthorized access", nil)
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
 			return
 		}
 
-		var req requests.ListAccountsRequest
+		var req requests.DeleteAccountRequest
 
 		err = ctx.BindJSON(&req)
 		if err != nil {
-			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			response.FormatResponse(ctx, http.StatusBadRequest, "Bad Request", nil)
 			return
 		}
 
-		input := services.ListAccountReportsInput{
-			Pager: services.Pager{
-				Page:    services.GetPageNumberFromContext(ctx),
-				PerPage: services.GetPerPageLimitFromContext(ctx),
-			},
-			Filters: services.AccountListFilters{
-				Query: ctx.Param("query"),
+		input := services.DeleteAccountInput{
+			AccountId: account.GetId(),
+			Reauthentication: services.Reauthentication{
+				Password:           req.Password,
+				TwoFactorCode:      req.TwoFactorCode,
+				SsoAuthenticatedAt: account.SsoAuthenticatedAt,
 			},
 		}
-		accounts, paginator, err := acctService.ListAccounts(ctx, input, accountsRepo)
+
+		err = acctService.DeleteAccount(ctx, input, accountsRepo, sensorRepo, devicesRepo, apiKeysRepo, preferencesRepo, inboxRepo, webhooksRepo, deliveriesRepo)
 		if err != nil {
 			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
 			return
 		}
 
-		payload := map[string]interface{}{
-			"records": response.MultipleAccountResponse(accounts),
-			"meta":    paginator,
+		response.FormatResponse(ctx, http.StatusOK, "account deleted", nil)
+	}
+}
+
+func (c *AccountsController) ExportAccountData(
+	acctService services.AccountsServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+	sensorRepo *repository.Repository[models.Sensor],
+	devicesRepo *repository.Repository[models.Devices],
+	apiKeysRepo *repository.Repository[models.ApiKey],
+	preferencesRepo *repository.Repository[models.NotificationPreferences],
+	inboxRepo *repository.Repository[models.InboxNotification],
+	webhooksRepo *repository.Repository[models.WebhookSubscription],
+	deliveriesRepo *repository.Repository[models.WebhookDelivery],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		accountInfo, err := GetAccountInfo(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
+
+		input := services.ExportAccountDataInput{
+			AccountId: accountInfo.Id,
+		}
+
+		archive, err := acctService.ExportAccountData(ctx, input, accountsRepo, sensorRepo, devicesRepo, apiKeysRepo, preferencesRepo, inboxRepo, webhooksRepo, deliveriesRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		ctx.Header("Content-Disposition", `attachment; filename="narx-export.zip"`)
+		ctx.Data(http.StatusOK, "application/zip", archive)
+	}
+}
+
+func (c *AccountsController) LogOut() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.SetCookie("auth", "", -1, "/", c.conf.GetAsString(env.FrontendUrl), false, true)
+		ctx.String(http.StatusOK, "Cookie has been deleted")
+	}
+}
+
+func (c *AccountsController) ForgotPassword(
+	acctService services.AccountsServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+	resetLimiter *limiter.RateLimiter,
+	publisher publisher.PublishInterface,
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		var req requests.ForgotPasswordRequest
+
+		err := ctx.BindJSON(&req)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		input := services.ForgotPasswordInput{
+			Email:     req.Email,
+			IpAddress: ctx.ClientIP(),
+		}
+
+		err = acctService.ForgotPassword(ctx, input, accountsRepo, resetLimiter, publisher)
+		if err != nil {
+			switch err {
+			case services.ErrTooManyResetAttempts:
+				response.FormatResponse(ctx, http.StatusTooManyRequests, err.Error(), nil)
+			default:
+				response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			}
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "if an account exists for this email, a reset code has been sent", nil)
+	}
+}
+
+func (c *AccountsController) ResetPassword(
+	acctService services.AccountsServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+	resetLimiter *limiter.RateLimiter,
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		var req requests.ResetPasswordRequest
+
+		err := ctx.BindJSON(&req)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		input := services.ResetPasswordInput{
+			Email:       req.Email,
+			ResetCode:   req.ResetCode,
+			NewPassword: req.NewPassword,
+			IpAddress:   ctx.ClientIP(),
+		}
+
+		_, err = acctService.ResetPassword(ctx, input, accountsRepo, resetLimiter)
+		if err != nil {
+			switch err {
+			case services.ErrTooManyResetAttempts:
+				response.FormatResponse(ctx, http.StatusTooManyRequests, err.Error(), nil)
+			default:
+				response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			}
+			return
 		}
 
-		response.FormatResponse(ctx, http.StatusOK, "successful", payload)
+		response.FormatResponse(ctx, http.StatusOK, "successful", nil)
 	}
 }
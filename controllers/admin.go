@@ -0,0 +1,297 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tejiriaustin/narx_api/consumer"
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/publisher"
+	"github.com/tejiriaustin/narx_api/repository"
+	"github.com/tejiriaustin/narx_api/requests"
+	"github.com/tejiriaustin/narx_api/response"
+	"github.com/tejiriaustin/narx_api/services"
+)
+
+type AdminController struct {
+	conf *env.Environment
+}
+
+func NewAdminController(conf *env.Environment) *AdminController {
+	return &AdminController{
+		conf: conf,
+	}
+}
+
+func (c *AdminController) ListAccounts(
+	acctService services.AccountsServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		_, err := GetAdminAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "Unauthorized access", nil)
+			return
+		}
+
+		input := services.ListAccountReportsInput{
+			Pager: services.Pager{
+				Page:    services.GetPageNumberFromContext(ctx),
+				PerPage: services.GetPerPageLimitFromContext(ctx),
+			},
+			Filters: services.AccountListFilters{
+				Query: ctx.Query("query"),
+			},
+		}
+		accounts, paginator, err := acctService.ListAccounts(ctx, input, accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		payload := map[string]interface{}{
+			"records": response.MultipleAccountResponse(accounts),
+			"meta":    paginator,
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", payload)
+	}
+}
+
+func (c *AdminController) SuspendAccount(
+	acctService services.AccountsServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		admin, err := GetAdminAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "Unauthorized access", nil)
+			return
+		}
+
+		var req requests.SuspendAccountRequest
+
+		err = ctx.BindJSON(&req)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, "Bad Request", nil)
+			return
+		}
+
+		input := services.SuspendAccountInput{
+			Admin:     admin.GetAccountInfo(),
+			AccountId: ctx.Param("account_id"),
+			Reason:    req.Reason,
+		}
+
+		account, err := acctService.SuspendAccount(ctx, input, accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", response.SingleAccountResponse(account))
+	}
+}
+
+// NotifyAccount sends a notification to an account, through its inbox and chosen channels, on behalf of support.
+func (c *AdminController) NotifyAccount(
+	notificationService services.NotificationServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+	publisher publisher.PublishInterface,
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		_, err := GetAdminAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "Unauthorized access", nil)
+			return
+		}
+
+		var req requests.NotifyAccountRequest
+
+		err = ctx.BindJSON(&req)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, "Bad Request", nil)
+			return
+		}
+
+		input := services.NotifyUserInput{
+			AccountId: ctx.Param("account_id"),
+			EventType: req.EventType,
+			Severity:  req.Severity,
+			Title:     req.Title,
+			Body:      req.Body,
+			Data:      req.Data,
+		}
+
+		err = notificationService.NotifyUser(ctx, input, accountsRepo, publisher)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", nil)
+	}
+}
+
+func (c *AdminController) ReactivateAccount(
+	acctService services.AccountsServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		admin, err := GetAdminAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "Unauthorized access", nil)
+			return
+		}
+
+		input := services.ReactivateAccountInput{
+			Admin:     admin.GetAccountInfo(),
+			AccountId: ctx.Param("account_id"),
+		}
+
+		account, err := acctService.ReactivateAccount(ctx, input, accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", response.SingleAccountResponse(account))
+	}
+}
+
+func (c *AdminController) ImpersonateAccount(
+	acctService services.AccountsServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+	impersonationsRepo *repository.Repository[models.Impersonation],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		admin, err := GetAdminAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "Unauthorized access", nil)
+			return
+		}
+
+		var req requests.ImpersonateAccountRequest
+
+		err = ctx.BindJSON(&req)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, "Bad Request", nil)
+			return
+		}
+
+		input := services.ImpersonateAccountInput{
+			Admin:     admin.GetAccountInfo(),
+			AccountId: ctx.Param("account_id"),
+			Reason:    req.Reason,
+			Duration:  time.Duration(req.Minutes) * time.Minute,
+			IpAddress: ctx.ClientIP(),
+		}
+
+		account, err := acctService.ImpersonateAccount(ctx, input, accountsRepo, impersonationsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", response.SingleAccountResponse(account))
+	}
+}
+
+func (c *AdminController) ListDeadLetters(
+	deadLetterService services.DeadLetterServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+	deadLettersRepo *repository.Repository[models.DeadLetter],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		_, err := GetAdminAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "Unauthorized access", nil)
+			return
+		}
+
+		input := services.ListDeadLettersInput{
+			Pager: services.Pager{
+				Page:    services.GetPageNumberFromContext(ctx),
+				PerPage: services.GetPerPageLimitFromContext(ctx),
+			},
+			EventKey:        ctx.Query("event_key"),
+			Group:           ctx.Query("group"),
+			IncludeRequeued: ctx.Query("include_requeued") == "true",
+		}
+
+		deadLetters, paginator, err := deadLetterService.ListDeadLetters(ctx, input, deadLettersRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		payload := map[string]interface{}{
+			"records": response.MultipleDeadLetterResponse(deadLetters),
+			"meta":    paginator,
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", payload)
+	}
+}
+
+func (c *AdminController) GetDeadLetter(
+	deadLetterService services.DeadLetterServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+	deadLettersRepo *repository.Repository[models.DeadLetter],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		_, err := GetAdminAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "Unauthorized access", nil)
+			return
+		}
+
+		deadLetter, err := deadLetterService.GetDeadLetter(ctx, ctx.Param("dead_letter_id"), deadLettersRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", response.SingleDeadLetterResponse(deadLetter))
+	}
+}
+
+func (c *AdminController) RequeueDeadLetter(
+	deadLetterService services.DeadLetterServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+	deadLettersRepo *repository.Repository[models.DeadLetter],
+	eventQueue consumer.Requeuer,
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		admin, err := GetAdminAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "Unauthorized access", nil)
+			return
+		}
+
+		actor := admin.GetAccountInfo()
+		input := services.RequeueDeadLetterInput{
+			Actor:        &actor,
+			DeadLetterId: ctx.Param("dead_letter_id"),
+		}
+
+		deadLetter, err := deadLetterService.RequeueDeadLetter(ctx, input, deadLettersRepo, eventQueue)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", response.SingleDeadLetterResponse(deadLetter))
+	}
+}
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+	"github.com/tejiriaustin/narx_api/requests"
+	"github.com/tejiriaustin/narx_api/response"
+	"github.com/tejiriaustin/narx_api/services"
+)
+
+type ApiKeyController struct {
+	conf *env.Environment
+}
+
+func NewApiKeyController(conf *env.Environment) *ApiKeyController {
+	return &ApiKeyController{
+		conf: conf,
+	}
+}
+
+func (c *ApiKeyController) CreateApiKey(
+	apiKeyService services.ApiKeyServiceInterface,
+	apiKeysRepo *repository.Repository[models.ApiKey],
+	accountsRepo *repository.Repository[models.Account],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		account, err := GetAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
+
+		var req requests.CreateApiKeyRequest
+
+		err = ctx.BindJSON(&req)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, "Bad Request", nil)
+			return
+		}
+
+		permissions := make([]models.ApiKeyPermission, 0, len(req.Permissions))
+		for _, p := range req.Permissions {
+			permissions = append(permissions, models.ApiKeyPermission(p))
+		}
+
+		input := services.CreateApiKeyInput{
+			Account:         *account,
+			Name:            req.Name,
+			Permissions:     permissions,
+			ExpiresAt:       req.ExpiresAt,
+			ForOrganisation: req.ForOrganisation,
+		}
+
+		apiKey, err := apiKeyService.CreateApiKey(ctx, input, apiKeysRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", response.SingleApiKeyResponse(apiKey))
+	}
+}
+
+func (c *ApiKeyController) ListApiKeys(
+	apiKeyService services.ApiKeyServiceInterface,
+	apiKeysRepo *repository.Repository[models.ApiKey],
+	accountsRepo *repository.Repository[models.Account],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		account, err := GetAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
+
+		input := services.ListApiKeysInput{
+			Pager: services.Pager{
+				Page:    services.GetPageNumberFromContext(ctx),
+				PerPage: services.GetPerPageLimitFromContext(ctx),
+			},
+			Account: *account,
+		}
+
+		keys, paginator, err := apiKeyService.ListApiKeys(ctx, input, apiKeysRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		payload := map[string]interface{}{
+			"records": response.MultipleApiKeyResponse(keys),
+			"meta":    paginator,
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", payload)
+	}
+}
+
+func (c *ApiKeyController) RevokeApiKey(
+	apiKeyService services.ApiKeyServiceInterface,
+	apiKeysRepo *repository.Repository[models.ApiKey],
+	accountsRepo *repository.Repository[models.Account],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		account, err := GetAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
+
+		input := services.RevokeApiKeyInput{
+			Account: *account,
+			KeyId:   ctx.Param("key_id"),
+		}
+
+		err = apiKeyService.RevokeApiKey(ctx, input, apiKeysRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", nil)
+	}
+}
@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+	"github.com/tejiriaustin/narx_api/response"
+	"github.com/tejiriaustin/narx_api/services"
+)
+
+type AuditController struct {
+	conf *env.Environment
+}
+
+func NewAuditController(conf *env.Environment) *AuditController {
+	return &AuditController{
+		conf: conf,
+	}
+}
+
+func (c *AuditController) ListAuditEntries(
+	auditService services.AuditServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+	auditLogRepo *repository.Repository[models.AuditEntry],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		_, err := GetAdminAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "Unauthorized access", nil)
+			return
+		}
+
+		filters, err := readAuditFilters(ctx)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		input := services.ListAuditEntriesInput{
+			Pager: services.Pager{
+				Page:    services.GetPageNumberFromContext(ctx),
+				PerPage: services.GetPerPageLimitFromContext(ctx),
+			},
+			Filters: filters,
+		}
+
+		entries, paginator, err := auditService.ListAuditEntries(ctx, input, auditLogRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		payload := map[string]interface{}{
+			"records": response.MultipleAuditEntryResponse(entries),
+			"meta":    paginator,
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", payload)
+	}
+}
+
+func readAuditFilters(ctx *gin.Context) (audit.Filters, error) {
+	filters := audit.Filters{
+		ActorId:    ctx.Query("actor_id"),
+		Action:     ctx.Query("action"),
+		TargetType: ctx.Query("target_type"),
+		TargetId:   ctx.Query("target_id"),
+	}
+
+	if from := ctx.Query("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return filters, errors.New("from must be an RFC 3339 timestamp")
+		}
+		filters.From = &t
+	}
+	if to := ctx.Query("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return filters, errors.New("to must be an RFC 3339 timestamp")
+		}
+		filters.To = &t
+	}
+
+	return filters, nil
+}
@@ -3,31 +3,66 @@ package controllers
 import (
 	"context"
 	"errors"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/constants"
 	"github.com/tejiriaustin/narx_api/env"
 	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
 	"github.com/tejiriaustin/narx_api/services"
+	"github.com/tejiriaustin/narx_api/utils"
 )
 
 type (
 	Controller struct {
-		conf               *env.Environment
-		AccountsController *AccountsController
-		SensorController   *SensorController
-		DeviceController   *DeviceController
+		conf                   *env.Environment
+		AccountsController     *AccountsController
+		SensorController       *SensorController
+		DeviceController       *DeviceController
+		ApiKeyController       *ApiKeyController
+		AdminController        *AdminController
+		AuditController        *AuditController
+		NotificationController *NotificationController
+		WebhookController      *WebhookController
 	}
 )
 
+const (
+	// ApiKeyHeader is the header scripts and integrations send their api key in
+	ApiKeyHeader = "X-API-Key"
+
+	// apiKeyLastUsedResolution avoids writing to the key on every single request
+	apiKeyLastUsedResolution = time.Minute
+)
+
+// impersonationBlockedRoutes can't be read from an impersonated session even though they are GETs:
+// the data export, and the tokens and secrets behind the account's devices, api keys and webhooks.
+var impersonationBlockedRoutes = map[string]bool{
+	"/v1/user/me/export":                  true,
+	"/v1/devices":                         true,
+	"/v1/api-keys":                        true,
+	"/v1/webhooks":                        true,
+	"/v1/webhooks/:webhook_id/deliveries": true,
+}
+
 func BuildNewController(ctx context.Context, conf *env.Environment) *Controller {
 	return &Controller{
-		AccountsController: NewAccountController(conf),
-		SensorController:   NewSensorController(conf),
-		DeviceController:   NewDeviceController(conf),
+		AccountsController:     NewAccountController(conf),
+		SensorController:       NewSensorController(conf),
+		DeviceController:       NewDeviceController(conf),
+		ApiKeyController:       NewApiKeyController(conf),
+		AdminController:        NewAdminController(conf),
+		AuditController:        NewAuditController(conf),
+		NotificationController: NewNotificationController(conf),
+		WebhookController:      NewWebhookController(conf),
 	}
 }
 
@@ -36,33 +71,163 @@ func (r *Controller) SetCookieHandlers(c *gin.Context, token string) {
 	c.String(http.StatusOK, "Cookie has been set")
 }
 
-func GetAccountInfo(ctx *gin.Context, jwtSecret []byte) (*models.AccountInfo, error) {
+func GetAccountInfo(ctx *gin.Context, jwtSecret []byte, accountsRepo *repository.Repository[models.Account]) (*models.AccountInfo, error) {
+	account, err := GetAccount(ctx, jwtSecret, accountsRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	acct := account.GetAccountInfo()
+	return &acct, nil
+}
+
+// GetAccount authenticates the request and returns the account the session belongs to.
+func GetAccount(ctx *gin.Context, jwtSecret []byte, accountsRepo *repository.Repository[models.Account]) (*models.Account, error) {
 	tokenString, _ := GetAuthHeader(ctx)
 	if tokenString == "" {
 		return nil, errors.New("token not set")
 	}
 
 	tokenStrings := strings.Split(tokenString, " ")
+	if len(tokenStrings) != 2 {
+		return nil, errors.New("malformed token")
+	}
 
 	claims := &services.Claims{}
 
 	_, err := jwt.ParseWithClaims(tokenStrings[1], claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("jwt was signed with an unknown signature")
+		}
 		return jwtSecret, nil
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	m := claims.Content.(map[string]interface{})
+	m, ok := claims.Content.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("malformed token")
+	}
+
+	accountId, _ := m["id"].(string)
+	id, err := primitive.ObjectIDFromHex(accountId)
+	if err != nil {
+		return nil, errors.New("malformed token")
+	}
+
+	account, err := accountsRepo.FindOne(ctx, repository.NewQueryFilter().AddFilter(models.FieldId, id), nil, nil)
+	if err != nil {
+		return nil, errors.New("session is no longer valid")
+	}
+
+	// tokens issued before the account's sessions were revoked are no longer valid
+	if account.SessionVersion != claims.SessionVersion {
+		return nil, errors.New("session is no longer valid")
+	}
+
+	if account.IsSuspended() {
+		return nil, services.ErrAccountSuspended
+	}
+
+	// support staff can look around an impersonated account, but not change anything
+	if claims.ImpersonatedBy != "" {
+		if ctx.Request.Method != http.MethodGet {
+			return nil, errors.New("impersonated sessions are read-only")
+		}
+		if impersonationBlockedRoutes[ctx.FullPath()] {
+			return nil, errors.New("this isn't available in an impersonated session")
+		}
+		account.ImpersonatedBy = claims.ImpersonatedBy
+	}
+	if claims.AuthTime != 0 {
+		authenticatedAt := time.Unix(claims.AuthTime, 0)
+		account.SsoAuthenticatedAt = &authenticatedAt
+	}
+
+	ctx.Set(string(constants.ContextKeyAuditActor), audit.Actor{
+		Account:        account.GetAccountInfo(),
+		ImpersonatedBy: account.ImpersonatedBy,
+	})
+
+	return &account, nil
+}
+
+// GetAdminAccount authenticates the request and checks that it was made by an admin in their own session.
+func GetAdminAccount(ctx *gin.Context, jwtSecret []byte, accountsRepo *repository.Repository[models.Account]) (*models.Account, error) {
+	account, err := GetAccount(ctx, jwtSecret, accountsRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	if !account.IsAdmin() || account.ImpersonatedBy != "" {
+		return nil, errors.New("admin access required")
+	}
+
+	return account, nil
+}
+
+// Authenticate accepts either an api key holding the given permission or a session token,
+// and returns the account the request is made on behalf of.
+func Authenticate(
+	ctx *gin.Context,
+	jwtSecret []byte,
+	accountsRepo *repository.Repository[models.Account],
+	apiKeysRepo *repository.Repository[models.ApiKey],
+	permission models.ApiKeyPermission,
+) (*models.Account, error) {
+	key := ctx.GetHeader(ApiKeyHeader)
+	if key == "" {
+		return GetAccount(ctx, jwtSecret, accountsRepo)
+	}
+
+	apiKey, err := apiKeysRepo.FindOne(ctx, repository.NewQueryFilter().AddFilter(models.FieldApiKeyHash, utils.HashToken(key)), nil, nil)
+	if err != nil {
+		return nil, errors.New("invalid api key")
+	}
+
+	now := time.Now().UTC()
+	if !apiKey.IsActive(now) {
+		return nil, errors.New("api key has expired or been revoked")
+	}
+	if !apiKey.HasPermission(permission) {
+		return nil, errors.New("api key does not have the " + string(permission) + " permission")
+	}
+
+	id, err := primitive.ObjectIDFromHex(apiKey.AccountInfo.Id)
+	if err != nil {
+		return nil, errors.New("invalid api key")
+	}
+	account, err := accountsRepo.FindOne(ctx, repository.NewQueryFilter().AddFilter(models.FieldId, id), nil, nil)
+	if err != nil {
+		return nil, errors.New("invalid api key")
+	}
+	if account.IsSuspended() {
+		return nil, services.ErrAccountSuspended
+	}
+	// an organisation's keys stop working once the admin that created them leaves it
+	if apiKey.OrganisationId != "" && (account.OrganisationId != apiKey.OrganisationId || !account.IsAdmin()) {
+		return nil, errors.New("api key has expired or been revoked")
+	}
 
-	acct := &models.AccountInfo{
-		Id:        m["id"].(string),
-		FirstName: m["first_name"].(string),
-		LastName:  m["last_name"].(string),
-		FullName:  m["full_name"].(string),
-		Email:     m["email"].(string),
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyLastUsedResolution {
+		updates := map[string]interface{}{
+			"$set": map[string]interface{}{
+				models.FieldApiKeyLastUsedAt: now,
+			},
+		}
+		err = apiKeysRepo.UpdateMany(ctx, repository.NewQueryFilter().AddFilter(models.FieldId, apiKey.ID), updates)
+		if err != nil {
+			// failing to track usage shouldn't fail the request
+			log.Printf("failed to update api key last used: %s", err)
+		}
 	}
-	return acct, nil
+
+	ctx.Set(string(constants.ContextKeyAuditActor), audit.Actor{
+		Account: account.GetAccountInfo(),
+	})
+
+	return &account, nil
 }
 
 func GetAuthHeader(c *gin.Context) (string, error) {
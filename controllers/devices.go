@@ -26,6 +26,8 @@ func NewDeviceController(conf *env.Environment) *DeviceController {
 func (c *DeviceController) SaveDeviceToken(
 	deviceService services.DeviceServiceInterface,
 	deviceRepo *repository.Repository[models.Devices],
+	accountsRepo *repository.Repository[models.Account],
+	apiKeysRepo *repository.Repository[models.ApiKey],
 ) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 
@@ -37,18 +39,87 @@ func (c *DeviceController) SaveDeviceToken(
 			return
 		}
 
-		accountInfo, err := GetAccountInfo(ctx, c.conf.GetAsBytes(env.JwtSecret))
+		account, err := Authenticate(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo, apiKeysRepo, models.PermissionManageDevices)
 		if err != nil {
 			response.FormatResponse(ctx, http.StatusUnauthorized, "Unauthorized access", nil)
 			return
 		}
 
 		input := services.SaveDeviceTokenInput{
-			AccountInfo: *accountInfo,
+			AccountInfo: account.GetAccountInfo(),
 			DeviceToken: req.DeviceToken,
+			Platform:    models.DevicePlatform(req.Platform),
+			AppVersion:  req.AppVersion,
+			Locale:      req.Locale,
 		}
 
-		err = deviceService.SaveDeviceToken(ctx, input, deviceRepo)
+		device, err := deviceService.SaveDeviceToken(ctx, input, deviceRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", response.SingleDeviceResponse(device))
+	}
+}
+
+func (c *DeviceController) ListDevices(
+	deviceService services.DeviceServiceInterface,
+	deviceRepo *repository.Repository[models.Devices],
+	accountsRepo *repository.Repository[models.Account],
+	apiKeysRepo *repository.Repository[models.ApiKey],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		account, err := Authenticate(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo, apiKeysRepo, models.PermissionManageDevices)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "Unauthorized access", nil)
+			return
+		}
+
+		input := services.ListDevicesInput{
+			Pager: services.Pager{
+				Page:    services.GetPageNumberFromContext(ctx),
+				PerPage: services.GetPerPageLimitFromContext(ctx),
+			},
+			AccountId: account.GetId(),
+		}
+
+		devices, paginator, err := deviceService.ListDevices(ctx, input, deviceRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		payload := map[string]interface{}{
+			"records": response.MultipleDeviceResponse(devices),
+			"meta":    paginator,
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", payload)
+	}
+}
+
+func (c *DeviceController) DeleteDevice(
+	deviceService services.DeviceServiceInterface,
+	deviceRepo *repository.Repository[models.Devices],
+	accountsRepo *repository.Repository[models.Account],
+	apiKeysRepo *repository.Repository[models.ApiKey],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		account, err := Authenticate(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo, apiKeysRepo, models.PermissionManageDevices)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "Unauthorized access", nil)
+			return
+		}
+
+		input := services.DeleteDeviceInput{
+			AccountId: account.GetId(),
+			DeviceId:  ctx.Param("device_id"),
+		}
+
+		err = deviceService.DeleteDevice(ctx, input, deviceRepo)
 		if err != nil {
 			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
 			return
@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+	"github.com/tejiriaustin/narx_api/requests"
+	"github.com/tejiriaustin/narx_api/response"
+	"github.com/tejiriaustin/narx_api/services"
+)
+
+type NotificationController struct {
+	conf *env.Environment
+}
+
+func NewNotificationController(conf *env.Environment) *NotificationController {
+	return &NotificationController{
+		conf: conf,
+	}
+}
+
+func (c *NotificationController) GetPreferences(
+	notificationService services.NotificationServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+	preferencesRepo *repository.Repository[models.NotificationPreferences],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		accountInfo, err := GetAccountInfo(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
+
+		preferences, err := notificationService.GetNotificationPreferences(ctx, *accountInfo, preferencesRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", response.NotificationPreferencesResponse(preferences))
+	}
+}
+
+func (c *NotificationController) UpdatePreferences(
+	notificationService services.NotificationServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+	preferencesRepo *repository.Repository[models.NotificationPreferences],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		accountInfo, err := GetAccountInfo(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
+
+		var req requests.UpdateNotificationPreferencesRequest
+
+		err = ctx.BindJSON(&req)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, "Bad Request", nil)
+			return
+		}
+
+		input := services.UpdateNotificationPreferencesInput{
+			AccountInfo:  *accountInfo,
+			Rules:        req.Rules,
+			Timezone:     req.Timezone,
+			QuietHours:   req.QuietHours,
+			CriticalOnly: req.CriticalOnly,
+			Digest:       req.Digest,
+		}
+
+		preferences, err := notificationService.UpdateNotificationPreferences(ctx, input, preferencesRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", response.NotificationPreferencesResponse(preferences))
+	}
+}
+
+func (c *NotificationController) ListNotifications(
+	notificationService services.NotificationServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+	inboxRepo *repository.Repository[models.InboxNotification],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		accountInfo, err := GetAccountInfo(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
+
+		input := services.ListNotificationsInput{
+			Pager: services.Pager{
+				Page:    services.GetPageNumberFromContext(ctx),
+				PerPage: services.GetPerPageLimitFromContext(ctx),
+			},
+			AccountId:  accountInfo.Id,
+			UnreadOnly: ctx.Query("unread") == "true",
+		}
+
+		notifications, paginator, err := notificationService.ListNotifications(ctx, input, inboxRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		payload := map[string]interface{}{
+			"records": response.MultipleInboxNotificationResponse(notifications),
+			"meta":    paginator,
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", payload)
+	}
+}
+
+func (c *NotificationController) CountUnreadNotifications(
+	notificationService services.NotificationServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+	inboxRepo *repository.Repository[models.InboxNotification],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		accountInfo, err := GetAccountInfo(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
+
+		count, err := notificationService.CountUnreadNotifications(ctx, accountInfo.Id, inboxRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", map[string]interface{}{"unread": count})
+	}
+}
+
+func (c *NotificationController) MarkNotificationRead(
+	notificationService services.NotificationServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+	inboxRepo *repository.Repository[models.InboxNotification],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		accountInfo, err := GetAccountInfo(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
+
+		input := services.MarkNotificationReadInput{
+			AccountId:      accountInfo.Id,
+			NotificationId: ctx.Param("notification_id"),
+		}
+
+		notification, err := notificationService.MarkNotificationRead(ctx, input, inboxRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", response.SingleInboxNotificationResponse(notification))
+	}
+}
+
+func (c *NotificationController) MarkAllNotificationsRead(
+	notificationService services.NotificationServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+	inboxRepo *repository.Repository[models.InboxNotification],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		accountInfo, err := GetAccountInfo(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
+
+		err = notificationService.MarkAllNotificationsRead(ctx, accountInfo.Id, inboxRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", nil)
+	}
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+	"github.com/tejiriaustin/narx_api/response"
+	"github.com/tejiriaustin/narx_api/services"
+)
+
+const (
+	oidcFlowCookie     = "oidc_flow"
+	oidcFlowCookiePath = "/v1/user/oidc"
+	oidcFlowCookieAge  = 600
+)
+
+func (c *AccountsController) StartOIDCLogin(
+	acctService services.AccountsServiceInterface,
+	organisationsRepo *repository.Repository[models.Organisation],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		input := services.StartOIDCLoginInput{
+			OrganisationSlug: ctx.Param("organisation"),
+			Reauthenticate:   ctx.Query("reauthenticate") == "true",
+		}
+
+		req, err := acctService.StartOIDCLogin(ctx, input, organisationsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		ctx.SetCookie(oidcFlowCookie, req.FlowToken, oidcFlowCookieAge, oidcFlowCookiePath, "", false, true)
+		ctx.Redirect(http.StatusFound, req.AuthURL)
+	}
+}
+
+// StartOIDCLink starts a flow that links the identity the user signs in with to their current account.
+// The flow cookie is set here and the caller navigates to the returned url itself.
+func (c *AccountsController) StartOIDCLink(
+	acctService services.AccountsServiceInterface,
+	organisationsRepo *repository.Repository[models.Organisation],
+	accountsRepo *repository.Repository[models.Account],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		account, err := GetAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
+
+		input := services.StartOIDCLoginInput{
+			OrganisationSlug: ctx.Param("organisation"),
+			LinkAccount:      account,
+		}
+
+		req, err := acctService.StartOIDCLogin(ctx, input, organisationsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		ctx.SetCookie(oidcFlowCookie, req.FlowToken, oidcFlowCookieAge, oidcFlowCookiePath, "", false, true)
+		response.FormatResponse(ctx, http.StatusOK, "successful", map[string]interface{}{
+			"authUrl": req.AuthURL,
+		})
+	}
+}
+
+func (c *AccountsController) CompleteOIDCLogin(
+	acctService services.AccountsServiceInterface,
+	organisationsRepo *repository.Repository[models.Organisation],
+	accountsRepo *repository.Repository[models.Account],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		if errMsg := ctx.Query("error"); errMsg != "" {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "single sign-on failed: "+errMsg, nil)
+			return
+		}
+
+		flowToken, _ := ctx.Cookie(oidcFlowCookie)
+		ctx.SetCookie(oidcFlowCookie, "", -1, oidcFlowCookiePath, "", false, true)
+
+		input := services.CompleteOIDCLoginInput{
+			OrganisationSlug: ctx.Param("organisation"),
+			Code:             ctx.Query("code"),
+			State:            ctx.Query("state"),
+			FlowToken:        flowToken,
+		}
+
+		user, err := acctService.CompleteOIDCLogin(ctx, input, organisationsRepo, accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", response.SingleAccountResponse(user))
+	}
+}
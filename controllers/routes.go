@@ -33,25 +33,83 @@ func BindRoutes(
 
 	accounts := r.Group("/user")
 	{
-		accounts.POST("/sign-up", controllers.AccountsController.SignUp(passwordGenerator, sc.AccountsService, repos.AccountsRepo))
-		accounts.POST("/login", controllers.AccountsController.Login(sc.AccountsService, repos.AccountsRepo))
-		accounts.POST("/forgot-password", controllers.AccountsController.ForgotPassword(sc.AccountsService, repos.AccountsRepo, sc.Publisher))
-		accounts.POST("/reset-password", controllers.AccountsController.ResetPassword(sc.AccountsService, repos.AccountsRepo))
-		accounts.GET("/")
+		accounts.POST("/sign-up", controllers.AccountsController.SignUp(passwordGenerator, sc.AccountsService, repos.AccountsRepo, sc.Publisher))
+		accounts.GET("/verify", controllers.AccountsController.VerifyEmail(sc.AccountsService, repos.AccountsRepo))
+		accounts.POST("/resend-verification", controllers.AccountsController.ResendVerification(sc.AccountsService, repos.AccountsRepo, sc.Publisher))
+		accounts.POST("/login", controllers.AccountsController.Login(sc.AccountsService, repos.AccountsRepo, sc.LoginLimiter, sc.Publisher))
+		accounts.GET("/oidc/:organisation/login", controllers.AccountsController.StartOIDCLogin(sc.AccountsService, repos.OrganisationsRepo))
+		accounts.GET("/oidc/:organisation/callback", controllers.AccountsController.CompleteOIDCLogin(sc.AccountsService, repos.OrganisationsRepo, repos.AccountsRepo))
+		accounts.POST("/oidc/:organisation/link", controllers.AccountsController.StartOIDCLink(sc.AccountsService, repos.OrganisationsRepo, repos.AccountsRepo))
+		accounts.POST("/login/2fa", controllers.AccountsController.VerifyTwoFactorLogin(sc.AccountsService, repos.AccountsRepo, sc.LoginLimiter))
+		accounts.POST("/2fa/enroll", controllers.AccountsController.EnrollTwoFactor(sc.AccountsService, repos.AccountsRepo))
+		accounts.POST("/2fa/confirm", controllers.AccountsController.ConfirmTwoFactor(sc.AccountsService, repos.AccountsRepo))
+		accounts.POST("/2fa/disable", controllers.AccountsController.DisableTwoFactor(sc.AccountsService, repos.AccountsRepo))
+		accounts.POST("/forgot-password", controllers.AccountsController.ForgotPassword(sc.AccountsService, repos.AccountsRepo, sc.ResetLimiter, sc.Publisher))
+		accounts.POST("/reset-password", controllers.AccountsController.ResetPassword(sc.AccountsService, repos.AccountsRepo, sc.ResetLimiter))
+		accounts.GET("/me", controllers.AccountsController.GetProfile(repos.AccountsRepo))
+		accounts.DELETE("/me", controllers.AccountsController.DeleteAccount(sc.AccountsService, repos.AccountsRepo, repos.SensorRepo, repos.DevicesRepo, repos.ApiKeysRepo, repos.PreferencesRepo, repos.InboxRepo, repos.WebhooksRepo, repos.DeliveriesRepo))
+		accounts.GET("/me/export", controllers.AccountsController.ExportAccountData(sc.AccountsService, repos.AccountsRepo, repos.SensorRepo, repos.DevicesRepo, repos.ApiKeysRepo, repos.PreferencesRepo, repos.InboxRepo, repos.WebhooksRepo, repos.DeliveriesRepo))
 		accounts.PUT("/edit-account", controllers.AccountsController.EditAccount(sc.AccountsService, repos.AccountsRepo))
+		accounts.POST("/change-password", controllers.AccountsController.ChangePassword(sc.AccountsService, repos.AccountsRepo))
+		accounts.POST("/change-email", controllers.AccountsController.ChangeEmail(sc.AccountsService, repos.AccountsRepo, sc.Publisher))
+		accounts.POST("/phone", controllers.AccountsController.StartPhoneVerification(sc.AccountsService, repos.AccountsRepo, sc.Publisher))
+		accounts.POST("/phone/verify", controllers.AccountsController.VerifyPhone(sc.AccountsService, repos.AccountsRepo))
+		accounts.DELETE("/phone", controllers.AccountsController.RemovePhone(sc.AccountsService, repos.AccountsRepo))
 	}
 
 	sensors := r.Group("/sensors")
 	{
-		sensors.POST("/add", controllers.SensorController.AddSensor(passwordGenerator, sc.SensorService, repos.SensorRepo))
-		sensors.PUT("/update", controllers.SensorController.UpdateSensor(sc.SensorService, repos.SensorRepo))
-		sensors.GET("/:sensor_id", controllers.SensorController.GetSensor(sc.SensorService, repos.SensorRepo))
-		sensors.GET("/list", controllers.SensorController.ListSensor(sc.SensorService, repos.SensorRepo))
-		sensors.DELETE("/:sensor_id", controllers.SensorController.DeleteSensor(sc.SensorService, repos.SensorRepo))
+		sensors.POST("/add", controllers.SensorController.AddSensor(passwordGenerator, sc.SensorService, repos.SensorRepo, repos.AccountsRepo, repos.ApiKeysRepo))
+		sensors.PUT("/update", controllers.SensorController.UpdateSensor(sc.SensorService, repos.SensorRepo, repos.AccountsRepo, repos.ApiKeysRepo))
+		sensors.GET("/:sensor_id", controllers.SensorController.GetSensor(sc.SensorService, repos.SensorRepo, repos.AccountsRepo, repos.ApiKeysRepo))
+		sensors.GET("/list", controllers.SensorController.ListSensor(sc.SensorService, repos.SensorRepo, repos.AccountsRepo, repos.ApiKeysRepo))
+		sensors.DELETE("/:sensor_id", controllers.SensorController.DeleteSensor(sc.SensorService, repos.SensorRepo, repos.AccountsRepo, repos.ApiKeysRepo))
+	}
+
+	admin := r.Group("/admin")
+	{
+		admin.GET("/accounts", controllers.AdminController.ListAccounts(sc.AccountsService, repos.AccountsRepo))
+		admin.POST("/accounts/:account_id/suspend", controllers.AdminController.SuspendAccount(sc.AccountsService, repos.AccountsRepo))
+		admin.POST("/accounts/:account_id/notify", controllers.AdminController.NotifyAccount(sc.NotificationService, repos.AccountsRepo, sc.Publisher))
+		admin.POST("/accounts/:account_id/reactivate", controllers.AdminController.ReactivateAccount(sc.AccountsService, repos.AccountsRepo))
+		admin.POST("/accounts/:account_id/impersonate", controllers.AdminController.ImpersonateAccount(sc.AccountsService, repos.AccountsRepo, repos.ImpersonationsRepo))
+		admin.GET("/dead-letters", controllers.AdminController.ListDeadLetters(sc.DeadLetterService, repos.AccountsRepo, repos.DeadLettersRepo))
+		admin.GET("/dead-letters/:dead_letter_id", controllers.AdminController.GetDeadLetter(sc.DeadLetterService, repos.AccountsRepo, repos.DeadLettersRepo))
+		admin.POST("/dead-letters/:dead_letter_id/requeue", controllers.AdminController.RequeueDeadLetter(sc.DeadLetterService, repos.AccountsRepo, repos.DeadLettersRepo, sc.EventQueue))
+	}
+
+	notifications := r.Group("/notifications")
+	{
+		notifications.GET("/preferences", controllers.NotificationController.GetPreferences(sc.NotificationService, repos.AccountsRepo, repos.PreferencesRepo))
+		notifications.PUT("/preferences", controllers.NotificationController.UpdatePreferences(sc.NotificationService, repos.AccountsRepo, repos.PreferencesRepo))
+		notifications.GET("", controllers.NotificationController.ListNotifications(sc.NotificationService, repos.AccountsRepo, repos.InboxRepo))
+		notifications.GET("/unread-count", controllers.NotificationController.CountUnreadNotifications(sc.NotificationService, repos.AccountsRepo, repos.InboxRepo))
+		notifications.POST("/read-all", controllers.NotificationController.MarkAllNotificationsRead(sc.NotificationService, repos.AccountsRepo, repos.InboxRepo))
+		notifications.POST("/:notification_id/read", controllers.NotificationController.MarkNotificationRead(sc.NotificationService, repos.AccountsRepo, repos.InboxRepo))
+	}
+
+	r.GET("/audit", controllers.AuditController.ListAuditEntries(sc.AuditService, repos.AccountsRepo, repos.AuditLogRepo))
+
+	apiKeys := r.Group("/api-keys")
+	{
+		apiKeys.POST("", controllers.ApiKeyController.CreateApiKey(sc.ApiKeyService, repos.ApiKeysRepo, repos.AccountsRepo))
+		apiKeys.GET("", controllers.ApiKeyController.ListApiKeys(sc.ApiKeyService, repos.ApiKeysRepo, repos.AccountsRepo))
+		apiKeys.DELETE("/:key_id", controllers.ApiKeyController.RevokeApiKey(sc.ApiKeyService, repos.ApiKeysRepo, repos.AccountsRepo))
+	}
+
+	webhooks := r.Group("/webhooks")
+	{
+		webhooks.POST("", controllers.WebhookController.CreateWebhook(sc.WebhookService, repos.WebhooksRepo, repos.AccountsRepo))
+		webhooks.GET("", controllers.WebhookController.ListWebhooks(sc.WebhookService, repos.WebhooksRepo, repos.AccountsRepo))
+		webhooks.DELETE("/:webhook_id", controllers.WebhookController.DeleteWebhook(sc.WebhookService, repos.WebhooksRepo, repos.DeliveriesRepo, repos.AccountsRepo))
+		webhooks.GET("/:webhook_id/deliveries", controllers.WebhookController.ListWebhookDeliveries(sc.WebhookService, repos.WebhooksRepo, repos.DeliveriesRepo, repos.AccountsRepo))
+		webhooks.POST("/:webhook_id/deliveries/:delivery_id/redeliver", controllers.WebhookController.RedeliverWebhook(sc.WebhookService, repos.WebhooksRepo, repos.DeliveriesRepo, repos.AccountsRepo))
 	}
 
 	devices := r.Group("/devices")
 	{
-		devices.POST("", controllers.DeviceController.SaveDeviceToken(sc.DeviceService, repos.DevicesRepo))
+		devices.POST("", controllers.DeviceController.SaveDeviceToken(sc.DeviceService, repos.DevicesRepo, repos.AccountsRepo, repos.ApiKeysRepo))
+		devices.GET("", controllers.DeviceController.ListDevices(sc.DeviceService, repos.DevicesRepo, repos.AccountsRepo, repos.ApiKeysRepo))
+		devices.DELETE("/:device_id", controllers.DeviceController.DeleteDevice(sc.DeviceService, repos.DevicesRepo, repos.AccountsRepo, repos.ApiKeysRepo))
 	}
 }
@@ -28,6 +28,8 @@ func (s *SensorController) AddSensor(
 	passwordGen utils.StrGenFunc,
 	sensorService services.SensorServiceInterface,
 	sensorRepo *repository.Repository[models.Sensor],
+	accountsRepo *repository.Repository[models.Account],
+	apiKeysRepo *repository.Repository[models.ApiKey],
 ) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 
@@ -39,20 +41,27 @@ func (s *SensorController) AddSensor(
 			return
 		}
 
-		accountInfo, err := GetAccountInfo(ctx, s.conf.GetAsBytes(env.JwtSecret))
+		account, err := Authenticate(ctx, s.conf.GetAsBytes(env.JwtSecret), accountsRepo, apiKeysRepo, models.PermissionManageSensors)
 		if err != nil {
 			response.FormatResponse(ctx, http.StatusUnauthorized, err.Error(), nil)
 			return
 		}
 
+		accountInfo := account.GetAccountInfo()
+
 		input := services.CreateSensorInput{
-			Name:        req.Name,
-			IpAddress:   req.IpAddress,
-			AccountInfo: accountInfo,
+			Name:          req.Name,
+			IpAddress:     req.IpAddress,
+			AccountInfo:   &accountInfo,
+			AccountStatus: account.Status,
 		}
 
 		sensor, err := sensorService.CreateSensor(ctx, input, passwordGen, sensorRepo)
 		if err != nil {
+			if err == services.ErrAccountNotVerified {
+				response.FormatResponse(ctx, http.StatusForbidden, err.Error(), nil)
+				return
+			}
 			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
 			return
 		}
@@ -64,19 +73,25 @@ func (s *SensorController) AddSensor(
 func (s *SensorController) GetSensor(
 	sensorService services.SensorServiceInterface,
 	sensorRepo *repository.Repository[models.Sensor],
+	accountsRepo *repository.Repository[models.Account],
+	apiKeysRepo *repository.Repository[models.ApiKey],
 ) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 
 		sensorId := ctx.Param("sensor_id")
 
-		_, err := GetAccountInfo(ctx, s.conf.GetAsBytes(env.JwtSecret))
+		account, err := Authenticate(ctx, s.conf.GetAsBytes(env.JwtSecret), accountsRepo, apiKeysRepo, models.PermissionReadReadings)
 		if err != nil {
 			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
 			return
 		}
 
-		sensor, err := sensorService.GetSensor(ctx, sensorId, sensorRepo)
+		sensor, err := sensorService.GetSensor(ctx, sensorId, account.GetId(), sensorRepo)
 		if err != nil {
+			if err == services.ErrSensorNotFound {
+				response.FormatResponse(ctx, http.StatusNotFound, err.Error(), nil)
+				return
+			}
 			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
 			return
 		}
@@ -88,10 +103,12 @@ func (s *SensorController) GetSensor(
 func (s *SensorController) ListSensor(
 	sensorService services.SensorServiceInterface,
 	sensorRepo *repository.Repository[models.Sensor],
+	accountsRepo *repository.Repository[models.Account],
+	apiKeysRepo *repository.Repository[models.ApiKey],
 ) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 
-		accountInfo, err := GetAccountInfo(ctx, s.conf.GetAsBytes(env.JwtSecret))
+		account, err := Authenticate(ctx, s.conf.GetAsBytes(env.JwtSecret), accountsRepo, apiKeysRepo, models.PermissionReadReadings)
 		if err != nil {
 			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
 			return
@@ -104,7 +121,7 @@ func (s *SensorController) ListSensor(
 				Page:    services.GetPageNumberFromContext(ctx),
 				PerPage: services.GetPerPageLimitFromContext(ctx),
 			},
-			Filters: services.SensorListFilters{Query: query, AccountId: accountInfo.Id},
+			Filters: services.SensorListFilters{Query: query, AccountId: account.GetId()},
 		}
 
 		sensors, paginator, err := sensorService.ListSensors(ctx, input, sensorRepo)
@@ -125,12 +142,14 @@ func (s *SensorController) ListSensor(
 func (s *SensorController) UpdateSensor(
 	sensorService services.SensorServiceInterface,
 	sensorRepo *repository.Repository[models.Sensor],
+	accountsRepo *repository.Repository[models.Account],
+	apiKeysRepo *repository.Repository[models.ApiKey],
 ) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 
 		var req requests.UpdateSensorRequest
 
-		_, err := GetAccountInfo(ctx, s.conf.GetAsBytes(env.JwtSecret))
+		account, err := Authenticate(ctx, s.conf.GetAsBytes(env.JwtSecret), accountsRepo, apiKeysRepo, models.PermissionManageSensors)
 		if err != nil {
 			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
 			return
@@ -144,12 +163,17 @@ func (s *SensorController) UpdateSensor(
 
 		input := services.UpdateSensorInput{
 			ID:        req.ID,
+			AccountId: account.GetId(),
 			Name:      req.Name,
 			IpAddress: req.IpAddress,
 		}
 
 		_, err = sensorService.UpdateSensor(ctx, input, sensorRepo)
 		if err != nil {
+			if err == services.ErrSensorNotFound {
+				response.FormatResponse(ctx, http.StatusNotFound, err.Error(), nil)
+				return
+			}
 			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
 			return
 		}
@@ -161,19 +185,25 @@ func (s *SensorController) UpdateSensor(
 func (s *SensorController) DeleteSensor(
 	sensorService services.SensorServiceInterface,
 	sensorRepo *repository.Repository[models.Sensor],
+	accountsRepo *repository.Repository[models.Account],
+	apiKeysRepo *repository.Repository[models.ApiKey],
 ) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 
 		sensorId := ctx.Param("sensor_id")
 
-		_, err := GetAccountInfo(ctx, s.conf.GetAsBytes(env.JwtSecret))
+		account, err := Authenticate(ctx, s.conf.GetAsBytes(env.JwtSecret), accountsRepo, apiKeysRepo, models.PermissionManageSensors)
 		if err != nil {
 			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
 			return
 		}
 
-		err = sensorService.DeleteSensor(ctx, sensorId, sensorRepo)
+		err = sensorService.DeleteSensor(ctx, sensorId, account.GetId(), sensorRepo)
 		if err != nil {
+			if err == services.ErrSensorNotFound {
+				response.FormatResponse(ctx, http.StatusNotFound, err.Error(), nil)
+				return
+			}
 			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
 			return
 		}
@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/limiter"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+	"github.com/tejiriaustin/narx_api/requests"
+	"github.com/tejiriaustin/narx_api/response"
+	"github.com/tejiriaustin/narx_api/services"
+)
+
+func (c *AccountsController) VerifyTwoFactorLogin(
+	acctService services.AccountsServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+	loginLimiter *limiter.LoginLimiter,
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		var req requests.VerifyTwoFactorLoginRequest
+
+		err := ctx.BindJSON(&req)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, "Bad Request", nil)
+			return
+		}
+
+		input := services.VerifyTwoFactorLoginInput{
+			ChallengeToken: req.ChallengeToken,
+			Code:           req.Code,
+			IpAddress:      ctx.ClientIP(),
+		}
+
+		user, err := acctService.VerifyTwoFactorLogin(ctx, input, accountsRepo, loginLimiter)
+		if err != nil {
+			switch err {
+			case services.ErrTooManyLoginAttempts:
+				response.FormatResponse(ctx, http.StatusTooManyRequests, err.Error(), nil)
+			case services.ErrInvalidChallenge, services.ErrInvalidTwoFactorCode:
+				response.FormatResponse(ctx, http.StatusUnauthorized, err.Error(), nil)
+			default:
+				response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			}
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", response.SingleAccountResponse(user))
+	}
+}
+
+func (c *AccountsController) EnrollTwoFactor(
+	acctService services.AccountsServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		accountInfo, err := GetAccountInfo(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
+
+		input := services.EnrollTwoFactorInput{
+			AccountId: accountInfo.Id,
+		}
+
+		enrollment, err := acctService.EnrollTwoFactor(ctx, input, accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", response.TwoFactorEnrollmentResponse(enrollment.Secret, enrollment.URI))
+	}
+}
+
+func (c *AccountsController) ConfirmTwoFactor(
+	acctService services.AccountsServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		accountInfo, err := GetAccountInfo(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
+
+		var req requests.ConfirmTwoFactorRequest
+
+		err = ctx.BindJSON(&req)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, "Bad Request", nil)
+			return
+		}
+
+		input := services.ConfirmTwoFactorInput{
+			AccountId: accountInfo.Id,
+			Code:      req.Code,
+		}
+
+		codes, err := acctService.ConfirmTwoFactor(ctx, input, accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", response.RecoveryCodesResponse(codes))
+	}
+}
+
+func (c *AccountsController) DisableTwoFactor(
+	acctService services.AccountsServiceInterface,
+	accountsRepo *repository.Repository[models.Account],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		account, err := GetAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
+
+		var req requests.DisableTwoFactorRequest
+
+		err = ctx.BindJSON(&req)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, "Bad Request", nil)
+			return
+		}
+
+		input := services.DisableTwoFactorInput{
+			AccountId: account.GetId(),
+			Code:      req.Code,
+			Reauthentication: services.Reauthentication{
+				Password:           req.Password,
+				SsoAuthenticatedAt: account.SsoAuthenticatedAt,
+			},
+		}
+
+		err = acctService.DisableTwoFactor(ctx, input, accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", nil)
+	}
+}
@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+	"github.com/tejiriaustin/narx_api/requests"
+	"github.com/tejiriaustin/narx_api/response"
+	"github.com/tejiriaustin/narx_api/services"
+)
+
+type WebhookController struct {
+	conf *env.Environment
+}
+
+func NewWebhookController(conf *env.Environment) *WebhookController {
+	return &WebhookController{
+		conf: conf,
+	}
+}
+
+func (c *WebhookController) CreateWebhook(
+	webhookService services.WebhookServiceInterface,
+	webhooksRepo *repository.Repository[models.WebhookSubscription],
+	accountsRepo *repository.Repository[models.Account],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		account, err := GetAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
+
+		var req requests.CreateWebhookRequest
+
+		err = ctx.BindJSON(&req)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, "Bad Request", nil)
+			return
+		}
+
+		eventTypes := make([]models.NotificationEventType, 0, len(req.EventTypes))
+		for _, t := range req.EventTypes {
+			eventTypes = append(eventTypes, models.NotificationEventType(t))
+		}
+
+		input := services.CreateWebhookInput{
+			Account:         *account,
+			Url:             req.Url,
+			Description:     req.Description,
+			EventTypes:      eventTypes,
+			ForOrganisation: req.ForOrganisation,
+		}
+
+		webhook, err := webhookService.CreateWebhook(ctx, input, webhooksRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", response.CreatedWebhookResponse(webhook))
+	}
+}
+
+func (c *WebhookController) ListWebhooks(
+	webhookService services.WebhookServiceInterface,
+	webhooksRepo *repository.Repository[models.WebhookSubscription],
+	accountsRepo *repository.Repository[models.Account],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		account, err := GetAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
+
+		input := services.ListWebhooksInput{
+			Pager: services.Pager{
+				Page:    services.GetPageNumberFromContext(ctx),
+				PerPage: services.GetPerPageLimitFromContext(ctx),
+			},
+			Account: *account,
+		}
+
+		webhooks, paginator, err := webhookService.ListWebhooks(ctx, input, webhooksRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		payload := map[string]interface{}{
+			"records": response.MultipleWebhookResponse(webhooks),
+			"meta":    paginator,
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", payload)
+	}
+}
+
+func (c *WebhookController) DeleteWebhook(
+	webhookService services.WebhookServiceInterface,
+	webhooksRepo *repository.Repository[models.WebhookSubscription],
+	deliveriesRepo *repository.Repository[models.WebhookDelivery],
+	accountsRepo *repository.Repository[models.Account],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		account, err := GetAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
+
+		input := services.DeleteWebhookInput{
+			Account:   *account,
+			WebhookId: ctx.Param("webhook_id"),
+		}
+
+		err = webhookService.DeleteWebhook(ctx, input, webhooksRepo, deliveriesRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", nil)
+	}
+}
+
+func (c *WebhookController) ListWebhookDeliveries(
+	webhookService services.WebhookServiceInterface,
+	webhooksRepo *repository.Repository[models.WebhookSubscription],
+	deliveriesRepo *repository.Repository[models.WebhookDelivery],
+	accountsRepo *repository.Repository[models.Account],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		account, err := GetAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
+
+		input := services.ListWebhookDeliveriesInput{
+			Pager: services.Pager{
+				Page:    services.GetPageNumberFromContext(ctx),
+				PerPage: services.GetPerPageLimitFromContext(ctx),
+			},
+			Account:   *account,
+			WebhookId: ctx.Param("webhook_id"),
+			Status:    models.WebhookDeliveryStatus(ctx.Query("status")),
+		}
+
+		deliveries, paginator, err := webhookService.ListWebhookDeliveries(ctx, input, webhooksRepo, deliveriesRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		payload := map[string]interface{}{
+			"records": response.MultipleWebhookDeliveryResponse(deliveries),
+			"meta":    paginator,
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", payload)
+	}
+}
+
+func (c *WebhookController) RedeliverWebhook(
+	webhookService services.WebhookServiceInterface,
+	webhooksRepo *repository.Repository[models.WebhookSubscription],
+	deliveriesRepo *repository.Repository[models.WebhookDelivery],
+	accountsRepo *repository.Repository[models.Account],
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		account, err := GetAccount(ctx, c.conf.GetAsBytes(env.JwtSecret), accountsRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusUnauthorized, "unauthorised access", nil)
+			return
+		}
+
+		input := services.RedeliverWebhookInput{
+			Account:    *account,
+			WebhookId:  ctx.Param("webhook_id"),
+			DeliveryId: ctx.Param("delivery_id"),
+		}
+
+		delivery, err := webhookService.RedeliverWebhook(ctx, input, webhooksRepo, deliveriesRepo)
+		if err != nil {
+			response.FormatResponse(ctx, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		response.FormatResponse(ctx, http.StatusOK, "successful", response.SingleWebhookDeliveryResponse(delivery))
+	}
+}
@@ -0,0 +1,835 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrChangeStreamUnsupported = errors.New("change streams need a MongoDB replica set")
+
+// MemoryCollection keeps documents in process memory, for tests and for running with nothing to
+// connect to. It answers the queries and updates the repositories and consumers make: equality,
+// $in, $nin, $ne, $exists, $lt, $lte, $gt, $gte, $regex, $elemMatch, $or and $and in filters, and
+// $set, $unset, $inc, $push and $addToSet in updates, with dotted paths into embedded documents.
+// Projections are ignored and there are no indexes, so unique constraints aren't enforced.
+type MemoryCollection struct {
+	mu   sync.Mutex
+	docs []bson.M
+}
+
+// MemoryDatabase hands out a MemoryCollection per collection name, so the repositories and
+// consumers can run on it in place of a Mongo database.
+type MemoryDatabase struct {
+	mu          sync.Mutex
+	collections map[string]*MemoryCollection
+}
+
+var (
+	_ Collection = (*MemoryCollection)(nil)
+	_ Database   = (*MemoryDatabase)(nil)
+)
+
+func NewMemoryCollection() *MemoryCollection {
+	return &MemoryCollection{}
+}
+
+func NewMemoryDatabase() *MemoryDatabase {
+	return &MemoryDatabase{collections: make(map[string]*MemoryCollection)}
+}
+
+func (d *MemoryDatabase) Disconnect(ctx context.Context) error {
+	return nil
+}
+
+// GetCollection returns the collection called name, creating it the first time it is asked for.
+func (d *MemoryDatabase) GetCollection(name string, opts ...*options.CollectionOptions) Collection {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	collection, ok := d.collections[name]
+	if !ok {
+		collection = NewMemoryCollection()
+		d.collections[name] = collection
+	}
+	return collection
+}
+
+func (c *MemoryCollection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	matched, err := c.match(filter)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(matched)), nil
+}
+
+func (c *MemoryCollection) DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return c.delete(filter, 1)
+}
+
+func (c *MemoryCollection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return c.delete(filter, -1)
+}
+
+func (c *MemoryCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	matched, err := c.match(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	opt := options.MergeFindOptions(opts...)
+	if err = sortDocuments(matched, opt.Sort); err != nil {
+		return nil, err
+	}
+	if opt.Skip != nil {
+		matched = matched[min(int(*opt.Skip), len(matched)):]
+	}
+	if opt.Limit != nil && *opt.Limit > 0 {
+		matched = matched[:min(int(*opt.Limit), len(matched))]
+	}
+
+	found := make([]interface{}, 0, len(matched))
+	for _, doc := range matched {
+		found = append(found, copyDocument(doc))
+	}
+	return mongo.NewCursorFromDocuments(found, nil, nil)
+}
+
+func (c *MemoryCollection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	opt := options.MergeFindOneOptions(opts...)
+
+	doc, err := c.first(filter, opt.Sort)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.M{}, err, nil)
+	}
+	return mongo.NewSingleResultFromDocument(copyDocument(doc), nil, nil)
+}
+
+func (c *MemoryCollection) FindOneAndReplace(ctx context.Context, filter interface{}, replacement interface{}, opts ...*options.FindOneAndReplaceOptions) *mongo.SingleResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	opt := options.MergeFindOneAndReplaceOptions(opts...)
+
+	doc, err := c.first(filter, opt.Sort)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.M{}, err, nil)
+	}
+
+	replaced, err := normalise(replacement)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.M{}, err, nil)
+	}
+	replaced["_id"] = doc["_id"]
+
+	before := copyDocument(doc)
+	clear(doc)
+	for key, value := range replaced {
+		doc[key] = value
+	}
+
+	if opt.ReturnDocument != nil && *opt.ReturnDocument == options.After {
+		return mongo.NewSingleResultFromDocument(copyDocument(doc), nil, nil)
+	}
+	return mongo.NewSingleResultFromDocument(before, nil, nil)
+}
+
+func (c *MemoryCollection) FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	opt := options.MergeFindOneAndUpdateOptions(opts...)
+
+	doc, err := c.first(filter, opt.Sort)
+	if errors.Is(err, mongo.ErrNoDocuments) && opt.Upsert != nil && *opt.Upsert {
+		doc, err = c.upsert(filter)
+	}
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.M{}, err, nil)
+	}
+
+	before := copyDocument(doc)
+	if err = applyUpdate(doc, update); err != nil {
+		return mongo.NewSingleResultFromDocument(bson.M{}, err, nil)
+	}
+
+	if opt.ReturnDocument != nil && *opt.ReturnDocument == options.After {
+		return mongo.NewSingleResultFromDocument(copyDocument(doc), nil, nil)
+	}
+	return mongo.NewSingleResultFromDocument(before, nil, nil)
+}
+
+func (c *MemoryCollection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id, err := c.insert(document)
+	if err != nil {
+		return nil, err
+	}
+	return &mongo.InsertOneResult{InsertedID: id}, nil
+}
+
+func (c *MemoryCollection) InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	res := &mongo.InsertManyResult{}
+	for _, document := range documents {
+		id, err := c.insert(document)
+		if err != nil {
+			return res, err
+		}
+		res.InsertedIDs = append(res.InsertedIDs, id)
+	}
+	return res, nil
+}
+
+func (c *MemoryCollection) UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.update(filter, update, -1, opts...)
+}
+
+func (c *MemoryCollection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.update(filter, update, 1, opts...)
+}
+
+func (c *MemoryCollection) Watch(ctx context.Context, pipeline interface{}, opts ...*options.ChangeStreamOptions) (*mongo.ChangeStream, error) {
+	return nil, ErrChangeStreamUnsupported
+}
+
+func (c *MemoryCollection) insert(document interface{}) (interface{}, error) {
+	doc, err := normalise(document)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+
+	c.docs = append(c.docs, doc)
+	return doc["_id"], nil
+}
+
+// upsert inserts the document a filter's equality conditions describe, for an update to apply to.
+func (c *MemoryCollection) upsert(filter interface{}) (bson.M, error) {
+	conditions, err := normalise(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := bson.M{}
+	for path, condition := range conditions {
+		if strings.HasPrefix(path, "$") || isOperatorDocument(condition) {
+			continue
+		}
+		setPath(doc, path, condition)
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+
+	c.docs = append(c.docs, doc)
+	return doc, nil
+}
+
+func (c *MemoryCollection) update(filter interface{}, update interface{}, limit int, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	matched, err := c.match(filter)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(matched) > limit {
+		matched = matched[:limit]
+	}
+
+	res := &mongo.UpdateResult{MatchedCount: int64(len(matched))}
+
+	opt := options.MergeUpdateOptions(opts...)
+	if len(matched) == 0 && opt.Upsert != nil && *opt.Upsert {
+		doc, err := c.upsert(filter)
+		if err != nil {
+			return nil, err
+		}
+		res.UpsertedCount = 1
+		res.UpsertedID = doc["_id"]
+		matched = append(matched, doc)
+	}
+
+	for _, doc := range matched {
+		before := copyDocument(doc)
+		if err = applyUpdate(doc, update); err != nil {
+			return res, err
+		}
+		if res.UpsertedCount == 0 && !reflect.DeepEqual(before, doc) {
+			res.ModifiedCount++
+		}
+	}
+	return res, nil
+}
+
+func (c *MemoryCollection) delete(filter interface{}, limit int) (*mongo.DeleteResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conditions, err := normalise(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &mongo.DeleteResult{}
+	kept := c.docs[:0]
+	for _, doc := range c.docs {
+		if (limit < 0 || res.DeletedCount < int64(limit)) && matches(doc, conditions) {
+			res.DeletedCount++
+			continue
+		}
+		kept = append(kept, doc)
+	}
+	clear(c.docs[len(kept):])
+	c.docs = kept
+
+	return res, nil
+}
+
+// match returns the stored documents matching filter, in the order they were inserted.
+func (c *MemoryCollection) match(filter interface{}) ([]bson.M, error) {
+	conditions, err := normalise(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []bson.M
+	for _, doc := range c.docs {
+		if matches(doc, conditions) {
+			matched = append(matched, doc)
+		}
+	}
+	return matched, nil
+}
+
+// first returns the first stored document matching filter in sortBy order, or insertion order without one.
+func (c *MemoryCollection) first(filter interface{}, sortBy interface{}) (bson.M, error) {
+	matched, err := c.match(filter)
+	if err != nil {
+		return nil, err
+	}
+	if err = sortDocuments(matched, sortBy); err != nil {
+		return nil, err
+	}
+	if len(matched) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return matched[0], nil
+}
+
+// normalise converts a document to the types it would have once stored, so values compare the way
+// MongoDB compares them: times become DateTimes, embedded documents bson.M and slices bson.A.
+func normalise(document interface{}) (bson.M, error) {
+	if document == nil {
+		return bson.M{}, nil
+	}
+
+	raw, err := bson.Marshal(bson.M{"document": document})
+	if err != nil {
+		return nil, fmt.Errorf("memory collection: %w", err)
+	}
+
+	var wrapped bson.M
+	if err = bson.Unmarshal(raw, &wrapped); err != nil {
+		return nil, fmt.Errorf("memory collection: %w", err)
+	}
+
+	doc, ok := wrapped["document"].(bson.M)
+	if !ok {
+		return nil, fmt.Errorf("memory collection: %T is not a document", document)
+	}
+	return doc, nil
+}
+
+func copyDocument(doc bson.M) bson.M {
+	copied, _ := copyValue(doc).(bson.M)
+	return copied
+}
+
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.M:
+		copied := make(bson.M, len(v))
+		for key, elem := range v {
+			copied[key] = copyValue(elem)
+		}
+		return copied
+	case bson.A:
+		copied := make(bson.A, len(v))
+		for i, elem := range v {
+			copied[i] = copyValue(elem)
+		}
+		return copied
+	default:
+		return v
+	}
+}
+
+func matches(doc bson.M, conditions bson.M) bool {
+	for path, condition := range conditions {
+		switch path {
+		case "$or":
+			branches, _ := condition.(bson.A)
+			if !anyMatches(doc, branches) {
+				return false
+			}
+		case "$and":
+			branches, _ := condition.(bson.A)
+			for _, branch := range branches {
+				if sub, ok := branch.(bson.M); !ok || !matches(doc, sub) {
+					return false
+				}
+			}
+		default:
+			values, found := lookup(doc, path)
+			if !satisfies(values, found, condition) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func anyMatches(doc bson.M, branches bson.A) bool {
+	for _, branch := range branches {
+		if sub, ok := branch.(bson.M); ok && matches(doc, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the values at a dotted path. Arrays along the way are searched element by element,
+// and an array at the end of the path gives both itself and its elements, as MongoDB does.
+func lookup(value interface{}, path string) ([]interface{}, bool) {
+	if path == "" {
+		values := []interface{}{value}
+		if arr, ok := value.(bson.A); ok {
+			values = append(values, arr...)
+		}
+		return values, true
+	}
+
+	name, rest, _ := strings.Cut(path, ".")
+
+	switch v := value.(type) {
+	case bson.M:
+		elem, ok := v[name]
+		if !ok {
+			return nil, false
+		}
+		return lookup(elem, rest)
+	case bson.A:
+		var values []interface{}
+		found := false
+		for _, elem := range v {
+			if sub, ok := lookup(elem, path); ok {
+				values = append(values, sub...)
+				found = true
+			}
+		}
+		return values, found
+	default:
+		return nil, false
+	}
+}
+
+func isOperatorDocument(condition interface{}) bool {
+	doc, ok := condition.(bson.M)
+	if !ok || len(doc) == 0 {
+		return false
+	}
+	for key := range doc {
+		if !strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// satisfies reports whether the values found at a path meet a condition, an operator document or a
+// value they must equal.
+func satisfies(values []interface{}, found bool, condition interface{}) bool {
+	if !isOperatorDocument(condition) {
+		return equalsAny(values, found, condition)
+	}
+
+	operators := condition.(bson.M)
+	for operator, operand := range operators {
+		switch operator {
+		case "$eq":
+			if !equalsAny(values, found, operand) {
+				return false
+			}
+		case "$ne":
+			if equalsAny(values, found, operand) {
+				return false
+			}
+		case "$exists":
+			if exists, _ := operand.(bool); exists != found {
+				return false
+			}
+		case "$in", "$nin":
+			operands, _ := operand.(bson.A)
+			in := false
+			for _, o := range operands {
+				if equalsAny(values, found, o) {
+					in = true
+					break
+				}
+			}
+			if in != (operator == "$in") {
+				return false
+			}
+		case "$lt", "$lte", "$gt", "$gte":
+			if !comparesAny(values, operator, operand) {
+				return false
+			}
+		case "$regex":
+			if !matchesPattern(values, operand, operators["$options"]) {
+				return false
+			}
+		case "$options":
+			// applied with $regex
+		case "$elemMatch":
+			if !elemMatches(values, operand) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// equalsAny reports whether any value equals want. A nil want matches a missing field too.
+func equalsAny(values []interface{}, found bool, want interface{}) bool {
+	if want == nil && !found {
+		return true
+	}
+	for _, value := range values {
+		if equal(value, want) {
+			return true
+		}
+	}
+	return false
+}
+
+func comparesAny(values []interface{}, operator string, operand interface{}) bool {
+	for _, value := range values {
+		order, ok := compare(value, operand)
+		if !ok {
+			continue
+		}
+		switch {
+		case operator == "$lt" && order < 0,
+			operator == "$lte" && order <= 0,
+			operator == "$gt" && order > 0,
+			operator == "$gte" && order >= 0:
+			return true
+		}
+	}
+	return false
+}
+
+func matchesPattern(values []interface{}, pattern interface{}, flags interface{}) bool {
+	expr, _ := pattern.(string)
+	if regex, ok := pattern.(primitive.Regex); ok {
+		expr, flags = regex.Pattern, regex.Options
+	}
+	if opts, _ := flags.(string); strings.Contains(opts, "i") {
+		expr = "(?i)" + expr
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return false
+	}
+	for _, value := range values {
+		if s, ok := value.(string); ok && re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func elemMatches(values []interface{}, condition interface{}) bool {
+	sub, _ := condition.(bson.M)
+	for _, value := range values {
+		arr, ok := value.(bson.A)
+		if !ok {
+			continue
+		}
+		for _, elem := range arr {
+			if doc, ok := elem.(bson.M); ok && !isOperatorDocument(sub) && matches(doc, sub) {
+				return true
+			}
+			if isOperatorDocument(sub) && satisfies([]interface{}{elem}, true, sub) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func equal(a, b interface{}) bool {
+	if order, ok := compare(a, b); ok {
+		return order == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compare orders two values of the same kind, numbers of any type being one kind.
+func compare(a, b interface{}) (int, bool) {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		return strings.Compare(x, y), ok
+	case bool:
+		y, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x == y:
+			return 0, true
+		case !x:
+			return -1, true
+		}
+		return 1, true
+	case primitive.DateTime:
+		y, ok := b.(primitive.DateTime)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case primitive.ObjectID:
+		y, ok := b.(primitive.ObjectID)
+		return bytes.Compare(x[:], y[:]), ok
+	case nil:
+		return 0, b == nil
+	}
+	return 0, false
+}
+
+func number(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// sortDocuments orders docs by a sort document such as bson.D{{Key: "_id", Value: -1}}. Documents
+// missing a field sort before those that have it.
+func sortDocuments(docs []bson.M, sortBy interface{}) error {
+	if sortBy == nil {
+		return nil
+	}
+
+	var keys bson.D
+	switch s := sortBy.(type) {
+	case bson.D:
+		keys = s
+	case bson.M:
+		for key, direction := range s {
+			keys = append(keys, bson.E{Key: key, Value: direction})
+		}
+	case map[string]interface{}:
+		for key, direction := range s {
+			keys = append(keys, bson.E{Key: key, Value: direction})
+		}
+	default:
+		return fmt.Errorf("memory collection: can't sort by %T", sortBy)
+	}
+
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, key := range keys {
+			a, aFound := lookup(docs[i], key.Key)
+			b, bFound := lookup(docs[j], key.Key)
+
+			order := 0
+			switch {
+			case !aFound && bFound:
+				order = -1
+			case aFound && !bFound:
+				order = 1
+			case aFound && bFound:
+				order, _ = compare(a[0], b[0])
+			}
+			if direction, _ := number(normaliseValue(key.Value)); direction < 0 {
+				order = -order
+			}
+			if order != 0 {
+				return order < 0
+			}
+		}
+		return false
+	})
+	return nil
+}
+
+func normaliseValue(value interface{}) interface{} {
+	doc, err := normalise(bson.M{"value": value})
+	if err != nil {
+		return nil
+	}
+	return doc["value"]
+}
+
+// applyUpdate applies an update document's operators to doc.
+func applyUpdate(doc bson.M, update interface{}) error {
+	operators, err := normalise(update)
+	if err != nil {
+		return err
+	}
+
+	for operator, fields := range operators {
+		changes, ok := fields.(bson.M)
+		if !ok {
+			return fmt.Errorf("memory collection: %s needs a document", operator)
+		}
+
+		for path, value := range changes {
+			switch operator {
+			case "$set":
+				setPath(doc, path, value)
+			case "$unset":
+				unsetPath(doc, path)
+			case "$inc":
+				current, _ := lookup(doc, path)
+				total := value
+				if len(current) > 0 {
+					total = add(current[0], value)
+				}
+				setPath(doc, path, total)
+			case "$push":
+				current, _ := lookup(doc, path)
+				var arr bson.A
+				if len(current) > 0 {
+					arr, _ = current[0].(bson.A)
+				}
+				setPath(doc, path, append(arr, value))
+			case "$addToSet":
+				current, _ := lookup(doc, path)
+				var arr bson.A
+				if len(current) > 0 {
+					arr, _ = current[0].(bson.A)
+				}
+				if !equalsAny(arr, true, value) {
+					arr = append(arr, value)
+				}
+				setPath(doc, path, arr)
+			default:
+				return fmt.Errorf("memory collection: unsupported update operator %s", operator)
+			}
+		}
+	}
+	return nil
+}
+
+func add(a, b interface{}) interface{} {
+	x, xInt := a.(int32)
+	y, yInt := b.(int32)
+	if xInt && yInt {
+		return x + y
+	}
+
+	i, iOk := integer(a)
+	j, jOk := integer(b)
+	if iOk && jOk {
+		return i + j
+	}
+
+	f, _ := number(a)
+	g, _ := number(b)
+	return f + g
+}
+
+func integer(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	}
+	return 0, false
+}
+
+func setPath(doc bson.M, path string, value interface{}) {
+	name, rest, nested := strings.Cut(path, ".")
+	if !nested {
+		doc[name] = value
+		return
+	}
+
+	sub, ok := doc[name].(bson.M)
+	if !ok {
+		sub = bson.M{}
+		doc[name] = sub
+	}
+	setPath(sub, rest, value)
+}
+
+func unsetPath(doc bson.M, path string) {
+	name, rest, nested := strings.Cut(path, ".")
+	if !nested {
+		delete(doc, name)
+		return
+	}
+
+	if sub, ok := doc[name].(bson.M); ok {
+		unsetPath(sub, rest)
+	}
+}
@@ -0,0 +1,278 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type testSensor struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Name      string             `bson:"name"`
+	AccountId string             `bson:"account_id,omitempty"`
+	Tags      []string           `bson:"tags,omitempty"`
+	Readings  int                `bson:"readings"`
+	SeenAt    *time.Time         `bson:"seen_at,omitempty"`
+	Owner     *testOwner         `bson:"owner,omitempty"`
+}
+
+type testOwner struct {
+	Id    string `bson:"_id"`
+	Email string `bson:"email"`
+}
+
+func newTestCollection(t *testing.T, now time.Time) *MemoryCollection {
+	t.Helper()
+
+	earlier := now.Add(-time.Hour)
+	c := NewMemoryCollection()
+	for _, sensor := range []testSensor{
+		{Name: "Inverter", AccountId: "a", Tags: []string{"roof", "east"}, Readings: 3, SeenAt: &now, Owner: &testOwner{Id: "a", Email: "ada@example.com"}},
+		{Name: "Battery", AccountId: "a", Tags: []string{"garage"}, Readings: 10, SeenAt: &earlier},
+		{Name: "Meter", AccountId: "b", Readings: 7},
+	} {
+		sensor.ID = primitive.NewObjectID()
+		if _, err := c.InsertOne(context.Background(), sensor); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func names(t *testing.T, cur *mongo.Cursor) []string {
+	t.Helper()
+
+	var sensors []testSensor
+	if err := cur.All(context.Background(), &sensors); err != nil {
+		t.Fatal(err)
+	}
+
+	found := make([]string, 0, len(sensors))
+	for _, sensor := range sensors {
+		found = append(found, sensor.Name)
+	}
+	return found
+}
+
+func TestMemoryCollectionFind(t *testing.T) {
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name   string
+		filter interface{}
+		opts   *options.FindOptions
+		want   []string
+	}{
+		{name: "everything", filter: bson.D{}, want: []string{"Inverter", "Battery", "Meter"}},
+		{name: "equal", filter: bson.D{{Key: "account_id", Value: "a"}}, want: []string{"Inverter", "Battery"}},
+		{name: "embedded field", filter: bson.D{{Key: "owner._id", Value: "a"}}, want: []string{"Inverter"}},
+		{name: "array element", filter: bson.M{"tags": "garage"}, want: []string{"Battery"}},
+		{name: "in", filter: bson.M{"name": bson.M{"$in": []string{"Meter", "Battery"}}}, want: []string{"Battery", "Meter"}},
+		{name: "not in", filter: bson.M{"name": bson.M{"$nin": []string{"Meter", "Battery"}}}, want: []string{"Inverter"}},
+		{name: "not equal matches missing", filter: bson.M{"account_id": bson.M{"$ne": "a"}}, want: []string{"Meter"}},
+		{name: "missing", filter: bson.M{"seen_at": bson.M{"$exists": false}}, want: []string{"Meter"}},
+		{name: "nil matches missing", filter: bson.M{"owner": nil}, want: []string{"Battery", "Meter"}},
+		{name: "numbers of any type", filter: bson.M{"readings": bson.M{"$gte": int64(7), "$lt": 10.5}}, want: []string{"Battery", "Meter"}},
+		{name: "times", filter: bson.M{"seen_at": bson.M{"$lt": now.Add(-time.Minute)}}, want: []string{"Battery"}},
+		{name: "regex", filter: bson.M{"name": bson.M{"$regex": "^in", "$options": "i"}}, want: []string{"Inverter"}},
+		{name: "or", filter: bson.M{"$or": []bson.M{{"name": "Meter"}, {"tags": "roof"}}}, want: []string{"Inverter", "Meter"}},
+		{name: "and", filter: bson.M{"$and": []bson.M{{"account_id": "a"}, {"readings": bson.M{"$gt": 5}}}}, want: []string{"Battery"}},
+		{name: "elem match", filter: bson.M{"tags": bson.M{"$elemMatch": bson.M{"$eq": "east"}}}, want: []string{"Inverter"}},
+		{name: "sorted", filter: bson.D{}, opts: options.Find().SetSort(bson.D{{Key: "readings", Value: -1}}), want: []string{"Battery", "Meter", "Inverter"}},
+		{name: "paged", filter: bson.D{}, opts: options.Find().SetSort(bson.D{{Key: "name", Value: 1}}).SetSkip(1).SetLimit(1), want: []string{"Inverter"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCollection(t, now)
+
+			opts := tt.opts
+			if opts == nil {
+				opts = options.Find()
+			}
+
+			cur, err := c.Find(context.Background(), tt.filter, opts)
+			if err != nil {
+				t.Fatalf("Find() error = %v", err)
+			}
+			if got := names(t, cur); !slices.Equal(got, tt.want) {
+				t.Errorf("Find() = %v, want %v", got, tt.want)
+			}
+
+			count, err := c.CountDocuments(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatalf("CountDocuments() error = %v", err)
+			}
+			if tt.opts == nil && count != int64(len(tt.want)) {
+				t.Errorf("CountDocuments() = %d, want %d", count, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestMemoryCollectionUpdate(t *testing.T) {
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name         string
+		update       interface{}
+		wantModified int64
+		check        func(t *testing.T, sensor testSensor)
+	}{
+		{
+			name:         "set",
+			update:       bson.M{"$set": bson.M{"name": "Inverter 2", "owner.email": "obi@example.com"}},
+			wantModified: 1,
+			check: func(t *testing.T, sensor testSensor) {
+				if sensor.Name != "Inverter 2" || sensor.Owner.Email != "obi@example.com" || sensor.Owner.Id != "a" {
+					t.Errorf("set %+v %+v", sensor, sensor.Owner)
+				}
+			},
+		},
+		{
+			name:         "unset",
+			update:       bson.M{"$unset": bson.M{"seen_at": ""}},
+			wantModified: 1,
+			check: func(t *testing.T, sensor testSensor) {
+				if sensor.SeenAt != nil {
+					t.Errorf("seen_at = %v, want it removed", sensor.SeenAt)
+				}
+			},
+		},
+		{
+			name:         "inc",
+			update:       map[string]interface{}{"$inc": map[string]interface{}{"readings": 2}},
+			wantModified: 1,
+			check: func(t *testing.T, sensor testSensor) {
+				if sensor.Readings != 5 {
+					t.Errorf("readings = %d, want 5", sensor.Readings)
+				}
+			},
+		},
+		{
+			name:         "push",
+			update:       bson.M{"$push": bson.M{"tags": "west"}},
+			wantModified: 1,
+			check: func(t *testing.T, sensor testSensor) {
+				if len(sensor.Tags) != 3 || sensor.Tags[2] != "west" {
+					t.Errorf("tags = %v, want west appended", sensor.Tags)
+				}
+			},
+		},
+		{
+			name:         "add to set",
+			update:       bson.M{"$addToSet": bson.M{"tags": "west"}},
+			wantModified: 1,
+			check: func(t *testing.T, sensor testSensor) {
+				if len(sensor.Tags) != 3 || sensor.Tags[2] != "west" {
+					t.Errorf("tags = %v, want west added", sensor.Tags)
+				}
+			},
+		},
+		{
+			name:         "add to set already there",
+			update:       bson.M{"$addToSet": bson.M{"tags": "roof"}},
+			wantModified: 0,
+			check: func(t *testing.T, sensor testSensor) {
+				if len(sensor.Tags) != 2 {
+					t.Errorf("tags = %v, want roof left once", sensor.Tags)
+				}
+			},
+		},
+		{
+			name:         "unchanged",
+			update:       bson.M{"$set": bson.M{"name": "Inverter"}},
+			wantModified: 0,
+			check:        func(t *testing.T, sensor testSensor) {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCollection(t, now)
+			filter := bson.D{{Key: "name", Value: "Inverter"}}
+
+			res, err := c.UpdateOne(context.Background(), filter, tt.update)
+			if err != nil {
+				t.Fatalf("UpdateOne() error = %v", err)
+			}
+			if res.MatchedCount != 1 || res.ModifiedCount != tt.wantModified {
+				t.Errorf("UpdateOne() matched %d and modified %d, want 1 and %d", res.MatchedCount, res.ModifiedCount, tt.wantModified)
+			}
+
+			var sensor testSensor
+			if err = c.FindOne(context.Background(), bson.M{"account_id": "a", "owner": bson.M{"$exists": true}}).Decode(&sensor); err != nil {
+				t.Fatalf("FindOne() error = %v", err)
+			}
+			tt.check(t, sensor)
+		})
+	}
+}
+
+func TestMemoryCollectionFindOneAndUpdate(t *testing.T) {
+	c := newTestCollection(t, time.Now().UTC())
+
+	var claimed testSensor
+	err := c.FindOneAndUpdate(context.Background(),
+		bson.M{"account_id": "a"},
+		bson.M{"$inc": bson.M{"readings": 1}},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "readings", Value: -1}}).SetReturnDocument(options.After),
+	).Decode(&claimed)
+	if err != nil {
+		t.Fatalf("FindOneAndUpdate() error = %v", err)
+	}
+	if claimed.Name != "Battery" || claimed.Readings != 11 {
+		t.Errorf("FindOneAndUpdate() = %s with %d readings, want Battery with 11", claimed.Name, claimed.Readings)
+	}
+
+	err = c.FindOneAndUpdate(context.Background(), bson.M{"account_id": "c"}, bson.M{"$set": bson.M{"name": "x"}}).Err()
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("FindOneAndUpdate() of nothing error = %v, want %v", err, mongo.ErrNoDocuments)
+	}
+
+	res, err := c.UpdateOne(context.Background(), bson.D{{Key: "_id", Value: "mailer"}}, bson.M{"$set": bson.M{"name": "token"}}, options.Update().SetUpsert(true))
+	if err != nil || res.UpsertedCount != 1 {
+		t.Fatalf("UpdateOne() upsert = %+v, %v", res, err)
+	}
+	var upserted bson.M
+	if err = c.FindOne(context.Background(), bson.M{"_id": "mailer"}).Decode(&upserted); err != nil || upserted["name"] != "token" {
+		t.Errorf("upserted %v, %v", upserted, err)
+	}
+}
+
+func TestMemoryCollectionReplaceAndDelete(t *testing.T) {
+	c := newTestCollection(t, time.Now().UTC())
+
+	var meter testSensor
+	if err := c.FindOne(context.Background(), bson.M{"name": "Meter"}).Decode(&meter); err != nil {
+		t.Fatal(err)
+	}
+
+	meter.Name = "Main meter"
+	meter.Readings = 0
+	if err := c.FindOneAndReplace(context.Background(), bson.D{{Key: "_id", Value: meter.ID}}, meter).Err(); err != nil {
+		t.Fatalf("FindOneAndReplace() error = %v", err)
+	}
+	if err := c.FindOne(context.Background(), bson.M{"name": "Main meter", "readings": 0}).Err(); err != nil {
+		t.Errorf("replaced document not found: %v", err)
+	}
+
+	res, err := c.DeleteMany(context.Background(), bson.M{"account_id": "a"})
+	if err != nil || res.DeletedCount != 2 {
+		t.Fatalf("DeleteMany() = %+v, %v, want 2 deleted", res, err)
+	}
+	if count, _ := c.CountDocuments(context.Background(), bson.D{}); count != 1 {
+		t.Errorf("%d documents left, want 1", count)
+	}
+
+	if _, err = c.Watch(context.Background(), nil); !errors.Is(err, ErrChangeStreamUnsupported) {
+		t.Errorf("Watch() error = %v, want %v", err, ErrChangeStreamUnsupported)
+	}
+}
@@ -2,8 +2,10 @@ package database
 
 import (
 	"context"
-	"github.com/redis/go-redis/v9"
 	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
 )
 
 var (
@@ -60,3 +62,43 @@ func (rdb *RedisClient) Subscribe(ctx context.Context) *redis.PubSub {
 func (rdb *RedisClient) Publish(ctx context.Context, msg interface{}) error {
 	return rdb.rdb.Publish(ctx, SubscriptionChannel, msg).Err()
 }
+
+// incrScript increments a counter and starts its expiry window in one step, so a key is never
+// left without a ttl. Counters that somehow lost theirs get a fresh window too.
+var incrScript = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+if n == 1 or redis.call("PTTL", KEYS[1]) < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return n
+`)
+
+// Incr increments the counter at key, starting its expiry window on the first increment.
+func (rdb *RedisClient) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	return incrScript.Run(ctx, rdb.rdb, []string{key}, window.Milliseconds()).Int64()
+}
+
+func (rdb *RedisClient) SetWithTTL(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	return rdb.rdb.Set(ctx, key, value, ttl).Err()
+}
+
+// TTL returns the remaining time to live of key, or zero if the key doesn't exist or has no expiry.
+func (rdb *RedisClient) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := rdb.rdb.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func (rdb *RedisClient) Del(ctx context.Context, keys ...string) error {
+	return rdb.rdb.Del(ctx, keys...).Err()
+}
+
+// Cmdable exposes the underlying client, for streams and other commands RedisClient doesn't wrap.
+func (rdb *RedisClient) Cmdable() redis.Cmdable {
+	return rdb.rdb
+}
@@ -0,0 +1,340 @@
+package digest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/events"
+	"github.com/tejiriaustin/narx_api/events/notifications"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/publisher"
+	"github.com/tejiriaustin/narx_api/repository"
+	"github.com/tejiriaustin/narx_api/templates"
+)
+
+const (
+	// defaultSendHour is the local hour from which the digest for the period just ended is sent.
+	defaultSendHour = 7
+
+	topAlertCount = 5
+
+	// offlineWindow is how long before the end of a period a sensor has to have sent a reading
+	// in to not be counted offline.
+	offlineWindow = time.Hour
+
+	dayFormat   = "2006-01-02"
+	alertFormat = "2006-01-02 15:04"
+)
+
+var alertEventTypes = []models.NotificationEventType{
+	models.EventTypeFault,
+	models.EventTypeAlert,
+	models.EventTypeSensorOffline,
+}
+
+type (
+	// ProductionSource supplies the figures of an account's sites that come from their readings.
+	ProductionSource interface {
+		// Production returns the energy produced from from up to to, nil if no sensor sent a reading.
+		Production(ctx context.Context, accountId string, from, to time.Time) (*templates.DigestProduction, error)
+
+		// Reporting returns the ids of the account's sensors that sent a reading from from up to to.
+		Reporting(ctx context.Context, accountId string, from, to time.Time) (map[string]bool, error)
+	}
+
+	// Job compiles each account's digest once its period has ended in the account's timezone,
+	// and publishes it for the listener to email. Energy, performance ratio and offline sensors come
+	// from the production source, the listener always sets one.
+	Job struct {
+		accountsRepo    *repository.Repository[models.Account]
+		preferencesRepo *repository.Repository[models.NotificationPreferences]
+		sensorsRepo     *repository.Repository[models.Sensor]
+		inboxRepo       *repository.Repository[models.InboxNotification]
+		publisher       publisher.PublishInterface
+		production      ProductionSource
+		sendHour        int
+	}
+
+	Options func(j *Job)
+
+	// Period is the span a digest covers, From inclusive and To exclusive, in the account's timezone.
+	// Key identifies it among the account's digests.
+	Period struct {
+		Frequency models.DigestFrequency
+		From      time.Time
+		To        time.Time
+		Key       string
+	}
+)
+
+func NewJob(
+	accountsRepo *repository.Repository[models.Account],
+	preferencesRepo *repository.Repository[models.NotificationPreferences],
+	sensorsRepo *repository.Repository[models.Sensor],
+	inboxRepo *repository.Repository[models.InboxNotification],
+	publisher publisher.PublishInterface,
+	opts ...Options,
+) *Job {
+	j := &Job{
+		accountsRepo:    accountsRepo,
+		preferencesRepo: preferencesRepo,
+		sensorsRepo:     sensorsRepo,
+		inboxRepo:       inboxRepo,
+		publisher:       publisher,
+		sendHour:        defaultSendHour,
+	}
+
+	for _, opt := range opts {
+		opt(j)
+	}
+	return j
+}
+
+func WithProductionSource(source ProductionSource) Options {
+	return func(j *Job) {
+		j.production = source
+	}
+}
+
+func WithSendHour(hour int) Options {
+	return func(j *Job) {
+		j.sendHour = hour
+	}
+}
+
+// LastPeriod returns the most recent complete period as of now in location. Daily periods run
+// midnight to midnight, weekly ones Monday to Monday.
+func LastPeriod(frequency models.DigestFrequency, now time.Time, location *time.Location) Period {
+	local := now.In(location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+
+	if frequency == models.DigestWeekly {
+		weekStart := midnight.AddDate(0, 0, -((int(local.Weekday()) + 6) % 7))
+		from := weekStart.AddDate(0, 0, -7)
+		return Period{
+			Frequency: frequency,
+			From:      from,
+			To:        weekStart,
+			Key:       string(frequency) + ":" + from.Format(dayFormat),
+		}
+	}
+
+	from := midnight.AddDate(0, 0, -1)
+	return Period{
+		Frequency: models.DigestDaily,
+		From:      from,
+		To:        midnight,
+		Key:       string(models.DigestDaily) + ":" + from.Format(dayFormat),
+	}
+}
+
+// Run sends due digests every interval until ctx is cancelled.
+func (j *Job) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := j.SendDue(ctx, time.Now().UTC()); err != nil {
+			log.Printf("failed to send digests: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendDue publishes the digest of every account whose last period ended at least the send hour
+// ago and hasn't had its digest yet. A listener that was down catches up on the next run.
+func (j *Job) SendDue(ctx context.Context, now time.Time) error {
+	filter := repository.NewQueryFilter().AddFilter(models.FieldPreferencesDigest, map[string]interface{}{
+		"$in": []models.DigestFrequency{models.DigestDaily, models.DigestWeekly},
+	})
+
+	preferences, err := j.preferencesRepo.Find(ctx, filter, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range preferences {
+		location, err := time.LoadLocation(p.Timezone)
+		if err != nil {
+			location = time.UTC
+		}
+
+		period := LastPeriod(p.Digest, now, location)
+		if p.LastDigestPeriod == period.Key || now.Sub(period.To) < time.Duration(j.sendHour)*time.Hour {
+			continue
+		}
+
+		claimed, err := j.claim(ctx, p, period)
+		if err != nil {
+			log.Printf("failed to claim digest %s for %s: %s", period.Key, p.AccountInfo.Id, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		if err = j.send(ctx, p.AccountInfo.Id, period); err != nil {
+			log.Printf("failed to send digest %s for %s: %s", period.Key, p.AccountInfo.Id, err)
+			j.release(ctx, p, period)
+		}
+	}
+
+	return nil
+}
+
+// Compile gathers the account's figures for the period.
+func (j *Job) Compile(ctx context.Context, account models.Account, period Period) (templates.DigestData, error) {
+	data := templates.DigestData{
+		FullName: account.FullName,
+		Weekly:   period.Frequency == models.DigestWeekly,
+		From:     period.From.Format(dayFormat),
+		To:       period.To.AddDate(0, 0, -1).Format(dayFormat),
+	}
+
+	sensors, err := j.sensorsRepo.Find(ctx, repository.NewQueryFilter().AddFilter(models.FieldAccountInfoId, account.GetId()), nil, nil)
+	if err != nil {
+		return data, err
+	}
+	data.Sensors = len(sensors)
+
+	if j.production != nil {
+		production, err := j.production.Production(ctx, account.GetId(), period.From, period.To)
+		if err != nil {
+			return data, err
+		}
+		data.Production = production
+
+		reporting, err := j.production.Reporting(ctx, account.GetId(), period.To.Add(-offlineWindow), period.To)
+		if err != nil {
+			return data, err
+		}
+		for _, sensor := range sensors {
+			if !reporting[sensor.GetId()] {
+				data.SensorsOffline++
+			}
+		}
+	}
+
+	filter := repository.NewQueryFilter().
+		AddFilter(models.FieldAccountInfoId, account.GetId()).
+		AddFilter(models.FieldInboxEventType, map[string]interface{}{"$in": alertEventTypes}).
+		AddFilter("created_at", map[string]interface{}{"$gte": period.From.UTC(), "$lt": period.To.UTC()})
+
+	alerts, err := j.inboxRepo.Find(ctx, filter, nil, nil)
+	if err != nil {
+		return data, err
+	}
+
+	for _, alert := range alerts {
+		switch alert.EventType {
+		case models.EventTypeFault:
+			data.FaultAlerts++
+		case models.EventTypeSensorOffline:
+			data.SensorOfflineAlerts++
+		}
+	}
+
+	// most severe first, then most recent
+	sort.SliceStable(alerts, func(a, b int) bool {
+		aFirst, bFirst := alerts[a].Severity.AtLeast(alerts[b].Severity), alerts[b].Severity.AtLeast(alerts[a].Severity)
+		if aFirst != bFirst {
+			return aFirst
+		}
+		return createdAt(alerts[a]).After(createdAt(alerts[b]))
+	})
+
+	for i := 0; i < len(alerts) && i < topAlertCount; i++ {
+		data.TopAlerts = append(data.TopAlerts, templates.DigestAlert{
+			Severity: string(alerts[i].Severity),
+			Title:    alerts[i].Title,
+			Body:     alerts[i].Body,
+			At:       createdAt(alerts[i]).In(period.From.Location()).Format(alertFormat),
+		})
+	}
+
+	return data, nil
+}
+
+func (j *Job) send(ctx context.Context, accountId string, period Period) error {
+	id, err := primitive.ObjectIDFromHex(accountId)
+	if err != nil {
+		return errors.New("invalid account id")
+	}
+
+	account, err := j.accountsRepo.FindOne(ctx, repository.NewQueryFilter().AddFilter(models.FieldId, id), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if !account.IsVerified() || account.IsSuspended() {
+		return nil
+	}
+
+	data, err := j.Compile(ctx, account, period)
+	if err != nil {
+		return err
+	}
+
+	rendered, err := templates.Render(templates.DIGEST, account.Locale, data)
+	if err != nil {
+		return fmt.Errorf("failed to render digest: %w", err)
+	}
+
+	event, err := events.Encode(notifications.DigestNotification, notifications.DigestPayload{
+		Id:       account.GetId(),
+		FullName: account.FullName,
+		Email:    account.Email,
+		Period:   period.Key,
+		Subject:  rendered.Subject,
+		HTML:     rendered.HTML,
+		Text:     rendered.Text,
+	})
+	if err != nil {
+		return err
+	}
+	return j.publisher.Publish(ctx, notifications.DigestNotification, "notification", event)
+}
+
+// claim records the period as sent before sending it, so listeners running side by side don't both send it.
+func (j *Job) claim(ctx context.Context, p models.NotificationPreferences, period Period) (bool, error) {
+	filter := repository.NewQueryFilter().
+		AddFilter(models.FieldId, p.ID).
+		AddFilter(models.FieldPreferencesLastDigestPeriod, map[string]interface{}{"$ne": period.Key})
+
+	modified, err := j.preferencesRepo.UpdateOne(ctx, filter, map[string]interface{}{
+		"$set": map[string]interface{}{models.FieldPreferencesLastDigestPeriod: period.Key},
+	})
+	return modified == 1, err
+}
+
+// release undoes a claim whose digest couldn't be sent, so the next run tries again.
+func (j *Job) release(ctx context.Context, p models.NotificationPreferences, period Period) {
+	filter := repository.NewQueryFilter().
+		AddFilter(models.FieldId, p.ID).
+		AddFilter(models.FieldPreferencesLastDigestPeriod, period.Key)
+
+	_, err := j.preferencesRepo.UpdateOne(ctx, filter, map[string]interface{}{
+		"$set": map[string]interface{}{models.FieldPreferencesLastDigestPeriod: p.LastDigestPeriod},
+	})
+	if err != nil {
+		log.Printf("failed to release digest %s for %s: %s", period.Key, p.AccountInfo.Id, err)
+	}
+}
+
+func createdAt(n models.InboxNotification) time.Time {
+	if n.CreatedAt == nil {
+		return n.ID.Timestamp()
+	}
+	return *n.CreatedAt
+}
@@ -0,0 +1,379 @@
+package digest
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/database"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+// recordingPublisher keeps the bodies of the events it is given, or fails while failing is set.
+type recordingPublisher struct {
+	mu        sync.Mutex
+	failing   bool
+	published []map[string]interface{}
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, key, kind string, message map[string]interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.failing {
+		return errors.New("queue unavailable")
+	}
+	p.published = append(p.published, message)
+	return nil
+}
+
+// periods returns the periods of the digests published so far.
+func (p *recordingPublisher) periods() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var periods []string
+	for _, message := range p.published {
+		period, _ := message["period"].(string)
+		periods = append(periods, period)
+	}
+	return periods
+}
+
+type testRepos struct {
+	accounts    *repository.Repository[models.Account]
+	preferences *repository.Repository[models.NotificationPreferences]
+	sensors     *repository.Repository[models.Sensor]
+	readings    *repository.Repository[models.Reading]
+	inbox       *repository.Repository[models.InboxNotification]
+}
+
+func newTestRepos() testRepos {
+	return testRepos{
+		accounts:    repository.NewRepository[models.Account](database.NewMemoryCollection()),
+		preferences: repository.NewRepository[models.NotificationPreferences](database.NewMemoryCollection()),
+		sensors:     repository.NewRepository[models.Sensor](database.NewMemoryCollection()),
+		readings:    repository.NewRepository[models.Reading](database.NewMemoryCollection()),
+		inbox:       repository.NewRepository[models.InboxNotification](database.NewMemoryCollection()),
+	}
+}
+
+func newShared(at time.Time) models.Shared {
+	return models.Shared{ID: primitive.NewObjectID(), CreatedAt: &at}
+}
+
+func TestLastPeriod(t *testing.T) {
+	lagos, err := time.LoadLocation("Africa/Lagos")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		frequency models.DigestFrequency
+		now       time.Time
+		location  *time.Location
+		wantFrom  time.Time
+		wantTo    time.Time
+		wantKey   string
+	}{
+		{
+			name:      "daily",
+			frequency: models.DigestDaily,
+			now:       time.Date(2024, 3, 6, 9, 30, 0, 0, time.UTC),
+			location:  time.UTC,
+			wantFrom:  time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC),
+			wantTo:    time.Date(2024, 3, 6, 0, 0, 0, 0, time.UTC),
+			wantKey:   "daily:2024-03-05",
+		},
+		{
+			name:      "daily in the account's timezone",
+			frequency: models.DigestDaily,
+			now:       time.Date(2024, 3, 5, 23, 30, 0, 0, time.UTC),
+			location:  lagos,
+			wantFrom:  time.Date(2024, 3, 5, 0, 0, 0, 0, lagos),
+			wantTo:    time.Date(2024, 3, 6, 0, 0, 0, 0, lagos),
+			wantKey:   "daily:2024-03-05",
+		},
+		{
+			name:      "weekly on a wednesday",
+			frequency: models.DigestWeekly,
+			now:       time.Date(2024, 3, 6, 9, 30, 0, 0, time.UTC),
+			location:  time.UTC,
+			wantFrom:  time.Date(2024, 2, 26, 0, 0, 0, 0, time.UTC),
+			wantTo:    time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC),
+			wantKey:   "weekly:2024-02-26",
+		},
+		{
+			name:      "weekly on a sunday",
+			frequency: models.DigestWeekly,
+			now:       time.Date(2024, 3, 10, 9, 30, 0, 0, time.UTC),
+			location:  time.UTC,
+			wantFrom:  time.Date(2024, 2, 26, 0, 0, 0, 0, time.UTC),
+			wantTo:    time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC),
+			wantKey:   "weekly:2024-02-26",
+		},
+		{
+			name:      "weekly on a monday",
+			frequency: models.DigestWeekly,
+			now:       time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC),
+			location:  time.UTC,
+			wantFrom:  time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC),
+			wantTo:    time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC),
+			wantKey:   "weekly:2024-03-04",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := LastPeriod(tt.frequency, tt.now, tt.location)
+			if !got.From.Equal(tt.wantFrom) || !got.To.Equal(tt.wantTo) || got.Key != tt.wantKey {
+				t.Errorf("LastPeriod() = %s to %s (%s), want %s to %s (%s)", got.From, got.To, got.Key, tt.wantFrom, tt.wantTo, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestCompile(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	job := NewJob(repos.accounts, repos.preferences, repos.sensors, repos.inbox, &recordingPublisher{},
+		WithProductionSource(NewReadingsSource(repos.readings)))
+
+	account := models.Account{Shared: newShared(time.Now()), FullName: "Ada Obi"}
+	period := LastPeriod(models.DigestDaily, time.Date(2024, 3, 6, 9, 0, 0, 0, time.UTC), time.UTC)
+	during := func(hour int) time.Time { return period.From.Add(time.Duration(hour) * time.Hour) }
+
+	sensors := make(map[string]models.Sensor)
+	for _, s := range []struct {
+		name  string
+		owner string
+	}{
+		{name: "roof", owner: account.GetId()},
+		{name: "carport", owner: account.GetId()},
+		{name: "shed", owner: account.GetId()},
+		{name: "theirs", owner: "someone else"},
+	} {
+		sensor, err := repos.sensors.Create(ctx, models.Sensor{Shared: newShared(time.Now()), AccountInfo: models.AccountInfo{Id: s.owner}, Name: s.name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sensors[s.name] = sensor
+	}
+
+	// the roof reports all day, the carport stops in the evening and the shed never reports
+	for _, r := range []struct {
+		sensor   string
+		energy   float64
+		expected float64
+		at       time.Time
+	}{
+		{sensor: "roof", energy: 10, expected: 12, at: during(9)},
+		{sensor: "roof", energy: 20, expected: 25, at: during(13)},
+		{sensor: "roof", energy: 0, expected: 0, at: during(23).Add(30 * time.Minute)},
+		{sensor: "carport", energy: 5, expected: 8, at: during(12)},
+		{sensor: "carport", energy: 100, expected: 100, at: during(-2)},
+		{sensor: "roof", energy: 100, expected: 100, at: during(24)},
+		{sensor: "theirs", energy: 100, expected: 100, at: during(12)},
+	} {
+		_, err := repos.readings.Create(ctx, models.Reading{
+			Shared:      newShared(time.Now()),
+			AccountInfo: sensors[r.sensor].AccountInfo,
+			SensorId:    sensors[r.sensor].GetId(),
+			EnergyKWh:   r.energy,
+			ExpectedKWh: r.expected,
+			RecordedAt:  r.at,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, n := range []struct {
+		owner     string
+		eventType models.NotificationEventType
+		severity  models.Severity
+		title     string
+		at        time.Time
+	}{
+		{owner: account.GetId(), eventType: models.EventTypeFault, severity: models.SeverityWarning, title: "early warning", at: during(1)},
+		{owner: account.GetId(), eventType: models.EventTypeFault, severity: models.SeverityCritical, title: "early critical", at: during(2)},
+		{owner: account.GetId(), eventType: models.EventTypeSensorOffline, severity: models.SeverityWarning, title: "late warning", at: during(20)},
+		{owner: account.GetId(), eventType: models.EventTypeAlert, severity: models.SeverityCritical, title: "late critical", at: during(21)},
+		{owner: account.GetId(), eventType: models.EventTypeAlert, severity: models.SeverityInfo, title: "info", at: during(3)},
+		{owner: account.GetId(), eventType: models.EventTypeSensorOffline, severity: models.SeverityInfo, title: "last info", at: during(23)},
+		{owner: account.GetId(), eventType: models.EventTypeFault, severity: models.SeverityCritical, title: "the day before", at: during(-1)},
+		{owner: account.GetId(), eventType: models.EventTypeFault, severity: models.SeverityCritical, title: "the day after", at: during(24)},
+		{owner: account.GetId(), eventType: models.EventTypeAccountSecurity, severity: models.SeverityCritical, title: "not an alert", at: during(4)},
+		{owner: "someone else", eventType: models.EventTypeFault, severity: models.SeverityCritical, title: "someone else's", at: during(5)},
+	} {
+		_, err := repos.inbox.Create(ctx, models.InboxNotification{
+			Shared:      newShared(n.at),
+			AccountInfo: models.AccountInfo{Id: n.owner},
+			EventType:   n.eventType,
+			Severity:    n.severity,
+			Title:       n.title,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := job.Compile(ctx, account, period)
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+
+	if data.From != "2024-03-05" || data.To != "2024-03-05" || data.Weekly {
+		t.Errorf("Compile() covers %s to %s, weekly %v, want the single day 2024-03-05", data.From, data.To, data.Weekly)
+	}
+	if data.Sensors != 3 || data.SensorsOffline != 2 {
+		t.Errorf("Compile() = %d of %d sensors offline, want 2 of 3", data.SensorsOffline, data.Sensors)
+	}
+	if data.FaultAlerts != 2 || data.SensorOfflineAlerts != 2 {
+		t.Errorf("Compile() = %d fault alerts, %d offline alerts, want 2 of each", data.FaultAlerts, data.SensorOfflineAlerts)
+	}
+	// only the readings of the account's sensors during the day count
+	if p := data.Production; p == nil || p.EnergyKWh != 35 || p.ExpectedKWh != 45 || math.Abs(p.PerformanceRatio-77.78) > 0.01 {
+		t.Errorf("Compile() production = %+v, want 35 of the 45 kWh expected", p)
+	}
+
+	var titles []string
+	for _, alert := range data.TopAlerts {
+		titles = append(titles, alert.Title)
+	}
+	// most severe first, then most recent, and only the top five
+	if got, want := strings.Join(titles, ", "), "late critical, early critical, late warning, early warning, last info"; got != want {
+		t.Errorf("Compile() top alerts = %s, want %s", got, want)
+	}
+	if data.TopAlerts[0].At != "2024-03-05 21:00" {
+		t.Errorf("top alert at %s, want 2024-03-05 21:00", data.TopAlerts[0].At)
+	}
+}
+
+func TestCompileWithoutReadings(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	job := NewJob(repos.accounts, repos.preferences, repos.sensors, repos.inbox, &recordingPublisher{},
+		WithProductionSource(NewReadingsSource(repos.readings)))
+
+	account := models.Account{Shared: newShared(time.Now())}
+	for i := 0; i < 2; i++ {
+		if _, err := repos.sensors.Create(ctx, models.Sensor{Shared: newShared(time.Now()), AccountInfo: account.GetAccountInfo()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	period := LastPeriod(models.DigestWeekly, time.Date(2024, 3, 6, 9, 0, 0, 0, time.UTC), time.UTC)
+	data, err := job.Compile(ctx, account, period)
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+
+	if data.Production != nil {
+		t.Errorf("Compile() production = %+v without readings, want none", data.Production)
+	}
+	if data.Sensors != 2 || data.SensorsOffline != 2 {
+		t.Errorf("Compile() = %d of %d sensors offline, want every sensor", data.SensorsOffline, data.Sensors)
+	}
+	if !data.Weekly || data.From != "2024-02-26" || data.To != "2024-03-03" {
+		t.Errorf("Compile() covers %s to %s, weekly %v, want the week of 2024-02-26", data.From, data.To, data.Weekly)
+	}
+}
+
+func TestSendDue(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	publisher := &recordingPublisher{}
+	job := NewJob(repos.accounts, repos.preferences, repos.sensors, repos.inbox, publisher, WithSendHour(7))
+
+	for _, a := range []struct {
+		email    string
+		status   models.Status
+		digest   models.DigestFrequency
+		timezone string
+	}{
+		{email: "daily@example.com", status: models.ActiveStatus, digest: models.DigestDaily, timezone: "UTC"},
+		{email: "weekly@example.com", status: models.ActiveStatus, digest: models.DigestWeekly, timezone: "UTC"},
+		{email: "lagos@example.com", status: models.ActiveStatus, digest: models.DigestDaily, timezone: "Africa/Lagos"},
+		{email: "off@example.com", status: models.ActiveStatus, digest: models.DigestOff, timezone: "UTC"},
+		{email: "unverified@example.com", status: models.PendingVerificationStatus, digest: models.DigestDaily, timezone: "UTC"},
+		{email: "suspended@example.com", status: models.SuspendedStatus, digest: models.DigestDaily, timezone: "UTC"},
+	} {
+		account, err := repos.accounts.Create(ctx, models.Account{Shared: newShared(time.Now()), FullName: "Ada Obi", Email: a.email, Status: a.status})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = repos.preferences.Create(ctx, models.NotificationPreferences{
+			Shared:      newShared(time.Now()),
+			AccountInfo: account.GetAccountInfo(),
+			Timezone:    a.timezone,
+			Digest:      a.digest,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want []string
+	}{
+		// 06:30 utc is 07:30 in lagos, and last week ended two days ago
+		{name: "before the send hour in utc", now: time.Date(2024, 3, 6, 6, 30, 0, 0, time.UTC), want: []string{"daily:2024-03-05", "weekly:2024-02-26"}},
+		{name: "after the send hour", now: time.Date(2024, 3, 6, 7, 30, 0, 0, time.UTC), want: []string{"daily:2024-03-05", "daily:2024-03-05", "weekly:2024-02-26"}},
+		{name: "each period is sent once", now: time.Date(2024, 3, 6, 12, 0, 0, 0, time.UTC), want: []string{"daily:2024-03-05", "daily:2024-03-05", "weekly:2024-02-26"}},
+		{name: "next day", now: time.Date(2024, 3, 7, 8, 0, 0, 0, time.UTC), want: []string{"daily:2024-03-05", "daily:2024-03-05", "weekly:2024-02-26", "daily:2024-03-06", "daily:2024-03-06"}},
+	}
+
+	for _, tt := range tests {
+		if err := job.SendDue(ctx, tt.now); err != nil {
+			t.Fatalf("%s: SendDue() error = %v", tt.name, err)
+		}
+		got, want := publisher.periods(), slices.Clone(tt.want)
+		slices.Sort(got)
+		slices.Sort(want)
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: sent %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+func TestSendDueRetriesFailedSends(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	publisher := &recordingPublisher{failing: true}
+	job := NewJob(repos.accounts, repos.preferences, repos.sensors, repos.inbox, publisher)
+
+	account, err := repos.accounts.Create(ctx, models.Account{Shared: newShared(time.Now()), Email: "ada@example.com", Status: models.ActiveStatus})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repos.preferences.Create(ctx, models.NotificationPreferences{Shared: newShared(time.Now()), AccountInfo: account.GetAccountInfo(), Timezone: "UTC", Digest: models.DigestDaily})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 3, 6, 9, 0, 0, 0, time.UTC)
+	if err = job.SendDue(ctx, now); err != nil {
+		t.Fatalf("SendDue() error = %v", err)
+	}
+
+	// the claim is released, so the next run sends the digest the failed one couldn't
+	publisher.failing = false
+	if err = job.SendDue(ctx, now.Add(time.Hour)); err != nil {
+		t.Fatalf("SendDue() error = %v", err)
+	}
+	if got := publisher.periods(); len(got) != 1 || got[0] != "daily:2024-03-05" {
+		t.Errorf("sent %v, want the 2024-03-05 digest once", got)
+	}
+}
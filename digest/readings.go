@@ -0,0 +1,64 @@
+package digest
+
+import (
+	"context"
+	"time"
+
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+	"github.com/tejiriaustin/narx_api/templates"
+)
+
+// ReadingsSource computes digest figures from the readings the ingest pipeline stores.
+type ReadingsSource struct {
+	readingsRepo *repository.Repository[models.Reading]
+}
+
+var _ ProductionSource = (*ReadingsSource)(nil)
+
+func NewReadingsSource(readingsRepo *repository.Repository[models.Reading]) *ReadingsSource {
+	return &ReadingsSource{readingsRepo: readingsRepo}
+}
+
+// Production totals the energy the account's sensors produced from from up to to. The performance
+// ratio is that energy as a percentage of what was expected under the measured irradiance.
+func (s *ReadingsSource) Production(ctx context.Context, accountId string, from, to time.Time) (*templates.DigestProduction, error) {
+	readings, err := s.find(ctx, accountId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if len(readings) == 0 {
+		return nil, nil
+	}
+
+	production := &templates.DigestProduction{}
+	for _, reading := range readings {
+		production.EnergyKWh += reading.EnergyKWh
+		production.ExpectedKWh += reading.ExpectedKWh
+	}
+	if production.ExpectedKWh > 0 {
+		production.PerformanceRatio = production.EnergyKWh / production.ExpectedKWh * 100
+	}
+	return production, nil
+}
+
+func (s *ReadingsSource) Reporting(ctx context.Context, accountId string, from, to time.Time) (map[string]bool, error) {
+	readings, err := s.find(ctx, accountId, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	reporting := make(map[string]bool)
+	for _, reading := range readings {
+		reporting[reading.SensorId] = true
+	}
+	return reporting, nil
+}
+
+func (s *ReadingsSource) find(ctx context.Context, accountId string, from, to time.Time) ([]models.Reading, error) {
+	filter := repository.NewQueryFilter().
+		AddFilter(models.FieldAccountInfoId, accountId).
+		AddFilter(models.FieldReadingRecordedAt, map[string]interface{}{"$gte": from.UTC(), "$lt": to.UTC()})
+
+	return s.readingsRepo.Find(ctx, filter, nil, nil)
+}
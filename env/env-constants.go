@@ -7,8 +7,14 @@ const (
 
 	MongoDbName = "MONGO_DB_NAME"
 
+	RedisDsn = "REDIS_DSN"
+
+	RedisPassword = "REDIS_PASSWORD"
+
 	FrontendUrl = "FRONTEND_URL"
 
+	ApiUrl = "API_URL"
+
 	JwtSecret = "JWT_SECRET_KEY"
 
 	SmtpHost = "SMTP_HOST"
@@ -19,9 +25,67 @@ const (
 
 	SmtpPassword = "SMTP_PASSWORD"
 
-	FirebaseAuthKey = "FIREBASE_AUTH_KEY"
+	SmtpUsername = "SMTP_USERNAME"
+
+	SmtpSenderName = "SMTP_SENDER_NAME"
+
+	SmtpTlsMode = "SMTP_TLS_MODE"
+
+	SmtpAuth = "SMTP_AUTH"
+
+	SmtpTimeout = "SMTP_TIMEOUT"
+
+	SmtpMaxConnections = "SMTP_MAX_CONNECTIONS"
+
+	MailTransport = "MAIL_TRANSPORT"
+
+	MailSinkDir = "MAIL_SINK_DIR"
+
+	SmsProvider = "SMS_PROVIDER"
+
+	SmsSender = "SMS_SENDER"
+
+	SmsRateLimit = "SMS_RATE_LIMIT"
+
+	SmsRateWindow = "SMS_RATE_WINDOW"
+
+	TwilioAccountSid = "TWILIO_ACCOUNT_SID"
+
+	TwilioAuthToken = "TWILIO_AUTH_TOKEN"
 
-	FirebaseRegistrationToken = "FIREBASE_REGISTRATION_TOKEN"
+	PushProvider = "PUSH_PROVIDER"
+
+	FirebaseAuthKey = "FIREBASE_AUTH_KEY"
 
 	FirebaseServiceAccountKey = "FIREBASE_SERVICE_ACCOUNT_KEY"
+
+	DeviceExpiryPeriod = "DEVICE_EXPIRY_PERIOD"
+
+	WebhookRetryInterval = "WEBHOOK_RETRY_INTERVAL"
+
+	DigestInterval = "DIGEST_INTERVAL"
+
+	ConsumerGroup = "CONSUMER_GROUP"
+
+	ConsumerLease = "CONSUMER_LEASE"
+
+	ConsumerMode = "CONSUMER_MODE"
+
+	EventQueue = "EVENT_QUEUE"
+
+	EventSecretKey = "EVENT_SECRET_KEY"
+
+	EventStream = "EVENT_STREAM"
+
+	EventStreamMaxLen = "EVENT_STREAM_MAX_LEN"
+
+	TrustedProxies = "TRUSTED_PROXIES"
+
+	PasswordResetRateLimit = "PASSWORD_RESET_RATE_LIMIT"
+
+	PasswordResetRateWindow = "PASSWORD_RESET_RATE_WINDOW"
+
+	TotpIssuer = "TOTP_ISSUER"
+
+	WebhookAllowLocalhost = "WEBHOOK_ALLOW_LOCALHOST"
 )
@@ -3,6 +3,7 @@ package env
 import (
 	"log"
 	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -35,6 +36,32 @@ func (e Environment) GetFloat64(key string) float64 {
 	return valueAsFloat
 }
 
+func (e Environment) GetAsInt(key string) int {
+	value := e[key]
+
+	valueAsString, _ := value.(string)
+	valueAsInt, err := strconv.Atoi(valueAsString)
+
+	if err != nil {
+		log.Fatal("couldn't parse value as int: ", err.Error())
+		return 0
+	}
+	return valueAsInt
+}
+
+func (e Environment) GetAsDuration(key string) time.Duration {
+	value := e[key]
+
+	valueAsString, _ := value.(string)
+	valueAsDuration, err := time.ParseDuration(valueAsString)
+
+	if err != nil {
+		log.Fatal("couldn't parse value as duration: ", err.Error())
+		return 0
+	}
+	return valueAsDuration
+}
+
 func (e Environment) GetAsString(key string) string {
 	value := e[key]
 
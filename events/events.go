@@ -1,6 +1,19 @@
 package events
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type DeliveryStatus string // Where an event is in its handling by a consumer group
+
+const (
+	DeliveryPending    DeliveryStatus = "pending"
+	DeliveryInProgress DeliveryStatus = "in_progress"
+	DeliveryDone       DeliveryStatus = "done"
+	DeliveryDead       DeliveryStatus = "dead"
+)
 
 type (
 	Event struct {
@@ -8,6 +21,26 @@ type (
 		EventKind string                 `json:"event_kind" bson:"event_kind"`
 		EventKey  string                 `json:"event_key" bson:"event_key"`
 		MsgBody   map[string]interface{} `json:"msg_body" bson:"msg_body"`
-		Processed bool                   `json:"processed" bson:"processed"`
+
+		// Processed is set on events handled before deliveries were tracked per group, which
+		// consumers skip. Events published since are never marked processed.
+		Processed bool `json:"processed" bson:"processed"`
+
+		// Deliveries tracks the handling of the event by each consumer group, keyed by group name.
+		Deliveries map[string]Delivery `json:"deliveries" bson:"deliveries,omitempty"`
+	}
+
+	// Delivery is one consumer group's handling of an event. While it is in progress the event is
+	// leased to Owner, and any consumer in the group may reclaim it once LeaseExpiresAt has passed.
+	// A failed event waits as pending until NextAttemptAt, and is dead once it runs out of attempts.
+	Delivery struct {
+		Status         DeliveryStatus `json:"status" bson:"status"`
+		Owner          string         `json:"owner" bson:"owner,omitempty"`
+		ClaimedAt      *time.Time     `json:"claimed_at" bson:"claimed_at,omitempty"`
+		LeaseExpiresAt *time.Time     `json:"lease_expires_at" bson:"lease_expires_at,omitempty"`
+		CompletedAt    *time.Time     `json:"completed_at" bson:"completed_at,omitempty"`
+		Attempts       int            `json:"attempts" bson:"attempts"`
+		LastError      string         `json:"last_error" bson:"last_error,omitempty"`
+		NextAttemptAt  *time.Time     `json:"next_attempt_at" bson:"next_attempt_at,omitempty"`
 	}
 )
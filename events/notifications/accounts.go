@@ -3,32 +3,63 @@ package notifications
 import (
 	"context"
 	"errors"
-	"fmt"
+	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/tejiriaustin/narx_api/consumer"
 	"github.com/tejiriaustin/narx_api/events"
 	"github.com/tejiriaustin/narx_api/messaging"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
 	"github.com/tejiriaustin/narx_api/templates"
 )
 
 const (
-	ForgotPasswordNotification = "NOTIFICATION.FORGOT_PASSWORD"
+	ForgotPasswordNotification    = "NOTIFICATION.FORGOT_PASSWORD"
+	AccountCreatedNotification    = "NOTIFICATION.ACCOUNT_CREATED"
+	AccountLockedNotification     = "NOTIFICATION.ACCOUNT_LOCKED"
+	PhoneVerificationNotification = "NOTIFICATION.PHONE_VERIFICATION"
+	DigestNotification            = "NOTIFICATION.DIGEST"
 )
 
-func ForgotPasswordNotificationEventHandler(mailer messaging.Messaging) consumer.Handler {
+// ForgotPasswordNotificationEventHandler emails a password reset code. The code is sealed in the
+// event, and only opened here to go in the email.
+func ForgotPasswordNotificationEventHandler(
+	mailer messaging.Messaging,
+	sealer *events.Sealer,
+	preferencesRepo *repository.Repository[models.NotificationPreferences],
+) consumer.Handler {
 	return func(ctx context.Context, msg events.Event) error {
-		fmt.Println("Forgot Password")
+		var payload ForgotPasswordPayload
+		if err := events.Decode(msg, &payload); err != nil {
+			return err
+		}
+
+		channels := deliveryChannels(ctx, preferencesRepo, payload.Id, models.EventTypeAccountSecurity, models.SeverityInfo)
+		if !models.HasNotificationChannel(channels, models.ChannelEmail) {
+			return nil
+		}
+
+		code := payload.Code
+		if payload.SealedCode != "" {
+			var err error
+			if code, err = sealer.Open(payload.SealedCode); err != nil {
+				zap.L().Error("failed to open forgot password code", zap.Error(err), zap.String("event_id", msg.ID.Hex()))
+				return errors.New("failed to send forgot password email")
+			}
+		}
 
-		template, err := templates.NewTemplate(templates.FORGOT_PASSWORD, msg.MsgBody["full_name"], msg.MsgBody["code"])
+		rendered, err := templates.Render(templates.FORGOT_PASSWORD, payload.Locale, templates.ForgotPasswordData{
+			FullName: payload.FullName,
+			Code:     code,
+		})
 		if err != nil {
-			zap.L().Error("failed to create template for forgot password", zap.String("template", template), zap.Any("data", msg))
+			zap.L().Error("failed to render forgot password template", zap.Error(err), zap.String("event_id", msg.ID.Hex()))
 			return errors.New("failed to send forgot password email")
 		}
-		email := msg.MsgBody["email"].(string)
 
-		err = mailer.Push(email, template)
+		err = mailer.Send(ctx, emailMessage(msg, payload.FullName, payload.Email, rendered))
 		if err != nil {
 			zap.L().Error("failed to push mail", zap.Error(err))
 			return err
@@ -37,3 +68,153 @@ func ForgotPasswordNotificationEventHandler(mailer messaging.Messaging) consumer
 		return nil
 	}
 }
+
+func AccountCreatedNotificationEventHandler(
+	mailer messaging.Messaging,
+	preferencesRepo *repository.Repository[models.NotificationPreferences],
+) consumer.Handler {
+	return func(ctx context.Context, msg events.Event) error {
+
+		var payload AccountCreatedPayload
+		if err := events.Decode(msg, &payload); err != nil {
+			return err
+		}
+
+		channels := deliveryChannels(ctx, preferencesRepo, payload.Id, models.EventTypeAccountSecurity, models.SeverityInfo)
+		if !models.HasNotificationChannel(channels, models.ChannelEmail) {
+			return nil
+		}
+
+		rendered, err := templates.Render(templates.ACCOUNT_CREATED, payload.Locale, templates.AccountCreatedData{
+			FullName:         payload.FullName,
+			VerificationLink: payload.VerificationLink,
+		})
+		if err != nil {
+			zap.L().Error("failed to render account created template", zap.Error(err), zap.String("event_id", msg.ID.Hex()))
+			return errors.New("failed to send verification email")
+		}
+
+		err = mailer.Send(ctx, emailMessage(msg, payload.FullName, payload.Email, rendered))
+		if err != nil {
+			zap.L().Error("failed to push mail", zap.Error(err))
+			return err
+		}
+
+		return nil
+	}
+}
+
+func AccountLockedNotificationEventHandler(
+	mailer messaging.Messaging,
+	preferencesRepo *repository.Repository[models.NotificationPreferences],
+) consumer.Handler {
+	return func(ctx context.Context, msg events.Event) error {
+
+		var payload AccountLockedPayload
+		if err := events.Decode(msg, &payload); err != nil {
+			return err
+		}
+
+		channels := deliveryChannels(ctx, preferencesRepo, payload.Id, models.EventTypeAccountSecurity, models.SeverityWarning)
+		if !models.HasNotificationChannel(channels, models.ChannelEmail) {
+			return nil
+		}
+
+		// validated as RFC 3339 when decoded
+		lockedUntil, _ := time.Parse(time.RFC3339, payload.LockedUntil)
+
+		rendered, err := templates.Render(templates.ACCOUNT_LOCKED, payload.Locale, templates.AccountLockedData{
+			FullName:    payload.FullName,
+			LockedUntil: lockedUntil.UTC().Format(time.RFC1123),
+		})
+		if err != nil {
+			zap.L().Error("failed to render account locked template", zap.Error(err), zap.Any("data", msg))
+			return errors.New("failed to send account locked email")
+		}
+
+		err = mailer.Send(ctx, emailMessage(msg, payload.FullName, payload.Email, rendered))
+		if err != nil {
+			zap.L().Error("failed to push mail", zap.Error(err))
+			return err
+		}
+
+		return nil
+	}
+}
+
+// PhoneVerificationNotificationEventHandler texts the code confirming a phone number. It is sent
+// whatever the preferences say, since the user has just asked for it.
+func PhoneVerificationNotificationEventHandler(sms messaging.Messaging) consumer.Handler {
+	return func(ctx context.Context, msg events.Event) error {
+
+		var payload PhoneVerificationPayload
+		if err := events.Decode(msg, &payload); err != nil {
+			return err
+		}
+
+		rendered, err := templates.Render(templates.PHONE_VERIFICATION, payload.Locale, templates.PhoneVerificationData{
+			Code:             payload.Code,
+			ExpiresInMinutes: payload.ExpiresInMinutes,
+		})
+		if err != nil {
+			zap.L().Error("failed to render phone verification sms", zap.String("event_id", msg.ID.Hex()), zap.Error(err))
+			return err
+		}
+
+		message := messaging.NewMessage("", messaging.Recipient{Address: payload.Phone})
+		message.Text = rendered.Text
+		message.Metadata = map[string]string{
+			"event_id":  msg.ID.Hex(),
+			"event_key": msg.EventKey,
+		}
+
+		err = sms.Send(ctx, message)
+		if errors.Is(err, messaging.ErrRateLimited) {
+			// retrying would only be limited again, the user can ask for another code later
+			zap.L().Warn("phone verification sms rate limited", zap.String("phone", payload.Phone))
+			return nil
+		}
+		if err != nil {
+			zap.L().Error("failed to send phone verification sms", zap.Error(err))
+			return err
+		}
+
+		return nil
+	}
+}
+
+// DigestNotificationEventHandler emails a digest, which is rendered when it is compiled so the event
+// holds exactly what is sent. Accounts opt in to digests in their preferences.
+func DigestNotificationEventHandler(mailer messaging.Messaging) consumer.Handler {
+	return func(ctx context.Context, msg events.Event) error {
+
+		var payload DigestPayload
+		if err := events.Decode(msg, &payload); err != nil {
+			return err
+		}
+
+		err := mailer.Send(ctx, emailMessage(msg, payload.FullName, payload.Email, &templates.Rendered{
+			Subject: payload.Subject,
+			HTML:    payload.HTML,
+			Text:    payload.Text,
+		}))
+		if err != nil {
+			zap.L().Error("failed to email digest", zap.Error(err))
+			return err
+		}
+
+		return nil
+	}
+}
+
+// emailMessage addresses a rendered email to a single account, tagged with the event it was sent for.
+func emailMessage(event events.Event, name, email string, rendered *templates.Rendered) messaging.Message {
+	message := messaging.NewMessage(rendered.Subject, messaging.Recipient{Name: name, Address: email})
+	message.HTML = rendered.HTML
+	message.Text = rendered.Text
+	message.Metadata = map[string]string{
+		"event_id":  event.ID.Hex(),
+		"event_key": event.EventKey,
+	}
+	return message
+}
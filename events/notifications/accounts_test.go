@@ -0,0 +1,83 @@
+package notifications
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/database"
+	"github.com/tejiriaustin/narx_api/events"
+	"github.com/tejiriaustin/narx_api/messaging"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+func TestForgotPasswordNotificationEventHandler(t *testing.T) {
+	sealer, err := events.NewSealer("event-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := events.NewSealer("another secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seal := func(sealer *events.Sealer, code string) string {
+		sealed, err := sealer.Seal(code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sealed
+	}
+
+	tests := []struct {
+		name    string
+		body    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name: "sealed code is opened for the email",
+			body: map[string]interface{}{"email": "ada@example.com", "sealed_code": seal(sealer, "482913"), events.SchemaVersionField: 2},
+		},
+		{
+			name: "version 1 carried the code in the clear",
+			body: map[string]interface{}{"email": "ada@example.com", "code": "482913"},
+		},
+		{
+			name:    "code sealed with another key",
+			body:    map[string]interface{}{"email": "ada@example.com", "sealed_code": seal(other, "482913"), events.SchemaVersionField: 2},
+			wantErr: true,
+		},
+		{
+			name:    "no code",
+			body:    map[string]interface{}{"email": "ada@example.com", events.SchemaVersionField: 2},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mailer := messaging.NewMemorySink()
+			preferencesRepo := repository.NewRepository[models.NotificationPreferences](database.NewMemoryCollection())
+			handler := ForgotPasswordNotificationEventHandler(mailer, sealer, preferencesRepo)
+
+			err := handler(context.Background(), events.Event{ID: primitive.NewObjectID(), EventKey: ForgotPasswordNotification, MsgBody: tt.body})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("handler error = %v, want error %v", err, tt.wantErr)
+			}
+
+			sent := mailer.SentTo("ada@example.com")
+			if tt.wantErr {
+				if len(sent) != 0 {
+					t.Errorf("%d emails sent, want none", len(sent))
+				}
+				return
+			}
+			if len(sent) != 1 || !strings.Contains(sent[0].Text, "482913") {
+				t.Errorf("emails sent = %+v, want one with the code", sent)
+			}
+		})
+	}
+}
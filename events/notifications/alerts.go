@@ -0,0 +1,148 @@
+package notifications
+
+import (
+	"context"
+	"time"
+
+	"github.com/tejiriaustin/narx_api/consumer"
+	"github.com/tejiriaustin/narx_api/events"
+	"github.com/tejiriaustin/narx_api/models"
+)
+
+// These events are published to the shared queue by the services that monitor sites and handle
+// support tickets, nothing in this service raises them.
+const (
+	FaultNotification          = "NOTIFICATION.FAULT"
+	AlertNotification          = "NOTIFICATION.ALERT"
+	SensorOfflineNotification  = "NOTIFICATION.SENSOR_OFFLINE"
+	TicketAssignedNotification = "NOTIFICATION.TICKET_ASSIGNED"
+)
+
+// FaultNotificationEventHandler notifies a sensor's owner of a fault detected on it.
+func FaultNotificationEventHandler(notifier *AccountNotifier) consumer.Handler {
+	return func(ctx context.Context, msg events.Event) error {
+
+		var payload FaultPayload
+		if err := events.Decode(msg, &payload); err != nil {
+			return err
+		}
+
+		return notifier.notify(ctx, msg, payload.notification())
+	}
+}
+
+// AlertNotificationEventHandler notifies an account of an alert about its sites.
+func AlertNotificationEventHandler(notifier *AccountNotifier) consumer.Handler {
+	return func(ctx context.Context, msg events.Event) error {
+
+		var payload AlertPayload
+		if err := events.Decode(msg, &payload); err != nil {
+			return err
+		}
+
+		return notifier.notify(ctx, msg, payload.notification())
+	}
+}
+
+// SensorOfflineNotificationEventHandler notifies a sensor's owner that it has stopped reporting.
+func SensorOfflineNotificationEventHandler(notifier *AccountNotifier) consumer.Handler {
+	return func(ctx context.Context, msg events.Event) error {
+
+		var payload SensorOfflinePayload
+		if err := events.Decode(msg, &payload); err != nil {
+			return err
+		}
+
+		return notifier.notify(ctx, msg, payload.notification())
+	}
+}
+
+// TicketAssignedNotificationEventHandler notifies an account of a support ticket assigned to it.
+func TicketAssignedNotificationEventHandler(notifier *AccountNotifier) consumer.Handler {
+	return func(ctx context.Context, msg events.Event) error {
+
+		var payload TicketAssignedPayload
+		if err := events.Decode(msg, &payload); err != nil {
+			return err
+		}
+
+		return notifier.notify(ctx, msg, payload.notification())
+	}
+}
+
+func (p FaultPayload) notification() UserNotificationPayload {
+	title := "Fault detected"
+	if p.SensorName != "" {
+		title += " on " + p.SensorName
+	}
+
+	return UserNotificationPayload{
+		AccountId: p.AccountId,
+		EventType: models.EventTypeFault,
+		Severity:  p.Severity,
+		Title:     title,
+		Body:      p.Description,
+		Data: map[string]string{
+			"sensor_id": p.SensorId,
+			"code":      p.Code,
+		},
+	}
+}
+
+func (p AlertPayload) notification() UserNotificationPayload {
+	notification := UserNotificationPayload{
+		AccountId: p.AccountId,
+		EventType: models.EventTypeAlert,
+		Severity:  p.Severity,
+		Title:     p.Title,
+		Body:      p.Body,
+	}
+	if p.SensorId != "" {
+		notification.Data = map[string]string{"sensor_id": p.SensorId}
+	}
+	return notification
+}
+
+func (p SensorOfflinePayload) notification() UserNotificationPayload {
+	body := sensorName(p.SensorName) + " has stopped reporting."
+	if lastSeenAt, err := time.Parse(time.RFC3339, p.LastSeenAt); err == nil {
+		body = sensorName(p.SensorName) + " has not reported since " + lastSeenAt.UTC().Format(time.RFC1123) + "."
+	}
+
+	return UserNotificationPayload{
+		AccountId: p.AccountId,
+		EventType: models.EventTypeSensorOffline,
+		Severity:  models.SeverityWarning,
+		Title:     sensorName(p.SensorName) + " is offline",
+		Body:      body,
+		Data: map[string]string{
+			"sensor_id": p.SensorId,
+		},
+	}
+}
+
+func (p TicketAssignedPayload) notification() UserNotificationPayload {
+	body := p.Subject
+	if p.AssignedBy != "" {
+		body = p.AssignedBy + " assigned you: " + p.Subject
+	}
+
+	return UserNotificationPayload{
+		AccountId: p.AccountId,
+		EventType: models.EventTypeTicketAssigned,
+		Severity:  models.SeverityInfo,
+		Title:     "Ticket assigned to you",
+		Body:      body,
+		Data: map[string]string{
+			"ticket_id": p.TicketId,
+		},
+	}
+}
+
+// sensorName is how a sensor is referred to in a notification, sensors don't have to be named.
+func sensorName(name string) string {
+	if name == "" {
+		return "A sensor"
+	}
+	return name
+}
@@ -0,0 +1,363 @@
+package notifications
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/consumer"
+	"github.com/tejiriaustin/narx_api/database"
+	"github.com/tejiriaustin/narx_api/events"
+	"github.com/tejiriaustin/narx_api/messaging"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+	"github.com/tejiriaustin/narx_api/webhooks"
+)
+
+func TestAlertHandlersFillInboxByPreferences(t *testing.T) {
+	// faults are pushed from warning up, alerts are only emailed when critical, sensors going offline
+	// are emailed and ticket assignments have no rule, so they only reach the inbox
+	rules := []models.NotificationRule{
+		{EventType: models.EventTypeFault, MinSeverity: models.SeverityWarning, Channels: []models.NotificationChannel{models.ChannelPush}},
+		{EventType: models.EventTypeAlert, MinSeverity: models.SeverityCritical, Channels: []models.NotificationChannel{models.ChannelEmail}},
+		{EventType: models.EventTypeSensorOffline, MinSeverity: models.SeverityInfo, Channels: []models.NotificationChannel{models.ChannelEmail}},
+	}
+
+	lastSeenAt := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		key       string
+		handler   func(notifier *AccountNotifier) consumer.Handler
+		payload   func(accountId string) events.Payload
+		eventType models.NotificationEventType
+		severity  models.Severity
+		title     string
+		body      string
+		data      map[string]string
+		pushed    int
+		emailed   int
+	}{
+		{
+			name:    "fault at warning is pushed",
+			key:     FaultNotification,
+			handler: FaultNotificationEventHandler,
+			payload: func(accountId string) events.Payload {
+				return FaultPayload{AccountId: accountId, SensorId: "sensor-1", SensorName: "Roof array", Severity: models.SeverityWarning, Code: "ARC_FAULT", Description: "An arc fault was detected."}
+			},
+			eventType: models.EventTypeFault,
+			severity:  models.SeverityWarning,
+			title:     "Fault detected on Roof array",
+			body:      "An arc fault was detected.",
+			data:      map[string]string{"sensor_id": "sensor-1", "code": "ARC_FAULT"},
+			pushed:    1,
+		},
+		{
+			name:    "fault below the rule's severity only reaches the inbox",
+			key:     FaultNotification,
+			handler: FaultNotificationEventHandler,
+			payload: func(accountId string) events.Payload {
+				return FaultPayload{AccountId: accountId, SensorId: "sensor-1", Severity: models.SeverityInfo, Code: "LOW_ISOLATION"}
+			},
+			eventType: models.EventTypeFault,
+			severity:  models.SeverityInfo,
+			title:     "Fault detected",
+			data:      map[string]string{"sensor_id": "sensor-1", "code": "LOW_ISOLATION"},
+		},
+		{
+			name:    "critical alert is emailed",
+			key:     AlertNotification,
+			handler: AlertNotificationEventHandler,
+			payload: func(accountId string) events.Payload {
+				return AlertPayload{AccountId: accountId, SensorId: "sensor-2", Severity: models.SeverityCritical, Title: "Output dropped", Body: "Output is 60% below forecast."}
+			},
+			eventType: models.EventTypeAlert,
+			severity:  models.SeverityCritical,
+			title:     "Output dropped",
+			body:      "Output is 60% below forecast.",
+			data:      map[string]string{"sensor_id": "sensor-2"},
+			emailed:   1,
+		},
+		{
+			name:    "warning alert only reaches the inbox",
+			key:     AlertNotification,
+			handler: AlertNotificationEventHandler,
+			payload: func(accountId string) events.Payload {
+				return AlertPayload{AccountId: accountId, Severity: models.SeverityWarning, Title: "Output dropped"}
+			},
+			eventType: models.EventTypeAlert,
+			severity:  models.SeverityWarning,
+			title:     "Output dropped",
+		},
+		{
+			name:    "sensor offline is emailed",
+			key:     SensorOfflineNotification,
+			handler: SensorOfflineNotificationEventHandler,
+			payload: func(accountId string) events.Payload {
+				return SensorOfflinePayload{AccountId: accountId, SensorId: "sensor-3", SensorName: "Carport", LastSeenAt: lastSeenAt.Format(time.RFC3339)}
+			},
+			eventType: models.EventTypeSensorOffline,
+			severity:  models.SeverityWarning,
+			title:     "Carport is offline",
+			body:      "Carport has not reported since " + lastSeenAt.Format(time.RFC1123) + ".",
+			data:      map[string]string{"sensor_id": "sensor-3"},
+			emailed:   1,
+		},
+		{
+			name:    "unnamed sensor that never reported",
+			key:     SensorOfflineNotification,
+			handler: SensorOfflineNotificationEventHandler,
+			payload: func(accountId string) events.Payload {
+				return SensorOfflinePayload{AccountId: accountId, SensorId: "sensor-4"}
+			},
+			eventType: models.EventTypeSensorOffline,
+			severity:  models.SeverityWarning,
+			title:     "A sensor is offline",
+			body:      "A sensor has stopped reporting.",
+			data:      map[string]string{"sensor_id": "sensor-4"},
+			emailed:   1,
+		},
+		{
+			name:    "ticket assignment without a rule only reaches the inbox",
+			key:     TicketAssignedNotification,
+			handler: TicketAssignedNotificationEventHandler,
+			payload: func(accountId string) events.Payload {
+				return TicketAssignedPayload{AccountId: accountId, TicketId: "ticket-1", Subject: "Inverter replacement", AssignedBy: "Chidi"}
+			},
+			eventType: models.EventTypeTicketAssigned,
+			severity:  models.SeverityInfo,
+			title:     "Ticket assigned to you",
+			body:      "Chidi assigned you: Inverter replacement",
+			data:      map[string]string{"ticket_id": "ticket-1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			accountsRepo := repository.NewRepository[models.Account](database.NewMemoryCollection())
+			preferencesRepo := repository.NewRepository[models.NotificationPreferences](database.NewMemoryCollection())
+			inboxRepo := repository.NewRepository[models.InboxNotification](database.NewMemoryCollection())
+
+			now := time.Now().UTC()
+			account, err := accountsRepo.Create(ctx, models.Account{
+				Shared:   models.Shared{ID: primitive.NewObjectID(), CreatedAt: &now},
+				FullName: "Ada Obi",
+				Email:    "ada@example.com",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = preferencesRepo.Create(ctx, models.NotificationPreferences{
+				Shared:      models.Shared{ID: primitive.NewObjectID(), CreatedAt: &now},
+				AccountInfo: models.AccountInfo{Id: account.GetId()},
+				Rules:       rules,
+				Timezone:    "UTC",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pusher := messaging.NewMemorySink()
+			mailer := messaging.NewMemorySink()
+			hooks := webhooks.NewDispatcher(
+				repository.NewRepository[models.WebhookSubscription](database.NewMemoryCollection()),
+				repository.NewRepository[models.WebhookDelivery](database.NewMemoryCollection()),
+			)
+			handler := tt.handler(NewAccountNotifier(mailer, pusher, messaging.NewMemorySink(), hooks, accountsRepo, preferencesRepo, inboxRepo))
+
+			body, err := events.Encode(tt.key, tt.payload(account.GetId()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			event := events.Event{ID: primitive.NewObjectID(), EventKey: tt.key, MsgBody: body}
+
+			if err = handler(ctx, event); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			// a redelivered event must not add a second inbox entry
+			if err = handler(ctx, event); err != nil {
+				t.Fatalf("redelivered handler error = %v", err)
+			}
+
+			if got := len(pusher.Messages()); got != tt.pushed {
+				t.Errorf("pushed %d times, want %d", got, tt.pushed)
+			}
+			if got := len(mailer.SentTo(account.Email)); got != tt.emailed {
+				t.Errorf("emailed %d times, want %d", got, tt.emailed)
+			}
+
+			inbox, err := inboxRepo.Find(ctx, repository.NewQueryFilter(), nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(inbox) != 1 {
+				t.Fatalf("%d inbox entries, want 1", len(inbox))
+			}
+
+			got := inbox[0]
+			if got.AccountInfo.Id != account.GetId() {
+				t.Errorf("inbox entry for account %q, want %q", got.AccountInfo.Id, account.GetId())
+			}
+			if got.EventId != event.ID.Hex() {
+				t.Errorf("inbox entry for event %q, want %q", got.EventId, event.ID.Hex())
+			}
+			if got.EventType != tt.eventType || got.Severity != tt.severity {
+				t.Errorf("inbox entry is a %s %s, want a %s %s", got.Severity, got.EventType, tt.severity, tt.eventType)
+			}
+			if got.Title != tt.title {
+				t.Errorf("title = %q, want %q", got.Title, tt.title)
+			}
+			if got.Body != tt.body {
+				t.Errorf("body = %q, want %q", got.Body, tt.body)
+			}
+			if len(got.Data) != len(tt.data) {
+				t.Errorf("data = %v, want %v", got.Data, tt.data)
+			}
+			for key, value := range tt.data {
+				if got.Data[key] != value {
+					t.Errorf("data[%s] = %q, want %q", key, got.Data[key], value)
+				}
+			}
+		})
+	}
+}
+
+func TestAlertHandlersRejectInvalidPayloads(t *testing.T) {
+	// these are what a producer that skipped validation would send, Encode refuses them
+	tests := []struct {
+		name    string
+		key     string
+		handler func(notifier *AccountNotifier) consumer.Handler
+		body    map[string]interface{}
+	}{
+		{
+			name: "fault without a code", key: FaultNotification, handler: FaultNotificationEventHandler,
+			body: map[string]interface{}{"account_id": "a", "sensor_id": "s", "severity": "warning", events.SchemaVersionField: 1},
+		},
+		{
+			name: "alert without a title", key: AlertNotification, handler: AlertNotificationEventHandler,
+			body: map[string]interface{}{"account_id": "a", "severity": "warning", events.SchemaVersionField: 1},
+		},
+		{
+			name: "sensor offline without a sensor", key: SensorOfflineNotification, handler: SensorOfflineNotificationEventHandler,
+			body: map[string]interface{}{"account_id": "a", events.SchemaVersionField: 1},
+		},
+		{
+			name: "ticket without a subject", key: TicketAssignedNotification, handler: TicketAssignedNotificationEventHandler,
+			body: map[string]interface{}{"account_id": "a", "ticket_id": "t", events.SchemaVersionField: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inboxRepo := repository.NewRepository[models.InboxNotification](database.NewMemoryCollection())
+			notifier := NewAccountNotifier(messaging.NewMemorySink(), messaging.NewMemorySink(), messaging.NewMemorySink(),
+				webhooks.NewDispatcher(
+					repository.NewRepository[models.WebhookSubscription](database.NewMemoryCollection()),
+					repository.NewRepository[models.WebhookDelivery](database.NewMemoryCollection()),
+				),
+				repository.NewRepository[models.Account](database.NewMemoryCollection()),
+				repository.NewRepository[models.NotificationPreferences](database.NewMemoryCollection()),
+				inboxRepo,
+			)
+
+			event := events.Event{ID: primitive.NewObjectID(), EventKey: tt.key, MsgBody: tt.body}
+			if err := tt.handler(notifier)(context.Background(), event); err == nil {
+				t.Fatal("handler accepted an invalid payload")
+			}
+
+			inbox, err := inboxRepo.Find(context.Background(), repository.NewQueryFilter(), nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(inbox) != 0 {
+				t.Errorf("%d inbox entries, want none", len(inbox))
+			}
+		})
+	}
+}
+
+func TestAlertEventsReachTheInboxThroughTheQueue(t *testing.T) {
+	// nothing in this service raises these events, the monitoring and ticketing services publish
+	// them to the shared queue, so this publishes each one the way they do
+	ctx, cancel := context.WithCancel(context.Background())
+	accountsRepo := repository.NewRepository[models.Account](database.NewMemoryCollection())
+	inboxRepo := repository.NewRepository[models.InboxNotification](database.NewMemoryCollection())
+
+	now := time.Now().UTC()
+	account, err := accountsRepo.Create(ctx, models.Account{Shared: models.Shared{ID: primitive.NewObjectID(), CreatedAt: &now}, Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifier := NewAccountNotifier(messaging.NewMemorySink(), messaging.NewMemorySink(), messaging.NewMemorySink(),
+		webhooks.NewDispatcher(
+			repository.NewRepository[models.WebhookSubscription](database.NewMemoryCollection()),
+			repository.NewRepository[models.WebhookDelivery](database.NewMemoryCollection()),
+		),
+		accountsRepo,
+		repository.NewRepository[models.NotificationPreferences](database.NewMemoryCollection()),
+		inboxRepo,
+	)
+
+	queue := consumer.NewMemoryQueue()
+	listener := consumer.NewConsumer(consumer.WithRefreshTime(5*time.Millisecond)).
+		SetHandler(FaultNotification, FaultNotificationEventHandler(notifier)).
+		SetHandler(AlertNotification, AlertNotificationEventHandler(notifier)).
+		SetHandler(SensorOfflineNotification, SensorOfflineNotificationEventHandler(notifier)).
+		SetHandler(TicketAssignedNotification, TicketAssignedNotificationEventHandler(notifier))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		listener.ListenAndServeMemory(ctx, queue)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	published := map[string]events.Payload{
+		FaultNotification:          FaultPayload{AccountId: account.GetId(), SensorId: "sensor-1", Severity: models.SeverityCritical, Code: "ARC_FAULT"},
+		AlertNotification:          AlertPayload{AccountId: account.GetId(), Severity: models.SeverityWarning, Title: "Output dropped"},
+		SensorOfflineNotification:  SensorOfflinePayload{AccountId: account.GetId(), SensorId: "sensor-1"},
+		TicketAssignedNotification: TicketAssignedPayload{AccountId: account.GetId(), TicketId: "ticket-1", Subject: "Inverter replacement"},
+	}
+	for key, payload := range published {
+		body, err := events.Encode(key, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = queue.Publish(ctx, key, "notification", body); err != nil {
+			t.Fatalf("Publish(%s) error = %v", key, err)
+		}
+	}
+
+	// the queue drops each event once it has been handled
+	deadline := time.Now().Add(5 * time.Second)
+	for len(queue.Published()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d events were not handled", len(queue.Published()))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	inbox, err := inboxRepo.Find(ctx, repository.NewQueryFilter(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[models.NotificationEventType]int)
+	for _, n := range inbox {
+		if n.AccountInfo.Id != account.GetId() {
+			t.Errorf("inbox entry for account %q, want %q", n.AccountInfo.Id, account.GetId())
+		}
+		got[n.EventType]++
+	}
+	for _, eventType := range []models.NotificationEventType{models.EventTypeFault, models.EventTypeAlert, models.EventTypeSensorOffline, models.EventTypeTicketAssigned} {
+		if got[eventType] != 1 {
+			t.Errorf("%d %s inbox entries, want 1", got[eventType], eventType)
+		}
+	}
+}
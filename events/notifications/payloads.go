@@ -0,0 +1,312 @@
+package notifications
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tejiriaustin/narx_api/events"
+	"github.com/tejiriaustin/narx_api/models"
+)
+
+var (
+	_ events.Payload = ForgotPasswordPayload{}
+	_ events.Payload = AccountCreatedPayload{}
+	_ events.Payload = AccountLockedPayload{}
+	_ events.Payload = PhoneVerificationPayload{}
+	_ events.Payload = DigestPayload{}
+	_ events.Payload = UserNotificationPayload{}
+	_ events.Payload = FaultPayload{}
+	_ events.Payload = AlertPayload{}
+	_ events.Payload = SensorOfflinePayload{}
+	_ events.Payload = TicketAssignedPayload{}
+)
+
+type (
+	ForgotPasswordPayload struct {
+		Id        string `bson:"id"`
+		FirstName string `bson:"first_name"`
+		LastName  string `bson:"last_name"`
+		FullName  string `bson:"full_name"`
+		Email     string `bson:"email"`
+		Locale    string `bson:"locale"`
+
+		// SealedCode is the reset code sealed with an events.Sealer, from version 2 on. Version 1
+		// carried the code in the clear in Code.
+		SealedCode string `bson:"sealed_code,omitempty"`
+		Code       string `bson:"code,omitempty"`
+	}
+
+	AccountCreatedPayload struct {
+		Id               string `bson:"id"`
+		FirstName        string `bson:"first_name"`
+		LastName         string `bson:"last_name"`
+		FullName         string `bson:"full_name"`
+		Email            string `bson:"email"`
+		Locale           string `bson:"locale"`
+		VerificationLink string `bson:"verification_link"`
+	}
+
+	AccountLockedPayload struct {
+		Id        string `bson:"id"`
+		FirstName string `bson:"first_name"`
+		LastName  string `bson:"last_name"`
+		FullName  string `bson:"full_name"`
+		Email     string `bson:"email"`
+		Locale    string `bson:"locale"`
+
+		// LockedUntil is an RFC 3339 time, from version 2 on.
+		LockedUntil string `bson:"locked_until"`
+	}
+
+	PhoneVerificationPayload struct {
+		Id               string `bson:"id"`
+		Phone            string `bson:"phone"`
+		Locale           string `bson:"locale"`
+		Code             string `bson:"code"`
+		ExpiresInMinutes int    `bson:"expires_in_minutes"`
+	}
+
+	// DigestPayload holds the digest as rendered when it was compiled.
+	DigestPayload struct {
+		Id       string `bson:"id"`
+		FullName string `bson:"full_name"`
+		Email    string `bson:"email"`
+		Period   string `bson:"period"`
+		Subject  string `bson:"subject"`
+		HTML     string `bson:"html"`
+		Text     string `bson:"text"`
+	}
+
+	UserNotificationPayload struct {
+		AccountId string                       `bson:"account_id"`
+		EventType models.NotificationEventType `bson:"event_type"`
+		Severity  models.Severity              `bson:"severity"`
+		Title     string                       `bson:"title"`
+		Body      string                       `bson:"body"`
+		Data      map[string]string            `bson:"data,omitempty"`
+	}
+
+	// FaultPayload is a fault detected on a sensor's panels, Code names the kind of fault.
+	FaultPayload struct {
+		AccountId   string          `bson:"account_id"`
+		SensorId    string          `bson:"sensor_id"`
+		SensorName  string          `bson:"sensor_name"`
+		Severity    models.Severity `bson:"severity"`
+		Code        string          `bson:"code"`
+		Description string          `bson:"description"`
+	}
+
+	// AlertPayload is a condition worth the owner's attention that isn't a fault, such as output
+	// falling below what was predicted.
+	AlertPayload struct {
+		AccountId  string          `bson:"account_id"`
+		SensorId   string          `bson:"sensor_id,omitempty"`
+		SensorName string          `bson:"sensor_name,omitempty"`
+		Severity   models.Severity `bson:"severity"`
+		Title      string          `bson:"title"`
+		Body       string          `bson:"body"`
+	}
+
+	SensorOfflinePayload struct {
+		AccountId  string `bson:"account_id"`
+		SensorId   string `bson:"sensor_id"`
+		SensorName string `bson:"sensor_name"`
+
+		// LastSeenAt is an RFC 3339 time, empty if the sensor never reported in.
+		LastSeenAt string `bson:"last_seen_at,omitempty"`
+	}
+
+	TicketAssignedPayload struct {
+		AccountId  string `bson:"account_id"`
+		TicketId   string `bson:"ticket_id"`
+		Subject    string `bson:"subject"`
+		AssignedBy string `bson:"assigned_by,omitempty"`
+	}
+)
+
+func init() {
+	events.RegisterSchema(events.Schema{
+		Key:     ForgotPasswordNotification,
+		Version: 2,
+		New:     func() events.Payload { return &ForgotPasswordPayload{} },
+		Upcasters: map[int]events.Upcaster{
+			1: upcastForgotPasswordV1,
+		},
+	})
+	events.RegisterSchema(events.Schema{Key: AccountCreatedNotification, Version: 1, New: func() events.Payload { return &AccountCreatedPayload{} }})
+	events.RegisterSchema(events.Schema{
+		Key:     AccountLockedNotification,
+		Version: 2,
+		New:     func() events.Payload { return &AccountLockedPayload{} },
+		Upcasters: map[int]events.Upcaster{
+			1: upcastAccountLockedV1,
+		},
+	})
+	events.RegisterSchema(events.Schema{
+		Key:     PhoneVerificationNotification,
+		Version: 2,
+		New:     func() events.Payload { return &PhoneVerificationPayload{} },
+		Upcasters: map[int]events.Upcaster{
+			1: upcastPhoneVerificationV1,
+		},
+	})
+	events.RegisterSchema(events.Schema{Key: DigestNotification, Version: 1, New: func() events.Payload { return &DigestPayload{} }})
+	events.RegisterSchema(events.Schema{Key: UserNotification, Version: 1, New: func() events.Payload { return &UserNotificationPayload{} }})
+	events.RegisterSchema(events.Schema{Key: FaultNotification, Version: 1, New: func() events.Payload { return &FaultPayload{} }})
+	events.RegisterSchema(events.Schema{Key: AlertNotification, Version: 1, New: func() events.Payload { return &AlertPayload{} }})
+	events.RegisterSchema(events.Schema{Key: SensorOfflineNotification, Version: 1, New: func() events.Payload { return &SensorOfflinePayload{} }})
+	events.RegisterSchema(events.Schema{Key: TicketAssignedNotification, Version: 1, New: func() events.Payload { return &TicketAssignedPayload{} }})
+}
+
+func (p ForgotPasswordPayload) Validate() error {
+	if p.Email == "" {
+		return errors.New("email is required")
+	}
+	if p.SealedCode == "" && p.Code == "" {
+		return errors.New("code is required")
+	}
+	return nil
+}
+
+func (p AccountCreatedPayload) Validate() error {
+	if p.Email == "" {
+		return errors.New("email is required")
+	}
+	if p.VerificationLink == "" {
+		return errors.New("verification link is required")
+	}
+	return nil
+}
+
+func (p AccountLockedPayload) Validate() error {
+	if p.Email == "" {
+		return errors.New("email is required")
+	}
+	if _, err := time.Parse(time.RFC3339, p.LockedUntil); err != nil {
+		return errors.New("locked until must be an RFC 3339 time")
+	}
+	return nil
+}
+
+func (p PhoneVerificationPayload) Validate() error {
+	if !models.IsValidPhoneNumber(p.Phone) {
+		return errors.New("phone must be an E.164 number")
+	}
+	if p.Code == "" {
+		return errors.New("code is required")
+	}
+	if p.ExpiresInMinutes < 1 {
+		return errors.New("expires in minutes must be positive")
+	}
+	return nil
+}
+
+func (p DigestPayload) Validate() error {
+	if p.Email == "" {
+		return errors.New("email is required")
+	}
+	if p.Subject == "" || p.HTML == "" && p.Text == "" {
+		return errors.New("digest has not been rendered")
+	}
+	return nil
+}
+
+func (p UserNotificationPayload) Validate() error {
+	if p.AccountId == "" {
+		return errors.New("account is required")
+	}
+	if !models.IsValidNotificationEventType(p.EventType) {
+		return errors.New("invalid event type: " + string(p.EventType))
+	}
+	if !models.IsValidSeverity(p.Severity) {
+		return errors.New("invalid severity: " + string(p.Severity))
+	}
+	if p.Title == "" {
+		return errors.New("title is required")
+	}
+	return nil
+}
+
+func (p FaultPayload) Validate() error {
+	if p.AccountId == "" {
+		return errors.New("account is required")
+	}
+	if p.SensorId == "" {
+		return errors.New("sensor is required")
+	}
+	if !models.IsValidSeverity(p.Severity) {
+		return errors.New("invalid severity: " + string(p.Severity))
+	}
+	if p.Code == "" {
+		return errors.New("fault code is required")
+	}
+	return nil
+}
+
+func (p AlertPayload) Validate() error {
+	if p.AccountId == "" {
+		return errors.New("account is required")
+	}
+	if !models.IsValidSeverity(p.Severity) {
+		return errors.New("invalid severity: " + string(p.Severity))
+	}
+	if p.Title == "" {
+		return errors.New("title is required")
+	}
+	return nil
+}
+
+func (p SensorOfflinePayload) Validate() error {
+	if p.AccountId == "" {
+		return errors.New("account is required")
+	}
+	if p.SensorId == "" {
+		return errors.New("sensor is required")
+	}
+	if p.LastSeenAt != "" {
+		if _, err := time.Parse(time.RFC3339, p.LastSeenAt); err != nil {
+			return errors.New("last seen at must be an RFC 3339 time")
+		}
+	}
+	return nil
+}
+
+func (p TicketAssignedPayload) Validate() error {
+	if p.AccountId == "" {
+		return errors.New("account is required")
+	}
+	if p.TicketId == "" {
+		return errors.New("ticket is required")
+	}
+	if p.Subject == "" {
+		return errors.New("subject is required")
+	}
+	return nil
+}
+
+// upcastForgotPasswordV1 leaves version 1's clear code be, there is no key to seal it with here.
+// Those events were queued before the upgrade and their codes expire within minutes.
+func upcastForgotPasswordV1(body map[string]interface{}) (map[string]interface{}, error) {
+	return body, nil
+}
+
+// upcastAccountLockedV1 rewrites locked_until, which version 1 formatted for display, as RFC 3339.
+func upcastAccountLockedV1(body map[string]interface{}) (map[string]interface{}, error) {
+	lockedUntil, _ := body["locked_until"].(string)
+
+	t, err := time.Parse(time.RFC1123, lockedUntil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid locked until %q", lockedUntil)
+	}
+
+	body["locked_until"] = t.UTC().Format(time.RFC3339)
+	return body, nil
+}
+
+// upcastPhoneVerificationV1 adds expires_in_minutes, which version 1 left out. Version 1 codes were
+// always valid for 10 minutes.
+func upcastPhoneVerificationV1(body map[string]interface{}) (map[string]interface{}, error) {
+	body["expires_in_minutes"] = 10
+	return body, nil
+}
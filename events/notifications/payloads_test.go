@@ -0,0 +1,185 @@
+package notifications
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/consumer"
+	"github.com/tejiriaustin/narx_api/events"
+	"github.com/tejiriaustin/narx_api/models"
+)
+
+func TestUpcastAccountLockedV1(t *testing.T) {
+	tests := []struct {
+		name        string
+		lockedUntil interface{}
+		want        string
+		wantErr     bool
+	}{
+		{name: "display time", lockedUntil: "Mon, 02 Jan 2006 15:04:05 UTC", want: "2006-01-02T15:04:05Z"},
+		{name: "display time in gmt", lockedUntil: "Tue, 10 Oct 2023 08:30:00 GMT", want: "2023-10-10T08:30:00Z"},
+		{name: "already rfc 3339", lockedUntil: "2006-01-02T15:04:05Z", wantErr: true},
+		{name: "empty", lockedUntil: "", wantErr: true},
+		{name: "missing", lockedUntil: nil, wantErr: true},
+		{name: "not a string", lockedUntil: 1136214245, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]interface{}{"email": "ada@example.com"}
+			if tt.lockedUntil != nil {
+				body["locked_until"] = tt.lockedUntil
+			}
+
+			got, err := upcastAccountLockedV1(body)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("upcastAccountLockedV1() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got["locked_until"] != tt.want {
+				t.Errorf("locked_until = %v, want %s", got["locked_until"], tt.want)
+			}
+			if got["email"] != "ada@example.com" {
+				t.Errorf("upcasting dropped the other fields: %v", got)
+			}
+		})
+	}
+}
+
+func TestDecodeAccountLocked(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    map[string]interface{}
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "version 1 is upcast",
+			body: map[string]interface{}{"email": "ada@example.com", "locked_until": "Mon, 02 Jan 2006 15:04:05 UTC"},
+			want: "2006-01-02T15:04:05Z",
+		},
+		{
+			name: "version 2 is read as is",
+			body: map[string]interface{}{"email": "ada@example.com", "locked_until": "2006-01-02T15:04:05Z", events.SchemaVersionField: 2},
+			want: "2006-01-02T15:04:05Z",
+		},
+		{
+			name:    "newer versions are refused",
+			body:    map[string]interface{}{"email": "ada@example.com", "locked_until": "2006-01-02T15:04:05Z", events.SchemaVersionField: 3},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := events.Event{ID: primitive.NewObjectID(), EventKey: AccountLockedNotification, MsgBody: tt.body}
+			published := tt.body["locked_until"]
+
+			var payload AccountLockedPayload
+			err := events.Decode(message, &payload)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if payload.LockedUntil != tt.want {
+				t.Errorf("LockedUntil = %s, want %s", payload.LockedUntil, tt.want)
+			}
+			if message.MsgBody["locked_until"] != published {
+				t.Error("upcasting rewrote the event's own body")
+			}
+		})
+	}
+}
+
+func TestDecodePhoneVerification(t *testing.T) {
+	tests := []struct {
+		name string
+		body map[string]interface{}
+		want int
+	}{
+		{
+			name: "version 1 codes lasted 10 minutes",
+			body: map[string]interface{}{"phone": "+2348012345678", "code": "482913"},
+			want: 10,
+		},
+		{
+			name: "version 2 carries its expiry",
+			body: map[string]interface{}{"phone": "+2348012345678", "code": "482913", "expires_in_minutes": 15, events.SchemaVersionField: 2},
+			want: 15,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := events.Event{ID: primitive.NewObjectID(), EventKey: PhoneVerificationNotification, MsgBody: tt.body}
+
+			var payload PhoneVerificationPayload
+			if err := events.Decode(message, &payload); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if payload.ExpiresInMinutes != tt.want {
+				t.Errorf("ExpiresInMinutes = %d, want %d", payload.ExpiresInMinutes, tt.want)
+			}
+		})
+	}
+}
+
+func TestPublishValidatesAgainstSchema(t *testing.T) {
+	encoded, err := events.Encode(FaultNotification, FaultPayload{AccountId: "account-1", SensorId: "sensor-1", Severity: models.SeverityCritical, Code: "ARC_FAULT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		body    map[string]interface{}
+		wantErr bool
+	}{
+		{name: "encoded", key: FaultNotification, body: encoded},
+		{
+			name: "valid and built by hand",
+			key:  FaultNotification,
+			body: map[string]interface{}{"account_id": "account-1", "sensor_id": "sensor-1", "severity": "critical", "code": "ARC_FAULT"},
+		},
+		{
+			name:    "invalid and built by hand",
+			key:     FaultNotification,
+			body:    map[string]interface{}{"account_id": "account-1", "severity": "critical"},
+			wantErr: true,
+		},
+		{
+			name:    "an older version",
+			key:     AccountLockedNotification,
+			body:    map[string]interface{}{"email": "ada@example.com", "locked_until": "Mon, 02 Jan 2006 15:04:05 UTC"},
+			wantErr: true,
+		},
+		{
+			name:    "a newer version",
+			key:     AccountLockedNotification,
+			body:    map[string]interface{}{"email": "ada@example.com", "locked_until": "2006-01-02T15:04:05Z", events.SchemaVersionField: 3},
+			wantErr: true,
+		},
+		{name: "no schema", key: "UNKNOWN.EVENT", body: map[string]interface{}{"anything": true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := consumer.NewMemoryQueue()
+
+			err := queue.Publish(context.Background(), tt.key, "notification", tt.body)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Publish() error = %v, want error %v", err, tt.wantErr)
+			}
+			if published := len(queue.Published()); tt.wantErr && published != 0 {
+				t.Errorf("%d events queued after a rejected publish, want none", published)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package notifications
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+// deliveryChannels returns the channels the account wants an event delivered on right now. The defaults
+// are used when the preferences can't be loaded, so that a database hiccup doesn't silence alerts.
+func deliveryChannels(ctx context.Context,
+	preferencesRepo *repository.Repository[models.NotificationPreferences],
+	accountId string,
+	eventType models.NotificationEventType,
+	severity models.Severity,
+) []models.NotificationChannel {
+
+	preferences := models.DefaultNotificationPreferences(models.AccountInfo{Id: accountId})
+
+	if accountId != "" {
+		filter := repository.NewQueryFilter().AddFilter(models.FieldAccountInfoId, accountId)
+
+		stored, err := preferencesRepo.FindOne(ctx, filter, nil, nil)
+		switch {
+		case err == nil:
+			preferences = stored
+		case err != repository.NoDocumentsFound:
+			zap.L().Error("failed to load notification preferences", zap.Error(err), zap.String("account", accountId))
+		}
+	}
+
+	return preferences.ChannelsFor(eventType, severity, time.Now())
+}
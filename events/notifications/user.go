@@ -0,0 +1,227 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+
+	"github.com/tejiriaustin/narx_api/consumer"
+	"github.com/tejiriaustin/narx_api/events"
+	"github.com/tejiriaustin/narx_api/messaging"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+	"github.com/tejiriaustin/narx_api/templates"
+	"github.com/tejiriaustin/narx_api/webhooks"
+)
+
+const (
+	// UserNotification carries a message meant for a single account, such as one sent by support.
+	UserNotification = "NOTIFICATION.USER"
+)
+
+// AccountNotifier adds a notification to the account's inbox, then delivers it on the channels the
+// account has chosen for its event type and severity. SMS is only sent to verified numbers.
+// Organisation webhook subscriptions receive every event they subscribe to, whatever a member's
+// preferences say. Each channel that succeeds is recorded on the inbox entry, so a retry only sends
+// the channels that failed. Every event that notifies a user is handled through it.
+type AccountNotifier struct {
+	mailer          messaging.Messaging
+	pusher          messaging.Messaging
+	sms             messaging.Messaging
+	hooks           *webhooks.Dispatcher
+	accountsRepo    *repository.Repository[models.Account]
+	preferencesRepo *repository.Repository[models.NotificationPreferences]
+	inboxRepo       *repository.Repository[models.InboxNotification]
+}
+
+func NewAccountNotifier(
+	mailer messaging.Messaging,
+	pusher messaging.Messaging,
+	sms messaging.Messaging,
+	hooks *webhooks.Dispatcher,
+	accountsRepo *repository.Repository[models.Account],
+	preferencesRepo *repository.Repository[models.NotificationPreferences],
+	inboxRepo *repository.Repository[models.InboxNotification],
+) *AccountNotifier {
+	return &AccountNotifier{
+		mailer:          mailer,
+		pusher:          pusher,
+		sms:             sms,
+		hooks:           hooks,
+		accountsRepo:    accountsRepo,
+		preferencesRepo: preferencesRepo,
+		inboxRepo:       inboxRepo,
+	}
+}
+
+// UserNotificationEventHandler notifies an account of a message written for it, such as one sent by support.
+func UserNotificationEventHandler(notifier *AccountNotifier) consumer.Handler {
+	return func(ctx context.Context, msg events.Event) error {
+
+		var payload UserNotificationPayload
+		if err := events.Decode(msg, &payload); err != nil {
+			return err
+		}
+
+		return notifier.notify(ctx, msg, payload)
+	}
+}
+
+func (n *AccountNotifier) notify(ctx context.Context, msg events.Event, payload UserNotificationPayload) error {
+	accountId := payload.AccountId
+
+	notification, err := addToInbox(ctx, n.inboxRepo, msg, payload)
+	if err != nil {
+		zap.L().Error("failed to add notification to inbox", zap.Error(err), zap.String("account", accountId))
+		return err
+	}
+
+	id, err := primitive.ObjectIDFromHex(accountId)
+	if err != nil {
+		return errors.New("invalid account id")
+	}
+
+	account, err := n.accountsRepo.FindOne(ctx, repository.NewQueryFilter().AddFilter(models.FieldId, id), nil, nil)
+	if err != nil {
+		zap.L().Error("failed to load notification account", zap.Error(err), zap.String("account", accountId))
+		return err
+	}
+
+	channels := deliveryChannels(ctx, n.preferencesRepo, accountId, payload.EventType, payload.Severity)
+
+	var errs []error
+
+	// deliver sends on channel unless an earlier attempt already did, and records it once sent.
+	deliver := func(channel models.NotificationChannel, send func() error) {
+		if models.HasNotificationChannel(notification.Delivered, channel) {
+			return
+		}
+		if err := send(); err != nil {
+			zap.L().Error("failed to deliver notification", zap.Error(err), zap.String("account", accountId), zap.String("channel", string(channel)))
+			errs = append(errs, err)
+			return
+		}
+		if err := markDelivered(ctx, n.inboxRepo, notification, channel); err != nil {
+			zap.L().Error("failed to record notification delivery", zap.Error(err), zap.String("account", accountId), zap.String("channel", string(channel)))
+		}
+	}
+
+	if models.HasNotificationChannel(channels, models.ChannelPush) {
+		deliver(models.ChannelPush, func() error {
+			message := messaging.NewMessage(payload.Title, messaging.Recipient{Address: accountId})
+			message.Text = payload.Body
+			message.Metadata = payload.Data
+
+			return n.pusher.Send(ctx, message)
+		})
+	}
+
+	if models.HasNotificationChannel(channels, models.ChannelEmail) {
+		deliver(models.ChannelEmail, func() error {
+			return emailUserNotification(ctx, n.mailer, account, msg, payload.Title, payload.Body)
+		})
+	}
+
+	if models.HasNotificationChannel(channels, models.ChannelSMS) && account.HasVerifiedPhone() {
+		deliver(models.ChannelSMS, func() error {
+			message := messaging.NewMessage(payload.Title, messaging.Recipient{Name: account.FullName, Address: account.Phone})
+			message.Text = payload.Body
+
+			err := n.sms.Send(ctx, message)
+			if errors.Is(err, messaging.ErrRateLimited) {
+				// retrying won't get past the limit any sooner, the inbox still has the notification
+				zap.L().Warn("notification sms rate limited", zap.String("account", accountId))
+				return nil
+			}
+			return err
+		})
+	}
+
+	deliver(models.ChannelWebhook, func() error {
+		return n.hooks.Publish(ctx, webhooks.Event{
+			Id:        msg.ID.Hex(),
+			Type:      payload.EventType,
+			CreatedAt: msg.ID.Timestamp().UTC(),
+			Data: webhooks.EventData{
+				AccountId: accountId,
+				Severity:  payload.Severity,
+				Title:     payload.Title,
+				Body:      payload.Body,
+				Data:      payload.Data,
+			},
+			OrganisationId:           account.OrganisationId,
+			SkipAccountSubscriptions: !models.HasNotificationChannel(channels, models.ChannelWebhook),
+		})
+	})
+
+	return errors.Join(errs...)
+}
+
+func emailUserNotification(ctx context.Context,
+	mailer messaging.Messaging,
+	account models.Account,
+	event events.Event,
+	title, body string,
+) error {
+	rendered, err := templates.Render(templates.USER_NOTIFICATION, account.Locale, templates.UserNotificationData{
+		FullName: account.FullName,
+		Title:    title,
+		Body:     body,
+	})
+	if err != nil {
+		return err
+	}
+
+	message := emailMessage(event, account.FullName, account.Email, rendered)
+
+	return mailer.Send(ctx, message)
+}
+
+// addToInbox stores the notification once per event, so a redelivered event doesn't show up twice,
+// and returns the stored entry.
+func addToInbox(ctx context.Context,
+	inboxRepo *repository.Repository[models.InboxNotification],
+	msg events.Event,
+	payload UserNotificationPayload,
+) (models.InboxNotification, error) {
+	eventId := msg.ID.Hex()
+
+	notification, err := inboxRepo.FindOne(ctx, repository.NewQueryFilter().AddFilter(models.FieldInboxEventId, eventId), nil, nil)
+	if err == nil {
+		return notification, nil
+	}
+	if err != repository.NoDocumentsFound {
+		return notification, err
+	}
+
+	now := time.Now().UTC()
+	return inboxRepo.Create(ctx, models.InboxNotification{
+		Shared: models.Shared{
+			ID:        primitive.NewObjectID(),
+			CreatedAt: &now,
+		},
+		AccountInfo: models.AccountInfo{Id: payload.AccountId},
+		EventId:     eventId,
+		EventType:   payload.EventType,
+		Severity:    payload.Severity,
+		Title:       payload.Title,
+		Body:        payload.Body,
+		Data:        payload.Data,
+	})
+}
+
+func markDelivered(ctx context.Context,
+	inboxRepo *repository.Repository[models.InboxNotification],
+	notification models.InboxNotification,
+	channel models.NotificationChannel,
+) error {
+	updates := map[string]interface{}{
+		"$addToSet": map[string]interface{}{
+			models.FieldInboxDelivered: channel,
+		},
+	}
+	return inboxRepo.UpdateMany(ctx, repository.NewQueryFilter().AddFilter(models.FieldId, notification.ID), updates)
+}
@@ -0,0 +1,123 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/database"
+	"github.com/tejiriaustin/narx_api/events"
+	"github.com/tejiriaustin/narx_api/messaging"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+	"github.com/tejiriaustin/narx_api/webhooks"
+)
+
+// flakySender fails its first failures sends, then keeps what it is sent.
+type flakySender struct {
+	*messaging.MemorySink
+	failures int
+}
+
+func (f *flakySender) Send(ctx context.Context, msg messaging.Message) error {
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("service unavailable")
+	}
+	return f.MemorySink.Send(ctx, msg)
+}
+
+func TestUserNotificationRetriesOnlyFailedChannels(t *testing.T) {
+	tests := []struct {
+		name         string
+		pushFailures int
+		mailFailures int
+	}{
+		{name: "every channel delivered"},
+		{name: "push failed once", pushFailures: 1},
+		{name: "email failed twice", mailFailures: 2},
+		{name: "both failed", pushFailures: 1, mailFailures: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			accountsRepo := repository.NewRepository[models.Account](database.NewMemoryCollection())
+			inboxRepo := repository.NewRepository[models.InboxNotification](database.NewMemoryCollection())
+
+			now := time.Now().UTC()
+			account, err := accountsRepo.Create(ctx, models.Account{
+				Shared:   models.Shared{ID: primitive.NewObjectID(), CreatedAt: &now},
+				FullName: "Ada Obi",
+				Email:    "ada@example.com",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pusher := &flakySender{MemorySink: messaging.NewMemorySink(), failures: tt.pushFailures}
+			mailer := &flakySender{MemorySink: messaging.NewMemorySink(), failures: tt.mailFailures}
+			hooks := webhooks.NewDispatcher(
+				repository.NewRepository[models.WebhookSubscription](database.NewMemoryCollection()),
+				repository.NewRepository[models.WebhookDelivery](database.NewMemoryCollection()),
+			)
+
+			handler := UserNotificationEventHandler(NewAccountNotifier(mailer, pusher, messaging.NewMemorySink(), hooks,
+				accountsRepo,
+				repository.NewRepository[models.NotificationPreferences](database.NewMemoryCollection()),
+				inboxRepo,
+			))
+
+			body, err := events.Encode(UserNotification, UserNotificationPayload{
+				AccountId: account.GetId(),
+				EventType: models.EventTypeFault,
+				Severity:  models.SeverityWarning,
+				Title:     "Inverter fault",
+				Body:      "Your inverter stopped reporting.",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			event := events.Event{ID: primitive.NewObjectID(), EventKey: UserNotification, MsgBody: body}
+
+			// the consumer retries until the handler succeeds, then a redelivery must send nothing more
+			failed := 0
+			for handler(ctx, event) != nil {
+				failed++
+				if failed > 3 {
+					t.Fatal("handler kept failing")
+				}
+			}
+			if want := max(tt.pushFailures, tt.mailFailures); failed != want {
+				t.Errorf("handler failed %d times, want %d", failed, want)
+			}
+			if err = handler(ctx, event); err != nil {
+				t.Fatalf("redelivered handler error = %v", err)
+			}
+
+			if got := len(pusher.Messages()); got != 1 {
+				t.Errorf("pushed %d times, want once", got)
+			}
+			if got := len(mailer.SentTo(account.Email)); got != 1 {
+				t.Errorf("emailed %d times, want once", got)
+			}
+
+			notifications, err := inboxRepo.Find(ctx, repository.NewQueryFilter(), nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(notifications) != 1 {
+				t.Fatalf("%d inbox entries, want 1", len(notifications))
+			}
+			delivered := notifications[0].Delivered
+			slices.Sort(delivered)
+			if want := []models.NotificationChannel{models.ChannelEmail, models.ChannelPush, models.ChannelWebhook}; !slices.Equal(delivered, want) {
+				t.Errorf("delivered on %v, want %v", delivered, want)
+			}
+		})
+	}
+}
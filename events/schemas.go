@@ -1,9 +1,180 @@
 package events
 
-import "github.com/tejiriaustin/narx_api/models"
+import (
+	"fmt"
+	"maps"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SchemaVersionField holds the version of the schema an event body was written with. Bodies written
+// before schemas were versioned don't have it and are read as version 1.
+const SchemaVersionField = "schema_version"
 
 type (
-	ForgotPasswordNotificationSchema struct {
-		models.AccountInfo
+	// Payload is the typed body of an event.
+	Payload interface {
+		Validate() error
+	}
+
+	// Upcaster rewrites an event body written with one version of its schema as the next version.
+	Upcaster func(body map[string]interface{}) (map[string]interface{}, error)
+
+	// Schema describes the body of the events with a key. When a body changes shape its Version is
+	// bumped and an upcaster from the previous version is added, so events already stored or queued
+	// can still be handled.
+	Schema struct {
+		Key     string
+		Version int
+
+		// New returns the payload a body of the current version decodes into, a pointer so it can be
+		// decoded into.
+		New func() Payload
+
+		// Upcasters are keyed by the version they rewrite from.
+		Upcasters map[int]Upcaster
 	}
 )
+
+var (
+	schemasMu sync.RWMutex
+	schemas   = map[string]Schema{}
+)
+
+// RegisterSchema makes schema the one used to encode and decode events with its key.
+func RegisterSchema(schema Schema) {
+	if schema.Version < 1 {
+		panic("schema version for " + schema.Key + " must be at least 1")
+	}
+	if schema.New == nil {
+		panic("schema for " + schema.Key + " has no payload")
+	}
+	for from := 1; from < schema.Version; from++ {
+		if schema.Upcasters[from] == nil {
+			panic(fmt.Sprintf("schema for %s has no upcaster from version %d", schema.Key, from))
+		}
+	}
+
+	schemasMu.Lock()
+	defer schemasMu.Unlock()
+
+	schemas[schema.Key] = schema
+}
+
+func lookupSchema(key string) (Schema, bool) {
+	schemasMu.RLock()
+	defer schemasMu.RUnlock()
+
+	schema, ok := schemas[key]
+	return schema, ok
+}
+
+// Encode validates payload and turns it into the body of an event with key, stamped with the current
+// version of the key's schema.
+func Encode(key string, payload Payload) (map[string]interface{}, error) {
+	if err := payload.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s event: %w", key, err)
+	}
+
+	raw, err := bson.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s event: %w", key, err)
+	}
+
+	var body map[string]interface{}
+	if err = bson.Unmarshal(raw, &body); err != nil {
+		return nil, fmt.Errorf("failed to encode %s event: %w", key, err)
+	}
+
+	if schema, ok := lookupSchema(key); ok {
+		body[SchemaVersionField] = schema.Version
+	}
+	return body, nil
+}
+
+// Validate checks that body is a valid event body for key, written with the current version of the
+// key's schema, as Encode writes them. Publishers call it so bodies built by hand can't reach the
+// queue. Keys without a schema have nothing to check against and are let through.
+func Validate(key string, body map[string]interface{}) error {
+	schema, ok := lookupSchema(key)
+	if !ok {
+		return nil
+	}
+
+	version, err := schemaVersion(body)
+	if err != nil {
+		return fmt.Errorf("invalid %s event: %w", key, err)
+	}
+	if version != schema.Version {
+		return fmt.Errorf("%s event has schema version %d, it must be published with version %d", key, version, schema.Version)
+	}
+
+	return Decode(Event{EventKey: key, MsgBody: body}, schema.New())
+}
+
+// Decode reads the body of message into payload, upcasting it first if it was written with an older
+// version of its schema, and validates it.
+func Decode(message Event, payload Payload) error {
+	body := message.MsgBody
+
+	if schema, ok := lookupSchema(message.EventKey); ok {
+		version, err := schemaVersion(body)
+		if err != nil {
+			return fmt.Errorf("invalid %s event: %w", message.EventKey, err)
+		}
+		if version > schema.Version {
+			return fmt.Errorf("%s event has schema version %d, this build only reads up to %d", message.EventKey, version, schema.Version)
+		}
+
+		// upcasters may rewrite the body in place, leave the event's alone
+		if version < schema.Version {
+			body = maps.Clone(body)
+		}
+		for ; version < schema.Version; version++ {
+			body, err = schema.Upcasters[version](body)
+			if err != nil {
+				return fmt.Errorf("failed to upcast %s event from version %d: %w", message.EventKey, version, err)
+			}
+		}
+	}
+
+	raw, err := bson.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s event: %w", message.EventKey, err)
+	}
+	if err = bson.Unmarshal(raw, payload); err != nil {
+		return fmt.Errorf("failed to decode %s event: %w", message.EventKey, err)
+	}
+
+	if err = payload.Validate(); err != nil {
+		return fmt.Errorf("invalid %s event: %w", message.EventKey, err)
+	}
+	return nil
+}
+
+// schemaVersion reads the version a body was written with, a positive whole number of whatever type
+// the body was last decoded with.
+func schemaVersion(body map[string]interface{}) (int, error) {
+	switch v := body[SchemaVersionField].(type) {
+	case nil:
+		return 1, nil
+	case int:
+		if v >= 1 {
+			return v, nil
+		}
+	case int32:
+		if v >= 1 {
+			return int(v), nil
+		}
+	case int64:
+		if v >= 1 {
+			return int(v), nil
+		}
+	case float64:
+		if v >= 1 && v == float64(int(v)) {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid schema version %v", body[SchemaVersionField])
+}
@@ -0,0 +1,66 @@
+package events
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+var (
+	ErrNoSecretKey = errors.New("events need a secret key to seal secrets")
+	ErrUnsealable  = errors.New("sealed value is malformed or was sealed with another key")
+)
+
+// Sealer encrypts the secrets an event carries, such as one-time codes, so events can be queued,
+// stored and dead-lettered without whoever reads the store being able to use them. The api and the
+// listener share the key.
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+// NewSealer derives the encryption key from secret, which can be any length.
+func NewSealer(secret string) (*Sealer, error) {
+	if secret == "" {
+		return nil, ErrNoSecretKey
+	}
+
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead}, nil
+}
+
+// Seal encrypts plaintext, the result is base64 so it can sit in any event body.
+func (s *Sealer) Seal(plaintext string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := s.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value Seal returned.
+func (s *Sealer) Open(sealed string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < s.aead.NonceSize() {
+		return "", ErrUnsealable
+	}
+
+	nonce, ciphertext := raw[:s.aead.NonceSize()], raw[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", ErrUnsealable
+	}
+	return string(plaintext), nil
+}
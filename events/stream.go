@@ -0,0 +1,55 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Fields of an event's entry in a Redis stream.
+const (
+	StreamFieldId   = "id"
+	StreamFieldKey  = "key"
+	StreamFieldKind = "kind"
+	StreamFieldBody = "body"
+)
+
+// StreamValues encodes the event as the field values of a stream entry, with its body as JSON.
+func (e Event) StreamValues() (map[string]interface{}, error) {
+	body, err := json.Marshal(e.MsgBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode event body: %w", err)
+	}
+
+	return map[string]interface{}{
+		StreamFieldId:   e.ID.Hex(),
+		StreamFieldKey:  e.EventKey,
+		StreamFieldKind: e.EventKind,
+		StreamFieldBody: string(body),
+	}, nil
+}
+
+// EventFromStream decodes an event from the field values of a stream entry.
+func EventFromStream(values map[string]interface{}) (Event, error) {
+	var e Event
+
+	id, _ := values[StreamFieldId].(string)
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return e, errors.New("stream entry has an invalid event id")
+	}
+
+	e.ID = objectId
+	e.EventKey, _ = values[StreamFieldKey].(string)
+	e.EventKind, _ = values[StreamFieldKind].(string)
+
+	if body, _ := values[StreamFieldBody].(string); body != "" {
+		if err = json.Unmarshal([]byte(body), &e.MsgBody); err != nil {
+			return e, fmt.Errorf("failed to decode event body: %w", err)
+		}
+	}
+
+	return e, nil
+}
@@ -0,0 +1,153 @@
+package limiter
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	defaultFreeAttempts       int64 = 3
+	defaultMaxAccountAttempts int64 = 10
+	defaultMaxIpAttempts      int64 = 50
+	defaultAttemptWindow            = 15 * time.Minute
+	defaultBaseDelay                = time.Second
+	defaultMaxDelay                 = 30 * time.Second
+	defaultLockoutDuration          = 15 * time.Minute
+)
+
+type (
+	// LoginLimiter tracks failed logins per account and per client IP.
+	// After a few free attempts each failure delays the next allowed attempt exponentially,
+	// and once the maximum is reached the account or IP is locked out for the lockout duration.
+	LoginLimiter struct {
+		store              Store
+		freeAttempts       int64
+		maxAccountAttempts int64
+		maxIpAttempts      int64
+		window             time.Duration
+		baseDelay          time.Duration
+		maxDelay           time.Duration
+		lockout            time.Duration
+	}
+
+	LoginLimiterOptions func(*LoginLimiter)
+)
+
+func NewLoginLimiter(store Store, opts ...LoginLimiterOptions) *LoginLimiter {
+	l := &LoginLimiter{
+		store:              store,
+		freeAttempts:       defaultFreeAttempts,
+		maxAccountAttempts: defaultMaxAccountAttempts,
+		maxIpAttempts:      defaultMaxIpAttempts,
+		window:             defaultAttemptWindow,
+		baseDelay:          defaultBaseDelay,
+		maxDelay:           defaultMaxDelay,
+		lockout:            defaultLockoutDuration,
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+func WithMaxAttempts(account, ip int64) LoginLimiterOptions {
+	return func(l *LoginLimiter) {
+		l.maxAccountAttempts = account
+		l.maxIpAttempts = ip
+	}
+}
+
+func WithLockoutDuration(d time.Duration) LoginLimiterOptions {
+	return func(l *LoginLimiter) {
+		l.lockout = d
+	}
+}
+
+// LockoutDuration is how long an account stays locked after too many failed attempts.
+func (l *LoginLimiter) LockoutDuration() time.Duration {
+	return l.lockout
+}
+
+// RetryAfter returns how long the caller has to wait before trying to log in again, or zero if allowed.
+func (l *LoginLimiter) RetryAfter(ctx context.Context, email, ip string) (time.Duration, error) {
+	accountWait, err := l.store.LockedFor(ctx, accountLockKey(email))
+	if err != nil {
+		return 0, err
+	}
+
+	ipWait, err := l.store.LockedFor(ctx, ipLockKey(ip))
+	if err != nil {
+		return 0, err
+	}
+
+	if ipWait > accountWait {
+		return ipWait, nil
+	}
+	return accountWait, nil
+}
+
+// Failed records a failed attempt and reports whether it caused the account to be locked out.
+func (l *LoginLimiter) Failed(ctx context.Context, email, ip string) (bool, error) {
+	ipFailures, err := l.store.Incr(ctx, ipFailKey(ip), l.window)
+	if err != nil {
+		return false, err
+	}
+	if ipFailures >= l.maxIpAttempts {
+		if err = l.store.Lock(ctx, ipLockKey(ip), l.lockout); err != nil {
+			return false, err
+		}
+		if err = l.store.Reset(ctx, ipFailKey(ip)); err != nil {
+			return false, err
+		}
+	}
+
+	failures, err := l.store.Incr(ctx, accountFailKey(email), l.window)
+	if err != nil {
+		return false, err
+	}
+
+	if failures >= l.maxAccountAttempts {
+		if err = l.store.Lock(ctx, accountLockKey(email), l.lockout); err != nil {
+			return false, err
+		}
+		return true, l.store.Reset(ctx, accountFailKey(email))
+	}
+
+	if failures > l.freeAttempts {
+		return false, l.store.Lock(ctx, accountLockKey(email), l.delay(failures))
+	}
+
+	return false, nil
+}
+
+// Succeeded clears the failure count of the account.
+func (l *LoginLimiter) Succeeded(ctx context.Context, email string) error {
+	return l.store.Reset(ctx, accountFailKey(email), accountLockKey(email))
+}
+
+func (l *LoginLimiter) delay(failures int64) time.Duration {
+	d := time.Duration(float64(l.baseDelay) * math.Pow(2, float64(failures-l.freeAttempts-1)))
+	if d > l.maxDelay || d <= 0 {
+		return l.maxDelay
+	}
+	return d
+}
+
+func accountFailKey(email string) string {
+	return "login:fail:account:" + strings.ToLower(email)
+}
+
+func accountLockKey(email string) string {
+	return "login:lock:account:" + strings.ToLower(email)
+}
+
+func ipFailKey(ip string) string {
+	return "login:fail:ip:" + ip
+}
+
+func ipLockKey(ip string) string {
+	return "login:lock:ip:" + ip
+}
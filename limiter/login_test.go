@@ -0,0 +1,140 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// roughly reports whether got is want, less the little time spent since the lock was taken.
+func roughly(got, want time.Duration) bool {
+	return got <= want && got > want-time.Second/2
+}
+
+func TestLoginLimiterBackoff(t *testing.T) {
+	tests := []struct {
+		failures    int
+		wantWait    time.Duration
+		wantLockout bool
+	}{
+		{failures: 1},
+		{failures: 3},
+		{failures: 4, wantWait: time.Second},
+		{failures: 5, wantWait: 2 * time.Second},
+		{failures: 6, wantWait: 4 * time.Second},
+		{failures: 9, wantWait: 30 * time.Second},
+		{failures: 10, wantWait: defaultLockoutDuration, wantLockout: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d failures", tt.failures), func(t *testing.T) {
+			l := NewLoginLimiter(NewMemoryStore())
+			ctx := context.Background()
+
+			var locked bool
+			for i := 0; i < tt.failures; i++ {
+				var err error
+				if locked, err = l.Failed(ctx, "ada@example.com", "203.0.113.7"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if locked != tt.wantLockout {
+				t.Errorf("Failed() locked out = %v, want %v", locked, tt.wantLockout)
+			}
+
+			wait, err := l.RetryAfter(ctx, "Ada@Example.com", "198.51.100.1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantWait == 0 && wait != 0 || tt.wantWait != 0 && !roughly(wait, tt.wantWait) {
+				t.Errorf("RetryAfter() after %d failures = %s, want %s", tt.failures, wait, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestLoginLimiterLockout(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("per client ip across accounts", func(t *testing.T) {
+		l := NewLoginLimiter(NewMemoryStore(), WithMaxAttempts(10, 3), WithLockoutDuration(time.Minute))
+
+		for _, email := range []string{"ada@example.com", "obi@example.com", "eze@example.com"} {
+			if _, err := l.Failed(ctx, email, "203.0.113.7"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		wait, err := l.RetryAfter(ctx, "new@example.com", "203.0.113.7")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !roughly(wait, time.Minute) {
+			t.Errorf("RetryAfter() from the locked ip = %s, want %s", wait, time.Minute)
+		}
+
+		if wait, _ = l.RetryAfter(ctx, "new@example.com", "198.51.100.1"); wait != 0 {
+			t.Errorf("RetryAfter() from another ip = %s, want 0", wait)
+		}
+	})
+
+	t.Run("success clears the account", func(t *testing.T) {
+		l := NewLoginLimiter(NewMemoryStore())
+
+		for i := 0; i < 5; i++ {
+			if _, err := l.Failed(ctx, "ada@example.com", "203.0.113.7"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := l.Succeeded(ctx, "ADA@example.com"); err != nil {
+			t.Fatal(err)
+		}
+
+		if wait, _ := l.RetryAfter(ctx, "ada@example.com", "198.51.100.1"); wait != 0 {
+			t.Errorf("RetryAfter() after a success = %s, want 0", wait)
+		}
+		// the count starts over, so the next failure is free again
+		if _, err := l.Failed(ctx, "ada@example.com", "203.0.113.7"); err != nil {
+			t.Fatal(err)
+		}
+		if wait, _ := l.RetryAfter(ctx, "ada@example.com", "198.51.100.1"); wait != 0 {
+			t.Errorf("RetryAfter() after one more failure = %s, want 0", wait)
+		}
+	})
+
+	t.Run("lock runs out", func(t *testing.T) {
+		l := NewLoginLimiter(NewMemoryStore(), WithMaxAttempts(1, 50), WithLockoutDuration(20*time.Millisecond))
+
+		locked, err := l.Failed(ctx, "ada@example.com", "203.0.113.7")
+		if err != nil || !locked {
+			t.Fatalf("Failed() = %v, %v, want the account locked", locked, err)
+		}
+
+		time.Sleep(30 * time.Millisecond)
+		if wait, _ := l.RetryAfter(ctx, "ada@example.com", "203.0.113.7"); wait != 0 {
+			t.Errorf("RetryAfter() after the lockout = %s, want 0", wait)
+		}
+	})
+}
+
+func TestLoginLimiterDelay(t *testing.T) {
+	l := NewLoginLimiter(NewMemoryStore())
+
+	tests := []struct {
+		failures int64
+		want     time.Duration
+	}{
+		{failures: 4, want: time.Second},
+		{failures: 7, want: 8 * time.Second},
+		{failures: 8, want: 16 * time.Second},
+		{failures: 9, want: defaultMaxDelay},
+		{failures: 200, want: defaultMaxDelay},
+	}
+
+	for _, tt := range tests {
+		if got := l.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
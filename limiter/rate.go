@@ -0,0 +1,38 @@
+package limiter
+
+import (
+	"context"
+	"time"
+)
+
+// RateLimiter allows up to limit events per key in each fixed window.
+type RateLimiter struct {
+	store  Store
+	prefix string
+	limit  int64
+	window time.Duration
+}
+
+// NewRateLimiter counts events under keys starting with prefix, so limiters can share a store.
+func NewRateLimiter(store Store, prefix string, limit int64, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		store:  store,
+		prefix: prefix,
+		limit:  limit,
+		window: window,
+	}
+}
+
+// Allow records an event for key and reports whether it is within the limit.
+func (l *RateLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	count, err := l.store.Incr(ctx, l.prefix+":"+key, l.window)
+	if err != nil {
+		return false, err
+	}
+	return count <= l.limit, nil
+}
+
+// Window is how long a key's count is kept for.
+func (l *RateLimiter) Window() time.Duration {
+	return l.window
+}
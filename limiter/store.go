@@ -0,0 +1,178 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/tejiriaustin/narx_api/database"
+)
+
+type (
+	// Store keeps short-lived counters and locks shared by limiters.
+	Store interface {
+		// Incr increments the counter at key. The counter expires window after its first increment.
+		Incr(ctx context.Context, key string, window time.Duration) (int64, error)
+		// Lock marks key as locked for ttl.
+		Lock(ctx context.Context, key string, ttl time.Duration) error
+		// LockedFor returns how long key remains locked, or zero if it isn't.
+		LockedFor(ctx context.Context, key string) (time.Duration, error)
+		// Reset removes the given keys.
+		Reset(ctx context.Context, keys ...string) error
+	}
+
+	RedisStore struct {
+		client *database.RedisClient
+	}
+
+	MemoryStore struct {
+		m       sync.Mutex
+		entries map[string]memoryEntry
+	}
+
+	memoryEntry struct {
+		count     int64
+		expiresAt time.Time
+	}
+
+	// FallbackStore uses primary and switches to secondary for any call the primary fails.
+	FallbackStore struct {
+		primary   Store
+		secondary Store
+	}
+)
+
+var (
+	_ Store = (*RedisStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*FallbackStore)(nil)
+)
+
+func NewRedisStore(client *database.RedisClient) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	return s.client.Incr(ctx, key, window)
+}
+
+func (s *RedisStore) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	return s.client.SetWithTTL(ctx, key, 1, ttl)
+}
+
+func (s *RedisStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	return s.client.TTL(ctx, key)
+}
+
+func (s *RedisStore) Reset(ctx context.Context, keys ...string) error {
+	return s.client.Del(ctx, keys...)
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		m:       sync.Mutex{},
+		entries: make(map[string]memoryEntry),
+	}
+}
+
+func (s *MemoryStore) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	now := time.Now()
+	s.evict(now)
+
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = memoryEntry{expiresAt: now.Add(window)}
+	}
+	entry.count++
+	s.entries[key] = entry
+
+	return entry.count, nil
+}
+
+func (s *MemoryStore) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.entries[key] = memoryEntry{count: 1, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return 0, nil
+	}
+
+	remaining := time.Until(entry.expiresAt)
+	if remaining <= 0 {
+		delete(s.entries, key)
+		return 0, nil
+	}
+	return remaining, nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, keys ...string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for _, key := range keys {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+// evict drops expired entries so the map doesn't grow without bound. Callers must hold the lock.
+func (s *MemoryStore) evict(now time.Time) {
+	for key, entry := range s.entries {
+		if now.After(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+func NewFallbackStore(primary, secondary Store) *FallbackStore {
+	return &FallbackStore{primary: primary, secondary: secondary}
+}
+
+func (s *FallbackStore) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	n, err := s.primary.Incr(ctx, key, window)
+	if err != nil {
+		zap.L().Warn("limiter store failed, falling back", zap.Error(err))
+		return s.secondary.Incr(ctx, key, window)
+	}
+	return n, nil
+}
+
+func (s *FallbackStore) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	if err := s.primary.Lock(ctx, key, ttl); err != nil {
+		zap.L().Warn("limiter store failed, falling back", zap.Error(err))
+		return s.secondary.Lock(ctx, key, ttl)
+	}
+	return nil
+}
+
+func (s *FallbackStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.primary.LockedFor(ctx, key)
+	if err != nil {
+		zap.L().Warn("limiter store failed, falling back", zap.Error(err))
+		return s.secondary.LockedFor(ctx, key)
+	}
+	if ttl == 0 {
+		// locks taken while the primary was unavailable live in the secondary
+		return s.secondary.LockedFor(ctx, key)
+	}
+	return ttl, nil
+}
+
+func (s *FallbackStore) Reset(ctx context.Context, keys ...string) error {
+	_ = s.secondary.Reset(ctx, keys...)
+	return s.primary.Reset(ctx, keys...)
+}
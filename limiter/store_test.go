@@ -0,0 +1,159 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// brokenStore fails every call, like a Redis that can't be reached.
+type brokenStore struct{}
+
+var errUnavailable = errors.New("store unavailable")
+
+func (brokenStore) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	return 0, errUnavailable
+}
+
+func (brokenStore) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	return errUnavailable
+}
+
+func (brokenStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	return 0, errUnavailable
+}
+
+func (brokenStore) Reset(ctx context.Context, keys ...string) error {
+	return errUnavailable
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+
+	for want := int64(1); want <= 3; want++ {
+		if got, _ := s.Incr(ctx, "count", 20*time.Millisecond); got != want {
+			t.Errorf("Incr() = %d, want %d", got, want)
+		}
+	}
+
+	// the window runs from the first increment, later ones don't extend it
+	time.Sleep(30 * time.Millisecond)
+	if got, _ := s.Incr(ctx, "count", time.Minute); got != 1 {
+		t.Errorf("Incr() after the window = %d, want 1", got)
+	}
+
+	if err := s.Lock(ctx, "lock", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.LockedFor(ctx, "lock"); !roughly(got, time.Minute) {
+		t.Errorf("LockedFor() = %s, want %s", got, time.Minute)
+	}
+	if got, _ := s.LockedFor(ctx, "other"); got != 0 {
+		t.Errorf("LockedFor() of an unlocked key = %s, want 0", got)
+	}
+
+	if err := s.Reset(ctx, "lock", "count"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.LockedFor(ctx, "lock"); got != 0 {
+		t.Errorf("LockedFor() after Reset() = %s, want 0", got)
+	}
+	if got, _ := s.Incr(ctx, "count", time.Minute); got != 1 {
+		t.Errorf("Incr() after Reset() = %d, want 1", got)
+	}
+}
+
+func TestFallbackStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("primary failing", func(t *testing.T) {
+		secondary := NewMemoryStore()
+		s := NewFallbackStore(brokenStore{}, secondary)
+
+		for want := int64(1); want <= 2; want++ {
+			got, err := s.Incr(ctx, "count", time.Minute)
+			if err != nil || got != want {
+				t.Errorf("Incr() = %d, %v, want %d counted by the secondary", got, err, want)
+			}
+		}
+
+		if err := s.Lock(ctx, "lock", time.Minute); err != nil {
+			t.Fatalf("Lock() error = %v", err)
+		}
+		if got, err := s.LockedFor(ctx, "lock"); err != nil || !roughly(got, time.Minute) {
+			t.Errorf("LockedFor() = %s, %v, want the secondary's lock", got, err)
+		}
+
+		if err := s.Reset(ctx, "lock"); !errors.Is(err, errUnavailable) {
+			t.Errorf("Reset() error = %v, want the primary's %v", err, errUnavailable)
+		}
+		if got, _ := secondary.LockedFor(ctx, "lock"); got != 0 {
+			t.Errorf("secondary still locked for %s after Reset()", got)
+		}
+	})
+
+	t.Run("primary back", func(t *testing.T) {
+		primary := NewMemoryStore()
+		secondary := NewMemoryStore()
+		s := NewFallbackStore(primary, secondary)
+
+		// a lock taken while the primary was down still holds once it is back
+		if err := secondary.Lock(ctx, "lock", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := s.LockedFor(ctx, "lock"); !roughly(got, time.Minute) {
+			t.Errorf("LockedFor() = %s, want the lock taken in the secondary", got)
+		}
+
+		if err := primary.Lock(ctx, "lock", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := s.LockedFor(ctx, "lock"); !roughly(got, time.Hour) {
+			t.Errorf("LockedFor() = %s, want the primary's lock", got)
+		}
+
+		if _, err := s.Incr(ctx, "count", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := secondary.Incr(ctx, "count", time.Minute); got != 1 {
+			t.Error("counted in the secondary while the primary works")
+		}
+	})
+}
+
+func TestRateLimiter(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	sms := NewRateLimiter(store, "sms", 2, time.Minute)
+	reset := NewRateLimiter(store, "password_reset", 1, time.Minute)
+
+	tests := []struct {
+		name    string
+		limiter *RateLimiter
+		key     string
+		want    bool
+	}{
+		{name: "first", limiter: sms, key: "+2348000000000", want: true},
+		{name: "second", limiter: sms, key: "+2348000000000", want: true},
+		{name: "over the limit", limiter: sms, key: "+2348000000000", want: false},
+		{name: "another key", limiter: sms, key: "+2348000000001", want: true},
+		{name: "same key under another prefix", limiter: reset, key: "+2348000000000", want: true},
+		{name: "over the other limit", limiter: reset, key: "+2348000000000", want: false},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.limiter.Allow(ctx, tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: Allow() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := NewRateLimiter(brokenStore{}, "sms", 2, time.Minute).Allow(ctx, "key"); !errors.Is(err, errUnavailable) {
+		t.Errorf("Allow() with a broken store error = %v, want %v", err, errUnavailable)
+	}
+}
@@ -3,7 +3,13 @@ Copyright © 2023 TEJIRI ODIASE <tejiriaustin123@gmail.com>
 */
 package main
 
-import "github.com/tejiriaustin/narx_api/cmd"
+import (
+	// notification preferences are kept in the user's timezone, embed the database so it
+	// doesn't depend on the host having one
+	_ "time/tzdata"
+
+	"github.com/tejiriaustin/narx_api/cmd"
+)
 
 func main() {
 	cmd.Execute()
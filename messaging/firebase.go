@@ -2,37 +2,39 @@ package messaging
 
 import (
 	"context"
-	"encoding/json"
-	"github.com/tejiriaustin/narx_api/env"
+	"errors"
 	"log"
 	"path/filepath"
+	"strings"
 
 	firebase "firebase.google.com/go"
 	"firebase.google.com/go/messaging"
 	"google.golang.org/api/option"
-)
 
-type (
-	FirebaseMessaging struct {
-		ApiKey string
-		app    *firebase.App
-		conf   *env.Environment
-	}
-	Message struct {
-		Title string `json:"title"`
-		Body  string `json:"body"`
-	}
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
 )
 
+// maxMulticastTokens is the most tokens firebase accepts in a single multicast message.
+const maxMulticastTokens = 500
+
+type FirebaseMessaging struct {
+	ApiKey      string
+	app         *firebase.App
+	conf        *env.Environment
+	devicesRepo *repository.Repository[models.Devices]
+}
+
 var _ Messaging = (*FirebaseMessaging)(nil)
 
-func NewFirebaseMessaging(conf *env.Environment) *FirebaseMessaging {
+func NewFirebaseMessaging(conf *env.Environment, devicesRepo *repository.Repository[models.Devices]) *FirebaseMessaging {
 	serviceAccountKeyFilePath, err := filepath.Abs("./serviceAccountKey.json")
 	if err != nil {
 		panic("Unable to load serviceAccountKeys.json file")
 	}
 
-	opt := option.WithCredentialsJSON([]byte(serviceAccountKeyFilePath))
+	opt := option.WithCredentialsFile(serviceAccountKeyFilePath)
 
 	//Firebase admin SDK initialization
 	app, err := firebase.NewApp(context.Background(), nil, opt)
@@ -41,45 +43,124 @@ func NewFirebaseMessaging(conf *env.Environment) *FirebaseMessaging {
 	}
 
 	return &FirebaseMessaging{
-		app:  app,
-		conf: conf,
+		app:         app,
+		conf:        conf,
+		devicesRepo: devicesRepo,
 	}
 }
 
-func (f *FirebaseMessaging) Push(to string, msg string) error {
-	ctx := context.Background()
+// Send pushes msg to every device registered to the accounts it is addressed to. Recipient addresses
+// are account ids, the subject is the notification title and the text is its body.
+func (f *FirebaseMessaging) Send(ctx context.Context, msg Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+
+	return f.PushToAccounts(ctx, msg.Addresses(), msg)
+}
 
-	client, err := f.app.Messaging(ctx)
+// PushToAccounts multicasts msg to all devices of the accounts, and removes devices whose
+// tokens firebase reports as no longer registered.
+func (f *FirebaseMessaging) PushToAccounts(ctx context.Context, accountIds []string, msg Message) error {
+	tokens, err := f.deviceTokens(ctx, accountIds)
 	if err != nil {
-		log.Fatalf("error getting Messaging client: %v\n", err)
+		return err
+	}
+	if len(tokens) == 0 {
+		log.Printf("no registered devices for accounts %v", accountIds)
+		return nil
 	}
 
-	firebaseMessage, err := UnmarshalFirebaseMessage(ctx, msg)
+	client, err := f.app.Messaging(ctx)
 	if err != nil {
-		return err
+		return errors.New("error getting messaging client: " + err.Error())
 	}
 
-	message := &messaging.Message{
-		Notification: &messaging.Notification{
-			Title: firebaseMessage.Title,
-			Body:  firebaseMessage.Body,
-		},
-		Token: f.conf.GetAsString(env.FirebaseRegistrationToken),
+	var staleTokens []string
+	sent := 0
+
+	for start := 0; start < len(tokens); start += maxMulticastTokens {
+		end := start + maxMulticastTokens
+		if end > len(tokens) {
+			end = len(tokens)
+		}
+		batch := tokens[start:end]
+
+		response, err := client.SendMulticast(ctx, &messaging.MulticastMessage{
+			Tokens: batch,
+			Notification: &messaging.Notification{
+				Title: msg.Subject,
+				Body:  msg.Text,
+			},
+			Data: msg.Metadata,
+		})
+		if err != nil {
+			log.Printf("firebase multicast failed: %s", err)
+			continue
+		}
+
+		sent += response.SuccessCount
+		for i, r := range response.Responses {
+			if r.Success {
+				continue
+			}
+			if isStaleToken(r.Error) {
+				staleTokens = append(staleTokens, batch[i])
+				continue
+			}
+			log.Printf("firebase push to device failed: %s", r.Error)
+		}
 	}
 
-	response, err := client.Send(ctx, message)
-	if err != nil {
-		log.Fatalln(err)
+	if len(staleTokens) > 0 {
+		f.pruneDevices(ctx, staleTokens)
+	}
+
+	if sent == 0 && len(staleTokens) < len(tokens) {
+		return errors.New("push notification could not be delivered to any device")
 	}
-	log.Println("Successfully sent message:", response)
+
+	log.Printf("push notification sent to %d of %d devices", sent, len(tokens))
 	return nil
 }
 
-func UnmarshalFirebaseMessage(ctx context.Context, msg string) (Message, error) {
-	var message Message
-	err := json.Unmarshal([]byte(msg), &message)
+func (f *FirebaseMessaging) deviceTokens(ctx context.Context, accountIds []string) ([]string, error) {
+	filter := repository.NewQueryFilter().AddFilter(models.FieldAccountInfoId, map[string]interface{}{"$in": accountIds})
+
+	devices, err := f.devicesRepo.Find(ctx, filter, nil, nil)
 	if err != nil {
-		return message, err
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	tokens := make([]string, 0, len(devices))
+	for _, device := range devices {
+		if device.DeviceToken == "" || seen[device.DeviceToken] {
+			continue
+		}
+		seen[device.DeviceToken] = true
+		tokens = append(tokens, device.DeviceToken)
+	}
+	return tokens, nil
+}
+
+func (f *FirebaseMessaging) pruneDevices(ctx context.Context, tokens []string) {
+	filter := repository.NewQueryFilter().AddFilter(models.FieldDeviceToken, map[string]interface{}{"$in": tokens})
+
+	err := f.devicesRepo.DeleteMany(ctx, filter)
+	if err != nil {
+		log.Printf("failed to remove unregistered devices: %s", err)
+		return
+	}
+	log.Printf("removed %d unregistered devices", len(tokens))
+}
+
+// isStaleToken reports whether a send failed because the token itself is no longer usable. Other
+// invalid argument errors, such as an oversized payload, say nothing about the device.
+func isStaleToken(err error) bool {
+	if messaging.IsRegistrationTokenNotRegistered(err) {
+		return true
 	}
-	return message, nil
+	// this sdk version has no dedicated check for a malformed token, fcm reports it as an invalid argument
+	return messaging.IsInvalidArgument(err) && strings.Contains(err.Error(), "not a valid FCM registration token")
 }
@@ -0,0 +1,216 @@
+package messaging
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	firebase "firebase.google.com/go"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/api/option"
+
+	"github.com/tejiriaustin/narx_api/database"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+// fcm errors as the batch endpoint reports them
+const (
+	unregisteredToken = `{"error": {"status": "NOT_FOUND", "message": "Requested entity was not found.", "details": [{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "UNREGISTERED"}]}}`
+	malformedToken    = `{"error": {"status": "INVALID_ARGUMENT", "message": "The registration token is not a valid FCM registration token"}}`
+	oversizedMessage  = `{"error": {"status": "INVALID_ARGUMENT", "message": "Message is too big"}}`
+	fcmUnavailable    = `{"error": {"status": "UNAVAILABLE", "message": "The service is currently unavailable."}}`
+)
+
+// fakeFCM answers firebase batch sends, failing the tokens in failures with their error.
+type fakeFCM struct {
+	failures map[string]string
+
+	mu     sync.Mutex
+	pushed []string
+}
+
+func (f *fakeFCM) RoundTrip(req *http.Request) (*http.Response, error) {
+	_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	requests := multipart.NewReader(req.Body, params["boundary"])
+	for i := 1; ; i++ {
+		part, err := requests.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		sent, err := http.ReadRequest(bufio.NewReader(part))
+		if err != nil {
+			return nil, err
+		}
+		var payload struct {
+			Message struct {
+				Token string `json:"token"`
+			} `json:"message"`
+		}
+		if err = json.NewDecoder(sent.Body).Decode(&payload); err != nil {
+			return nil, err
+		}
+
+		response := "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\n\r\n" + `{"name": "projects/narx-test/messages/1"}`
+		if failure, ok := f.failures[payload.Message.Token]; ok {
+			response = "HTTP/1.1 400 Bad Request\r\nContent-Type: application/json\r\n\r\n" + failure
+		} else {
+			f.mu.Lock()
+			f.pushed = append(f.pushed, payload.Message.Token)
+			f.mu.Unlock()
+		}
+
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {"application/http"},
+			"Content-Id":   {fmt.Sprintf("response-%d", i)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err = io.WriteString(w, response); err != nil {
+			return nil, err
+		}
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"multipart/mixed; boundary=" + writer.Boundary()}},
+		Body:       io.NopCloser(&body),
+		Request:    req,
+	}, nil
+}
+
+func newTestFirebaseMessaging(t *testing.T, fcm *fakeFCM, devicesRepo *repository.Repository[models.Devices]) *FirebaseMessaging {
+	t.Helper()
+
+	app, err := firebase.NewApp(context.Background(), &firebase.Config{ProjectID: "narx-test"}, option.WithHTTPClient(&http.Client{Transport: fcm}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &FirebaseMessaging{app: app, devicesRepo: devicesRepo}
+}
+
+func TestPushToAccounts(t *testing.T) {
+	tests := []struct {
+		name       string
+		devices    map[string][]string // tokens of each account's devices
+		failures   map[string]string
+		wantPushed []string
+		wantKept   []string
+		wantErr    bool
+	}{
+		{
+			name:       "every device of every account",
+			devices:    map[string][]string{"ada": {"phone", "tablet"}, "obi": {"laptop"}},
+			wantPushed: []string{"laptop", "phone", "tablet"},
+			wantKept:   []string{"laptop", "phone", "tablet"},
+		},
+		{
+			name:       "a token shared by two accounts is pushed once",
+			devices:    map[string][]string{"ada": {"phone"}, "obi": {"phone"}},
+			wantPushed: []string{"phone"},
+			wantKept:   []string{"phone", "phone"},
+		},
+		{
+			name:     "no devices",
+			devices:  map[string][]string{"eze": {"phone"}},
+			wantKept: []string{"phone"},
+		},
+		{
+			name:       "unregistered and malformed tokens are pruned",
+			devices:    map[string][]string{"ada": {"phone", "old phone", "garbled"}},
+			failures:   map[string]string{"old phone": unregisteredToken, "garbled": malformedToken},
+			wantPushed: []string{"phone"},
+			wantKept:   []string{"phone"},
+		},
+		{
+			name:     "only stale tokens is not a failure",
+			devices:  map[string][]string{"ada": {"old phone"}},
+			failures: map[string]string{"old phone": unregisteredToken},
+		},
+		{
+			name:     "other invalid arguments keep the device",
+			devices:  map[string][]string{"ada": {"phone"}},
+			failures: map[string]string{"phone": oversizedMessage},
+			wantKept: []string{"phone"},
+			wantErr:  true,
+		},
+		{
+			name:     "unavailable keeps the device",
+			devices:  map[string][]string{"ada": {"phone", "old phone"}},
+			failures: map[string]string{"phone": fcmUnavailable, "old phone": unregisteredToken},
+			wantKept: []string{"phone"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			devicesRepo := repository.NewRepository[models.Devices](database.NewMemoryCollection())
+			for accountId, tokens := range tt.devices {
+				for _, token := range tokens {
+					now := time.Now().UTC()
+					_, err := devicesRepo.Create(ctx, models.Devices{
+						Shared:      models.Shared{ID: primitive.NewObjectID(), CreatedAt: &now},
+						AccountInfo: models.AccountInfo{Id: accountId},
+						DeviceToken: token,
+					})
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			fcm := &fakeFCM{failures: tt.failures}
+			f := newTestFirebaseMessaging(t, fcm, devicesRepo)
+
+			err := f.PushToAccounts(ctx, []string{"ada", "obi"}, Message{Subject: "Inverter fault", Text: "Your inverter stopped reporting."})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("PushToAccounts() error = %v, want error %v", err, tt.wantErr)
+			}
+
+			slices.Sort(fcm.pushed)
+			if !slices.Equal(fcm.pushed, tt.wantPushed) {
+				t.Errorf("pushed to %v, want %v", fcm.pushed, tt.wantPushed)
+			}
+
+			devices, err := devicesRepo.Find(ctx, repository.NewQueryFilter(), nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var kept []string
+			for _, device := range devices {
+				kept = append(kept, device.DeviceToken)
+			}
+			slices.Sort(kept)
+			if !slices.Equal(kept, tt.wantKept) {
+				t.Errorf("devices left %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package messaging
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"log"
 
 	"github.com/mailjet/mailjet-apiv3-go/v4"
@@ -28,24 +31,55 @@ func NewMailjetClient(publicKey, privateKey string, opts ...Options) *MailjetCli
 	return m
 }
 
-func (m *MailjetClient) Push(to string, msg string) error {
-	messagesInfo := []mailjet.InfoMessagesV31{
-		{
-			From: &mailjet.RecipientV31{
-				Email: m.from.email,
-				Name:  m.from.name,
-			},
-			To: &mailjet.RecipientsV31{
-				mailjet.RecipientV31{
-					Email: to,
-					Name:  "",
-				},
-			},
-			TextPart: msg,
-			HTMLPart: msg,
+func (m *MailjetClient) Send(ctx context.Context, msg Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+
+	to := make(mailjet.RecipientsV31, 0, len(msg.To))
+	for _, recipient := range msg.To {
+		if recipient.Address == "" {
+			continue
+		}
+		to = append(to, mailjet.RecipientV31{
+			Email: recipient.Address,
+			Name:  recipient.Name,
+		})
+	}
+
+	info := mailjet.InfoMessagesV31{
+		From: &mailjet.RecipientV31{
+			Email: m.from.email,
+			Name:  m.from.name,
 		},
+		To:       &to,
+		Subject:  msg.Subject,
+		TextPart: msg.Text,
+		HTMLPart: msg.HTML,
+	}
+
+	if len(msg.Attachments) > 0 {
+		attachments := make(mailjet.AttachmentsV31, 0, len(msg.Attachments))
+		for _, attachment := range msg.Attachments {
+			attachments = append(attachments, mailjet.AttachmentV31{
+				ContentType:   attachment.contentType(),
+				Filename:      attachment.Filename,
+				Base64Content: base64.StdEncoding.EncodeToString(attachment.Data),
+			})
+		}
+		info.Attachments = &attachments
 	}
-	messages := mailjet.MessagesV31{Info: messagesInfo}
+
+	// metadata comes back to us on mailjet's event webhooks
+	if len(msg.Metadata) > 0 {
+		payload, err := json.Marshal(msg.Metadata)
+		if err != nil {
+			return err
+		}
+		info.EventPayload = string(payload)
+	}
+
+	messages := mailjet.MessagesV31{Info: []mailjet.InfoMessagesV31{info}}
 	_, err := m.client.SendMailV31(&messages)
 	if err != nil {
 		log.Println("Mailjet send failed: ", err)
@@ -59,3 +93,9 @@ func WithSenderEmail(email string) Options {
 		m.from.email = email
 	}
 }
+
+func WithSenderName(name string) Options {
+	return func(m *MailjetClient) {
+		m.from.name = name
+	}
+}
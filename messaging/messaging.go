@@ -1,8 +1,18 @@
 package messaging
 
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrNoRecipients = errors.New("message has no recipients")
+	ErrEmptyMessage = errors.New("message has no subject or body")
+)
+
 type (
 	Messaging interface {
-		Push(to string, msg string) error
+		Send(ctx context.Context, msg Message) error
 	}
 
 	Author struct {
@@ -10,12 +20,28 @@ type (
 		email string
 	}
 
-	Mail struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Subject  string `json:"subject"`
-		TextPart string `json:"textPart"`
-		HtmlPart string `json:"htmlPart"`
+	// Message is a channel independent notification. Email senders use every part of it, push
+	// senders use the subject as the title, the text as the body and the metadata as the data payload.
+	Message struct {
+		To          []Recipient
+		Subject     string
+		Text        string
+		HTML        string
+		Attachments []Attachment
+		Metadata    map[string]string
+	}
+
+	// Recipient is who a message goes to. Address is an email address for mail senders, a phone
+	// number for SMS and an account id for push senders.
+	Recipient struct {
+		Name    string
+		Address string
+	}
+
+	Attachment struct {
+		Filename    string
+		ContentType string
+		Data        []byte
 	}
 )
 
@@ -26,11 +52,30 @@ func NewMailer(name string, email string) *Author {
 	}
 }
 
-func BuildMail(name, email, subject, template string) Mail {
-	return Mail{
-		Name:     name,
-		Email:    email,
-		Subject:  subject,
-		HtmlPart: template,
+func NewMessage(subject string, to ...Recipient) Message {
+	return Message{
+		To:      to,
+		Subject: subject,
+	}
+}
+
+func (m Message) Validate() error {
+	if len(m.Addresses()) == 0 {
+		return ErrNoRecipients
+	}
+	if m.Subject == "" && m.Text == "" && m.HTML == "" {
+		return ErrEmptyMessage
+	}
+	return nil
+}
+
+// Addresses returns the non-empty recipient addresses of the message.
+func (m Message) Addresses() []string {
+	addresses := make([]string, 0, len(m.To))
+	for _, to := range m.To {
+		if to.Address != "" {
+			addresses = append(addresses, to.Address)
+		}
 	}
+	return addresses
 }
@@ -0,0 +1,37 @@
+package messaging
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestMessageValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     Message
+		wantErr error
+	}{
+		{name: "subject only", msg: Message{To: []Recipient{{Address: "ada@example.com"}}, Subject: "Hello"}},
+		{name: "html only", msg: Message{To: []Recipient{{Address: "ada@example.com"}}, HTML: "<p>Hello</p>"}},
+		{name: "no recipients", msg: Message{Subject: "Hello"}, wantErr: ErrNoRecipients},
+		{name: "recipients without addresses", msg: Message{To: []Recipient{{Name: "Ada"}}, Subject: "Hello"}, wantErr: ErrNoRecipients},
+		{name: "nothing to say", msg: Message{To: []Recipient{{Address: "ada@example.com"}}}, wantErr: ErrEmptyMessage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.msg.Validate(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMessageAddresses(t *testing.T) {
+	msg := NewMessage("Hello", Recipient{Name: "Ada", Address: "ada@example.com"}, Recipient{Name: "Nobody"}, Recipient{Address: "obi@example.com"})
+
+	if got, want := msg.Addresses(), []string{"ada@example.com", "obi@example.com"}; !slices.Equal(got, want) {
+		t.Errorf("Addresses() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,194 @@
+package messaging
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// base64LineLength is the longest line RFC 2045 allows in a base64 body.
+const base64LineLength = 76
+
+// BuildMIME renders msg as an RFC 5322 email. The text and HTML bodies become a multipart/alternative
+// part, and any attachments wrap that in a multipart/mixed message.
+func BuildMIME(from mail.Address, msg Message) ([]byte, error) {
+	var buf bytes.Buffer
+
+	to := make([]string, 0, len(msg.To))
+	for _, recipient := range msg.To {
+		if recipient.Address == "" {
+			continue
+		}
+		to = append(to, (&mail.Address{Name: recipient.Name, Address: recipient.Address}).String())
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("From", from.String())
+	header.Set("To", strings.Join(to, ", "))
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header.Set("Date", time.Now().UTC().Format(time.RFC1123Z))
+	header.Set("Message-ID", messageId(from.Address))
+	header.Set("MIME-Version", "1.0")
+	for key, value := range msg.Metadata {
+		header.Set("X-Narx-"+textproto.CanonicalMIMEHeaderKey(key), mime.QEncoding.Encode("utf-8", value))
+	}
+
+	body, err := renderBody(header, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(msg.Attachments) == 0 {
+		writeHeader(&buf, header)
+		buf.Write(body)
+		return buf.Bytes(), nil
+	}
+
+	// the body's content headers move onto its part of the mixed message
+	bodyHeader := textproto.MIMEHeader{}
+	for _, key := range []string{"Content-Type", "Content-Transfer-Encoding"} {
+		if value := header.Get(key); value != "" {
+			bodyHeader.Set(key, value)
+			header.Del(key)
+		}
+	}
+
+	mixed := multipart.NewWriter(&buf)
+	header.Set("Content-Type", "multipart/mixed; boundary="+mixed.Boundary())
+	writeHeader(&buf, header)
+
+	part, err := mixed.CreatePart(bodyHeader)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(body); err != nil {
+		return nil, err
+	}
+
+	for _, attachment := range msg.Attachments {
+		part, err := mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.contentType()},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64(part, attachment.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderBody encodes the message body and sets its content headers on header. A message with only
+// one of text or HTML is sent as a single part.
+func renderBody(header textproto.MIMEHeader, msg Message) ([]byte, error) {
+	var buf bytes.Buffer
+
+	switch {
+	case msg.Text != "" && msg.HTML != "":
+		alternative := multipart.NewWriter(&buf)
+		header.Set("Content-Type", "multipart/alternative; boundary="+alternative.Boundary())
+
+		for _, p := range []struct{ contentType, content string }{
+			{"text/plain; charset=utf-8", msg.Text},
+			{"text/html; charset=utf-8", msg.HTML},
+		} {
+			part, err := alternative.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {p.contentType},
+				"Content-Transfer-Encoding": {"quoted-printable"},
+			})
+			if err != nil {
+				return nil, err
+			}
+			if err := writeQuotedPrintable(part, p.content); err != nil {
+				return nil, err
+			}
+		}
+		if err := alternative.Close(); err != nil {
+			return nil, err
+		}
+
+	case msg.HTML != "":
+		header.Set("Content-Type", "text/html; charset=utf-8")
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		if err := writeQuotedPrintable(&buf, msg.HTML); err != nil {
+			return nil, err
+		}
+
+	default:
+		header.Set("Content-Type", "text/plain; charset=utf-8")
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		if err := writeQuotedPrintable(&buf, msg.Text); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeHeader(w *bytes.Buffer, header textproto.MIMEHeader) {
+	for key, values := range header {
+		for _, value := range values {
+			fmt.Fprintf(w, "%s: %s\r\n", key, value)
+		}
+	}
+	w.WriteString("\r\n")
+}
+
+func writeQuotedPrintable(w io.Writer, content string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(content)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > base64LineLength {
+		if _, err := io.WriteString(w, encoded[:base64LineLength]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[base64LineLength:]
+	}
+	_, err := io.WriteString(w, encoded+"\r\n")
+	return err
+}
+
+func messageId(from string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = from[at+1:]
+	}
+
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), domain)
+}
+
+func (a Attachment) contentType() string {
+	if a.ContentType != "" {
+		return a.ContentType
+	}
+	if contentType := mime.TypeByExtension(filepath.Ext(a.Filename)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,214 @@
+package messaging
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// mimePart is a leaf of a parsed email: its media type, decoded content and filename if attached.
+type mimePart struct {
+	mediaType string
+	content   string
+	filename  string
+}
+
+// parseMIME flattens the parts of an email, in order, decoding their transfer encodings.
+func parseMIME(t *testing.T, header map[string][]string, body io.Reader) []mimePart {
+	t.Helper()
+
+	get := func(key string) string {
+		if values := header[key]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	mediaType, params, err := mime.ParseMediaType(get("Content-Type"))
+	if err != nil {
+		t.Fatalf("content type %q: %v", get("Content-Type"), err)
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		var parts []mimePart
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return parts
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			parts = append(parts, parseMIME(t, part.Header, part)...)
+		}
+	}
+
+	switch get("Content-Transfer-Encoding") {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var filename string
+	if disposition := get("Content-Disposition"); disposition != "" {
+		if _, params, err := mime.ParseMediaType(disposition); err == nil {
+			filename = params["filename"]
+		}
+	}
+	return []mimePart{{mediaType: mediaType, content: string(content), filename: filename}}
+}
+
+func TestBuildMIME(t *testing.T) {
+	from := mail.Address{Name: "Narx", Address: "alerts@narx.example"}
+	to := []Recipient{{Name: "Adaeze Obi", Address: "ada@example.com"}, {Name: "No address"}, {Address: "obi@example.com"}}
+	report := bytes.Repeat([]byte("kWh,"), 100)
+
+	tests := []struct {
+		name      string
+		msg       Message
+		wantType  string
+		wantParts []mimePart
+	}{
+		{
+			name:      "text",
+			msg:       Message{To: to, Subject: "Inverter fault", Text: "Your inverter stopped reporting."},
+			wantType:  "text/plain",
+			wantParts: []mimePart{{mediaType: "text/plain", content: "Your inverter stopped reporting."}},
+		},
+		{
+			name:      "html",
+			msg:       Message{To: to, Subject: "Inverter fault", HTML: "<p>Your inverter stopped reporting.</p>"},
+			wantType:  "text/html",
+			wantParts: []mimePart{{mediaType: "text/html", content: "<p>Your inverter stopped reporting.</p>"}},
+		},
+		{
+			name:     "text and html",
+			msg:      Message{To: to, Subject: "Inverter fault", Text: "Your inverter stopped reporting.", HTML: "<p>Your inverter stopped reporting.</p>"},
+			wantType: "multipart/alternative",
+			wantParts: []mimePart{
+				{mediaType: "text/plain", content: "Your inverter stopped reporting."},
+				{mediaType: "text/html", content: "<p>Your inverter stopped reporting.</p>"},
+			},
+		},
+		{
+			name: "attachments",
+			msg: Message{To: to, Subject: "Weekly report", Text: "Your report is attached.", HTML: "<p>Your report is attached.</p>", Attachments: []Attachment{
+				{Filename: "report.csv", ContentType: "text/csv", Data: report},
+				{Filename: "summary.pdf", Data: []byte("%PDF-1.4")},
+				{Filename: "readings", Data: []byte{0, 1, 2}},
+			}},
+			wantType: "multipart/mixed",
+			wantParts: []mimePart{
+				{mediaType: "text/plain", content: "Your report is attached."},
+				{mediaType: "text/html", content: "<p>Your report is attached.</p>"},
+				{mediaType: "text/csv", content: string(report), filename: "report.csv"},
+				{mediaType: "application/pdf", content: "%PDF-1.4", filename: "summary.pdf"},
+				{mediaType: "application/octet-stream", content: "\x00\x01\x02", filename: "readings"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := BuildMIME(from, tt.msg)
+			if err != nil {
+				t.Fatalf("BuildMIME() error = %v", err)
+			}
+			for _, line := range strings.Split(string(raw), "\r\n") {
+				if len(line) > 998 {
+					t.Fatalf("line of %d characters, the most RFC 5322 allows is 998", len(line))
+				}
+			}
+
+			email, err := mail.ReadMessage(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("BuildMIME() isn't a valid email: %v", err)
+			}
+
+			subject, err := new(mime.WordDecoder).DecodeHeader(email.Header.Get("Subject"))
+			if err != nil || subject != tt.msg.Subject {
+				t.Errorf("Subject = %q (%v), want %q", subject, err, tt.msg.Subject)
+			}
+			recipients, err := email.Header.AddressList("To")
+			if err != nil || len(recipients) != 2 || recipients[0].Address != "ada@example.com" || recipients[1].Address != "obi@example.com" {
+				t.Errorf("To = %v (%v), want ada@example.com and obi@example.com", recipients, err)
+			}
+			if sender, err := email.Header.AddressList("From"); err != nil || sender[0].String() != from.String() {
+				t.Errorf("From = %v (%v), want %s", sender, err, from.String())
+			}
+			if id := email.Header.Get("Message-Id"); !strings.HasSuffix(id, "@narx.example>") {
+				t.Errorf("Message-Id = %q, want one at narx.example", id)
+			}
+
+			if mediaType, _, _ := mime.ParseMediaType(email.Header.Get("Content-Type")); mediaType != tt.wantType {
+				t.Errorf("Content-Type = %s, want %s", mediaType, tt.wantType)
+			}
+
+			parts := parseMIME(t, email.Header, email.Body)
+			if len(parts) != len(tt.wantParts) {
+				t.Fatalf("%d parts, want %d: %+v", len(parts), len(tt.wantParts), parts)
+			}
+			for i, want := range tt.wantParts {
+				if parts[i] != want {
+					t.Errorf("part %d = %+v, want %+v", i, parts[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildMIMEHeaders(t *testing.T) {
+	raw, err := BuildMIME(mail.Address{Address: "alerts@narx.example"}, Message{
+		To:       []Recipient{{Name: "Adaeze Óbí", Address: "ada@example.com"}},
+		Subject:  "Défaut de l'onduleur",
+		Text:     "Votre onduleur ne répond plus.",
+		Metadata: map[string]string{"event-id": "42", "site": "Lagos – Ikeja"},
+	})
+	if err != nil {
+		t.Fatalf("BuildMIME() error = %v", err)
+	}
+
+	if !isASCII(raw) {
+		t.Error("BuildMIME() wrote non-ascii bytes, headers and bodies should be encoded")
+	}
+
+	email, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := new(mime.WordDecoder)
+	for header, want := range map[string]string{
+		"Subject":         "Défaut de l'onduleur",
+		"X-Narx-Event-Id": "42",
+		"X-Narx-Site":     "Lagos – Ikeja",
+	} {
+		if got, err := decoder.DecodeHeader(email.Header.Get(header)); err != nil || got != want {
+			t.Errorf("%s = %q (%v), want %q", header, got, err, want)
+		}
+	}
+	if recipients, err := email.Header.AddressList("To"); err != nil || recipients[0].Name != "Adaeze Óbí" {
+		t.Errorf("To = %v (%v), want Adaeze Óbí", recipients, err)
+	}
+}
+
+func isASCII(b []byte) bool {
+	for _, c := range b {
+		if c > 127 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	_ Messaging = (*MemorySink)(nil)
+	_ Messaging = (*FileSink)(nil)
+	_ Messaging = (*LogSink)(nil)
+)
+
+type (
+	// MemorySink keeps every message it is sent, for tests and local development.
+	MemorySink struct {
+		mu       sync.Mutex
+		messages []Message
+	}
+
+	// FileSink writes each message to its own .eml file, which any mail client can open.
+	FileSink struct {
+		dir  string
+		from mail.Address
+	}
+
+	// LogSink logs each message instead of sending it, for push notifications in local development.
+	LogSink struct{}
+)
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (m *MemorySink) Send(ctx context.Context, msg Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first.
+func (m *MemorySink) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
+
+// SentTo returns the messages addressed to address.
+func (m *MemorySink) SentTo(address string) []Message {
+	var sent []Message
+	for _, msg := range m.Messages() {
+		for _, to := range msg.Addresses() {
+			if strings.EqualFold(to, address) {
+				sent = append(sent, msg)
+				break
+			}
+		}
+	}
+	return sent
+}
+
+func (m *MemorySink) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = nil
+}
+
+func NewFileSink(dir string, from mail.Address) (*FileSink, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileSink{dir: dir, from: from}, nil
+}
+
+func (f *FileSink) Send(ctx context.Context, msg Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+
+	body, err := BuildMIME(f.from, msg)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), fileSafe(msg.Addresses()[0]))
+	path := filepath.Join(f.dir, name)
+
+	err = os.WriteFile(path, body, 0o644)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("mail written to %s", path)
+	return nil
+}
+
+func NewLogSink() *LogSink {
+	return &LogSink{}
+}
+
+func (l *LogSink) Send(ctx context.Context, msg Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+
+	log.Printf("message to %s: %s: %s", strings.Join(msg.Addresses(), ", "), msg.Subject, msg.Text)
+	return nil
+}
+
+func fileSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_', r == '@':
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,156 @@
+package messaging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMemorySink(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     Message
+		wantErr error
+	}{
+		{name: "sent", msg: Message{To: []Recipient{{Address: "ada@example.com"}}, Subject: "Reset your password", Text: "Code: 482913"}},
+		{name: "no recipients", msg: Message{Subject: "Reset your password"}, wantErr: ErrNoRecipients},
+		{name: "blank recipient", msg: Message{To: []Recipient{{Name: "Ada"}}, Subject: "Reset your password"}, wantErr: ErrNoRecipients},
+		{name: "empty message", msg: Message{To: []Recipient{{Address: "ada@example.com"}}}, wantErr: ErrEmptyMessage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := NewMemorySink()
+
+			err := sink.Send(context.Background(), tt.msg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Send() error = %v, want %v", err, tt.wantErr)
+			}
+
+			wantKept := 1
+			if tt.wantErr != nil {
+				wantKept = 0
+			}
+			if got := len(sink.Messages()); got != wantKept {
+				t.Errorf("sink kept %d messages, want %d", got, wantKept)
+			}
+		})
+	}
+}
+
+func TestMemorySinkSentTo(t *testing.T) {
+	sink := NewMemorySink()
+
+	send := func(subject string, to ...string) {
+		msg := NewMessage(subject)
+		for _, address := range to {
+			msg.To = append(msg.To, Recipient{Address: address})
+		}
+		if err := sink.Send(context.Background(), msg); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+	send("first", "ada@example.com")
+	send("second", "Ada@Example.com", "obi@example.com")
+	send("third", "obi@example.com")
+
+	tests := []struct {
+		address string
+		want    []string
+	}{
+		{address: "ada@example.com", want: []string{"first", "second"}},
+		{address: "OBI@example.com", want: []string{"second", "third"}},
+		{address: "nobody@example.com", want: nil},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, msg := range sink.SentTo(tt.address) {
+			got = append(got, msg.Subject)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("SentTo(%s) = %v, want %v", tt.address, got, tt.want)
+		}
+	}
+
+	sink.Reset()
+	if got := len(sink.Messages()); got != 0 {
+		t.Errorf("%d messages kept after Reset()", got)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+
+	sink, err := NewFileSink(dir, mail.Address{Name: "Narx", Address: "no-reply@example.com"})
+	if err != nil {
+		t.Fatalf("NewFileSink() error = %v", err)
+	}
+
+	msg := NewMessage("Réinitialisez votre mot de passe", Recipient{Name: "Ada Obi", Address: "ada+alerts@example.com"})
+	msg.Text = "Code : 482913"
+	msg.HTML = "<p>Code : <b>482913</b></p>"
+
+	if err = sink.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if err = sink.Send(context.Background(), Message{Subject: "no one to send to"}); !errors.Is(err, ErrNoRecipients) {
+		t.Fatalf("Send() of an invalid message error = %v, want %v", err, ErrNoRecipients)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("sink wrote %d files, want 1", len(files))
+	}
+	if name := files[0].Name(); !strings.HasSuffix(name, "-ada_alerts@example.com.eml") {
+		t.Errorf("file name = %s, want it to end with the recipient made file safe", name)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	written, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("written file isn't a mail message: %v", err)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(written.Header.Get("Subject"))
+	if err != nil || subject != msg.Subject {
+		t.Errorf("Subject = %q, want %q", subject, msg.Subject)
+	}
+	to, err := written.Header.AddressList("To")
+	if err != nil || len(to) != 1 || to[0].Address != "ada+alerts@example.com" {
+		t.Errorf("To = %v, want ada+alerts@example.com", to)
+	}
+	from, err := written.Header.AddressList("From")
+	if err != nil || len(from) != 1 || from[0].Address != "no-reply@example.com" {
+		t.Errorf("From = %v, want no-reply@example.com", from)
+	}
+}
+
+func TestFileSafe(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "ada@example.com", want: "ada@example.com"},
+		{in: "ada+alerts@example.com", want: "ada_alerts@example.com"},
+		{in: "../../etc/passwd", want: ".._.._etc_passwd"},
+		{in: "+2348012345678", want: "_2348012345678"},
+	}
+
+	for _, tt := range tests {
+		if got := fileSafe(tt.in); got != tt.want {
+			t.Errorf("fileSafe(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
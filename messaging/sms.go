@@ -0,0 +1,129 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/tejiriaustin/narx_api/limiter"
+)
+
+// maxSMSLength keeps a message to three concatenated segments.
+const maxSMSLength = 459
+
+var ErrRateLimited = errors.New("recipient has been sent too many messages")
+
+var (
+	_ Messaging   = (*SMS)(nil)
+	_ SMSProvider = (*StubSMSProvider)(nil)
+)
+
+type (
+	// SMSProvider hands a text message to a carrier gateway.
+	SMSProvider interface {
+		SendSMS(ctx context.Context, to, body string) error
+	}
+
+	// SMS sends messages as text messages through a provider. Recipient addresses are E.164 phone
+	// numbers, and each number is rate limited so a flapping sensor can't run up a bill.
+	SMS struct {
+		provider SMSProvider
+		limiter  *limiter.RateLimiter
+	}
+
+	// StubSMSProvider logs and keeps every message instead of sending it, for tests and local development.
+	StubSMSProvider struct {
+		mu   sync.Mutex
+		sent []SentSMS
+	}
+
+	SentSMS struct {
+		To   string
+		Body string
+	}
+)
+
+// NewSMS sends through provider. A nil rateLimiter disables rate limiting.
+func NewSMS(provider SMSProvider, rateLimiter *limiter.RateLimiter) *SMS {
+	return &SMS{
+		provider: provider,
+		limiter:  rateLimiter,
+	}
+}
+
+// Send texts msg to each recipient. Recipients over their rate limit are skipped and reported with
+// ErrRateLimited.
+func (s *SMS) Send(ctx context.Context, msg Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+
+	body := smsBody(msg)
+
+	var errs []error
+	for _, to := range msg.Addresses() {
+		if s.limiter != nil {
+			allowed, err := s.limiter.Allow(ctx, to)
+			if err != nil {
+				// an unavailable limiter shouldn't stop fault alerts going out
+				log.Printf("failed to check sms rate limit: %s", err)
+			} else if !allowed {
+				errs = append(errs, fmt.Errorf("%w: %s", ErrRateLimited, to))
+				continue
+			}
+		}
+
+		if err := s.provider.SendSMS(ctx, to, body); err != nil {
+			errs = append(errs, fmt.Errorf("failed to send sms to %s: %w", to, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// smsBody flattens a message to plain text, leading with the subject when there is one.
+func smsBody(msg Message) string {
+	body := msg.Text
+	switch {
+	case body == "":
+		body = msg.Subject
+	case msg.Subject != "":
+		body = msg.Subject + ": " + body
+	}
+
+	runes := []rune(body)
+	if len(runes) > maxSMSLength {
+		body = string(runes[:maxSMSLength-3]) + "..."
+	}
+	return body
+}
+
+func NewStubSMSProvider() *StubSMSProvider {
+	return &StubSMSProvider{}
+}
+
+func (p *StubSMSProvider) SendSMS(ctx context.Context, to, body string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sent = append(p.sent, SentSMS{To: to, Body: body})
+	log.Printf("sms to %s: %s", to, body)
+	return nil
+}
+
+// Sent returns the messages sent so far, oldest first.
+func (p *StubSMSProvider) Sent() []SentSMS {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]SentSMS(nil), p.sent...)
+}
+
+func (p *StubSMSProvider) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sent = nil
+}
@@ -0,0 +1,142 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tejiriaustin/narx_api/limiter"
+)
+
+// unavailableStore fails every call, like a limiter store that can't be reached.
+type unavailableStore struct{}
+
+func (unavailableStore) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	return 0, errors.New("store unavailable")
+}
+
+func (unavailableStore) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	return errors.New("store unavailable")
+}
+
+func (unavailableStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	return 0, errors.New("store unavailable")
+}
+
+func (unavailableStore) Reset(ctx context.Context, keys ...string) error {
+	return errors.New("store unavailable")
+}
+
+func TestSMSRateLimiting(t *testing.T) {
+	const first, second = "+2348012345678", "+33612345678"
+
+	tests := []struct {
+		name    string
+		limiter *limiter.RateLimiter
+
+		// sends are the recipients of each message, in order
+		sends [][]string
+
+		wantSent        []string
+		wantRateLimited []string
+	}{
+		{
+			name:     "within the limit",
+			limiter:  limiter.NewRateLimiter(limiter.NewMemoryStore(), "sms", 2, time.Hour),
+			sends:    [][]string{{first}, {first}},
+			wantSent: []string{first, first},
+		},
+		{
+			name:            "over the limit",
+			limiter:         limiter.NewRateLimiter(limiter.NewMemoryStore(), "sms", 2, time.Hour),
+			sends:           [][]string{{first}, {first}, {first}},
+			wantSent:        []string{first, first},
+			wantRateLimited: []string{first},
+		},
+		{
+			name:            "limited per number",
+			limiter:         limiter.NewRateLimiter(limiter.NewMemoryStore(), "sms", 1, time.Hour),
+			sends:           [][]string{{first}, {first, second}},
+			wantSent:        []string{first, second},
+			wantRateLimited: []string{first},
+		},
+		{
+			name:     "no limiter",
+			limiter:  nil,
+			sends:    [][]string{{first}, {first}, {first}},
+			wantSent: []string{first, first, first},
+		},
+		{
+			name:     "unavailable limiter lets messages through",
+			limiter:  limiter.NewRateLimiter(unavailableStore{}, "sms", 1, time.Hour),
+			sends:    [][]string{{first}, {first}},
+			wantSent: []string{first, first},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewStubSMSProvider()
+			sms := NewSMS(provider, tt.limiter)
+
+			var rateLimited []string
+			for _, recipients := range tt.sends {
+				msg := NewMessage("")
+				for _, to := range recipients {
+					msg.To = append(msg.To, Recipient{Address: to})
+				}
+				msg.Text = "Inverter fault on SN-1042"
+
+				err := sms.Send(context.Background(), msg)
+				if err != nil && !errors.Is(err, ErrRateLimited) {
+					t.Fatalf("Send() error = %v", err)
+				}
+				for _, to := range recipients {
+					if err != nil && strings.Contains(err.Error(), to) {
+						rateLimited = append(rateLimited, to)
+					}
+				}
+			}
+
+			var sent []string
+			for _, s := range provider.Sent() {
+				sent = append(sent, s.To)
+			}
+			if strings.Join(sent, ",") != strings.Join(tt.wantSent, ",") {
+				t.Errorf("sent to %v, want %v", sent, tt.wantSent)
+			}
+			if strings.Join(rateLimited, ",") != strings.Join(tt.wantRateLimited, ",") {
+				t.Errorf("rate limited %v, want %v", rateLimited, tt.wantRateLimited)
+			}
+		})
+	}
+}
+
+func TestSMSBody(t *testing.T) {
+	long := strings.Repeat("é", maxSMSLength+10)
+
+	tests := []struct {
+		name    string
+		subject string
+		text    string
+		want    string
+	}{
+		{name: "text only", text: "Your code is 482913", want: "Your code is 482913"},
+		{name: "subject only", subject: "Inverter fault", want: "Inverter fault"},
+		{name: "subject leads", subject: "Inverter fault", text: "SN-1042 stopped", want: "Inverter fault: SN-1042 stopped"},
+		{name: "truncated by character", text: long, want: strings.Repeat("é", maxSMSLength-3) + "..."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := NewMessage(tt.subject, Recipient{Address: "+2348012345678"})
+			msg.Text = tt.text
+
+			if got := smsBody(msg); got != tt.want {
+				t.Errorf("smsBody() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,36 +1,343 @@
 package messaging
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"log"
+	"net"
+	"net/mail"
 	"net/smtp"
+	"strings"
+	"sync"
+	"time"
 )
 
-var (
-	defaultAddr           = "smtp.gmail.com:587"
-	_           Messaging = (*SMTP)(nil)
+type (
+	// TLSMode is how the connection to the SMTP server is secured.
+	TLSMode string
+
+	// AuthMechanism is the SASL mechanism used to log in to the SMTP server.
+	AuthMechanism string
+)
+
+const (
+	TLSModeStartTLS TLSMode = "starttls"
+	TLSModeImplicit TLSMode = "tls"
+	TLSModeNone     TLSMode = "none"
+
+	AuthPlain   AuthMechanism = "plain"
+	AuthLogin   AuthMechanism = "login"
+	AuthCramMD5 AuthMechanism = "cram-md5"
+	AuthNone    AuthMechanism = "none"
 )
 
-type SMTP struct {
-	From string
-	To   string
-	Pass string
-	Host string
-	Port string
+var _ Messaging = (*SMTP)(nil)
+
+type (
+	SMTPConfig struct {
+		Host     string
+		Port     string
+		Username string
+		Password string
+
+		// From is the sender address, FromName its display name.
+		From     string
+		FromName string
+
+		TLSMode            TLSMode
+		InsecureSkipVerify bool
+		Auth               AuthMechanism
+
+		// DialTimeout bounds connecting and the SMTP handshake, SendTimeout a single message.
+		DialTimeout time.Duration
+		SendTimeout time.Duration
+
+		// MaxConnections caps the connections open at once. Idle ones are kept for reuse
+		// until they have been unused for IdleTimeout.
+		MaxConnections int
+		IdleTimeout    time.Duration
+	}
+
+	SMTP struct {
+		config SMTPConfig
+		slots  chan struct{}
+
+		mu   sync.Mutex
+		idle []*smtpConn
+	}
+
+	smtpConn struct {
+		conn      net.Conn
+		client    *smtp.Client
+		idleSince time.Time
+	}
+)
+
+func NewSMTP(config SMTPConfig) *SMTP {
+	if config.TLSMode == "" {
+		config.TLSMode = TLSModeStartTLS
+	}
+	if config.Auth == "" {
+		config.Auth = AuthPlain
+	}
+	if config.Username == "" {
+		config.Username = config.From
+	}
+	if config.DialTimeout <= 0 {
+		config.DialTimeout = 10 * time.Second
+	}
+	if config.SendTimeout <= 0 {
+		config.SendTimeout = 30 * time.Second
+	}
+	if config.MaxConnections <= 0 {
+		config.MaxConnections = 2
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = 30 * time.Second
+	}
+
+	return &SMTP{
+		config: config,
+		slots:  make(chan struct{}, config.MaxConnections),
+	}
 }
 
-func NewSMTP(pass, from, host, port string) *SMTP {
-	return &SMTP{Pass: pass, Host: host, From: from, Port: port}
+func (c SMTPConfig) Validate() error {
+	if c.Host == "" || c.Port == "" {
+		return errors.New("smtp host and port are required")
+	}
+	if c.From == "" {
+		return errors.New("smtp sender address is required")
+	}
+
+	switch c.TLSMode {
+	case "", TLSModeStartTLS, TLSModeImplicit, TLSModeNone:
+	default:
+		return fmt.Errorf("unknown smtp tls mode: %s", c.TLSMode)
+	}
+
+	switch c.Auth {
+	case "", AuthPlain, AuthLogin, AuthCramMD5, AuthNone:
+	default:
+		return fmt.Errorf("unknown smtp auth mechanism: %s", c.Auth)
+	}
+	return nil
 }
 
-func (s *SMTP) Push(to string, msg string) error {
-	auth := smtp.PlainAuth("", "tejiriaustin123@gmail.com", s.Pass, "smtp.gmail.com")
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+
+	body, err := BuildMIME(mail.Address{Name: s.config.FromName, Address: s.config.From}, msg)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-s.slots }()
 
-	err := smtp.SendMail(defaultAddr, auth, s.From, []string{to}, []byte(msg))
+	conn, err := s.getConn(ctx)
 	if err != nil {
 		log.Printf("smtp error: %s", err)
 		return err
 	}
 
+	err = s.send(conn, msg.Addresses(), body)
+	if err != nil {
+		log.Printf("smtp error: %s", err)
+		conn.close()
+		return err
+	}
+
+	s.putConn(conn)
 	log.Println("mail sent successfully")
 	return nil
 }
+
+// Close closes the idle connections. Connections in use are closed when their send finishes.
+func (s *SMTP) Close() error {
+	s.mu.Lock()
+	idle := s.idle
+	s.idle = nil
+	s.mu.Unlock()
+
+	for _, conn := range idle {
+		conn.close()
+	}
+	return nil
+}
+
+func (s *SMTP) send(conn *smtpConn, to []string, body []byte) error {
+	_ = conn.conn.SetDeadline(time.Now().Add(s.config.SendTimeout))
+
+	if err := conn.client.Mail(s.config.From); err != nil {
+		return err
+	}
+	for _, address := range to {
+		if err := conn.client.Rcpt(address); err != nil {
+			return err
+		}
+	}
+
+	w, err := conn.client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// getConn reuses an idle connection that the server still answers on, or dials a new one.
+func (s *SMTP) getConn(ctx context.Context) (*smtpConn, error) {
+	for {
+		s.mu.Lock()
+		if len(s.idle) == 0 {
+			s.mu.Unlock()
+			break
+		}
+		conn := s.idle[len(s.idle)-1]
+		s.idle = s.idle[:len(s.idle)-1]
+		s.mu.Unlock()
+
+		if time.Since(conn.idleSince) > s.config.IdleTimeout {
+			conn.close()
+			continue
+		}
+
+		_ = conn.conn.SetDeadline(time.Now().Add(s.config.DialTimeout))
+		if err := conn.client.Reset(); err != nil {
+			conn.close()
+			continue
+		}
+		return conn, nil
+	}
+
+	return s.dial(ctx)
+}
+
+func (s *SMTP) putConn(conn *smtpConn) {
+	conn.idleSince = time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.idle) >= s.config.MaxConnections {
+		go conn.close()
+		return
+	}
+	s.idle = append(s.idle, conn)
+}
+
+func (s *SMTP) dial(ctx context.Context) (*smtpConn, error) {
+	addr := net.JoinHostPort(s.config.Host, s.config.Port)
+	tlsConfig := &tls.Config{
+		ServerName:         s.config.Host,
+		InsecureSkipVerify: s.config.InsecureSkipVerify,
+	}
+
+	dialer := &net.Dialer{Timeout: s.config.DialTimeout}
+
+	var conn net.Conn
+	var err error
+	if s.config.TLSMode == TLSModeImplicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to smtp server %s: %w", addr, err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(s.config.DialTimeout))
+
+	client, err := smtp.NewClient(conn, s.config.Host)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	c := &smtpConn{conn: conn, client: client}
+
+	if s.config.TLSMode == TLSModeStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			c.close()
+			return nil, fmt.Errorf("smtp server %s does not support STARTTLS", addr)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			c.close()
+			return nil, err
+		}
+	}
+
+	if auth := s.auth(); auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			c.close()
+			return nil, fmt.Errorf("smtp server %s does not support authentication", addr)
+		}
+		if err := client.Auth(auth); err != nil {
+			c.close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (s *SMTP) auth() smtp.Auth {
+	switch s.config.Auth {
+	case AuthNone:
+		return nil
+	case AuthLogin:
+		return &loginAuth{username: s.config.Username, password: s.config.Password, host: s.config.Host}
+	case AuthCramMD5:
+		return smtp.CRAMMD5Auth(s.config.Username, s.config.Password)
+	default:
+		return smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)
+	}
+}
+
+func (c *smtpConn) close() {
+	_ = c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := c.client.Quit(); err != nil {
+		_ = c.client.Close()
+	}
+}
+
+// loginAuth implements the LOGIN mechanism, which some servers (notably Office 365) offer instead of PLAIN.
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// like smtp.PlainAuth, never send the password over an unencrypted connection to a remote host
+	if !server.TLS && server.Name != "localhost" && server.Name != "127.0.0.1" && server.Name != "::1" {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected smtp login challenge: %s", fromServer)
+}
@@ -0,0 +1,478 @@
+package messaging
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testSMTPUsername = "mailer@example.com"
+	testSMTPPassword = "smtp password"
+)
+
+// fakeSMTP is an SMTP server that keeps the messages it is sent. It offers STARTTLS when tlsMode is
+// TLSModeStartTLS, with TLSModeImplicit the whole connection is TLS.
+type fakeSMTP struct {
+	listener net.Listener
+	tls      *tls.Config
+	tlsMode  TLSMode
+
+	// offerAuth advertises PLAIN and LOGIN once the connection is encrypted.
+	offerAuth bool
+
+	mu          sync.Mutex
+	conns       []net.Conn
+	connections int
+	commands    []string
+	received    []receivedMail
+}
+
+type receivedMail struct {
+	from   string
+	to     []string
+	data   string
+	tls    bool
+	user   string
+	connNo int
+}
+
+func newFakeSMTP(t *testing.T, tlsMode TLSMode, offerAuth bool) *fakeSMTP {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeSMTP{
+		tls:       &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t)}},
+		tlsMode:   tlsMode,
+		offerAuth: offerAuth,
+	}
+	if tlsMode == TLSModeImplicit {
+		listener = tls.NewListener(listener, s.tls)
+	}
+	s.listener = listener
+
+	go s.serve()
+	t.Cleanup(func() {
+		_ = listener.Close()
+		s.dropConnections()
+	})
+	return s
+}
+
+func (s *fakeSMTP) port() string {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return port
+}
+
+func (s *fakeSMTP) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.connections++
+		connNo := s.connections
+		s.mu.Unlock()
+
+		go s.handle(conn, connNo)
+	}
+}
+
+// dropConnections closes every open connection, as a server restarting or timing out idle clients does.
+func (s *fakeSMTP) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeSMTP) handle(conn net.Conn, connNo int) {
+	defer conn.Close()
+
+	_, encrypted := conn.(*tls.Conn)
+	text := textproto.NewConn(conn)
+
+	var mail receivedMail
+	var user string
+
+	reply := func(format string, args ...any) bool {
+		return text.PrintfLine(format, args...) == nil
+	}
+	if !reply("220 localhost ESMTP fake") {
+		return
+	}
+
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		verb = strings.ToUpper(verb)
+
+		s.mu.Lock()
+		s.commands = append(s.commands, verb)
+		s.mu.Unlock()
+
+		switch verb {
+		case "EHLO", "HELO":
+			lines := []string{"localhost"}
+			if s.tlsMode == TLSModeStartTLS && !encrypted {
+				lines = append(lines, "STARTTLS")
+			}
+			if s.offerAuth && encrypted {
+				lines = append(lines, "AUTH PLAIN LOGIN")
+			}
+			lines = append(lines, "8BITMIME")
+			for i, l := range lines {
+				sep := "-"
+				if i == len(lines)-1 {
+					sep = " "
+				}
+				if !reply("250%s%s", sep, l) {
+					return
+				}
+			}
+		case "STARTTLS":
+			if s.tlsMode != TLSModeStartTLS || encrypted {
+				reply("502 not offered")
+				continue
+			}
+			if !reply("220 ready to start TLS") {
+				return
+			}
+			tlsConn := tls.Server(conn, s.tls)
+			if err = tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, encrypted = tlsConn, true
+			text = textproto.NewConn(conn)
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			var username, password string
+			switch strings.ToUpper(mechanism) {
+			case "PLAIN":
+				decoded, _ := base64.StdEncoding.DecodeString(initial)
+				parts := strings.Split(string(decoded), "\x00")
+				if len(parts) == 3 {
+					username, password = parts[1], parts[2]
+				}
+			case "LOGIN":
+				username = s.challenge(text, "Username:")
+				password = s.challenge(text, "Password:")
+			}
+			if username != testSMTPUsername || password != testSMTPPassword {
+				reply("535 authentication failed")
+				continue
+			}
+			user = username
+			reply("235 authenticated")
+		case "MAIL":
+			mail = receivedMail{from: envelopeAddress(arg), tls: encrypted, user: user, connNo: connNo}
+			reply("250 ok")
+		case "RCPT":
+			mail.to = append(mail.to, envelopeAddress(arg))
+			reply("250 ok")
+		case "DATA":
+			if !reply("354 go ahead") {
+				return
+			}
+			data, err := io.ReadAll(text.DotReader())
+			if err != nil {
+				return
+			}
+			mail.data = string(data)
+
+			s.mu.Lock()
+			s.received = append(s.received, mail)
+			s.mu.Unlock()
+			reply("250 queued")
+		case "RSET", "NOOP":
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 unknown command")
+		}
+	}
+}
+
+// envelopeAddress is the address in a MAIL FROM or RCPT TO argument, such as "FROM:<a@b.c> BODY=8BITMIME".
+func envelopeAddress(arg string) string {
+	_, address, _ := strings.Cut(arg, "<")
+	address, _, _ = strings.Cut(address, ">")
+	return address
+}
+
+// challenge sends a LOGIN prompt and returns the decoded answer.
+func (s *fakeSMTP) challenge(text *textproto.Conn, prompt string) string {
+	if text.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(prompt))) != nil {
+		return ""
+	}
+	line, err := text.ReadLine()
+	if err != nil {
+		return ""
+	}
+	decoded, _ := base64.StdEncoding.DecodeString(line)
+	return string(decoded)
+}
+
+func (s *fakeSMTP) messages() []receivedMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]receivedMail(nil), s.received...)
+}
+
+func (s *fakeSMTP) connectionCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections
+}
+
+func (s *fakeSMTP) sawCommand(verb string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, command := range s.commands {
+		if command == verb {
+			return true
+		}
+	}
+	return false
+}
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func newTestSMTP(server *fakeSMTP, config SMTPConfig) *SMTP {
+	config.Host = "127.0.0.1"
+	config.Port = server.port()
+	config.From = "alerts@narx.example"
+	config.InsecureSkipVerify = true
+	config.DialTimeout = 5 * time.Second
+	config.SendTimeout = 5 * time.Second
+	return NewSMTP(config)
+}
+
+func testMail(to string) Message {
+	msg := NewMessage("Inverter fault", Recipient{Name: "Ada Obi", Address: to})
+	msg.Text = "Your inverter stopped reporting."
+	return msg
+}
+
+func TestSMTPTLSModes(t *testing.T) {
+	tests := []struct {
+		name          string
+		serverTLSMode TLSMode
+		clientTLSMode TLSMode
+		wantTLS       bool
+		wantErr       string
+	}{
+		{name: "none", serverTLSMode: TLSModeNone, clientTLSMode: TLSModeNone},
+		{name: "starttls", serverTLSMode: TLSModeStartTLS, clientTLSMode: TLSModeStartTLS, wantTLS: true},
+		{name: "implicit tls", serverTLSMode: TLSModeImplicit, clientTLSMode: TLSModeImplicit, wantTLS: true},
+		{name: "starttls required but not offered", serverTLSMode: TLSModeNone, clientTLSMode: TLSModeStartTLS, wantErr: "does not support STARTTLS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeSMTP(t, tt.serverTLSMode, false)
+			mailer := newTestSMTP(server, SMTPConfig{TLSMode: tt.clientTLSMode, Auth: AuthNone})
+			defer mailer.Close()
+
+			err := mailer.Send(context.Background(), testMail("ada@example.com"))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Send() error = %v, want %q", err, tt.wantErr)
+				}
+				if got := len(server.messages()); got != 0 {
+					t.Errorf("server received %d messages, want none", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+
+			messages := server.messages()
+			if len(messages) != 1 {
+				t.Fatalf("server received %d messages, want 1", len(messages))
+			}
+			got := messages[0]
+			if got.tls != tt.wantTLS {
+				t.Errorf("sent over tls = %v, want %v", got.tls, tt.wantTLS)
+			}
+			if got.from != "alerts@narx.example" || len(got.to) != 1 || got.to[0] != "ada@example.com" {
+				t.Errorf("envelope from %s to %v, want alerts@narx.example to ada@example.com", got.from, got.to)
+			}
+			if !strings.Contains(got.data, "Subject: Inverter fault") || !strings.Contains(got.data, "Your inverter stopped reporting.") {
+				t.Errorf("data = %q, want the subject and body", got.data)
+			}
+		})
+	}
+}
+
+func TestSMTPAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		offerAuth  bool
+		auth       AuthMechanism
+		password   string
+		wantUser   string
+		wantErr    bool
+		wantNoAuth bool
+	}{
+		{name: "plain", offerAuth: true, auth: AuthPlain, password: testSMTPPassword, wantUser: testSMTPUsername},
+		{name: "login", offerAuth: true, auth: AuthLogin, password: testSMTPPassword, wantUser: testSMTPUsername},
+		{name: "wrong password", offerAuth: true, auth: AuthPlain, password: "wrong", wantErr: true},
+		{name: "server without auth", auth: AuthPlain, password: testSMTPPassword, wantErr: true},
+		{name: "auth turned off", offerAuth: true, auth: AuthNone, wantNoAuth: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeSMTP(t, TLSModeStartTLS, tt.offerAuth)
+			mailer := newTestSMTP(server, SMTPConfig{
+				TLSMode:  TLSModeStartTLS,
+				Auth:     tt.auth,
+				Username: testSMTPUsername,
+				Password: tt.password,
+			})
+			defer mailer.Close()
+
+			err := mailer.Send(context.Background(), testMail("ada@example.com"))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Send() succeeded, want an authentication error")
+				}
+				if got := len(server.messages()); got != 0 {
+					t.Errorf("server received %d messages, want none", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+
+			messages := server.messages()
+			if len(messages) != 1 {
+				t.Fatalf("server received %d messages, want 1", len(messages))
+			}
+			if messages[0].user != tt.wantUser {
+				t.Errorf("sent as %q, want %q", messages[0].user, tt.wantUser)
+			}
+			if tt.wantNoAuth && server.sawCommand("AUTH") {
+				t.Error("client authenticated with auth turned off")
+			}
+		})
+	}
+}
+
+func TestSMTPReusesConnections(t *testing.T) {
+	server := newFakeSMTP(t, TLSModeStartTLS, true)
+	mailer := newTestSMTP(server, SMTPConfig{Username: testSMTPUsername, Password: testSMTPPassword})
+
+	for _, to := range []string{"ada@example.com", "obi@example.com", "chidi@example.com"} {
+		if err := mailer.Send(context.Background(), testMail(to)); err != nil {
+			t.Fatalf("Send() to %s error = %v", to, err)
+		}
+	}
+
+	if got := server.connectionCount(); got != 1 {
+		t.Errorf("opened %d connections, want 1", got)
+	}
+	messages := server.messages()
+	if len(messages) != 3 {
+		t.Fatalf("server received %d messages, want 3", len(messages))
+	}
+	for _, msg := range messages {
+		if !msg.tls || msg.user != testSMTPUsername {
+			t.Errorf("message to %v sent over tls %v as %q, want the authenticated tls connection", msg.to, msg.tls, msg.user)
+		}
+	}
+	// the connection is checked with RSET before it is reused
+	if !server.sawCommand("RSET") {
+		t.Error("pooled connection was reused without a RSET")
+	}
+
+	_ = mailer.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for !server.sawCommand("QUIT") {
+		if time.Now().After(deadline) {
+			t.Fatal("Close() didn't QUIT the idle connection")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSMTPRecoversFromDroppedConnection(t *testing.T) {
+	server := newFakeSMTP(t, TLSModeStartTLS, true)
+	mailer := newTestSMTP(server, SMTPConfig{Username: testSMTPUsername, Password: testSMTPPassword})
+	defer mailer.Close()
+
+	if err := mailer.Send(context.Background(), testMail("ada@example.com")); err != nil {
+		t.Fatalf("first Send() error = %v", err)
+	}
+
+	// the server drops the pooled connection while it is idle
+	server.dropConnections()
+
+	if err := mailer.Send(context.Background(), testMail("obi@example.com")); err != nil {
+		t.Fatalf("Send() after the connection was dropped error = %v", err)
+	}
+
+	if got := server.connectionCount(); got != 2 {
+		t.Errorf("opened %d connections, want a second after the first was dropped", got)
+	}
+	messages := server.messages()
+	if len(messages) != 2 {
+		t.Fatalf("server received %d messages, want 2", len(messages))
+	}
+	if messages[1].connNo != 2 || !messages[1].tls || messages[1].user != testSMTPUsername {
+		t.Errorf("second message sent on connection %d over tls %v as %q, want a fresh authenticated tls connection", messages[1].connNo, messages[1].tls, messages[1].user)
+	}
+}
@@ -0,0 +1,71 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const twilioApiUrl = "https://api.twilio.com"
+
+var _ SMSProvider = (*TwilioProvider)(nil)
+
+// TwilioProvider sends text messages through Twilio's messages API.
+type TwilioProvider struct {
+	accountSid string
+	authToken  string
+	from       string
+	baseUrl    string
+	client     *http.Client
+}
+
+func NewTwilioProvider(accountSid, authToken, from string) *TwilioProvider {
+	return &TwilioProvider{
+		accountSid: accountSid,
+		authToken:  authToken,
+		from:       from,
+		baseUrl:    twilioApiUrl,
+		client:     &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (t *TwilioProvider) SendSMS(ctx context.Context, to, body string) error {
+	form := url.Values{
+		"To":   {to},
+		"From": {t.from},
+		"Body": {body},
+	}
+
+	endpoint := t.baseUrl + "/2010-04-01/Accounts/" + url.PathEscape(t.accountSid) + "/Messages.json"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(t.accountSid, t.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	var apiErr struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr)
+
+	return fmt.Errorf("twilio responded %d: %s (code %d)", resp.StatusCode, apiErr.Message, apiErr.Code)
+}
@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/constants"
+)
+
+// ReadRequestInfo keeps the caller's ip address and user agent in context for the audit log.
+func ReadRequestInfo() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(string(constants.ContextKeyRequestInfo), audit.RequestInfo{
+			IpAddress: ctx.ClientIP(),
+			UserAgent: ctx.Request.UserAgent(),
+		})
+	}
+}
@@ -12,26 +12,26 @@ import (
 
 func ReadPaginationOptions() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		params := ctx.Params
-
-		if page, _ := params.Get("page"); page != "" {
+		if page := ctx.Query("page"); page != "" {
 			pageNum, err := strconv.ParseInt(page, 10, 64)
 			if err != nil {
 				response.FormatResponse(ctx, http.StatusBadRequest, "page number must be a number", nil)
+				ctx.Abort()
 				return
 			}
 
 			ctx.Set(string(constants.ContextKeyPageNumber), pageNum)
 		}
 
-		if perPage, _ := params.Get("per_page"); perPage != "" {
+		if perPage := ctx.Query("per_page"); perPage != "" {
 			perPageNum, err := strconv.ParseInt(perPage, 10, 64)
 			if err != nil {
 				response.FormatResponse(ctx, http.StatusBadRequest, "per page number must be a number", nil)
+				ctx.Abort()
 				return
 			}
 
-			ctx.Set(string(constants.ContextKeyPageNumber), perPageNum)
+			ctx.Set(string(constants.ContextKeyPerPageLimit), perPageNum)
 		}
 
 	}
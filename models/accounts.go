@@ -1,6 +1,10 @@
 package models
 
-import "strings"
+import (
+	"regexp"
+	"strings"
+	"time"
+)
 
 type (
 	Status string // Status of an account type
@@ -9,8 +13,9 @@ type (
 )
 
 const (
-	ActiveStatus    Status = "ACTIVE"
-	SuspendedStatus Status = "SUSPENDED"
+	ActiveStatus              Status = "ACTIVE"
+	SuspendedStatus           Status = "SUSPENDED"
+	PendingVerificationStatus Status = "PENDING_VERIFICATION"
 
 	AdminAccountKind    Kind = "SLABMARK.ACCOUNT.KIND.ADMIN"
 	EmployeeAccountKind Kind = "SLABMARK.ACCOUNT.KIND.EMPLOYEE"
@@ -25,7 +30,11 @@ var (
 	FieldAccountFirstName  = "first_name"
 	FieldAccountLastName   = "last_name"
 	FieldAccountDepartment = "department"
-	FieldAccountInfoId     = "account_info.id"
+	FieldAccountInfoId     = "account_info._id"
+	FieldAccountStatus     = "status"
+	FieldAccountIdentities = "identities"
+	FieldAccountKind       = "kind"
+	FieldAccountLocale     = "locale"
 )
 
 type (
@@ -41,8 +50,80 @@ type (
 		FullName  string `json:"full_name" bson:"full_name"`
 		Email     string `json:"email" bson:"email"`
 		Status    Status `json:"status" bson:"status"`
-		Password  string `json:"password" bson:"password"`
+		Kind      Kind   `json:"kind" bson:"kind,omitempty"`
+		Password  string `json:"-" bson:"password"`
 		Token     string `json:"token" bson:"-"`
+
+		// Locale is the language emails are sent in, as a tag like "fr" or "en-GB".
+		Locale string `json:"locale" bson:"locale,omitempty"`
+
+		// PendingEmail is the address the account is changing to, until it has been verified.
+		PendingEmail string `json:"pending_email" bson:"pending_email,omitempty"`
+
+		// Phone is a verified E.164 number SMS alerts are sent to. A number being verified is held in
+		// PhoneVerification until its code has been confirmed.
+		Phone             string             `json:"phone" bson:"phone,omitempty"`
+		PhoneVerifiedAt   *time.Time         `json:"phone_verified_at" bson:"phone_verified_at,omitempty"`
+		PhoneVerification *PhoneVerification `json:"-" bson:"phone_verification,omitempty"`
+
+		// SessionVersion is embedded in every issued token, bumping it revokes all existing sessions.
+		SessionVersion int            `json:"-" bson:"session_version"`
+		PasswordReset  *PasswordReset `json:"-" bson:"password_reset,omitempty"`
+		TwoFactor      *TwoFactor     `json:"-" bson:"two_factor,omitempty"`
+
+		OrganisationId string             `json:"organisation_id" bson:"organisation_id,omitempty"`
+		Identities     []ExternalIdentity `json:"identities" bson:"identities,omitempty"`
+
+		Suspension *Suspension `json:"suspension" bson:"suspension,omitempty"`
+
+		// ImpersonatedBy is set on accounts loaded from an impersonation session, it is never stored.
+		ImpersonatedBy string `json:"-" bson:"-"`
+
+		// SsoAuthenticatedAt is set on accounts loaded from a single sign-on session to when the provider
+		// authenticated the user, it is never stored.
+		SsoAuthenticatedAt *time.Time `json:"-" bson:"-"`
+
+		// ChallengeToken is returned instead of Token when a second factor is still required to log in.
+		ChallengeToken string `json:"challenge_token" bson:"-"`
+	}
+
+	Suspension struct {
+		Reason      string      `json:"reason" bson:"reason"`
+		SuspendedAt time.Time   `json:"suspended_at" bson:"suspended_at"`
+		SuspendedBy AccountInfo `json:"suspended_by" bson:"suspended_by"`
+	}
+
+	// ExternalIdentity links an account to a user at an external OpenID Connect provider.
+	ExternalIdentity struct {
+		Issuer  string `json:"issuer" bson:"issuer"`
+		Subject string `json:"subject" bson:"subject"`
+	}
+
+	// TwoFactor holds an account's TOTP enrolment. PendingSecret is set between enrolment and confirmation.
+	TwoFactor struct {
+		Enabled       bool     `json:"enabled" bson:"enabled"`
+		Secret        string   `json:"-" bson:"secret"`
+		PendingSecret string   `json:"-" bson:"pending_secret"`
+		LastUsedStep  int64    `json:"-" bson:"last_used_step"`
+		RecoveryCodes []string `json:"-" bson:"recovery_codes"`
+	}
+
+	// PasswordReset holds a pending password reset code. Only the hash of the code is stored.
+	// Attempts counts wrong codes across every code issued since the last successful reset, so asking
+	// for a new code doesn't buy more guesses. Once they run out resets are locked until LockedUntil.
+	PasswordReset struct {
+		CodeHash    string     `json:"-" bson:"code_hash"`
+		ExpiresAt   time.Time  `json:"expires_at" bson:"expires_at"`
+		Attempts    int        `json:"attempts" bson:"attempts"`
+		LockedUntil *time.Time `json:"locked_until" bson:"locked_until,omitempty"`
+	}
+
+	// PhoneVerification holds the code texted to a number the account is adding. Only the hash of the code is stored.
+	PhoneVerification struct {
+		Phone     string    `json:"phone" bson:"phone"`
+		CodeHash  string    `json:"-" bson:"code_hash"`
+		ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+		Attempts  int       `json:"attempts" bson:"attempts"`
 	}
 )
 
@@ -65,6 +146,56 @@ func (a Account) GetFullName() string {
 	return a.FirstName + " " + a.LastName
 }
 
+func (a Account) GetAccountInfo() AccountInfo {
+	return AccountInfo{
+		Id:        a.ID.Hex(),
+		FirstName: a.FirstName,
+		LastName:  a.LastName,
+		FullName:  a.FullName,
+		Email:     a.Email,
+	}
+}
+
+func (a Account) IsVerified() bool {
+	return a.Status != PendingVerificationStatus
+}
+
+func (a Account) IsAdmin() bool {
+	return a.Kind == AdminAccountKind
+}
+
+func (a Account) IsSuspended() bool {
+	return a.Status == SuspendedStatus
+}
+
+// PasswordResetLocked reports whether password resets are locked after too many wrong codes.
+func (a Account) PasswordResetLocked(now time.Time) bool {
+	return a.PasswordReset != nil && a.PasswordReset.LockedUntil != nil && now.Before(*a.PasswordReset.LockedUntil)
+}
+
+func (a Account) HasTwoFactor() bool {
+	return a.TwoFactor != nil && a.TwoFactor.Enabled
+}
+
+// HasVerifiedPhone reports whether SMS can be sent to the account.
+func (a Account) HasVerifiedPhone() bool {
+	return a.Phone != "" && a.PhoneVerifiedAt != nil
+}
+
 func (a Account) GetUsername() string {
 	return string(a.FirstName[0]) + strings.ToLower(a.LastName)
 }
+
+var localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}([-_][a-zA-Z0-9]{2,8})*$`)
+
+// IsValidLocale reports whether locale looks like a language tag, eg. "fr" or "en-GB".
+func IsValidLocale(locale string) bool {
+	return localePattern.MatchString(locale)
+}
+
+var phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{7,14}$`)
+
+// IsValidPhoneNumber reports whether phone is an E.164 number, eg. "+2348012345678".
+func IsValidPhoneNumber(phone string) bool {
+	return phonePattern.MatchString(phone)
+}
@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+type ApiKeyPermission string // Permission granted to an api key
+
+const (
+	PermissionReadReadings  ApiKeyPermission = "readings:read"
+	PermissionIngest        ApiKeyPermission = "readings:ingest"
+	PermissionManageSensors ApiKeyPermission = "sensors:manage"
+	PermissionManageDevices ApiKeyPermission = "devices:manage"
+)
+
+var (
+	FieldApiKeyHash           = "key_hash"
+	FieldApiKeyLastUsedAt     = "last_used_at"
+	FieldApiKeyRevokedAt      = "revoked_at"
+	FieldApiKeyOrganisationId = "organisation_id"
+)
+
+type ApiKey struct {
+	Shared      `bson:",inline"`
+	AccountInfo AccountInfo        `json:"accountInfo" bson:"account_info"`
+	Name        string             `json:"name" bson:"name"`
+	Prefix      string             `json:"prefix" bson:"prefix"`
+	KeyHash     string             `json:"-" bson:"key_hash"`
+	Permissions []ApiKeyPermission `json:"permissions" bson:"permissions"`
+	LastUsedAt  *time.Time         `json:"last_used_at" bson:"last_used_at"`
+	ExpiresAt   *time.Time         `json:"expires_at" bson:"expires_at"`
+	RevokedAt   *time.Time         `json:"revoked_at" bson:"revoked_at"`
+
+	// OrganisationId is set on service keys owned by an organisation, which any of its admins can
+	// manage. They act as the admin that created them for as long as that admin is in the organisation.
+	OrganisationId string `json:"organisationId" bson:"organisation_id,omitempty"`
+
+	// Key is only populated when the key is created, it is never stored.
+	Key string `json:"key" bson:"-"`
+}
+
+func IsValidApiKeyPermission(p ApiKeyPermission) bool {
+	switch p {
+	case PermissionReadReadings, PermissionIngest, PermissionManageSensors, PermissionManageDevices:
+		return true
+	}
+	return false
+}
+
+func (k ApiKey) IsActive(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+func (k ApiKey) HasPermission(p ApiKeyPermission) bool {
+	for _, permission := range k.Permissions {
+		if permission == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApiKeyIsActive(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	earlier, later := now.Add(-time.Minute), now.Add(time.Minute)
+
+	tests := []struct {
+		name string
+		key  ApiKey
+		want bool
+	}{
+		{name: "no expiry", key: ApiKey{}, want: true},
+		{name: "before expiry", key: ApiKey{ExpiresAt: &later}, want: true},
+		{name: "at expiry", key: ApiKey{ExpiresAt: &now}},
+		{name: "expired", key: ApiKey{ExpiresAt: &earlier}},
+		{name: "revoked", key: ApiKey{RevokedAt: &earlier}},
+		{name: "revoked before expiry", key: ApiKey{ExpiresAt: &later, RevokedAt: &earlier}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.key.IsActive(now); got != tt.want {
+				t.Errorf("IsActive() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApiKeyHasPermission(t *testing.T) {
+	key := ApiKey{Permissions: []ApiKeyPermission{PermissionReadReadings, PermissionIngest}}
+
+	tests := []struct {
+		permission ApiKeyPermission
+		want       bool
+	}{
+		{permission: PermissionReadReadings, want: true},
+		{permission: PermissionIngest, want: true},
+		{permission: PermissionManageSensors},
+		{permission: PermissionManageDevices},
+	}
+
+	for _, tt := range tests {
+		if got := key.HasPermission(tt.permission); got != tt.want {
+			t.Errorf("HasPermission(%s) = %v, want %v", tt.permission, got, tt.want)
+		}
+	}
+}
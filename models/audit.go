@@ -0,0 +1,29 @@
+package models
+
+const (
+	FieldAuditActorId    = "actor._id"
+	FieldAuditAction     = "action"
+	FieldAuditTargetType = "target_type"
+	FieldAuditTargetId   = "target_id"
+)
+
+// AuditEntry is a single record in the append-only audit log.
+type AuditEntry struct {
+	Shared         `bson:",inline"`
+	Actor          AccountInfo       `json:"actor" bson:"actor"`
+	ImpersonatedBy string            `json:"impersonated_by,omitempty" bson:"impersonated_by,omitempty"`
+	Action         string            `json:"action" bson:"action"`
+	TargetType     string            `json:"target_type" bson:"target_type"`
+	TargetId       string            `json:"target_id" bson:"target_id"`
+	Changes        []AuditChange     `json:"changes,omitempty" bson:"changes,omitempty"`
+	Metadata       map[string]string `json:"metadata,omitempty" bson:"metadata,omitempty"`
+	IpAddress      string            `json:"ip_address" bson:"ip_address"`
+	UserAgent      string            `json:"user_agent" bson:"user_agent"`
+}
+
+// AuditChange is the before and after value of a single field, nested fields use dotted paths.
+type AuditChange struct {
+	Field  string      `json:"field" bson:"field"`
+	Before interface{} `json:"before" bson:"before"`
+	After  interface{} `json:"after" bson:"after"`
+}
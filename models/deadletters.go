@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+var (
+	FieldDeadLetterEventId    = "event_id"
+	FieldDeadLetterEventKey   = "event_key"
+	FieldDeadLetterGroup      = "group"
+	FieldDeadLetterRequeuedAt = "requeued_at"
+)
+
+// DeadLetter is a copy of an event a consumer group gave up on after running out of attempts, kept
+// so it can be inspected and requeued once whatever broke it is fixed.
+type DeadLetter struct {
+	Shared    `bson:",inline"`
+	EventId   string                 `json:"eventId" bson:"event_id"`
+	EventKey  string                 `json:"eventKey" bson:"event_key"`
+	EventKind string                 `json:"eventKind" bson:"event_kind"`
+	Group     string                 `json:"group" bson:"group"`
+	MsgBody   map[string]interface{} `json:"msgBody" bson:"msg_body"`
+	Attempts  int                    `json:"attempts" bson:"attempts"`
+	LastError string                 `json:"lastError" bson:"last_error"`
+
+	RequeuedAt *time.Time   `json:"requeuedAt" bson:"requeued_at,omitempty"`
+	RequeuedBy *AccountInfo `json:"requeuedBy" bson:"requeued_by,omitempty"`
+}
+
+func (d DeadLetter) IsRequeued() bool {
+	return d.RequeuedAt != nil
+}
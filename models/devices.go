@@ -1,7 +1,34 @@
 package models
 
+import "time"
+
+type DevicePlatform string
+
+const (
+	PlatformIOS     DevicePlatform = "ios"
+	PlatformAndroid DevicePlatform = "android"
+	PlatformWeb     DevicePlatform = "web"
+)
+
+const (
+	FieldDeviceToken      = "device_token"
+	FieldDeviceLastSeenAt = "last_seen_at"
+)
+
 type Devices struct {
 	Shared      `bson:",inline"`
-	AccountInfo AccountInfo `json:"accountInfo" bson:"account_info"`
-	DeviceToken string      `json:"deviceToken" bson:"device_token"`
+	AccountInfo AccountInfo    `json:"accountInfo" bson:"account_info"`
+	DeviceToken string         `json:"deviceToken" bson:"device_token"`
+	Platform    DevicePlatform `json:"platform" bson:"platform,omitempty"`
+	AppVersion  string         `json:"appVersion" bson:"app_version,omitempty"`
+	Locale      string         `json:"locale" bson:"locale,omitempty"`
+	LastSeenAt  *time.Time     `json:"lastSeenAt" bson:"last_seen_at,omitempty"`
+}
+
+func IsValidDevicePlatform(p DevicePlatform) bool {
+	switch p {
+	case PlatformIOS, PlatformAndroid, PlatformWeb:
+		return true
+	}
+	return false
 }
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Impersonation records a support admin signing in as another account.
+type Impersonation struct {
+	Shared    `bson:",inline"`
+	Admin     AccountInfo `json:"admin" bson:"admin"`
+	Target    AccountInfo `json:"target" bson:"target"`
+	Reason    string      `json:"reason" bson:"reason"`
+	IpAddress string      `json:"ip_address" bson:"ip_address"`
+	ExpiresAt time.Time   `json:"expires_at" bson:"expires_at"`
+}
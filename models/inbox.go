@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+var (
+	FieldInboxEventId   = "event_id"
+	FieldInboxEventType = "event_type"
+	FieldInboxReadAt    = "read_at"
+	FieldInboxDelivered = "delivered"
+)
+
+// InboxNotification is a user facing notification kept so the app can show a history.
+type InboxNotification struct {
+	Shared      `bson:",inline"`
+	AccountInfo AccountInfo           `json:"accountInfo" bson:"account_info"`
+	EventId     string                `json:"eventId" bson:"event_id"`
+	EventType   NotificationEventType `json:"eventType" bson:"event_type"`
+	Severity    Severity              `json:"severity" bson:"severity"`
+	Title       string                `json:"title" bson:"title"`
+	Body        string                `json:"body" bson:"body"`
+	Data        map[string]string     `json:"data,omitempty" bson:"data,omitempty"`
+	ReadAt      *time.Time            `json:"readAt" bson:"read_at"`
+
+	// Delivered lists the channels the notification has gone out on, so a retry only sends the rest.
+	Delivered []NotificationChannel `json:"-" bson:"delivered,omitempty"`
+}
+
+func (n InboxNotification) IsRead() bool {
+	return n.ReadAt != nil
+}
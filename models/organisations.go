@@ -0,0 +1,47 @@
+package models
+
+import "strings"
+
+var (
+	FieldOrganisationSlug = "slug"
+)
+
+type (
+	Organisation struct {
+		Shared `bson:",inline"`
+		Name   string      `json:"name" bson:"name"`
+		Slug   string      `json:"slug" bson:"slug"`
+		OIDC   *OIDCConfig `json:"oidc" bson:"oidc,omitempty"`
+	}
+
+	// OIDCConfig is an organisation's corporate identity provider.
+	OIDCConfig struct {
+		Enabled      bool     `json:"enabled" bson:"enabled"`
+		Issuer       string   `json:"issuer" bson:"issuer"`
+		ClientId     string   `json:"client_id" bson:"client_id"`
+		ClientSecret string   `json:"-" bson:"client_secret"`
+		Scopes       []string `json:"scopes" bson:"scopes"`
+
+		// AllowJitProvisioning creates accounts for identities signing in for the first time.
+		AllowJitProvisioning bool `json:"allow_jit_provisioning" bson:"allow_jit_provisioning"`
+
+		// VerifiedDomains are the email domains the organisation has proven it owns. Existing accounts
+		// on them that aren't in another organisation are linked on their first sign in.
+		VerifiedDomains []string `json:"verified_domains" bson:"verified_domains,omitempty"`
+	}
+)
+
+// IsVerifiedDomain reports whether email is on one of the organisation's verified domains.
+func (c OIDCConfig) IsVerifiedDomain(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := email[at+1:]
+	for _, verified := range c.VerifiedDomains {
+		if strings.EqualFold(domain, verified) {
+			return true
+		}
+	}
+	return false
+}
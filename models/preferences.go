@@ -0,0 +1,268 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type NotificationChannel string // Channel a notification is delivered on
+
+const (
+	ChannelPush    NotificationChannel = "push"
+	ChannelEmail   NotificationChannel = "email"
+	ChannelSMS     NotificationChannel = "sms"
+	ChannelWebhook NotificationChannel = "webhook"
+)
+
+type NotificationEventType string // Kind of event a user can be notified about
+
+const (
+	EventTypeFault           NotificationEventType = "fault"
+	EventTypeAlert           NotificationEventType = "alert"
+	EventTypeSensorOffline   NotificationEventType = "sensor_offline"
+	EventTypeTicketAssigned  NotificationEventType = "ticket_assigned"
+	EventTypeAccountSecurity NotificationEventType = "account_security"
+)
+
+type DigestFrequency string // How often a performance digest is emailed
+
+const (
+	DigestOff    DigestFrequency = ""
+	DigestDaily  DigestFrequency = "daily"
+	DigestWeekly DigestFrequency = "weekly"
+)
+
+var (
+	FieldPreferencesDigest           = "digest"
+	FieldPreferencesLastDigestPeriod = "last_digest_period"
+)
+
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+var severityRank = map[Severity]int{
+	SeverityInfo:     0,
+	SeverityWarning:  1,
+	SeverityCritical: 2,
+}
+
+type (
+	NotificationPreferences struct {
+		Shared      `bson:",inline"`
+		AccountInfo AccountInfo        `json:"accountInfo" bson:"account_info"`
+		Rules       []NotificationRule `json:"rules" bson:"rules"`
+		Timezone    string             `json:"timezone" bson:"timezone"`
+		QuietHours  *QuietHours        `json:"quietHours" bson:"quiet_hours,omitempty"`
+
+		// CriticalOnly opts out of everything that isn't critical.
+		CriticalOnly bool `json:"criticalOnly" bson:"critical_only"`
+
+		// Digest is how often a summary of the account's sites is emailed, in its timezone.
+		Digest DigestFrequency `json:"digest" bson:"digest,omitempty"`
+
+		// LastDigestPeriod identifies the last period a digest was sent for, so each is only sent once.
+		LastDigestPeriod string `json:"-" bson:"last_digest_period,omitempty"`
+	}
+
+	// NotificationRule picks the channels for an event type, for events at or above MinSeverity.
+	NotificationRule struct {
+		EventType   NotificationEventType `json:"eventType" bson:"event_type"`
+		MinSeverity Severity              `json:"minSeverity" bson:"min_severity"`
+		Channels    []NotificationChannel `json:"channels" bson:"channels"`
+	}
+
+	// QuietHours is a daily window, in the account's timezone, during which only critical
+	// notifications are pushed. Start and End are "15:04" times and the window may cross midnight.
+	QuietHours struct {
+		Start string `json:"start" bson:"start"`
+		End   string `json:"end" bson:"end"`
+	}
+)
+
+// DefaultNotificationPreferences is used for accounts that haven't saved any preferences.
+func DefaultNotificationPreferences(account AccountInfo) NotificationPreferences {
+	rules := make([]NotificationRule, 0, len(notificationEventTypes))
+	for _, eventType := range notificationEventTypes {
+		rules = append(rules, NotificationRule{
+			EventType:   eventType,
+			MinSeverity: SeverityInfo,
+			Channels:    []NotificationChannel{ChannelPush, ChannelEmail, ChannelWebhook},
+		})
+	}
+
+	return NotificationPreferences{
+		AccountInfo: account,
+		Rules:       rules,
+		Timezone:    "UTC",
+	}
+}
+
+var notificationEventTypes = []NotificationEventType{
+	EventTypeFault,
+	EventTypeAlert,
+	EventTypeSensorOffline,
+	EventTypeTicketAssigned,
+	EventTypeAccountSecurity,
+}
+
+func IsValidNotificationEventType(t NotificationEventType) bool {
+	for _, eventType := range notificationEventTypes {
+		if eventType == t {
+			return true
+		}
+	}
+	return false
+}
+
+func IsValidNotificationChannel(c NotificationChannel) bool {
+	switch c {
+	case ChannelPush, ChannelEmail, ChannelSMS, ChannelWebhook:
+		return true
+	}
+	return false
+}
+
+func IsValidDigestFrequency(f DigestFrequency) bool {
+	switch f {
+	case DigestOff, DigestDaily, DigestWeekly:
+		return true
+	}
+	return false
+}
+
+func IsValidSeverity(s Severity) bool {
+	_, ok := severityRank[s]
+	return ok
+}
+
+// AtLeast reports whether s is as severe as other.
+func (s Severity) AtLeast(other Severity) bool {
+	return severityRank[s] >= severityRank[other]
+}
+
+func (p NotificationPreferences) Validate() error {
+	if _, err := time.LoadLocation(p.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone: %s", p.Timezone)
+	}
+
+	if p.QuietHours != nil {
+		if _, err := time.Parse("15:04", p.QuietHours.Start); err != nil {
+			return errors.New("quiet hours start must be a time like 22:00")
+		}
+		if _, err := time.Parse("15:04", p.QuietHours.End); err != nil {
+			return errors.New("quiet hours end must be a time like 07:00")
+		}
+	}
+
+	if !IsValidDigestFrequency(p.Digest) {
+		return fmt.Errorf("digest must be daily, weekly or empty, got %s", p.Digest)
+	}
+
+	seen := map[NotificationEventType]bool{}
+	for _, rule := range p.Rules {
+		if !IsValidNotificationEventType(rule.EventType) {
+			return fmt.Errorf("unknown event type: %s", rule.EventType)
+		}
+		if seen[rule.EventType] {
+			return fmt.Errorf("event type %s is listed more than once", rule.EventType)
+		}
+		seen[rule.EventType] = true
+
+		if !IsValidSeverity(rule.MinSeverity) {
+			return fmt.Errorf("unknown severity: %s", rule.MinSeverity)
+		}
+		for _, channel := range rule.Channels {
+			if !IsValidNotificationChannel(channel) {
+				return fmt.Errorf("unknown channel: %s", channel)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ChannelsFor returns the channels an event should be delivered on at the given time.
+// Account security messages are always emailed, whatever the preferences say.
+func (p NotificationPreferences) ChannelsFor(eventType NotificationEventType, severity Severity, now time.Time) []NotificationChannel {
+	var channels []NotificationChannel
+
+	for _, rule := range p.Rules {
+		if rule.EventType == eventType && severity.AtLeast(rule.MinSeverity) {
+			channels = append(channels, rule.Channels...)
+		}
+	}
+
+	if eventType == EventTypeAccountSecurity {
+		if !HasNotificationChannel(channels, ChannelEmail) {
+			channels = append(channels, ChannelEmail)
+		}
+		return channels
+	}
+
+	if severity == SeverityCritical {
+		return channels
+	}
+
+	if p.CriticalOnly {
+		return nil
+	}
+
+	// quiet hours only hold back channels that interrupt the user
+	if p.InQuietHours(now) {
+		allowed := channels[:0:0]
+		for _, channel := range channels {
+			if channel != ChannelPush && channel != ChannelSMS {
+				allowed = append(allowed, channel)
+			}
+		}
+		channels = allowed
+	}
+
+	return channels
+}
+
+// InQuietHours reports whether now falls in the account's quiet hours.
+func (p NotificationPreferences) InQuietHours(now time.Time) bool {
+	if p.QuietHours == nil {
+		return false
+	}
+
+	location, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+
+	start, err := time.Parse("15:04", p.QuietHours.Start)
+	if err != nil {
+		return false
+	}
+	end, err := time.Parse("15:04", p.QuietHours.End)
+	if err != nil {
+		return false
+	}
+
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+
+	if startMinute <= endMinute {
+		return minute >= startMinute && minute < endMinute
+	}
+	return minute >= startMinute || minute < endMinute
+}
+
+func HasNotificationChannel(channels []NotificationChannel, channel NotificationChannel) bool {
+	for _, c := range channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
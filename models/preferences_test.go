@@ -0,0 +1,110 @@
+package models
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestInQuietHours(t *testing.T) {
+	tests := []struct {
+		name       string
+		timezone   string
+		quietHours *QuietHours
+		now        time.Time
+		want       bool
+	}{
+		{name: "none set", timezone: "UTC", now: time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC)},
+		{name: "within a daytime window", timezone: "UTC", quietHours: &QuietHours{Start: "12:00", End: "14:00"}, now: time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC), want: true},
+		{name: "after a daytime window", timezone: "UTC", quietHours: &QuietHours{Start: "12:00", End: "14:00"}, now: time.Date(2024, 3, 1, 14, 0, 0, 0, time.UTC)},
+		{name: "before midnight in a window crossing it", timezone: "UTC", quietHours: &QuietHours{Start: "22:00", End: "07:00"}, now: time.Date(2024, 3, 1, 23, 30, 0, 0, time.UTC), want: true},
+		{name: "after midnight in a window crossing it", timezone: "UTC", quietHours: &QuietHours{Start: "22:00", End: "07:00"}, now: time.Date(2024, 3, 2, 6, 59, 0, 0, time.UTC), want: true},
+		{name: "outside a window crossing midnight", timezone: "UTC", quietHours: &QuietHours{Start: "22:00", End: "07:00"}, now: time.Date(2024, 3, 2, 7, 0, 0, 0, time.UTC)},
+		{name: "in the account's timezone", timezone: "Africa/Lagos", quietHours: &QuietHours{Start: "22:00", End: "07:00"}, now: time.Date(2024, 3, 1, 21, 30, 0, 0, time.UTC), want: true},
+		{name: "unknown timezone falls back to utc", timezone: "Mars/Olympus", quietHours: &QuietHours{Start: "22:00", End: "07:00"}, now: time.Date(2024, 3, 1, 21, 30, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NotificationPreferences{Timezone: tt.timezone, QuietHours: tt.quietHours}
+			if got := p.InQuietHours(tt.now); got != tt.want {
+				t.Errorf("InQuietHours(%s) = %v, want %v", tt.now, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChannelsFor(t *testing.T) {
+	night := time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC)
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	preferences := NotificationPreferences{
+		Timezone:   "UTC",
+		QuietHours: &QuietHours{Start: "22:00", End: "07:00"},
+		Rules: []NotificationRule{
+			{EventType: EventTypeFault, MinSeverity: SeverityInfo, Channels: []NotificationChannel{ChannelPush, ChannelEmail, ChannelWebhook}},
+			{EventType: EventTypeAlert, MinSeverity: SeverityWarning, Channels: []NotificationChannel{ChannelSMS}},
+			{EventType: EventTypeAccountSecurity, MinSeverity: SeverityInfo, Channels: []NotificationChannel{ChannelPush}},
+		},
+	}
+	criticalOnly := preferences
+	criticalOnly.CriticalOnly = true
+
+	tests := []struct {
+		name        string
+		preferences NotificationPreferences
+		eventType   NotificationEventType
+		severity    Severity
+		now         time.Time
+		want        []NotificationChannel
+	}{
+		{name: "chosen channels", preferences: preferences, eventType: EventTypeFault, severity: SeverityWarning, now: day, want: []NotificationChannel{ChannelPush, ChannelEmail, ChannelWebhook}},
+		{name: "below the minimum severity", preferences: preferences, eventType: EventTypeAlert, severity: SeverityInfo, now: day},
+		{name: "no rule for the event type", preferences: preferences, eventType: EventTypeTicketAssigned, severity: SeverityWarning, now: day},
+		{name: "quiet hours hold back push but not email or webhooks", preferences: preferences, eventType: EventTypeFault, severity: SeverityWarning, now: night, want: []NotificationChannel{ChannelEmail, ChannelWebhook}},
+		{name: "quiet hours hold back sms", preferences: preferences, eventType: EventTypeAlert, severity: SeverityWarning, now: night},
+		{name: "critical bypasses quiet hours", preferences: preferences, eventType: EventTypeFault, severity: SeverityCritical, now: night, want: []NotificationChannel{ChannelPush, ChannelEmail, ChannelWebhook}},
+		{name: "critical only drops the rest", preferences: criticalOnly, eventType: EventTypeFault, severity: SeverityWarning, now: day},
+		{name: "critical only still sends critical", preferences: criticalOnly, eventType: EventTypeAlert, severity: SeverityCritical, now: night, want: []NotificationChannel{ChannelSMS}},
+		{name: "security is always emailed", preferences: preferences, eventType: EventTypeAccountSecurity, severity: SeverityInfo, now: day, want: []NotificationChannel{ChannelPush, ChannelEmail}},
+		{name: "security is emailed in quiet hours", preferences: preferences, eventType: EventTypeAccountSecurity, severity: SeverityInfo, now: night, want: []NotificationChannel{ChannelPush, ChannelEmail}},
+		{name: "security is emailed to critical only accounts", preferences: criticalOnly, eventType: EventTypeAccountSecurity, severity: SeverityWarning, now: day, want: []NotificationChannel{ChannelPush, ChannelEmail}},
+		{name: "security is emailed without a rule", preferences: NotificationPreferences{Timezone: "UTC"}, eventType: EventTypeAccountSecurity, severity: SeverityInfo, now: day, want: []NotificationChannel{ChannelEmail}},
+		{name: "defaults", preferences: DefaultNotificationPreferences(AccountInfo{Id: "a"}), eventType: EventTypeSensorOffline, severity: SeverityInfo, now: night, want: []NotificationChannel{ChannelPush, ChannelEmail, ChannelWebhook}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.preferences.ChannelsFor(tt.eventType, tt.severity, tt.now)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ChannelsFor(%s, %s) = %v, want %v", tt.eventType, tt.severity, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateNotificationPreferences(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []NotificationRule
+		quiet   *QuietHours
+		wantErr bool
+	}{
+		{name: "every channel", rules: []NotificationRule{{EventType: EventTypeFault, MinSeverity: SeverityInfo, Channels: []NotificationChannel{ChannelPush, ChannelEmail, ChannelSMS, ChannelWebhook}}}},
+		{name: "unknown channel", rules: []NotificationRule{{EventType: EventTypeFault, MinSeverity: SeverityInfo, Channels: []NotificationChannel{"pager"}}}, wantErr: true},
+		{name: "unknown event type", rules: []NotificationRule{{EventType: "weather", MinSeverity: SeverityInfo}}, wantErr: true},
+		{name: "unknown severity", rules: []NotificationRule{{EventType: EventTypeFault, MinSeverity: "urgent"}}, wantErr: true},
+		{name: "event type listed twice", rules: []NotificationRule{{EventType: EventTypeFault, MinSeverity: SeverityInfo}, {EventType: EventTypeFault, MinSeverity: SeverityCritical}}, wantErr: true},
+		{name: "quiet hours", quiet: &QuietHours{Start: "22:00", End: "07:00"}},
+		{name: "malformed quiet hours", quiet: &QuietHours{Start: "10pm", End: "07:00"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NotificationPreferences{Timezone: "UTC", Rules: tt.rules, QuietHours: tt.quiet}
+			if err := p.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
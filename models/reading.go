@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+var (
+	FieldReadingSensorId   = "sensor_id"
+	FieldReadingRecordedAt = "recorded_at"
+)
+
+// Reading is a sensor's output over one interval, as stored by the ingest pipeline. This service
+// only reads them.
+type Reading struct {
+	Shared      `bson:",inline"`
+	AccountInfo AccountInfo `json:"accountInfo" bson:"account_info"`
+	SensorId    string      `json:"sensorId" bson:"sensor_id"`
+	EnergyKWh   float64     `json:"energyKWh" bson:"energy_kwh"`
+
+	// ExpectedKWh is what the array would have produced at its rated power under the irradiance
+	// measured over the interval, zero when the sensor has no irradiance meter.
+	ExpectedKWh float64   `json:"expectedKWh" bson:"expected_kwh"`
+	RecordedAt  time.Time `json:"recordedAt" bson:"recorded_at"`
+}
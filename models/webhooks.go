@@ -0,0 +1,109 @@
+package models
+
+import (
+	"net/url"
+	"time"
+)
+
+type WebhookDeliveryStatus string // Where a webhook delivery is in its lifecycle
+
+const (
+	DeliveryPending   WebhookDeliveryStatus = "pending"
+	DeliverySucceeded WebhookDeliveryStatus = "succeeded"
+	DeliveryFailed    WebhookDeliveryStatus = "failed"
+
+	// DeliveryCancelled deliveries were still pending when their subscription was deleted.
+	DeliveryCancelled WebhookDeliveryStatus = "cancelled"
+)
+
+var (
+	FieldWebhookOrganisationId  = "organisation_id"
+	FieldWebhookEventTypes      = "event_types"
+	FieldDeliverySubscriptionId = "subscription_id"
+	FieldDeliveryEventId        = "event_id"
+	FieldDeliveryStatus         = "status"
+	FieldDeliveryAttemptCount   = "attempt_count"
+	FieldDeliveryAttempts       = "attempts"
+	FieldDeliveryNextAttemptAt  = "next_attempt_at"
+)
+
+type (
+	// WebhookSubscription posts events of the chosen types to a customer's URL. Subscriptions with an
+	// OrganisationId receive the events of every account in the organisation.
+	WebhookSubscription struct {
+		Shared         `bson:",inline"`
+		AccountInfo    AccountInfo             `json:"accountInfo" bson:"account_info"`
+		OrganisationId string                  `json:"organisationId" bson:"organisation_id,omitempty"`
+		Url            string                  `json:"url" bson:"url"`
+		Description    string                  `json:"description" bson:"description"`
+		EventTypes     []NotificationEventType `json:"eventTypes" bson:"event_types"`
+
+		// Secret signs every delivery. It is only shown to the user when the subscription is created.
+		Secret string `json:"-" bson:"secret"`
+	}
+
+	// WebhookDelivery is one event sent to one subscription, with every attempt made to send it.
+	WebhookDelivery struct {
+		Shared         `bson:",inline"`
+		SubscriptionId string                `json:"subscriptionId" bson:"subscription_id"`
+		EventId        string                `json:"eventId" bson:"event_id"`
+		EventType      NotificationEventType `json:"eventType" bson:"event_type"`
+
+		// Payload is the exact body posted, so a redelivery sends the same bytes.
+		Payload string `json:"payload" bson:"payload"`
+
+		Status        WebhookDeliveryStatus `json:"status" bson:"status"`
+		AttemptCount  int                   `json:"attemptCount" bson:"attempt_count"`
+		NextAttemptAt *time.Time            `json:"nextAttemptAt" bson:"next_attempt_at"`
+		Attempts      []WebhookAttempt      `json:"attempts" bson:"attempts"`
+	}
+
+	WebhookAttempt struct {
+		AttemptedAt  time.Time `json:"attemptedAt" bson:"attempted_at"`
+		ResponseCode int       `json:"responseCode" bson:"response_code"`
+		Error        string    `json:"error,omitempty" bson:"error,omitempty"`
+		DurationMs   int64     `json:"durationMs" bson:"duration_ms"`
+	}
+)
+
+// IsValidWebhookEventType reports whether events of type t can be subscribed to. Account security
+// messages are only ever sent to the account holder.
+func IsValidWebhookEventType(t NotificationEventType) bool {
+	return t != EventTypeAccountSecurity && IsValidNotificationEventType(t)
+}
+
+// IsValidWebhookUrl accepts https URLs, and when allowLocalhost is set for development, plain http
+// ones on localhost. Where the host resolves to is checked separately, when the url is saved and
+// again on every delivery.
+func IsValidWebhookUrl(raw string, allowLocalhost bool) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		return allowLocalhost && (host == "localhost" || host == "127.0.0.1" || host == "::1")
+	}
+	return false
+}
+
+func (w WebhookSubscription) Subscribes(t NotificationEventType) bool {
+	for _, eventType := range w.EventTypes {
+		if eventType == t {
+			return true
+		}
+	}
+	return false
+}
+
+func IsValidDeliveryStatus(s WebhookDeliveryStatus) bool {
+	switch s {
+	case DeliveryPending, DeliverySucceeded, DeliveryFailed, DeliveryCancelled:
+		return true
+	}
+	return false
+}
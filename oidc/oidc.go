@@ -0,0 +1,272 @@
+// Package oidc implements the parts of OpenID Connect needed for the authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+
+	defaultHttpTimeout = 10 * time.Second
+)
+
+var (
+	ErrInvalidIdToken = errors.New("invalid id token")
+)
+
+type (
+	// Provider is an OpenID Connect provider discovered from its issuer url.
+	Provider struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JwksUri               string `json:"jwks_uri"`
+
+		client *http.Client
+	}
+
+	// Client is a relying party registered with a Provider.
+	Client struct {
+		ClientId     string
+		ClientSecret string
+		RedirectUri  string
+		Scopes       []string
+	}
+
+	// IdentityClaims are the claims of a verified id token this application cares about.
+	IdentityClaims struct {
+		Issuer        string
+		Subject       string
+		Email         string
+		EmailVerified bool
+		GivenName     string
+		FamilyName    string
+		Nonce         string
+
+		// AuthTime is when the provider last authenticated the user, from auth_time or else iat.
+		AuthTime time.Time
+	}
+
+	tokenResponse struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		IdToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+
+	jsonWebKeySet struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	jsonWebKey struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		Alg string `json:"alg"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	}
+)
+
+// Discover fetches the provider's configuration from the well-known discovery document.
+func Discover(ctx context.Context, issuer string) (*Provider, error) {
+	client := &http.Client{Timeout: defaultHttpTimeout}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(issuer, "/")+discoveryPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := &Provider{}
+	if err = getJSON(client, req, provider); err != nil {
+		return nil, fmt.Errorf("oidc discovery failed: %w", err)
+	}
+
+	if strings.TrimSuffix(provider.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, errors.New("oidc discovery returned a different issuer")
+	}
+
+	provider.client = client
+	return provider, nil
+}
+
+// AuthCodeURL returns the url to send the user to, using the S256 PKCE challenge of verifier.
+// A non-empty prompt, such as "login", is passed on to the provider.
+func (p *Provider) AuthCodeURL(c Client, state, nonce, verifier, prompt string) string {
+	scopes := c.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", c.ClientId)
+	v.Set("redirect_uri", c.RedirectUri)
+	v.Set("scope", strings.Join(scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", CodeChallenge(verifier))
+	v.Set("code_challenge_method", "S256")
+	if prompt != "" {
+		v.Set("prompt", prompt)
+	}
+
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.AuthorizationEndpoint + sep + v.Encode()
+}
+
+// Exchange redeems an authorization code at the token endpoint and returns the raw id token.
+func (p *Provider) Exchange(ctx context.Context, c Client, code, verifier string) (string, error) {
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", c.RedirectUri)
+	v.Set("client_id", c.ClientId)
+	v.Set("code_verifier", verifier)
+	if c.ClientSecret != "" {
+		v.Set("client_secret", c.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	token := &tokenResponse{}
+	if err = getJSON(p.client, req, token); err != nil {
+		return "", fmt.Errorf("oidc token exchange failed: %w", err)
+	}
+	if token.Error != "" {
+		return "", fmt.Errorf("oidc token exchange failed: %s %s", token.Error, token.Description)
+	}
+	if token.IdToken == "" {
+		return "", errors.New("oidc token response has no id token")
+	}
+
+	return token.IdToken, nil
+}
+
+// VerifyIdToken checks the id token's signature against the provider's keys and validates
+// its issuer, audience, expiry and nonce.
+func (p *Provider) VerifyIdToken(ctx context.Context, c Client, rawIdToken, nonce string) (*IdentityClaims, error) {
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(rawIdToken, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, errors.New("unsupported id token signing method")
+		}
+		kid, _ := token.Header["kid"].(string)
+		for _, key := range keys {
+			if key.Kid == kid || kid == "" {
+				return key.publicKey()
+			}
+		}
+		return nil, errors.New("id token signed with an unknown key")
+	})
+	if err != nil {
+		return nil, ErrInvalidIdToken
+	}
+
+	if !claims.VerifyIssuer(p.Issuer, true) || !claims.VerifyAudience(c.ClientId, true) {
+		return nil, ErrInvalidIdToken
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, ErrInvalidIdToken
+	}
+
+	identity := &IdentityClaims{}
+	identity.Issuer, _ = claims["iss"].(string)
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	identity.GivenName, _ = claims["given_name"].(string)
+	identity.FamilyName, _ = claims["family_name"].(string)
+	identity.Nonce, _ = claims["nonce"].(string)
+	if authTime, ok := claims["auth_time"].(float64); ok {
+		identity.AuthTime = time.Unix(int64(authTime), 0)
+	} else if iat, ok := claims["iat"].(float64); ok {
+		identity.AuthTime = time.Unix(int64(iat), 0)
+	}
+
+	if identity.Subject == "" || identity.Nonce != nonce {
+		return nil, ErrInvalidIdToken
+	}
+
+	return identity, nil
+}
+
+func (p *Provider) fetchKeys(ctx context.Context) ([]jsonWebKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.JwksUri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &jsonWebKeySet{}
+	if err = getJSON(p.client, req, set); err != nil {
+		return nil, fmt.Errorf("oidc jwks fetch failed: %w", err)
+	}
+	return set.Keys, nil
+}
+
+func (k jsonWebKey) publicKey() (*rsa.PublicKey, error) {
+	if k.Kty != "RSA" {
+		return nil, errors.New("unsupported key type " + k.Kty)
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+// CodeChallenge returns the S256 PKCE challenge for verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func getJSON(client *http.Client, req *http.Request, target any) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(target)
+}
@@ -0,0 +1,148 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// startMockProvider serves a MockProvider signing everyone in as identity, at its own issuer url.
+func startMockProvider(t *testing.T, identity IdentityClaims) *Provider {
+	t.Helper()
+
+	var mock *MockProvider
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mock.Handler().ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	mock, err := NewMockProvider(srv.URL, "narx", identity)
+	if err != nil {
+		t.Fatalf("NewMockProvider() error = %v", err)
+	}
+
+	provider, err := Discover(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	return provider
+}
+
+// authorize follows authUrl to the provider and returns the query of the redirect back to the client.
+func authorize(t *testing.T, authUrl string) url.Values {
+	t.Helper()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	res, err := client.Get(authUrl)
+	if err != nil {
+		t.Fatalf("authorize request error = %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("authorize status = %d, want %d", res.StatusCode, http.StatusFound)
+	}
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid redirect: %v", err)
+	}
+	return location.Query()
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	identity := IdentityClaims{Subject: "user-1", Email: "ada@example.com", EmailVerified: true, GivenName: "Ada", FamilyName: "Obi"}
+	provider := startMockProvider(t, identity)
+
+	client := Client{ClientId: "narx", RedirectUri: "https://api.example.com/v1/user/oidc/callback"}
+
+	tests := []struct {
+		name string
+
+		// what the client sends back after the redirect, empty to use what it sent out
+		verifier string
+		nonce    string
+
+		wantExchangeErr bool
+		wantVerifyErr   bool
+	}{
+		{name: "round trip"},
+		{name: "wrong pkce verifier", verifier: "not-the-verifier", wantExchangeErr: true},
+		{name: "wrong nonce", nonce: "not-the-nonce", wantVerifyErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const state, nonce, verifier = "state-123", "nonce-456", "verifier-789-verifier-789-verifier-789-abcd"
+
+			query := authorize(t, provider.AuthCodeURL(client, state, nonce, verifier, ""))
+			if got := query.Get("state"); got != state {
+				t.Fatalf("state = %q, want %q", got, state)
+			}
+
+			sentVerifier := verifier
+			if tt.verifier != "" {
+				sentVerifier = tt.verifier
+			}
+			idToken, err := provider.Exchange(context.Background(), client, query.Get("code"), sentVerifier)
+			if (err != nil) != tt.wantExchangeErr {
+				t.Fatalf("Exchange() error = %v, want error %v", err, tt.wantExchangeErr)
+			}
+			if err != nil {
+				return
+			}
+
+			expectedNonce := nonce
+			if tt.nonce != "" {
+				expectedNonce = tt.nonce
+			}
+			claims, err := provider.VerifyIdToken(context.Background(), client, idToken, expectedNonce)
+			if (err != nil) != tt.wantVerifyErr {
+				t.Fatalf("VerifyIdToken() error = %v, want error %v", err, tt.wantVerifyErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if claims.Subject != identity.Subject || claims.Email != identity.Email || !claims.EmailVerified {
+				t.Errorf("claims = %+v, want the mock identity %+v", claims, identity)
+			}
+			if claims.AuthTime.IsZero() {
+				t.Error("claims have no auth time")
+			}
+		})
+	}
+}
+
+func TestAuthorizationCodeIsSingleUse(t *testing.T) {
+	provider := startMockProvider(t, IdentityClaims{Subject: "user-1"})
+
+	client := Client{ClientId: "narx", RedirectUri: "https://api.example.com/v1/user/oidc/callback"}
+	const verifier = "verifier-789-verifier-789-verifier-789-abcd"
+
+	code := authorize(t, provider.AuthCodeURL(client, "state", "nonce", verifier, "")).Get("code")
+
+	if _, err := provider.Exchange(context.Background(), client, code, verifier); err != nil {
+		t.Fatalf("first Exchange() error = %v", err)
+	}
+	if _, err := provider.Exchange(context.Background(), client, code, verifier); err == nil {
+		t.Fatal("second Exchange() of the same code succeeded")
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// the example from RFC 7636, appendix B
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	const want = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if got := CodeChallenge(verifier); got != want {
+		t.Errorf("CodeChallenge() = %q, want %q", got, want)
+	}
+}
@@ -35,6 +35,10 @@ func NewPublisher(inserter Inserter) PublishInterface {
 }
 
 func (p *Publisher) Publish(ctx context.Context, key, kind string, message map[string]interface{}) error {
+	if err := events.Validate(key, message); err != nil {
+		return err
+	}
+
 	event := events.Event{
 		ID:        primitive.NewObjectID(),
 		EventKind: kind,
@@ -0,0 +1,54 @@
+package publisher
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/events"
+)
+
+// RedisPublisher appends events to a Redis stream, for consumers reading it through consumer groups.
+type RedisPublisher struct {
+	rdb    redis.Cmdable
+	stream string
+	maxLen int64
+}
+
+var _ PublishInterface = (*RedisPublisher)(nil)
+
+// NewRedisPublisher publishes to stream, trimming it to about maxLen entries. A maxLen of zero
+// leaves the stream untrimmed.
+func NewRedisPublisher(rdb redis.Cmdable, stream string, maxLen int64) PublishInterface {
+	return &RedisPublisher{
+		rdb:    rdb,
+		stream: stream,
+		maxLen: maxLen,
+	}
+}
+
+func (p *RedisPublisher) Publish(ctx context.Context, key, kind string, message map[string]interface{}) error {
+	if err := events.Validate(key, message); err != nil {
+		return err
+	}
+
+	event := events.Event{
+		ID:        primitive.NewObjectID(),
+		EventKind: kind,
+		EventKey:  key,
+		MsgBody:   message,
+	}
+
+	values, err := event.StreamValues()
+	if err != nil {
+		return err
+	}
+
+	return p.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: p.stream,
+		MaxLen: p.maxLen,
+		Approx: true,
+		Values: values,
+	}).Err()
+}
@@ -15,22 +15,42 @@ import (
 
 type (
 	Container struct {
-		AccountsRepo *Repository[models.Account]
-		SensorRepo   *Repository[models.Sensor]
-		DevicesRepo  *Repository[models.Devices]
+		AccountsRepo       *Repository[models.Account]
+		SensorRepo         *Repository[models.Sensor]
+		ReadingsRepo       *Repository[models.Reading]
+		DevicesRepo        *Repository[models.Devices]
+		ApiKeysRepo        *Repository[models.ApiKey]
+		OrganisationsRepo  *Repository[models.Organisation]
+		ImpersonationsRepo *Repository[models.Impersonation]
+		AuditLogRepo       *Repository[models.AuditEntry]
+		PreferencesRepo    *Repository[models.NotificationPreferences]
+		InboxRepo          *Repository[models.InboxNotification]
+		WebhooksRepo       *Repository[models.WebhookSubscription]
+		DeliveriesRepo     *Repository[models.WebhookDelivery]
+		DeadLettersRepo    *Repository[models.DeadLetter]
 	}
 	Repository[T models.SharedInterface] struct {
 		dbCollection database.Collection
 	}
 )
 
-func NewRepositoryContainer(dbConn *database.Client) *Container {
+func NewRepositoryContainer(dbConn database.Database) *Container {
 	log.Println("building repository container...")
 
 	return &Container{
-		AccountsRepo: NewRepository[models.Account](dbConn.GetCollection("accounts")),
-		SensorRepo:   NewRepository[models.Sensor](dbConn.GetCollection("sensors")),
-		DevicesRepo:  NewRepository[models.Devices](dbConn.GetCollection("devices")),
+		AccountsRepo:       NewRepository[models.Account](dbConn.GetCollection("accounts")),
+		SensorRepo:         NewRepository[models.Sensor](dbConn.GetCollection("sensors")),
+		ReadingsRepo:       NewRepository[models.Reading](dbConn.GetCollection("readings")),
+		DevicesRepo:        NewRepository[models.Devices](dbConn.GetCollection("devices")),
+		ApiKeysRepo:        NewRepository[models.ApiKey](dbConn.GetCollection("api_keys")),
+		OrganisationsRepo:  NewRepository[models.Organisation](dbConn.GetCollection("organisations")),
+		ImpersonationsRepo: NewRepository[models.Impersonation](dbConn.GetCollection("impersonations")),
+		AuditLogRepo:       NewRepository[models.AuditEntry](dbConn.GetCollection("audit_log")),
+		PreferencesRepo:    NewRepository[models.NotificationPreferences](dbConn.GetCollection("notification_preferences")),
+		InboxRepo:          NewRepository[models.InboxNotification](dbConn.GetCollection("inbox")),
+		WebhooksRepo:       NewRepository[models.WebhookSubscription](dbConn.GetCollection("webhooks")),
+		DeliveriesRepo:     NewRepository[models.WebhookDelivery](dbConn.GetCollection("webhook_deliveries")),
+		DeadLettersRepo:    NewRepository[models.DeadLetter](dbConn.GetCollection("dead_letters")),
 	}
 }
 
@@ -82,8 +102,13 @@ func (r *Repository[T]) Update(ctx context.Context, dataObject T) (T, error) {
 		return dataObject, errors.New("can't Update Document That Was Queried With A Projection - Some Fields May Be Lost")
 	}
 
+	id, err := primitive.ObjectIDFromHex(dataObject.GetId())
+	if err != nil {
+		return dataObject, errors.New("can't Update Document Without A Valid Id")
+	}
+
 	dataObject.SetUpdatedAt()
-	queryFilter := NewQueryFilter().AddFilter("_id", dataObject.GetId())
+	queryFilter := NewQueryFilter().AddFilter("_id", id)
 	res := r.dbCollection.FindOneAndReplace(ctx, queryFilter.GetFilters(), dataObject)
 
 	if res.Err() != nil {
@@ -93,6 +118,14 @@ func (r *Repository[T]) Update(ctx context.Context, dataObject T) (T, error) {
 	return dataObject, nil
 }
 
+func (r *Repository[T]) Count(ctx context.Context, queryFilter *QueryFilter) (int64, error) {
+	count, err := r.dbCollection.CountDocuments(ctx, queryFilter.GetFilters())
+	if err != nil {
+		return 0, errors.New("failed to count: " + err.Error())
+	}
+	return count, nil
+}
+
 func (r *Repository[T]) UpdateMany(ctx context.Context, queryFilter *QueryFilter, opts map[string]interface{}) error {
 	_, err := r.dbCollection.UpdateMany(ctx, queryFilter.GetFilters(), opts)
 	if err != nil {
@@ -102,6 +135,52 @@ func (r *Repository[T]) UpdateMany(ctx context.Context, queryFilter *QueryFilter
 	return nil
 }
 
+// UpdateOne applies update to the first document matching the filters, and returns how many
+// documents were modified. Matching on a document's current state makes it a compare-and-set.
+func (r *Repository[T]) UpdateOne(ctx context.Context, queryFilter *QueryFilter, update map[string]interface{}) (int64, error) {
+	res, err := r.dbCollection.UpdateOne(ctx, queryFilter.GetFilters(), update)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.ModifiedCount, nil
+}
+
+// Find returns every document that matches the provided filters.
+// Use Paginate for anything that is listed to users, Find is meant for exports and cascades.
+func (r *Repository[T]) Find(ctx context.Context, queryFilter *QueryFilter, projection *QueryProjection, sort *QuerySort) ([]T, error) {
+	if sort == nil {
+		sort = NewDefaultQuerySort()
+	}
+
+	opts := &options.FindOptions{
+		Sort: sort.GetSort(),
+	}
+	if projection != nil {
+		opts.Projection = projection.GetProjection()
+	}
+
+	cur, err := r.dbCollection.Find(ctx, queryFilter.GetFilters(), opts)
+	if err != nil {
+		return nil, errors.New("failed to find: " + err.Error())
+	}
+	defer func(cur *mongo.Cursor, ctx context.Context) {
+		if err := cur.Close(ctx); err != nil {
+			log.Println("Cursor.Close failed to close cursor")
+		}
+	}(cur, ctx)
+
+	dataObjects := make([]T, 0)
+	for cur.Next(ctx) {
+		var dataObject T
+		if err := cur.Decode(&dataObject); err != nil {
+			return nil, errors.New("failed to decode")
+		}
+		dataObjects = append(dataObjects, dataObject)
+	}
+	return dataObjects, cur.Err()
+}
+
 // findPaginated searches for document that matches the provided filters.
 // paginatorOptions control CurrentPage and PerPage value.
 // If projection is nil, all fields are returned.
@@ -1,5 +1,11 @@
 package requests
 
+import (
+	"time"
+
+	"github.com/tejiriaustin/narx_api/models"
+)
+
 type (
 	CreateUserRequest struct {
 		FirstName string `json:"firstName"`
@@ -13,6 +19,49 @@ type (
 		LastName   string `json:"lastName"`
 		Email      string `json:"email"`
 		Department string `json:"department"`
+		Locale     string `json:"locale"`
+	}
+
+	ChangePasswordRequest struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+		TwoFactorCode   string `json:"twoFactorCode"`
+	}
+
+	ChangeEmailRequest struct {
+		Password      string `json:"password"`
+		NewEmail      string `json:"newEmail"`
+		TwoFactorCode string `json:"twoFactorCode"`
+	}
+
+	StartPhoneVerificationRequest struct {
+		Phone string `json:"phone"`
+	}
+
+	VerifyPhoneRequest struct {
+		Code string `json:"code"`
+	}
+
+	DeleteAccountRequest struct {
+		Password      string `json:"password"`
+		TwoFactorCode string `json:"twoFactorCode"`
+	}
+
+	SuspendAccountRequest struct {
+		Reason string `json:"reason"`
+	}
+
+	NotifyAccountRequest struct {
+		EventType models.NotificationEventType `json:"eventType"`
+		Severity  models.Severity              `json:"severity"`
+		Title     string                       `json:"title"`
+		Body      string                       `json:"body"`
+		Data      map[string]string            `json:"data"`
+	}
+
+	ImpersonateAccountRequest struct {
+		Reason  string `json:"reason"`
+		Minutes int    `json:"minutes"`
 	}
 
 	ListAccountFilters struct {
@@ -28,13 +77,32 @@ type (
 		Password string `json:"password"`
 	}
 
+	VerifyTwoFactorLoginRequest struct {
+		ChallengeToken string `json:"challengeToken"`
+		Code           string `json:"code"`
+	}
+
+	ConfirmTwoFactorRequest struct {
+		Code string `json:"code"`
+	}
+
+	DisableTwoFactorRequest struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	ResendVerificationRequest struct {
+		Email string `json:"email"`
+	}
+
 	ForgotPasswordRequest struct {
 		Email string `json:"email"`
 	}
 
 	ResetPasswordRequest struct {
-		ResetCode   string
-		NewPassword string
+		Email       string `json:"email"`
+		ResetCode   string `json:"resetCode"`
+		NewPassword string `json:"newPassword"`
 	}
 )
 
@@ -54,5 +122,32 @@ type (
 type (
 	SaveDeviceToken struct {
 		DeviceToken string `json:"deviceToken" bson:"device_token"`
+		Platform    string `json:"platform" bson:"platform"`
+		AppVersion  string `json:"appVersion" bson:"app_version"`
+		Locale      string `json:"locale" bson:"locale"`
+	}
+)
+
+type (
+	CreateWebhookRequest struct {
+		Url             string   `json:"url"`
+		Description     string   `json:"description"`
+		EventTypes      []string `json:"eventTypes"`
+		ForOrganisation bool     `json:"forOrganisation"`
+	}
+
+	CreateApiKeyRequest struct {
+		Name            string     `json:"name"`
+		Permissions     []string   `json:"permissions"`
+		ExpiresAt       *time.Time `json:"expiresAt"`
+		ForOrganisation bool       `json:"forOrganisation"`
+	}
+
+	UpdateNotificationPreferencesRequest struct {
+		Rules        []models.NotificationRule `json:"rules"`
+		Timezone     string                    `json:"timezone"`
+		QuietHours   *models.QuietHours        `json:"quietHours"`
+		CriticalOnly bool                      `json:"criticalOnly"`
+		Digest       models.DigestFrequency    `json:"digest"`
 	}
 )
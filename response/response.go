@@ -6,13 +6,39 @@ import (
 
 func SingleAccountResponse(account *models.Account) map[string]interface{} {
 	return map[string]interface{}{
+		"_id":       account.ID.Hex(),
 		"email":     account.Email,
-		"password":  account.Password,
 		"firstName": account.FirstName,
 		"lastName":  account.LastName,
 		"fullName":  account.FullName,
+		"locale":    account.Locale,
 		"status":    account.Status,
 		"token":     account.Token,
+		"createdAt": account.CreatedAt,
+
+		"pendingEmail":   account.PendingEmail,
+		"phone":          account.Phone,
+		"phoneVerified":  account.HasVerifiedPhone(),
+		"organisationId": account.OrganisationId,
+		"suspension":     account.Suspension,
+		"impersonatedBy": account.ImpersonatedBy,
+
+		"twoFactorEnabled":  account.HasTwoFactor(),
+		"twoFactorRequired": account.ChallengeToken != "",
+		"challengeToken":    account.ChallengeToken,
+	}
+}
+
+func TwoFactorEnrollmentResponse(secret, uri string) map[string]interface{} {
+	return map[string]interface{}{
+		"secret": secret,
+		"uri":    uri,
+	}
+}
+
+func RecoveryCodesResponse(codes []string) map[string]interface{} {
+	return map[string]interface{}{
+		"recoveryCodes": codes,
 	}
 }
 
@@ -42,3 +68,196 @@ func MultipleSensorResponse(sensors []models.Sensor) interface{} {
 	}
 	return m
 }
+
+func SingleApiKeyResponse(apiKey *models.ApiKey) map[string]interface{} {
+	m := map[string]interface{}{
+		"_id":            apiKey.ID.Hex(),
+		"name":           apiKey.Name,
+		"prefix":         apiKey.Prefix,
+		"permissions":    apiKey.Permissions,
+		"createdAt":      apiKey.CreatedAt,
+		"lastUsedAt":     apiKey.LastUsedAt,
+		"expiresAt":      apiKey.ExpiresAt,
+		"revokedAt":      apiKey.RevokedAt,
+		"organisationId": apiKey.OrganisationId,
+	}
+	if apiKey.Key != "" {
+		m["key"] = apiKey.Key
+	}
+	return m
+}
+
+func MultipleApiKeyResponse(apiKeys []models.ApiKey) interface{} {
+	m := make([]map[string]interface{}, 0, len(apiKeys))
+	for _, a := range apiKeys {
+		m = append(m, SingleApiKeyResponse(&a))
+	}
+	return m
+}
+
+func SingleAuditEntryResponse(entry *models.AuditEntry) map[string]interface{} {
+	return map[string]interface{}{
+		"_id":            entry.ID.Hex(),
+		"createdAt":      entry.CreatedAt,
+		"actor":          entry.Actor,
+		"impersonatedBy": entry.ImpersonatedBy,
+		"action":         entry.Action,
+		"targetType":     entry.TargetType,
+		"targetId":       entry.TargetId,
+		"changes":        entry.Changes,
+		"metadata":       entry.Metadata,
+		"ipAddress":      entry.IpAddress,
+		"userAgent":      entry.UserAgent,
+	}
+}
+
+func MultipleAuditEntryResponse(entries []models.AuditEntry) interface{} {
+	m := make([]map[string]interface{}, 0, len(entries))
+	for _, e := range entries {
+		m = append(m, SingleAuditEntryResponse(&e))
+	}
+	return m
+}
+
+func SingleDeviceResponse(device *models.Devices) map[string]interface{} {
+	return map[string]interface{}{
+		"_id":         device.ID.Hex(),
+		"deviceToken": device.DeviceToken,
+		"platform":    device.Platform,
+		"appVersion":  device.AppVersion,
+		"locale":      device.Locale,
+		"createdAt":   device.CreatedAt,
+		"lastSeenAt":  device.LastSeenAt,
+	}
+}
+
+func MultipleDeviceResponse(devices []models.Devices) interface{} {
+	m := make([]map[string]interface{}, 0, len(devices))
+	for _, d := range devices {
+		m = append(m, SingleDeviceResponse(&d))
+	}
+	return m
+}
+
+func NotificationPreferencesResponse(preferences *models.NotificationPreferences) map[string]interface{} {
+	return map[string]interface{}{
+		"rules":        preferences.Rules,
+		"timezone":     preferences.Timezone,
+		"quietHours":   preferences.QuietHours,
+		"criticalOnly": preferences.CriticalOnly,
+		"digest":       preferences.Digest,
+		"updatedAt":    preferences.UpdatedAt,
+	}
+}
+
+func SingleInboxNotificationResponse(notification *models.InboxNotification) map[string]interface{} {
+	return map[string]interface{}{
+		"_id":       notification.ID.Hex(),
+		"eventType": notification.EventType,
+		"severity":  notification.Severity,
+		"title":     notification.Title,
+		"body":      notification.Body,
+		"data":      notification.Data,
+		"read":      notification.IsRead(),
+		"readAt":    notification.ReadAt,
+		"createdAt": notification.CreatedAt,
+	}
+}
+
+func MultipleInboxNotificationResponse(notifications []models.InboxNotification) interface{} {
+	m := make([]map[string]interface{}, 0, len(notifications))
+	for _, n := range notifications {
+		m = append(m, SingleInboxNotificationResponse(&n))
+	}
+	return m
+}
+
+func SingleWebhookResponse(webhook *models.WebhookSubscription) map[string]interface{} {
+	return map[string]interface{}{
+		"_id":            webhook.ID.Hex(),
+		"url":            webhook.Url,
+		"description":    webhook.Description,
+		"eventTypes":     webhook.EventTypes,
+		"organisationId": webhook.OrganisationId,
+		"createdBy":      webhook.AccountInfo,
+		"createdAt":      webhook.CreatedAt,
+	}
+}
+
+// CreatedWebhookResponse includes the signing secret, which is only ever shown once.
+func CreatedWebhookResponse(webhook *models.WebhookSubscription) map[string]interface{} {
+	m := SingleWebhookResponse(webhook)
+	m["secret"] = webhook.Secret
+	return m
+}
+
+func MultipleWebhookResponse(webhooks []models.WebhookSubscription) interface{} {
+	m := make([]map[string]interface{}, 0, len(webhooks))
+	for _, w := range webhooks {
+		m = append(m, SingleWebhookResponse(&w))
+	}
+	return m
+}
+
+func SingleWebhookDeliveryResponse(delivery *models.WebhookDelivery) map[string]interface{} {
+	return map[string]interface{}{
+		"_id":           delivery.ID.Hex(),
+		"webhookId":     delivery.SubscriptionId,
+		"eventId":       delivery.EventId,
+		"eventType":     delivery.EventType,
+		"payload":       delivery.Payload,
+		"status":        delivery.Status,
+		"attemptCount":  delivery.AttemptCount,
+		"nextAttemptAt": delivery.NextAttemptAt,
+		"attempts":      delivery.Attempts,
+		"createdAt":     delivery.CreatedAt,
+	}
+}
+
+func MultipleWebhookDeliveryResponse(deliveries []models.WebhookDelivery) interface{} {
+	m := make([]map[string]interface{}, 0, len(deliveries))
+	for _, d := range deliveries {
+		m = append(m, SingleWebhookDeliveryResponse(&d))
+	}
+	return m
+}
+
+// deadLetterSecrets are event body fields that hold codes or links an admin shouldn't see.
+var deadLetterSecrets = map[string]bool{
+	"code":              true,
+	"password":          true,
+	"token":             true,
+	"verification_link": true,
+}
+
+func SingleDeadLetterResponse(deadLetter *models.DeadLetter) map[string]interface{} {
+	body := make(map[string]interface{}, len(deadLetter.MsgBody))
+	for k, v := range deadLetter.MsgBody {
+		if deadLetterSecrets[k] {
+			v = "[redacted]"
+		}
+		body[k] = v
+	}
+
+	return map[string]interface{}{
+		"_id":        deadLetter.ID.Hex(),
+		"eventId":    deadLetter.EventId,
+		"eventKey":   deadLetter.EventKey,
+		"eventKind":  deadLetter.EventKind,
+		"group":      deadLetter.Group,
+		"msgBody":    body,
+		"attempts":   deadLetter.Attempts,
+		"lastError":  deadLetter.LastError,
+		"requeuedAt": deadLetter.RequeuedAt,
+		"requeuedBy": deadLetter.RequeuedBy,
+		"createdAt":  deadLetter.CreatedAt,
+	}
+}
+
+func MultipleDeadLetterResponse(deadLetters []models.DeadLetter) interface{} {
+	m := make([]map[string]interface{}, 0, len(deadLetters))
+	for _, d := range deadLetters {
+		m = append(m, SingleDeadLetterResponse(&d))
+	}
+	return m
+}
@@ -2,9 +2,12 @@ package server
 
 import (
 	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -24,29 +27,58 @@ func Start(
 ) {
 	router := gin.New()
 
+	// ClientIP only honours X-Forwarded-For from these proxies; with none set it is the peer address,
+	// so the login throttle can't be sidestepped by a forged header
+	if err := router.SetTrustedProxies(trustedProxies(conf.GetAsString(env.TrustedProxies))); err != nil {
+		log.Fatal("invalid trusted proxies: ", err.Error())
+	}
+
 	router.Use(
 		middleware.DefaultStructuredLogs(),
 		middleware.ReadPaginationOptions(),
+		middleware.ReadRequestInfo(),
 		middleware.CORSMiddleware(),
 	)
 	log.Println("starting server...")
 
 	controllers.BindRoutes(ctx, router, service, repo, conf)
 
+	srv := &http.Server{
+		Addr:    ":" + conf.GetAsString(env.Port),
+		Handler: router,
+	}
+
 	go func() {
-		if err := router.Run(); err != nil {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatal("shutting down server...:", err.Error())
 		}
 	}()
 
-	// graceful shutdown
+	// graceful shutdown, on an interrupt or once ctx is cancelled
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
-	<-quit
+	defer signal.Stop(quit)
+
+	select {
+	case <-quit:
+	case <-ctx.Done():
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	if err := router; err != nil {
+	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// trustedProxies parses a comma separated list of proxy ips or cidrs.
+func trustedProxies(value string) []string {
+	var proxies []string
+	for _, proxy := range strings.Split(value, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
@@ -1,18 +1,25 @@
 package services
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"log"
-	"math/rand"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/tejiriaustin/narx_api/audit"
 	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/events"
 	"github.com/tejiriaustin/narx_api/events/notifications"
+	"github.com/tejiriaustin/narx_api/limiter"
 	"github.com/tejiriaustin/narx_api/models"
 	"github.com/tejiriaustin/narx_api/publisher"
 	"github.com/tejiriaustin/narx_api/repository"
@@ -20,12 +27,14 @@ import (
 )
 
 type AccountsService struct {
-	conf *env.Environment
+	conf     *env.Environment
+	auditLog *audit.Log
 }
 
-func NewAccountsService(conf *env.Environment) *AccountsService {
+func NewAccountsService(conf *env.Environment, auditLog *audit.Log) *AccountsService {
 	return &AccountsService{
-		conf: conf,
+		conf:     conf,
+		auditLog: auditLog,
 	}
 }
 
@@ -40,23 +49,72 @@ type (
 		Id        string
 		FirstName string
 		LastName  string
-		Email     string
+		Locale    string
+	}
+	// Reauthentication is how the account holder confirms a sensitive change. Accounts with a
+	// password give it, single sign-on accounts give a two factor code or use a session their
+	// provider authenticated them in moments ago.
+	Reauthentication struct {
+		Password           string
+		TwoFactorCode      string
+		SsoAuthenticatedAt *time.Time
+	}
+	ChangePasswordInput struct {
+		AccountId   string
+		NewPassword string
+		Reauthentication
+	}
+	ChangeEmailInput struct {
+		AccountId string
+		NewEmail  string
+		Reauthentication
+	}
+	StartPhoneVerificationInput struct {
+		AccountId string
+		Phone     string
+	}
+	VerifyPhoneInput struct {
+		AccountId string
+		Code      string
+	}
+	RemovePhoneInput struct {
+		AccountId string
+	}
+	DeleteAccountInput struct {
+		AccountId string
+		Reauthentication
+	}
+	ExportAccountDataInput struct {
+		AccountId string
 	}
 
 	LoginUserInput struct {
-		Email    string
-		Password string
+		Email     string
+		Password  string
+		IpAddress string
 	}
 	ForgotPasswordInput struct {
+		Email     string
+		IpAddress string
+	}
+	VerifyEmailInput struct {
+		Token string
+	}
+	ResendVerificationInput struct {
 		Email string
 	}
 	ResetPasswordInput struct {
-		ResetToken  string
+		Email       string
+		ResetCode   string
 		NewPassword string
+		IpAddress   string
 	}
 	Claims struct {
-		Exp           time.Time
-		Authorization bool
+		Exp            time.Time
+		Authorization  bool
+		SessionVersion int
+		ImpersonatedBy string `json:",omitempty"`
+		AuthTime       int64  `json:",omitempty"` // when a single sign-on provider authenticated the user, in unix seconds
 		jwt.StandardClaims
 		Content any
 	}
@@ -72,12 +130,54 @@ type (
 	}
 )
 
+const (
+	sessionTokenTTL = 3600 * time.Minute
+
+	emailVerificationTokenTTL = 48 * time.Hour
+	emailVerificationPurpose  = "verify_email"
+
+	passwordResetCodeLength  = 6
+	passwordResetCodeTTL     = 15 * time.Minute
+	maxPasswordResetAttempts = 5
+	passwordResetLockout     = 24 * time.Hour
+
+	phoneVerificationCodeLength  = 6
+	phoneVerificationCodeTTL     = 10 * time.Minute
+	phoneVerificationResendDelay = time.Minute
+	maxPhoneVerificationAttempts = 5
+
+	ssoReauthenticationWindow = 5 * time.Minute
+)
+
+var (
+	ErrInvalidCredentials      = errors.New("invalid credentials")
+	ErrTooManyLoginAttempts    = errors.New("too many login attempts, please try again later")
+	ErrAccountSuspended        = errors.New("this account has been suspended")
+	ErrInvalidResetCode        = errors.New("invalid or expired reset code")
+	ErrTooManyResetAttempts    = errors.New("too many password reset attempts, please try again later")
+	ErrInvalidVerificationLink = errors.New("invalid or expired verification link")
+	ErrInvalidPhoneCode        = errors.New("invalid or expired verification code")
+	ErrReauthenticationNeeded  = errors.New("sign in again with single sign-on, or enter a two factor code, to confirm this change")
+)
+
+// dummyPasswordHash is compared against when no account matches a login so that
+// response times don't reveal whether an email is registered.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("narx-api-dummy-password"), 8)
+
+// emailVerificationClaims is the content of the signed token sent in verification links.
+type emailVerificationClaims struct {
+	AccountId string `json:"account_id"`
+	Email     string `json:"email"`
+	Purpose   string `json:"purpose"`
+}
+
 var _ AccountsServiceInterface = (*AccountsService)(nil)
 
 func (s *AccountsService) CreateUser(ctx context.Context,
 	input AddAccountInput,
 	passwordGen utils.StrGenFunc,
 	accountsRepo *repository.Repository[models.Account],
+	publisher publisher.PublishInterface,
 ) (*models.Account, error) {
 	if input.Email == "" {
 		return nil, errors.New("email is required")
@@ -115,7 +215,7 @@ func (s *AccountsService) CreateUser(ctx context.Context,
 		FirstName: input.FirstName,
 		LastName:  input.LastName,
 		Email:     input.Email,
-		Status:    models.ActiveStatus,
+		Status:    models.PendingVerificationStatus,
 		Password:  string(passwordHash),
 	}
 
@@ -126,65 +226,724 @@ func (s *AccountsService) CreateUser(ctx context.Context,
 		return nil, err
 	}
 
+	info := acct.GetAccountInfo()
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionAccountCreated,
+		TargetType: audit.TargetAccount,
+		TargetId:   acct.GetId(),
+		After:      acct,
+		Actor:      &info,
+	})
+
+	// the account exists at this point, so a failed publish shouldn't fail the sign-up;
+	// the user can ask for the link again through ResendVerification
+	err = s.publishVerificationEmail(ctx, acct, acct.Email, publisher)
+	if err != nil {
+		log.Printf("failed to publish verification email for account %s: %s", acct.GetId(), err)
+	}
+
 	return &acct, nil
 }
 
+func (s *AccountsService) VerifyEmail(ctx context.Context,
+	input VerifyEmailInput,
+	accountsRepo *repository.Repository[models.Account],
+) (*models.Account, error) {
+
+	claims := &emailVerificationClaims{}
+	err := s.verifySignedToken(ctx, input.Token, claims)
+	if err != nil || claims.Purpose != emailVerificationPurpose {
+		return nil, ErrInvalidVerificationLink
+	}
+
+	id, err := primitive.ObjectIDFromHex(claims.AccountId)
+	if err != nil {
+		return nil, ErrInvalidVerificationLink
+	}
+
+	filter := repository.NewQueryFilter().AddFilter(models.FieldId, id)
+
+	account, err := accountsRepo.FindOne(ctx, filter, nil, nil)
+	if err != nil {
+		if err == repository.NoDocumentsFound {
+			return nil, ErrInvalidVerificationLink
+		}
+		return nil, err
+	}
+	before := account
+
+	switch {
+	case account.PendingEmail != "" && account.PendingEmail == claims.Email:
+		err = ensureEmailAvailable(ctx, account.PendingEmail, accountsRepo)
+		if err != nil {
+			return nil, err
+		}
+		account.Email = account.PendingEmail
+		account.PendingEmail = ""
+	case account.Email == claims.Email:
+		if account.IsVerified() {
+			return &account, nil
+		}
+	default:
+		// links sent to a previous email address are not valid for the current one
+		return nil, ErrInvalidVerificationLink
+	}
+
+	if account.Status == models.PendingVerificationStatus {
+		account.Status = models.ActiveStatus
+	}
+
+	updatedAccount, err := accountsRepo.Update(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	info := updatedAccount.GetAccountInfo()
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionAccountVerified,
+		TargetType: audit.TargetAccount,
+		TargetId:   updatedAccount.GetId(),
+		Before:     before,
+		After:      updatedAccount,
+		Actor:      &info,
+	})
+
+	return &updatedAccount, nil
+}
+
+func (s *AccountsService) ResendVerification(ctx context.Context,
+	input ResendVerificationInput,
+	accountsRepo *repository.Repository[models.Account],
+	publisher publisher.PublishInterface,
+) error {
+
+	filter := repository.NewQueryFilter().AddFilter(models.FieldAccountEmail, input.Email)
+
+	account, err := accountsRepo.FindOne(ctx, filter, nil, nil)
+	if err != nil {
+		if err == repository.NoDocumentsFound {
+			// don't reveal whether an account exists for this email
+			return nil
+		}
+		return err
+	}
+
+	if account.IsVerified() {
+		return nil
+	}
+
+	return s.publishVerificationEmail(ctx, account, account.Email, publisher)
+}
+
+// publishVerificationEmail sends a verification link for email, which is either the account's
+// email or the one it is changing to.
+func (s *AccountsService) publishVerificationEmail(ctx context.Context,
+	account models.Account,
+	email string,
+	publisher publisher.PublishInterface,
+) error {
+
+	token, err := s.generateSignedToken(ctx, emailVerificationClaims{
+		AccountId: account.ID.Hex(),
+		Email:     email,
+		Purpose:   emailVerificationPurpose,
+	}, account.SessionVersion, emailVerificationTokenTTL)
+	if err != nil {
+		return errors.New("failed to generate verification link")
+	}
+
+	event, err := events.Encode(notifications.AccountCreatedNotification, notifications.AccountCreatedPayload{
+		Id:               account.ID.Hex(),
+		FirstName:        account.FirstName,
+		LastName:         account.LastName,
+		FullName:         account.FullName,
+		Email:            email,
+		Locale:           account.Locale,
+		VerificationLink: s.conf.GetAsString(env.ApiUrl) + "/v1/user/verify?token=" + url.QueryEscape(token),
+	})
+	if err != nil {
+		return err
+	}
+
+	return publisher.Publish(ctx, notifications.AccountCreatedNotification, "notification", event)
+}
+
 func (s *AccountsService) EditAccount(ctx context.Context,
 	input EditAccountInput,
 	accountsRepo *repository.Repository[models.Account],
 ) (*models.Account, error) {
 
-	fields := map[string]interface{}{}
+	account, err := findAccountById(ctx, input.Id, accountsRepo)
+	if err != nil {
+		return nil, err
+	}
+	before := *account
 
 	if input.FirstName != "" {
-		fields[models.FieldAccountFirstName] = input.FirstName
+		account.FirstName = input.FirstName
 	}
 	if input.LastName != "" {
-		fields[models.FieldAccountLastName] = input.LastName
+		account.LastName = input.LastName
+	}
+	account.FullName = account.GetFullName()
+
+	if input.Locale != "" {
+		if !models.IsValidLocale(input.Locale) {
+			return nil, errors.New("invalid locale")
+		}
+		account.Locale = input.Locale
+	}
+
+	updatedAccount, err := accountsRepo.Update(ctx, *account)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionAccountUpdated,
+		TargetType: audit.TargetAccount,
+		TargetId:   updatedAccount.GetId(),
+		Before:     before,
+		After:      updatedAccount,
+	})
+
+	return &updatedAccount, nil
+}
+
+// ChangePassword revokes every other session of the account and returns it with a fresh token.
+func (s *AccountsService) ChangePassword(ctx context.Context,
+	input ChangePasswordInput,
+	accountsRepo *repository.Repository[models.Account],
+) (*models.Account, error) {
+	if input.NewPassword == "" {
+		return nil, errors.New("password is required")
+	}
+
+	account, err := findAccountById(ctx, input.AccountId, accountsRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = confirmIdentity(account, input.Reauthentication); err != nil {
+		return nil, err
+	}
+	before := *account
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(input.NewPassword), 8)
+	if err != nil {
+		return nil, errors.New("couldn't generate password")
+	}
+
+	account.Password = string(passwordHash)
+	account.PasswordReset = nil
+	account.SessionVersion++
+
+	updatedAccount, err := accountsRepo.Update(ctx, *account)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionPasswordChanged,
+		TargetType: audit.TargetAccount,
+		TargetId:   updatedAccount.GetId(),
+		Before:     before,
+		After:      updatedAccount,
+	})
+
+	token, err := s.generateSignedToken(ctx, updatedAccount.GetAccountInfo(), updatedAccount.SessionVersion, sessionTokenTTL)
+	if err != nil {
+		return nil, errors.New("an error occurred: " + err.Error())
+	}
+
+	updatedAccount.Token = token
+
+	return &updatedAccount, nil
+}
+
+// ChangeEmail doesn't change the email straight away, it sends a verification link to the new
+// address and the change is applied by VerifyEmail.
+func (s *AccountsService) ChangeEmail(ctx context.Context,
+	input ChangeEmailInput,
+	accountsRepo *repository.Repository[models.Account],
+	publisher publisher.PublishInterface,
+) error {
+	if input.NewEmail == "" {
+		return errors.New("email is required")
+	}
+
+	account, err := findAccountById(ctx, input.AccountId, accountsRepo)
+	if err != nil {
+		return err
+	}
+
+	if err = confirmIdentity(account, input.Reauthentication); err != nil {
+		return err
+	}
+
+	if input.NewEmail == account.Email {
+		return errors.New("this is already your email address")
+	}
+
+	err = ensureEmailAvailable(ctx, input.NewEmail, accountsRepo)
+	if err != nil {
+		return err
+	}
+
+	before := *account
+	account.PendingEmail = input.NewEmail
+
+	_, err = accountsRepo.Update(ctx, *account)
+	if err != nil {
+		return err
+	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionEmailChangeRequested,
+		TargetType: audit.TargetAccount,
+		TargetId:   account.GetId(),
+		Before:     before,
+		After:      account,
+	})
+
+	return s.publishVerificationEmail(ctx, *account, account.PendingEmail, publisher)
+}
+
+// StartPhoneVerification texts a code to the number the account wants alerts sent to. The number only
+// replaces the account's phone once VerifyPhone confirms the code.
+func (s *AccountsService) StartPhoneVerification(ctx context.Context,
+	input StartPhoneVerificationInput,
+	accountsRepo *repository.Repository[models.Account],
+	publisher publisher.PublishInterface,
+) error {
+	if !models.IsValidPhoneNumber(input.Phone) {
+		return errors.New("phone must be an international number like +2348012345678")
+	}
+
+	account, err := findAccountById(ctx, input.AccountId, accountsRepo)
+	if err != nil {
+		return err
+	}
+
+	if account.HasVerifiedPhone() && account.Phone == input.Phone {
+		return errors.New("this number is already verified")
+	}
+
+	now := time.Now().UTC()
+	if pending := account.PhoneVerification; pending != nil {
+		sentAt := pending.ExpiresAt.Add(-phoneVerificationCodeTTL)
+		if now.Before(sentAt.Add(phoneVerificationResendDelay)) {
+			return errors.New("a code was sent recently, please wait a minute before asking for another")
+		}
+	}
+
+	code, err := utils.RandomNumericCode(phoneVerificationCodeLength)
+	if err != nil {
+		return errors.New("failed to generate verification code")
+	}
+
+	before := *account
+	account.PhoneVerification = &models.PhoneVerification{
+		Phone:     input.Phone,
+		CodeHash:  utils.HashToken(code),
+		ExpiresAt: now.Add(phoneVerificationCodeTTL),
+	}
+
+	_, err = accountsRepo.Update(ctx, *account)
+	if err != nil {
+		return err
+	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionPhoneVerificationRequested,
+		TargetType: audit.TargetAccount,
+		TargetId:   account.GetId(),
+		Before:     before,
+		After:      account,
+		Metadata:   map[string]string{"phone": input.Phone},
+	})
+
+	event, err := events.Encode(notifications.PhoneVerificationNotification, notifications.PhoneVerificationPayload{
+		Id:               account.ID.Hex(),
+		Phone:            input.Phone,
+		Locale:           account.Locale,
+		Code:             code,
+		ExpiresInMinutes: int(phoneVerificationCodeTTL / time.Minute),
+	})
+	if err != nil {
+		return err
+	}
+	return publisher.Publish(ctx, notifications.PhoneVerificationNotification, "notification", event)
+}
+
+// VerifyPhone confirms the code sent by StartPhoneVerification and makes its number the account's phone.
+func (s *AccountsService) VerifyPhone(ctx context.Context,
+	input VerifyPhoneInput,
+	accountsRepo *repository.Repository[models.Account],
+) (*models.Account, error) {
+	if input.Code == "" {
+		return nil, ErrInvalidPhoneCode
+	}
+
+	account, err := findAccountById(ctx, input.AccountId, accountsRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := account.PhoneVerification
+	if pending == nil || time.Now().UTC().After(pending.ExpiresAt) || pending.Attempts >= maxPhoneVerificationAttempts {
+		return nil, ErrInvalidPhoneCode
+	}
+
+	if !utils.CompareTokenHash(pending.CodeHash, input.Code) {
+		pending.Attempts++
+		if pending.Attempts >= maxPhoneVerificationAttempts {
+			account.PhoneVerification = nil
+		}
+		if _, err = accountsRepo.Update(ctx, *account); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidPhoneCode
+	}
+
+	now := time.Now().UTC()
+	before := *account
+	account.Phone = pending.Phone
+	account.PhoneVerifiedAt = &now
+	account.PhoneVerification = nil
+
+	updatedAccount, err := accountsRepo.Update(ctx, *account)
+	if err != nil {
+		return nil, err
 	}
-	if input.Email != "" {
-		fields[models.FieldAccountEmail] = input.Email
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionPhoneVerified,
+		TargetType: audit.TargetAccount,
+		TargetId:   updatedAccount.GetId(),
+		Before:     before,
+		After:      updatedAccount,
+	})
+
+	return &updatedAccount, nil
+}
+
+// RemovePhone stops SMS alerts by clearing the account's phone and any verification in progress.
+func (s *AccountsService) RemovePhone(ctx context.Context,
+	input RemovePhoneInput,
+	accountsRepo *repository.Repository[models.Account],
+) (*models.Account, error) {
+
+	account, err := findAccountById(ctx, input.AccountId, accountsRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	if account.Phone == "" && account.PhoneVerification == nil {
+		return nil, errors.New("no phone number is set")
+	}
+
+	before := *account
+	account.Phone = ""
+	account.PhoneVerifiedAt = nil
+	account.PhoneVerification = nil
+
+	updatedAccount, err := accountsRepo.Update(ctx, *account)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionPhoneRemoved,
+		TargetType: audit.TargetAccount,
+		TargetId:   updatedAccount.GetId(),
+		Before:     before,
+		After:      updatedAccount,
+	})
+
+	return &updatedAccount, nil
+}
+
+// DeleteAccount removes the account along with its sensors, devices and api keys.
+func (s *AccountsService) DeleteAccount(ctx context.Context,
+	input DeleteAccountInput,
+	accountsRepo *repository.Repository[models.Account],
+	sensorRepo *repository.Repository[models.Sensor],
+	devicesRepo *repository.Repository[models.Devices],
+	apiKeysRepo *repository.Repository[models.ApiKey],
+	preferencesRepo *repository.Repository[models.NotificationPreferences],
+	inboxRepo *repository.Repository[models.InboxNotification],
+	webhooksRepo *repository.Repository[models.WebhookSubscription],
+	deliveriesRepo *repository.Repository[models.WebhookDelivery],
+) error {
+
+	account, err := findAccountById(ctx, input.AccountId, accountsRepo)
+	if err != nil {
+		return err
+	}
+
+	if err = confirmIdentity(account, input.Reauthentication); err != nil {
+		return err
+	}
+
+	owned := repository.NewQueryFilter().AddFilter(models.FieldAccountInfoId, account.GetId())
+
+	if err = sensorRepo.DeleteMany(ctx, owned); err != nil {
+		return err
+	}
+	if err = devicesRepo.DeleteMany(ctx, owned); err != nil {
+		return err
+	}
+	if err = apiKeysRepo.DeleteMany(ctx, owned); err != nil {
+		return err
+	}
+	if err = preferencesRepo.DeleteMany(ctx, owned); err != nil {
+		return err
+	}
+	if err = inboxRepo.DeleteMany(ctx, owned); err != nil {
+		return err
+	}
+
+	// subscriptions made for the organisation belong to it and outlive the account
+	personal := repository.NewQueryFilter().
+		AddFilter(models.FieldAccountInfoId, account.GetId()).
+		AddFilter(models.FieldWebhookOrganisationId, map[string]interface{}{"$exists": false})
+	webhooks, err := webhooksRepo.Find(ctx, personal, nil, nil)
+	if err != nil {
+		return err
+	}
+	if len(webhooks) > 0 {
+		webhookIds := make([]string, 0, len(webhooks))
+		for _, webhook := range webhooks {
+			webhookIds = append(webhookIds, webhook.GetId())
+		}
+		deliveries := repository.NewQueryFilter().AddFilter(models.FieldDeliverySubscriptionId, map[string]interface{}{"$in": webhookIds})
+		if err = deliveriesRepo.DeleteMany(ctx, deliveries); err != nil {
+			return err
+		}
+		if err = webhooksRepo.DeleteMany(ctx, personal); err != nil {
+			return err
+		}
+	}
+
+	err = accountsRepo.DeleteMany(ctx, repository.NewQueryFilter().AddFilter(models.FieldId, account.ID))
+	if err != nil {
+		return err
 	}
 
-	updates := map[string]interface{}{
-		"$set": fields,
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionAccountDeleted,
+		TargetType: audit.TargetAccount,
+		TargetId:   account.GetId(),
+		Before:     account,
+	})
+
+	return nil
+}
+
+// ExportAccountData returns a zip archive of everything stored about the account.
+func (s *AccountsService) ExportAccountData(ctx context.Context,
+	input ExportAccountDataInput,
+	accountsRepo *repository.Repository[models.Account],
+	sensorRepo *repository.Repository[models.Sensor],
+	devicesRepo *repository.Repository[models.Devices],
+	apiKeysRepo *repository.Repository[models.ApiKey],
+	preferencesRepo *repository.Repository[models.NotificationPreferences],
+	inboxRepo *repository.Repository[models.InboxNotification],
+	webhooksRepo *repository.Repository[models.WebhookSubscription],
+	deliveriesRepo *repository.Repository[models.WebhookDelivery],
+) ([]byte, error) {
+
+	account, err := findAccountById(ctx, input.AccountId, accountsRepo)
+	if err != nil {
+		return nil, err
 	}
 
-	filter := repository.NewQueryFilter().AddFilter(models.FieldId, input.Id)
-	err := accountsRepo.UpdateMany(ctx, filter, updates)
+	owned := repository.NewQueryFilter().AddFilter(models.FieldAccountInfoId, account.GetId())
+
+	sensors, err := sensorRepo.Find(ctx, owned, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := devicesRepo.Find(ctx, owned, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	apiKeys, err := apiKeysRepo.Find(ctx, owned, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	preferences, err := preferencesRepo.Find(ctx, owned, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	inbox, err := inboxRepo.Find(ctx, owned, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	webhooks, err := webhooksRepo.Find(ctx, owned, nil, nil)
 	if err != nil {
 		return nil, err
 	}
+	// deliveries to organisation subscriptions carry other members' events, so only personal ones are included
+	webhookIds := make([]string, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		if webhook.OrganisationId == "" {
+			webhookIds = append(webhookIds, webhook.GetId())
+		}
+	}
+	deliveries, err := deliveriesRepo.Find(ctx, repository.NewQueryFilter().AddFilter(models.FieldDeliverySubscriptionId, map[string]interface{}{"$in": webhookIds}), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	type exportFile struct {
+		name string
+		data any
+	}
+
+	files := []exportFile{
+		{"account.json", account},
+		{"sensors.json", sensors},
+		{"devices.json", devices},
+		{"api_keys.json", apiKeys},
+		{"notification_preferences.json", preferences},
+		{"inbox.json", inbox},
+		{"webhooks.json", webhooks},
+		{"webhook_deliveries.json", deliveries},
+	}
+	// the number being verified isn't part of the account's json, only its verified phone is
+	if account.PhoneVerification != nil {
+		files = append(files, exportFile{"phone_verification.json", account.PhoneVerification})
+	}
+
+	buf := &bytes.Buffer{}
+	archive := zip.NewWriter(buf)
+
+	for _, file := range files {
+		w, err := archive.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(file.data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = archive.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// confirmIdentity checks the account holder is present before a sensitive change. Accounts created
+// through single sign-on have no password, so they need a two factor code or a fresh sign in.
+// A used two factor code is only recorded once the caller saves the account.
+func confirmIdentity(account *models.Account, reauth Reauthentication) error {
+	if account.Password != "" {
+		err := bcrypt.CompareHashAndPassword([]byte(account.Password), []byte(reauth.Password))
+		if err != nil {
+			return ErrInvalidCredentials
+		}
+		return nil
+	}
+
+	if reauth.TwoFactorCode != "" && account.HasTwoFactor() {
+		if !verifySecondFactor(account, reauth.TwoFactorCode) {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
 
-	return nil, nil
+	if reauth.SsoAuthenticatedAt != nil && time.Since(*reauth.SsoAuthenticatedAt) <= ssoReauthenticationWindow {
+		return nil
+	}
+	return ErrReauthenticationNeeded
+}
+
+func ensureEmailAvailable(ctx context.Context, email string, accountsRepo *repository.Repository[models.Account]) error {
+	qf := repository.NewQueryFilter().AddFilter(models.FieldAccountEmail, email)
+
+	_, err := accountsRepo.FindOne(ctx, qf, nil, nil)
+	if err == nil {
+		return errors.New("user with this email already exists")
+	}
+	if err != repository.NoDocumentsFound {
+		return err
+	}
+	return nil
 }
 
 func (s *AccountsService) LoginUser(ctx context.Context,
 	input LoginUserInput,
 	accountsRepo *repository.Repository[models.Account],
+	loginLimiter *limiter.LoginLimiter,
+	publisher publisher.PublishInterface,
 ) (*models.Account, error) {
 
+	retryAfter, err := loginLimiter.RetryAfter(ctx, input.Email, input.IpAddress)
+	if err != nil {
+		return nil, err
+	}
+	if retryAfter > 0 {
+		return nil, ErrTooManyLoginAttempts
+	}
+
 	filter := repository.NewQueryFilter().AddFilter(models.FieldAccountEmail, input.Email)
 
 	account, err := accountsRepo.FindOne(ctx, filter, nil, nil)
-	if err != nil {
-		if err == repository.NoDocumentsFound {
-			return nil, errors.New("account not found")
-		}
+	if err != nil && err != repository.NoDocumentsFound {
 		return nil, err
 	}
-	err = bcrypt.CompareHashAndPassword([]byte(account.Password), []byte(input.Password))
+	found := err == nil
+
+	passwordHash := dummyPasswordHash
+	if found {
+		passwordHash = []byte(account.Password)
+	}
+
+	err = bcrypt.CompareHashAndPassword(passwordHash, []byte(input.Password))
+	if err != nil || !found {
+		locked, err := loginLimiter.Failed(ctx, input.Email, input.IpAddress)
+		if err != nil {
+			return nil, err
+		}
+		if locked && found {
+			s.auditLog.Record(ctx, audit.Entry{
+				Action:     audit.ActionAccountLocked,
+				TargetType: audit.TargetAccount,
+				TargetId:   account.GetId(),
+				Actor:      &models.AccountInfo{},
+			})
+			s.publishAccountLocked(ctx, account, loginLimiter.LockoutDuration(), publisher)
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	err = loginLimiter.Succeeded(ctx, input.Email)
 	if err != nil {
-		return nil, errors.New("incorrect password")
+		return nil, err
 	}
 
-	token, err := s.generateSignedToken(ctx, models.AccountInfo{
-		Id:        account.ID.Hex(),
-		FirstName: account.FirstName,
-		LastName:  account.LastName,
-		FullName:  account.FullName,
-		Email:     account.Email,
-	})
+	if account.IsSuspended() {
+		return nil, ErrAccountSuspended
+	}
+
+	if account.HasTwoFactor() {
+		challenge, err := s.generateTwoFactorChallenge(ctx, account)
+		if err != nil {
+			return nil, errors.New("an error occurred: " + err.Error())
+		}
+
+		account.ChallengeToken = challenge
+		return &account, nil
+	}
+
+	token, err := s.generateSignedToken(ctx, account.GetAccountInfo(), account.SessionVersion, sessionTokenTTL)
 	if err != nil {
 		return nil, errors.New("an error occurred: " + err.Error())
 	}
@@ -194,68 +953,209 @@ func (s *AccountsService) LoginUser(ctx context.Context,
 	return &account, nil
 }
 
+func (s *AccountsService) publishAccountLocked(ctx context.Context,
+	account models.Account,
+	lockout time.Duration,
+	publisher publisher.PublishInterface,
+) {
+	event, err := events.Encode(notifications.AccountLockedNotification, notifications.AccountLockedPayload{
+		Id:          account.ID.Hex(),
+		FirstName:   account.FirstName,
+		LastName:    account.LastName,
+		FullName:    account.FullName,
+		Email:       account.Email,
+		Locale:      account.Locale,
+		LockedUntil: time.Now().UTC().Add(lockout).Format(time.RFC3339),
+	})
+	if err == nil {
+		err = publisher.Publish(ctx, notifications.AccountLockedNotification, "notification", event)
+	}
+	if err != nil {
+		// the lockout itself has already been applied, a missing email shouldn't fail the request
+		log.Printf("failed to publish account locked event: %s", err)
+	}
+}
+
 func (s *AccountsService) ForgotPassword(ctx context.Context,
 	input ForgotPasswordInput,
 	accountsRepo *repository.Repository[models.Account],
+	resetLimiter *limiter.RateLimiter,
 	publisher publisher.PublishInterface,
-) (*models.Account, error) {
+) error {
+
+	err := allowPasswordReset(ctx, resetLimiter, input.Email, input.IpAddress)
+	if err != nil {
+		return err
+	}
 
 	filter := repository.NewQueryFilter().AddFilter(models.FieldAccountEmail, input.Email)
 
 	account, err := accountsRepo.FindOne(ctx, filter, nil, nil)
 	if err != nil {
-		return nil, err
+		if err == repository.NoDocumentsFound {
+			// don't reveal whether an account exists for this email
+			return nil
+		}
+		return err
+	}
+
+	now := time.Now().UTC()
+	if account.PasswordResetLocked(now) {
+		// nothing is sent until the lockout ends, without revealing it to whoever asked
+		return nil
+	}
+
+	code, err := utils.RandomNumericCode(passwordResetCodeLength)
+	if err != nil {
+		return errors.New("failed to generate reset code")
 	}
 
-	event := map[string]interface{}{
-		"id":         account.ID.Hex(),
-		"first_name": account.FirstName,
-		"last_name":  account.LastName,
-		"full_name":  account.FullName,
-		"email":      account.Email,
-		"code":       rand.Int(),
+	// a new code keeps the wrong guesses made at earlier ones, the budget only starts again after a lockout
+	reset := account.PasswordReset
+	if reset == nil || reset.LockedUntil != nil {
+		reset = &models.PasswordReset{}
+	}
+	reset.CodeHash = utils.HashToken(code)
+	reset.ExpiresAt = now.Add(passwordResetCodeTTL)
+	account.PasswordReset = reset
+
+	_, err = accountsRepo.Update(ctx, account)
+	if err != nil {
+		return err
+	}
+
+	// the event is kept by the queue and any dead letters, so only the listener can read the code
+	sealer, err := events.NewSealer(s.conf.GetAsString(env.EventSecretKey))
+	if err != nil {
+		return err
+	}
+	sealedCode, err := sealer.Seal(code)
+	if err != nil {
+		return errors.New("failed to seal reset code")
+	}
+
+	event, err := events.Encode(notifications.ForgotPasswordNotification, notifications.ForgotPasswordPayload{
+		Id:         account.ID.Hex(),
+		FirstName:  account.FirstName,
+		LastName:   account.LastName,
+		FullName:   account.FullName,
+		Email:      account.Email,
+		Locale:     account.Locale,
+		SealedCode: sealedCode,
+	})
+	if err != nil {
+		return err
 	}
 	err = publisher.Publish(ctx, notifications.ForgotPasswordNotification, "notification", event)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &account, nil
+	return nil
 }
 
 func (s *AccountsService) ResetPassword(ctx context.Context,
 	input ResetPasswordInput,
 	accountsRepo *repository.Repository[models.Account],
+	resetLimiter *limiter.RateLimiter,
 ) (*models.Account, error) {
+	if input.Email == "" || input.ResetCode == "" {
+		return nil, ErrInvalidResetCode
+	}
+	if input.NewPassword == "" {
+		return nil, errors.New("password is required")
+	}
 
-	accountInfo := &models.AccountInfo{}
-	err := s.verifySignedToken(ctx, input.ResetToken, accountInfo)
+	err := allowPasswordReset(ctx, resetLimiter, input.Email, input.IpAddress)
 	if err != nil {
 		return nil, err
 	}
 
-	filter := repository.NewQueryFilter().AddFilter(models.FieldAccountEmail, accountInfo.Email)
+	filter := repository.NewQueryFilter().AddFilter(models.FieldAccountEmail, input.Email)
 
 	account, err := accountsRepo.FindOne(ctx, filter, nil, nil)
 	if err != nil {
+		if err == repository.NoDocumentsFound {
+			return nil, ErrInvalidResetCode
+		}
 		return nil, err
 	}
 
+	now := time.Now().UTC()
+	reset := account.PasswordReset
+	if reset == nil || reset.CodeHash == "" || account.PasswordResetLocked(now) || now.After(reset.ExpiresAt) {
+		return nil, ErrInvalidResetCode
+	}
+
+	if !utils.CompareTokenHash(reset.CodeHash, input.ResetCode) {
+		reset.Attempts++
+		locked := reset.Attempts >= maxPasswordResetAttempts
+		if locked {
+			lockedUntil := now.Add(passwordResetLockout)
+			reset.CodeHash = ""
+			reset.LockedUntil = &lockedUntil
+		}
+		if _, err = accountsRepo.Update(ctx, account); err != nil {
+			return nil, err
+		}
+		if locked {
+			s.auditLog.Record(ctx, audit.Entry{
+				Action:     audit.ActionAccountLocked,
+				TargetType: audit.TargetAccount,
+				TargetId:   account.GetId(),
+				Actor:      &models.AccountInfo{},
+			})
+		}
+		return nil, ErrInvalidResetCode
+	}
+
 	passwordHash, err := bcrypt.GenerateFromPassword([]byte(input.NewPassword), 8)
 	if err != nil {
 		return nil, errors.New("couldn't generate password")
 	}
 
+	before := account
 	account.Password = string(passwordHash)
+	account.PasswordReset = nil
+	account.SessionVersion++
 
 	updatedAccount, err := accountsRepo.Update(ctx, account)
 	if err != nil {
 		return nil, err
 	}
 
+	info := updatedAccount.GetAccountInfo()
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionPasswordReset,
+		TargetType: audit.TargetAccount,
+		TargetId:   updatedAccount.GetId(),
+		Before:     before,
+		After:      updatedAccount,
+		Actor:      &info,
+	})
+
 	return &updatedAccount, nil
 }
 
+// allowPasswordReset counts a forgot or reset password request against the email and the client IP,
+// so neither one address nor one client can keep asking for codes or guessing them.
+func allowPasswordReset(ctx context.Context, resetLimiter *limiter.RateLimiter, email, ip string) error {
+	if resetLimiter == nil {
+		return nil
+	}
+
+	for _, key := range []string{"email:" + strings.ToLower(email), "ip:" + ip} {
+		allowed, err := resetLimiter.Allow(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return ErrTooManyResetAttempts
+		}
+	}
+	return nil
+}
+
 func (s *AccountsService) ListAccounts(ctx context.Context,
 	input ListAccountReportsInput,
 	accountsRepo *repository.Repository[models.Account],
@@ -283,12 +1183,24 @@ func (s *AccountsService) ListAccounts(ctx context.Context,
 	return account, paginator, nil
 }
 
-func (s *AccountsService) generateSignedToken(ctx context.Context, content any) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
-		Exp:           time.Now().Add(3600 * time.Minute),
-		Authorization: true,
-		Content:       content,
-	})
+func (s *AccountsService) generateSignedToken(ctx context.Context, content any, sessionVersion int, ttl time.Duration) (string, error) {
+	return s.signClaims(&Claims{
+		Authorization:  true,
+		SessionVersion: sessionVersion,
+		Content:        content,
+	}, ttl)
+}
+
+func (s *AccountsService) signClaims(claims *Claims, ttl time.Duration) (string, error) {
+	now := time.Now()
+
+	claims.Exp = now.Add(ttl)
+	claims.StandardClaims = jwt.StandardClaims{
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	pkey := s.conf.GetAsBytes(env.JwtSecret)
 	tokenString, err := token.SignedString(pkey)
@@ -300,9 +1212,15 @@ func (s *AccountsService) generateSignedToken(ctx context.Context, content any)
 }
 
 func (s *AccountsService) verifySignedToken(ctx context.Context, token string, target any) error {
+	_, err := s.parseSignedToken(ctx, token, target)
+	return err
+}
+
+// parseSignedToken verifies token, decoding its content into target, and returns the full claims.
+func (s *AccountsService) parseSignedToken(ctx context.Context, token string, target any) (*Claims, error) {
 
-	if token != "" {
-		return errors.New("token not set")
+	if token == "" {
+		return nil, errors.New("token not set")
 	}
 	claims := &Claims{
 		Content: target,
@@ -315,8 +1233,8 @@ func (s *AccountsService) verifySignedToken(ctx context.Context, token string, t
 		return s.conf.GetAsBytes(env.JwtSecret), nil
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return claims, nil
 }
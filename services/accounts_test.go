@@ -0,0 +1,1065 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/consumer"
+	"github.com/tejiriaustin/narx_api/database"
+	"github.com/tejiriaustin/narx_api/events"
+	"github.com/tejiriaustin/narx_api/events/notifications"
+	"github.com/tejiriaustin/narx_api/limiter"
+	"github.com/tejiriaustin/narx_api/messaging"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+const resetEmail = "ada@example.com"
+
+// resetCodes returns the codes of the forgot password emails published to queue, oldest first.
+func resetCodes(t *testing.T, queue *consumer.MemoryQueue) []string {
+	t.Helper()
+
+	var codes []string
+	for _, event := range queue.Published() {
+		if event.EventKey != notifications.ForgotPasswordNotification {
+			continue
+		}
+
+		var payload notifications.ForgotPasswordPayload
+		if err := events.Decode(event, &payload); err != nil {
+			t.Fatalf("forgot password event: %v", err)
+		}
+		code, err := newTestSealer(t).Open(payload.SealedCode)
+		if err != nil {
+			t.Fatalf("forgot password code: %v", err)
+		}
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+func forgotPassword(t *testing.T, s *AccountsService, repos testRepos, queue *consumer.MemoryQueue) string {
+	t.Helper()
+
+	err := s.ForgotPassword(context.Background(), ForgotPasswordInput{Email: resetEmail}, repos.accounts, nil, queue)
+	if err != nil {
+		t.Fatalf("ForgotPassword() error = %v", err)
+	}
+
+	codes := resetCodes(t, queue)
+	if len(codes) == 0 {
+		t.Fatal("no reset code was sent")
+	}
+	return codes[len(codes)-1]
+}
+
+func resetPassword(s *AccountsService, repos testRepos, code string) error {
+	_, err := s.ResetPassword(context.Background(), ResetPasswordInput{
+		Email:       resetEmail,
+		ResetCode:   code,
+		NewPassword: "a new password",
+	}, repos.accounts, nil)
+	return err
+}
+
+func TestResetPassword(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// prepare returns the code to reset with, having asked for one
+		prepare func(t *testing.T, s *AccountsService, repos testRepos, queue *consumer.MemoryQueue) string
+
+		wantErr error
+	}{
+		{
+			name: "code sent by email",
+			prepare: func(t *testing.T, s *AccountsService, repos testRepos, queue *consumer.MemoryQueue) string {
+				return forgotPassword(t, s, repos, queue)
+			},
+		},
+		{
+			name: "wrong code",
+			prepare: func(t *testing.T, s *AccountsService, repos testRepos, queue *consumer.MemoryQueue) string {
+				return wrongCode(forgotPassword(t, s, repos, queue))
+			},
+			wantErr: ErrInvalidResetCode,
+		},
+		{
+			name: "code replaced by a newer one",
+			prepare: func(t *testing.T, s *AccountsService, repos testRepos, queue *consumer.MemoryQueue) string {
+				first := forgotPassword(t, s, repos, queue)
+				if second := forgotPassword(t, s, repos, queue); second == first {
+					t.Skip("the same code was issued twice")
+				}
+				return first
+			},
+			wantErr: ErrInvalidResetCode,
+		},
+		{
+			name: "expired code",
+			prepare: func(t *testing.T, s *AccountsService, repos testRepos, queue *consumer.MemoryQueue) string {
+				code := forgotPassword(t, s, repos, queue)
+
+				account := findTestAccount(t, repos.accounts, resetEmail)
+				account.PasswordReset.ExpiresAt = time.Now().UTC().Add(-time.Minute)
+				if _, err := repos.accounts.Update(context.Background(), account); err != nil {
+					t.Fatal(err)
+				}
+				return code
+			},
+			wantErr: ErrInvalidResetCode,
+		},
+		{
+			name: "code already used",
+			prepare: func(t *testing.T, s *AccountsService, repos testRepos, queue *consumer.MemoryQueue) string {
+				code := forgotPassword(t, s, repos, queue)
+				if err := resetPassword(s, repos, code); err != nil {
+					t.Fatalf("first reset error = %v", err)
+				}
+				return code
+			},
+			wantErr: ErrInvalidResetCode,
+		},
+		{
+			name: "no code asked for",
+			prepare: func(t *testing.T, s *AccountsService, repos testRepos, queue *consumer.MemoryQueue) string {
+				return "123456"
+			},
+			wantErr: ErrInvalidResetCode,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repos := newTestRepos()
+			s := newTestAccountsService(repos)
+			queue := consumer.NewMemoryQueue()
+			before := createTestAccount(t, repos.accounts, resetEmail)
+
+			code := tt.prepare(t, s, repos, queue)
+
+			err := resetPassword(s, repos, code)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ResetPassword() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			after := findTestAccount(t, repos.accounts, resetEmail)
+			if after.Password == before.Password {
+				t.Error("password wasn't changed")
+			}
+			if after.SessionVersion <= before.SessionVersion {
+				t.Error("existing sessions weren't revoked")
+			}
+			if after.PasswordReset != nil {
+				t.Error("reset code wasn't invalidated")
+			}
+		})
+	}
+}
+
+func TestForgotPasswordEmail(t *testing.T) {
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+	queue := consumer.NewMemoryQueue()
+	mailer := messaging.NewMemorySink()
+	createTestAccount(t, repos.accounts, resetEmail)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	listener := consumer.NewConsumer(consumer.WithRefreshTime(5*time.Millisecond)).
+		SetHandler(notifications.ForgotPasswordNotification, notifications.ForgotPasswordNotificationEventHandler(mailer, newTestSealer(t), repository.NewRepository[models.NotificationPreferences](database.NewMemoryCollection())))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		listener.ListenAndServeMemory(ctx, queue)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	code := forgotPassword(t, s, repos, queue)
+
+	// the queue drops the event once the listener has emailed it
+	deadline := time.Now().Add(5 * time.Second)
+	for len(queue.Published()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("forgot password event was not handled")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	sent := mailer.SentTo(resetEmail)
+	if len(sent) != 1 {
+		t.Fatalf("%d emails sent, want 1", len(sent))
+	}
+	if !strings.Contains(sent[0].Text, code) {
+		t.Errorf("email doesn't contain the reset code %s:\n%s", code, sent[0].Text)
+	}
+	if err := resetPassword(s, repos, code); err != nil {
+		t.Errorf("ResetPassword() with the emailed code error = %v", err)
+	}
+}
+
+func TestForgotPasswordEventKeepsCodeSealed(t *testing.T) {
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+	queue := consumer.NewMemoryQueue()
+	createTestAccount(t, repos.accounts, resetEmail)
+
+	code := forgotPassword(t, s, repos, queue)
+
+	// the body is what the queue, the stream and any dead letter keep
+	published := queue.Published()
+	if len(published) != 1 {
+		t.Fatalf("%d events published, want 1", len(published))
+	}
+	body, err := json.Marshal(published[0].MsgBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(body), code) {
+		t.Errorf("event body holds the reset code %s in the clear: %s", code, body)
+	}
+
+	var payload notifications.ForgotPasswordPayload
+	if err = events.Decode(published[0], &payload); err != nil {
+		t.Fatal(err)
+	}
+	other, err := events.NewSealer("another secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.Open(payload.SealedCode); !errors.Is(err, events.ErrUnsealable) {
+		t.Errorf("Open() with another key error = %v, want %v", err, events.ErrUnsealable)
+	}
+}
+
+func TestPasswordResetGuessBudget(t *testing.T) {
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+	queue := consumer.NewMemoryQueue()
+	createTestAccount(t, repos.accounts, resetEmail)
+
+	// every code but the last is abandoned after a wrong guess, so a fresh code must not mean fresh guesses
+	var code string
+	for i := 0; i < maxPasswordResetAttempts; i++ {
+		code = forgotPassword(t, s, repos, queue)
+		if err := resetPassword(s, repos, wrongCode(code)); !errors.Is(err, ErrInvalidResetCode) {
+			t.Fatalf("guess %d error = %v, want %v", i+1, err, ErrInvalidResetCode)
+		}
+	}
+
+	account := findTestAccount(t, repos.accounts, resetEmail)
+	if !account.PasswordResetLocked(time.Now().UTC()) {
+		t.Fatalf("resets not locked after %d wrong guesses across reissued codes: %+v", maxPasswordResetAttempts, account.PasswordReset)
+	}
+	if err := resetPassword(s, repos, code); !errors.Is(err, ErrInvalidResetCode) {
+		t.Errorf("reset with the right code while locked error = %v, want %v", err, ErrInvalidResetCode)
+	}
+
+	sent := len(resetCodes(t, queue))
+	if err := s.ForgotPassword(context.Background(), ForgotPasswordInput{Email: resetEmail}, repos.accounts, nil, queue); err != nil {
+		t.Fatalf("ForgotPassword() while locked error = %v", err)
+	}
+	if got := len(resetCodes(t, queue)); got != sent {
+		t.Error("a new code was sent while resets are locked")
+	}
+
+	// once the lockout ends the account can be reset again
+	lockedUntil := time.Now().UTC().Add(-time.Minute)
+	account.PasswordReset.LockedUntil = &lockedUntil
+	if _, err := repos.accounts.Update(context.Background(), account); err != nil {
+		t.Fatal(err)
+	}
+	if err := resetPassword(s, repos, forgotPassword(t, s, repos, queue)); err != nil {
+		t.Errorf("ResetPassword() after the lockout error = %v", err)
+	}
+}
+
+func TestPasswordResetRateLimit(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// requests are the email and client IP of each forgot password request, in order
+		requests [][2]string
+
+		wantLimited []bool
+	}{
+		{
+			name:        "within the limit",
+			requests:    [][2]string{{resetEmail, "203.0.113.7"}, {resetEmail, "203.0.113.7"}},
+			wantLimited: []bool{false, false},
+		},
+		{
+			name:        "limited per email",
+			requests:    [][2]string{{resetEmail, "203.0.113.7"}, {resetEmail, "198.51.100.1"}, {"Ada@Example.com", "192.0.2.44"}},
+			wantLimited: []bool{false, false, true},
+		},
+		{
+			name:        "limited per client",
+			requests:    [][2]string{{"ada@example.com", "203.0.113.7"}, {"obi@example.com", "203.0.113.7"}, {"eze@example.com", "203.0.113.7"}},
+			wantLimited: []bool{false, false, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repos := newTestRepos()
+			s := newTestAccountsService(repos)
+			queue := consumer.NewMemoryQueue()
+			resetLimiter := limiter.NewRateLimiter(limiter.NewMemoryStore(), "password_reset", 2, time.Hour)
+
+			for i, request := range tt.requests {
+				err := s.ForgotPassword(context.Background(), ForgotPasswordInput{Email: request[0], IpAddress: request[1]}, repos.accounts, resetLimiter, queue)
+				if limited := errors.Is(err, ErrTooManyResetAttempts); limited != tt.wantLimited[i] {
+					t.Errorf("request %d limited = %v (error %v), want %v", i+1, limited, err, tt.wantLimited[i])
+				}
+			}
+		})
+	}
+
+	// guesses count against the same limits as asking for codes
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+	createTestAccount(t, repos.accounts, resetEmail)
+	resetLimiter := limiter.NewRateLimiter(limiter.NewMemoryStore(), "password_reset", 2, time.Hour)
+
+	input := ResetPasswordInput{Email: resetEmail, ResetCode: "123456", NewPassword: "a new password", IpAddress: "203.0.113.7"}
+	for i := 0; i < 2; i++ {
+		if _, err := s.ResetPassword(context.Background(), input, repos.accounts, resetLimiter); !errors.Is(err, ErrInvalidResetCode) {
+			t.Fatalf("guess %d error = %v, want %v", i+1, err, ErrInvalidResetCode)
+		}
+	}
+	if _, err := s.ResetPassword(context.Background(), input, repos.accounts, resetLimiter); !errors.Is(err, ErrTooManyResetAttempts) {
+		t.Errorf("third guess error = %v, want %v", err, ErrTooManyResetAttempts)
+	}
+}
+
+// wrongCode returns a code of the same length that isn't code.
+func wrongCode(code string) string {
+	wrong := []byte(code)
+	wrong[0] = '0' + (wrong[0]-'0'+1)%10
+	return string(wrong)
+}
+
+// verificationTokens returns the tokens of the verification links published to queue for email,
+// oldest first.
+func verificationTokens(t *testing.T, queue *consumer.MemoryQueue, email string) []string {
+	t.Helper()
+
+	var tokens []string
+	for _, event := range queue.Published() {
+		if event.EventKey != notifications.AccountCreatedNotification {
+			continue
+		}
+
+		var payload notifications.AccountCreatedPayload
+		if err := events.Decode(event, &payload); err != nil {
+			t.Fatalf("account created event: %v", err)
+		}
+		if payload.Email != email {
+			continue
+		}
+
+		link, err := url.Parse(payload.VerificationLink)
+		if err != nil {
+			t.Fatalf("verification link %q: %v", payload.VerificationLink, err)
+		}
+		tokens = append(tokens, link.Query().Get("token"))
+	}
+	return tokens
+}
+
+func TestEmailVerification(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+	queue := consumer.NewMemoryQueue()
+
+	account, err := s.CreateUser(ctx, AddAccountInput{
+		FirstName: "Ada",
+		LastName:  "Obi",
+		Email:     "ada@example.com",
+		Password:  testPassword,
+	}, nil, repos.accounts, queue)
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	if account.Status != models.PendingVerificationStatus {
+		t.Errorf("new account status = %s, want %s", account.Status, models.PendingVerificationStatus)
+	}
+
+	_, err = s.CreateUser(ctx, AddAccountInput{FirstName: "Ada", Email: "ada@example.com", Password: testPassword}, nil, repos.accounts, queue)
+	if err == nil {
+		t.Error("CreateUser() with a registered email succeeded")
+	}
+
+	tokens := verificationTokens(t, queue, "ada@example.com")
+	if len(tokens) != 1 {
+		t.Fatalf("%d verification links sent, want 1", len(tokens))
+	}
+
+	for _, token := range []string{"", "not-a-token", tokens[0] + "x"} {
+		if _, err = s.VerifyEmail(ctx, VerifyEmailInput{Token: token}, repos.accounts); !errors.Is(err, ErrInvalidVerificationLink) {
+			t.Errorf("VerifyEmail(%q) error = %v, want %v", token, err, ErrInvalidVerificationLink)
+		}
+	}
+
+	// a session token is signed with the same key but isn't a verification link
+	session, err := s.generateSignedToken(ctx, account.GetAccountInfo(), account.SessionVersion, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.VerifyEmail(ctx, VerifyEmailInput{Token: session}, repos.accounts); !errors.Is(err, ErrInvalidVerificationLink) {
+		t.Errorf("VerifyEmail() with a session token error = %v, want %v", err, ErrInvalidVerificationLink)
+	}
+
+	verified, err := s.VerifyEmail(ctx, VerifyEmailInput{Token: tokens[0]}, repos.accounts)
+	if err != nil {
+		t.Fatalf("VerifyEmail() error = %v", err)
+	}
+	if verified.Status != models.ActiveStatus {
+		t.Errorf("verified account status = %s, want %s", verified.Status, models.ActiveStatus)
+	}
+
+	// following the link again is harmless
+	if _, err = s.VerifyEmail(ctx, VerifyEmailInput{Token: tokens[0]}, repos.accounts); err != nil {
+		t.Errorf("VerifyEmail() a second time error = %v", err)
+	}
+
+	for _, email := range []string{"ada@example.com", "nobody@example.com"} {
+		if err = s.ResendVerification(ctx, ResendVerificationInput{Email: email}, repos.accounts, queue); err != nil {
+			t.Errorf("ResendVerification(%s) error = %v", email, err)
+		}
+	}
+	if got := len(queue.Published()); got != 1 {
+		t.Errorf("%d events published after resending to verified and unknown emails, want 1", got)
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+	queue := consumer.NewMemoryQueue()
+
+	_, err := s.CreateUser(ctx, AddAccountInput{FirstName: "Ada", Email: "ada@example.com", Password: testPassword}, nil, repos.accounts, queue)
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	if err = s.ResendVerification(ctx, ResendVerificationInput{Email: "ada@example.com"}, repos.accounts, queue); err != nil {
+		t.Fatalf("ResendVerification() error = %v", err)
+	}
+
+	tokens := verificationTokens(t, queue, "ada@example.com")
+	if len(tokens) != 2 {
+		t.Fatalf("%d verification links sent, want 2", len(tokens))
+	}
+
+	// either link works, whichever arrives first
+	verified, err := s.VerifyEmail(ctx, VerifyEmailInput{Token: tokens[0]}, repos.accounts)
+	if err != nil {
+		t.Fatalf("VerifyEmail() with the first link error = %v", err)
+	}
+	if verified.Status != models.ActiveStatus {
+		t.Errorf("verified account status = %s, want %s", verified.Status, models.ActiveStatus)
+	}
+}
+
+func TestEditAccount(t *testing.T) {
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+	account := createTestAccount(t, repos.accounts, "ada@example.com")
+
+	tests := []struct {
+		name     string
+		input    EditAccountInput
+		wantName string
+		wantErr  bool
+	}{
+		{name: "first name", input: EditAccountInput{FirstName: "Adaeze"}, wantName: "Adaeze Obi"},
+		{name: "locale", input: EditAccountInput{Locale: "fr"}, wantName: "Adaeze Obi"},
+		{name: "unknown locale", input: EditAccountInput{Locale: "not a locale"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.input.Id = account.GetId()
+			edited, err := s.EditAccount(context.Background(), tt.input, repos.accounts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EditAccount() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && edited.FullName != tt.wantName {
+				t.Errorf("EditAccount() full name = %q, want %q", edited.FullName, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+	account := createTestAccount(t, repos.accounts, "ada@example.com")
+
+	_, err := s.ChangePassword(ctx, ChangePasswordInput{
+		AccountId:        account.GetId(),
+		NewPassword:      "a new password",
+		Reauthentication: Reauthentication{Password: "a guess"},
+	}, repos.accounts)
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("ChangePassword() with the wrong password error = %v, want %v", err, ErrInvalidCredentials)
+	}
+
+	changed, err := s.ChangePassword(ctx, ChangePasswordInput{
+		AccountId:        account.GetId(),
+		NewPassword:      "a new password",
+		Reauthentication: Reauthentication{Password: testPassword},
+	}, repos.accounts)
+	if err != nil {
+		t.Fatalf("ChangePassword() error = %v", err)
+	}
+	if changed.SessionVersion != account.SessionVersion+1 {
+		t.Errorf("session version = %d, want %d so other sessions are signed out", changed.SessionVersion, account.SessionVersion+1)
+	}
+	if changed.Token == "" {
+		t.Error("ChangePassword() returned no new session token")
+	}
+
+	stored := findTestAccount(t, repos.accounts, "ada@example.com")
+	if bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte("a new password")) != nil {
+		t.Error("the new password doesn't match the stored hash")
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+	queue := consumer.NewMemoryQueue()
+	account := createTestAccount(t, repos.accounts, "ada@example.com")
+	createTestAccount(t, repos.accounts, "obi@example.com")
+
+	tests := []struct {
+		name     string
+		newEmail string
+		password string
+		wantErr  bool
+	}{
+		{name: "wrong password", newEmail: "ada@example.org", password: "a guess", wantErr: true},
+		{name: "same address", newEmail: "ada@example.com", password: testPassword, wantErr: true},
+		{name: "taken by another account", newEmail: "obi@example.com", password: testPassword, wantErr: true},
+		{name: "new address", newEmail: "ada@example.org", password: testPassword},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.ChangeEmail(ctx, ChangeEmailInput{
+				AccountId:        account.GetId(),
+				NewEmail:         tt.newEmail,
+				Reauthentication: Reauthentication{Password: tt.password},
+			}, repos.accounts, queue)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ChangeEmail() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// the address only changes once the link sent to it is followed
+	if stored := findTestAccount(t, repos.accounts, "ada@example.com"); stored.PendingEmail != "ada@example.org" {
+		t.Errorf("pending email = %q, want ada@example.org", stored.PendingEmail)
+	}
+
+	tokens := verificationTokens(t, queue, "ada@example.org")
+	if len(tokens) != 1 {
+		t.Fatalf("%d verification links sent to the new address, want 1", len(tokens))
+	}
+	verified, err := s.VerifyEmail(ctx, VerifyEmailInput{Token: tokens[0]}, repos.accounts)
+	if err != nil {
+		t.Fatalf("VerifyEmail() error = %v", err)
+	}
+	if verified.Email != "ada@example.org" || verified.PendingEmail != "" {
+		t.Errorf("verified account email = %q, pending %q, want ada@example.org and nothing pending", verified.Email, verified.PendingEmail)
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+	sensors := NewSensorService(newTestConfig(), audit.NewLog(repos.auditLog))
+	keep := createTestAccount(t, repos.accounts, "obi@example.com")
+	account := createTestAccount(t, repos.accounts, "ada@example.com")
+
+	for _, owner := range []models.Account{account, keep} {
+		info := owner.GetAccountInfo()
+		_, err := sensors.CreateSensor(ctx, CreateSensorInput{
+			Name:          "Inverter",
+			IpAddress:     "10.0.0.7",
+			AccountInfo:   &info,
+			AccountStatus: owner.Status,
+		}, func() string { return "token" }, repos.sensors)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleteAccount := func(password string) error {
+		return s.DeleteAccount(ctx, DeleteAccountInput{
+			AccountId:        account.GetId(),
+			Reauthentication: Reauthentication{Password: password},
+		}, repos.accounts, repos.sensors,
+			repository.NewRepository[models.Devices](database.NewMemoryCollection()),
+			repos.apiKeys,
+			repository.NewRepository[models.NotificationPreferences](database.NewMemoryCollection()),
+			repository.NewRepository[models.InboxNotification](database.NewMemoryCollection()),
+			repository.NewRepository[models.WebhookSubscription](database.NewMemoryCollection()),
+			repository.NewRepository[models.WebhookDelivery](database.NewMemoryCollection()),
+		)
+	}
+
+	if err := deleteAccount("a guess"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("DeleteAccount() with the wrong password error = %v, want %v", err, ErrInvalidCredentials)
+	}
+	if err := deleteAccount(testPassword); err != nil {
+		t.Fatalf("DeleteAccount() error = %v", err)
+	}
+
+	if _, err := repos.accounts.FindOne(ctx, repository.NewQueryFilter().AddFilter(models.FieldId, account.ID), nil, nil); err != repository.NoDocumentsFound {
+		t.Errorf("deleted account lookup error = %v, want %v", err, repository.NoDocumentsFound)
+	}
+	wantSensors := map[string]int64{account.Email: 0, keep.Email: 1}
+	for _, owner := range []models.Account{account, keep} {
+		count, err := repos.sensors.Count(ctx, repository.NewQueryFilter().AddFilter(models.FieldAccountInfoId, owner.GetId()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != wantSensors[owner.Email] {
+			t.Errorf("%s has %d sensors, want %d", owner.Email, count, wantSensors[owner.Email])
+		}
+	}
+}
+
+func TestExportAccountData(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+	account := createTestAccount(t, repos.accounts, "ada@example.com")
+
+	data, err := s.ExportAccountData(ctx, ExportAccountDataInput{AccountId: account.GetId()},
+		repos.accounts, repos.sensors,
+		repository.NewRepository[models.Devices](database.NewMemoryCollection()),
+		repos.apiKeys,
+		repository.NewRepository[models.NotificationPreferences](database.NewMemoryCollection()),
+		repository.NewRepository[models.InboxNotification](database.NewMemoryCollection()),
+		repository.NewRepository[models.WebhookSubscription](database.NewMemoryCollection()),
+		repository.NewRepository[models.WebhookDelivery](database.NewMemoryCollection()),
+	)
+	if err != nil {
+		t.Fatalf("ExportAccountData() error = %v", err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("export isn't a zip archive: %v", err)
+	}
+
+	var names []string
+	for _, file := range archive.File {
+		names = append(names, file.Name)
+	}
+	want := []string{"account.json", "sensors.json", "devices.json", "api_keys.json", "notification_preferences.json", "inbox.json", "webhooks.json", "webhook_deliveries.json"}
+	if !slices.Equal(names, want) {
+		t.Errorf("exported files = %v, want %v", names, want)
+	}
+
+	f, err := archive.Open("account.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var exported map[string]interface{}
+	if err = json.NewDecoder(f).Decode(&exported); err != nil {
+		t.Fatal(err)
+	}
+	if exported["email"] != "ada@example.com" {
+		t.Errorf("exported email = %v, want ada@example.com", exported["email"])
+	}
+	if _, ok := exported["password"]; ok {
+		t.Error("the password hash was exported")
+	}
+}
+
+const testPhone = "+2348012345678"
+
+// phoneVerificationTexts returns the phone verification texts published to queue, oldest first.
+func phoneVerificationTexts(t *testing.T, queue *consumer.MemoryQueue) []notifications.PhoneVerificationPayload {
+	t.Helper()
+
+	var payloads []notifications.PhoneVerificationPayload
+	for _, event := range queue.Published() {
+		if event.EventKey != notifications.PhoneVerificationNotification {
+			continue
+		}
+
+		var payload notifications.PhoneVerificationPayload
+		if err := events.Decode(event, &payload); err != nil {
+			t.Fatalf("phone verification event: %v", err)
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads
+}
+
+// startPhoneVerification asks for a code to be texted to testPhone and returns it.
+func startPhoneVerification(t *testing.T, s *AccountsService, repos testRepos, queue *consumer.MemoryQueue) string {
+	t.Helper()
+
+	account := findTestAccount(t, repos.accounts, resetEmail)
+	err := s.StartPhoneVerification(context.Background(), StartPhoneVerificationInput{AccountId: account.GetId(), Phone: testPhone}, repos.accounts, queue)
+	if err != nil {
+		t.Fatalf("StartPhoneVerification() error = %v", err)
+	}
+
+	texts := phoneVerificationTexts(t, queue)
+	if len(texts) == 0 {
+		t.Fatal("no verification code was texted")
+	}
+	return texts[len(texts)-1].Code
+}
+
+func verifyPhone(s *AccountsService, repos testRepos, accountId, code string) (*models.Account, error) {
+	return s.VerifyPhone(context.Background(), VerifyPhoneInput{AccountId: accountId, Code: code}, repos.accounts)
+}
+
+func TestStartPhoneVerification(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+	queue := consumer.NewMemoryQueue()
+	account := createTestAccount(t, repos.accounts, resetEmail)
+
+	err := s.StartPhoneVerification(ctx, StartPhoneVerificationInput{AccountId: account.GetId(), Phone: "08012345678"}, repos.accounts, queue)
+	if err == nil {
+		t.Error("StartPhoneVerification() accepted a number without a country code")
+	}
+
+	startPhoneVerification(t, s, repos, queue)
+
+	texts := phoneVerificationTexts(t, queue)
+	if len(texts) != 1 {
+		t.Fatalf("%d codes texted, want 1", len(texts))
+	}
+	if texts[0].Phone != testPhone || texts[0].Id != account.GetId() || texts[0].ExpiresInMinutes != 10 {
+		t.Errorf("texted %+v, want a code for %s that expires in 10 minutes", texts[0], testPhone)
+	}
+
+	stored := findTestAccount(t, repos.accounts, resetEmail)
+	if stored.Phone != "" || stored.HasVerifiedPhone() {
+		t.Errorf("phone = %q before it was verified", stored.Phone)
+	}
+	if stored.PhoneVerification == nil || stored.PhoneVerification.CodeHash == texts[0].Code {
+		t.Errorf("stored verification = %+v, want the code kept hashed", stored.PhoneVerification)
+	}
+	if ttl := time.Until(stored.PhoneVerification.ExpiresAt); ttl < 9*time.Minute || ttl > 10*time.Minute {
+		t.Errorf("code expires in %s, want 10 minutes", ttl)
+	}
+
+	// another code can't be asked for straight away
+	err = s.StartPhoneVerification(ctx, StartPhoneVerificationInput{AccountId: account.GetId(), Phone: testPhone}, repos.accounts, queue)
+	if err == nil {
+		t.Error("StartPhoneVerification() sent another code straight away")
+	}
+	if got := len(phoneVerificationTexts(t, queue)); got != 1 {
+		t.Errorf("%d codes texted, want 1", got)
+	}
+}
+
+func TestVerifyPhone(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// prepare returns the code to verify with, having asked for one
+		prepare func(t *testing.T, s *AccountsService, repos testRepos, queue *consumer.MemoryQueue) string
+
+		wantErr error
+	}{
+		{
+			name: "code sent by text",
+			prepare: func(t *testing.T, s *AccountsService, repos testRepos, queue *consumer.MemoryQueue) string {
+				return startPhoneVerification(t, s, repos, queue)
+			},
+		},
+		{
+			name: "wrong code",
+			prepare: func(t *testing.T, s *AccountsService, repos testRepos, queue *consumer.MemoryQueue) string {
+				return wrongCode(startPhoneVerification(t, s, repos, queue))
+			},
+			wantErr: ErrInvalidPhoneCode,
+		},
+		{
+			name: "no code",
+			prepare: func(t *testing.T, s *AccountsService, repos testRepos, queue *consumer.MemoryQueue) string {
+				startPhoneVerification(t, s, repos, queue)
+				return ""
+			},
+			wantErr: ErrInvalidPhoneCode,
+		},
+		{
+			name: "expired code",
+			prepare: func(t *testing.T, s *AccountsService, repos testRepos, queue *consumer.MemoryQueue) string {
+				code := startPhoneVerification(t, s, repos, queue)
+
+				account := findTestAccount(t, repos.accounts, resetEmail)
+				account.PhoneVerification.ExpiresAt = time.Now().UTC().Add(-time.Second)
+				if _, err := repos.accounts.Update(context.Background(), account); err != nil {
+					t.Fatal(err)
+				}
+				return code
+			},
+			wantErr: ErrInvalidPhoneCode,
+		},
+		{
+			name: "code already used",
+			prepare: func(t *testing.T, s *AccountsService, repos testRepos, queue *consumer.MemoryQueue) string {
+				code := startPhoneVerification(t, s, repos, queue)
+				if _, err := verifyPhone(s, repos, findTestAccount(t, repos.accounts, resetEmail).GetId(), code); err != nil {
+					t.Fatalf("first verification error = %v", err)
+				}
+				return code
+			},
+			wantErr: ErrInvalidPhoneCode,
+		},
+		{
+			name: "no code asked for",
+			prepare: func(t *testing.T, s *AccountsService, repos testRepos, queue *consumer.MemoryQueue) string {
+				return "123456"
+			},
+			wantErr: ErrInvalidPhoneCode,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repos := newTestRepos()
+			s := newTestAccountsService(repos)
+			queue := consumer.NewMemoryQueue()
+			account := createTestAccount(t, repos.accounts, resetEmail)
+
+			code := tt.prepare(t, s, repos, queue)
+			alreadyVerified := findTestAccount(t, repos.accounts, resetEmail).HasVerifiedPhone()
+
+			verified, err := verifyPhone(s, repos, account.GetId(), code)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyPhone() error = %v, want %v", err, tt.wantErr)
+			}
+
+			stored := findTestAccount(t, repos.accounts, resetEmail)
+			if err != nil {
+				if stored.HasVerifiedPhone() != alreadyVerified {
+					t.Error("a failed verification changed the account's phone")
+				}
+				return
+			}
+
+			if verified.Phone != testPhone || verified.PhoneVerifiedAt == nil {
+				t.Errorf("VerifyPhone() = phone %q verified at %v, want %s verified", verified.Phone, verified.PhoneVerifiedAt, testPhone)
+			}
+			if stored.Phone != testPhone || !stored.HasVerifiedPhone() || stored.PhoneVerification != nil {
+				t.Errorf("stored phone %q, verified %v, pending %+v, want %s verified with nothing pending", stored.Phone, stored.HasVerifiedPhone(), stored.PhoneVerification, testPhone)
+			}
+		})
+	}
+}
+
+func TestVerifyPhoneAttemptLimit(t *testing.T) {
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+	queue := consumer.NewMemoryQueue()
+	account := createTestAccount(t, repos.accounts, resetEmail)
+
+	code := startPhoneVerification(t, s, repos, queue)
+
+	for i := 0; i < maxPhoneVerificationAttempts; i++ {
+		if _, err := verifyPhone(s, repos, account.GetId(), wrongCode(code)); !errors.Is(err, ErrInvalidPhoneCode) {
+			t.Fatalf("wrong code %d error = %v, want %v", i+1, err, ErrInvalidPhoneCode)
+		}
+	}
+
+	// the code is spent once the attempts run out, even the right one is refused
+	if _, err := verifyPhone(s, repos, account.GetId(), code); !errors.Is(err, ErrInvalidPhoneCode) {
+		t.Errorf("VerifyPhone() after %d wrong codes error = %v, want %v", maxPhoneVerificationAttempts, err, ErrInvalidPhoneCode)
+	}
+
+	stored := findTestAccount(t, repos.accounts, resetEmail)
+	if stored.PhoneVerification != nil || stored.HasVerifiedPhone() {
+		t.Errorf("after running out of attempts pending %+v, verified %v, want neither", stored.PhoneVerification, stored.HasVerifiedPhone())
+	}
+}
+
+func TestRemovePhone(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+	queue := consumer.NewMemoryQueue()
+	account := createTestAccount(t, repos.accounts, resetEmail)
+
+	if _, err := s.RemovePhone(ctx, RemovePhoneInput{AccountId: account.GetId()}, repos.accounts); err == nil {
+		t.Error("RemovePhone() without a phone succeeded")
+	}
+
+	code := startPhoneVerification(t, s, repos, queue)
+	if _, err := verifyPhone(s, repos, account.GetId(), code); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := s.RemovePhone(ctx, RemovePhoneInput{AccountId: account.GetId()}, repos.accounts)
+	if err != nil {
+		t.Fatalf("RemovePhone() error = %v", err)
+	}
+
+	stored := findTestAccount(t, repos.accounts, resetEmail)
+	for _, got := range []models.Account{*removed, stored} {
+		if got.Phone != "" || got.PhoneVerifiedAt != nil || got.PhoneVerification != nil || got.HasVerifiedPhone() {
+			t.Errorf("after removing phone %q, verified at %v, pending %+v, want none", got.Phone, got.PhoneVerifiedAt, got.PhoneVerification)
+		}
+	}
+}
+
+func TestLoginUser(t *testing.T) {
+	const email = "ada@example.com"
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+
+		suspended bool
+		twoFactor bool
+
+		wantErr       error
+		wantChallenge bool
+	}{
+		{name: "right password", email: email, password: testPassword},
+		// an unknown email fails the same way as a wrong password, so logins don't reveal who has an account
+		{name: "unknown email", email: "obi@example.com", password: testPassword, wantErr: ErrInvalidCredentials},
+		{name: "wrong password", email: email, password: "wrong password", wantErr: ErrInvalidCredentials},
+		// a suspension is only revealed to someone who knows the password
+		{name: "suspended account with a wrong password", email: email, password: "wrong password", suspended: true, wantErr: ErrInvalidCredentials},
+		{name: "suspended account with the right password", email: email, password: testPassword, suspended: true, wantErr: ErrAccountSuspended},
+		{name: "two factor enabled", email: email, password: testPassword, twoFactor: true, wantChallenge: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repos := newTestRepos()
+			s := newTestAccountsService(repos)
+
+			account := createTestAccount(t, repos.accounts, email)
+			if tt.twoFactor {
+				enableTestTwoFactor(t, s, repos, account)
+			}
+			if tt.suspended {
+				account = findTestAccount(t, repos.accounts, email)
+				account.Status = models.SuspendedStatus
+				if _, err := repos.accounts.Update(ctx, account); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			input := LoginUserInput{Email: tt.email, Password: tt.password, IpAddress: "203.0.113.7"}
+			got, err := s.LoginUser(ctx, input, repos.accounts, limiter.NewLoginLimiter(limiter.NewMemoryStore()), consumer.NewMemoryQueue())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("LoginUser() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if got != nil {
+					t.Errorf("LoginUser() = %+v with an error, want nothing", got)
+				}
+				return
+			}
+
+			if tt.wantChallenge {
+				if got.ChallengeToken == "" || got.Token != "" {
+					t.Errorf("LoginUser() = challenge %q, token %q, want only a challenge", got.ChallengeToken, got.Token)
+				}
+				return
+			}
+			if got.Token == "" || got.ChallengeToken != "" {
+				t.Errorf("LoginUser() = challenge %q, token %q, want only a session", got.ChallengeToken, got.Token)
+			}
+		})
+	}
+}
+
+func TestLoginUserLocksOut(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+	queue := consumer.NewMemoryQueue()
+	account := createTestAccount(t, repos.accounts, "ada@example.com")
+
+	// the limiter's free attempts are also its maximum, so the lockout comes before any backoff
+	loginLimiter := limiter.NewLoginLimiter(limiter.NewMemoryStore(), limiter.WithMaxAttempts(3, 50))
+	login := func(password string) error {
+		_, err := s.LoginUser(ctx, LoginUserInput{Email: account.Email, Password: password, IpAddress: "203.0.113.7"}, repos.accounts, loginLimiter, queue)
+		return err
+	}
+
+	for i := 1; i <= 3; i++ {
+		if err := login("wrong password"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("failed login %d error = %v, want %v", i, err, ErrInvalidCredentials)
+		}
+		if i < 3 && len(queue.Published()) != 0 {
+			t.Fatalf("account locked event published after %d failed logins, want it only on the lockout", i)
+		}
+	}
+
+	if err := login(testPassword); !errors.Is(err, ErrTooManyLoginAttempts) {
+		t.Errorf("LoginUser() of a locked account error = %v, want %v", err, ErrTooManyLoginAttempts)
+	}
+
+	published := queue.Published()
+	if len(published) != 1 || published[0].EventKey != notifications.AccountLockedNotification {
+		t.Fatalf("published %v, want one account locked event", published)
+	}
+	var payload notifications.AccountLockedPayload
+	if err := events.Decode(published[0], &payload); err != nil {
+		t.Fatalf("account locked event: %v", err)
+	}
+	if payload.Id != account.GetId() || payload.Email != account.Email {
+		t.Errorf("account locked event for %s (%s), want %s (%s)", payload.Id, payload.Email, account.GetId(), account.Email)
+	}
+	lockedUntil, err := time.Parse(time.RFC3339, payload.LockedUntil)
+	if err != nil {
+		t.Fatalf("locked until %q: %v", payload.LockedUntil, err)
+	}
+	if wait := time.Until(lockedUntil); wait <= 0 || wait > loginLimiter.LockoutDuration() {
+		t.Errorf("account locked until %s, want within the next %s", lockedUntil, loginLimiter.LockoutDuration())
+	}
+
+	entries, err := repos.auditLog.Find(ctx, repository.NewQueryFilter().AddFilter(models.FieldAuditAction, audit.ActionAccountLocked), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].TargetId != account.GetId() {
+		t.Errorf("%d account locked audit entries, want 1 for the account", len(entries))
+	}
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+const (
+	defaultImpersonationTTL = 30 * time.Minute
+	maxImpersonationTTL     = 2 * time.Hour
+)
+
+var (
+	ErrAccountNotSuspended = errors.New("account is not suspended")
+	ErrCannotTargetAdmin   = errors.New("admin accounts can't be suspended or impersonated")
+)
+
+type (
+	SuspendAccountInput struct {
+		Admin     models.AccountInfo
+		AccountId string
+		Reason    string
+	}
+	ReactivateAccountInput struct {
+		Admin     models.AccountInfo
+		AccountId string
+	}
+	ImpersonateAccountInput struct {
+		Admin     models.AccountInfo
+		AccountId string
+		Reason    string
+		Duration  time.Duration
+		IpAddress string
+	}
+)
+
+// SuspendAccount blocks the account from logging in and revokes its existing sessions.
+func (s *AccountsService) SuspendAccount(ctx context.Context,
+	input SuspendAccountInput,
+	accountsRepo *repository.Repository[models.Account],
+) (*models.Account, error) {
+	if input.Reason == "" {
+		return nil, errors.New("a reason is required to suspend an account")
+	}
+
+	account, err := findAccountById(ctx, input.AccountId, accountsRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	if account.IsAdmin() {
+		return nil, ErrCannotTargetAdmin
+	}
+	before := *account
+
+	account.Status = models.SuspendedStatus
+	account.SessionVersion++
+	account.Suspension = &models.Suspension{
+		Reason:      input.Reason,
+		SuspendedAt: time.Now().UTC(),
+		SuspendedBy: input.Admin,
+	}
+
+	updatedAccount, err := accountsRepo.Update(ctx, *account)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionAccountSuspended,
+		TargetType: audit.TargetAccount,
+		TargetId:   updatedAccount.GetId(),
+		Before:     before,
+		After:      updatedAccount,
+		Metadata:   map[string]string{"reason": input.Reason},
+	})
+
+	return &updatedAccount, nil
+}
+
+func (s *AccountsService) ReactivateAccount(ctx context.Context,
+	input ReactivateAccountInput,
+	accountsRepo *repository.Repository[models.Account],
+) (*models.Account, error) {
+
+	account, err := findAccountById(ctx, input.AccountId, accountsRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	if !account.IsSuspended() {
+		return nil, ErrAccountNotSuspended
+	}
+	before := *account
+
+	account.Status = models.ActiveStatus
+	account.Suspension = nil
+
+	updatedAccount, err := accountsRepo.Update(ctx, *account)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionAccountReactivated,
+		TargetType: audit.TargetAccount,
+		TargetId:   updatedAccount.GetId(),
+		Before:     before,
+		After:      updatedAccount,
+	})
+
+	return &updatedAccount, nil
+}
+
+// ImpersonateAccount issues a short-lived, read-only session for the account on behalf of an admin,
+// and records who started it and why.
+func (s *AccountsService) ImpersonateAccount(ctx context.Context,
+	input ImpersonateAccountInput,
+	accountsRepo *repository.Repository[models.Account],
+	impersonationsRepo *repository.Repository[models.Impersonation],
+) (*models.Account, error) {
+	if input.Reason == "" {
+		return nil, errors.New("a reason is required to impersonate an account")
+	}
+
+	ttl := input.Duration
+	if ttl <= 0 {
+		ttl = defaultImpersonationTTL
+	}
+	if ttl > maxImpersonationTTL {
+		ttl = maxImpersonationTTL
+	}
+
+	account, err := findAccountById(ctx, input.AccountId, accountsRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	if account.IsAdmin() {
+		return nil, ErrCannotTargetAdmin
+	}
+
+	now := time.Now().UTC()
+	impersonation, err := impersonationsRepo.Create(ctx, models.Impersonation{
+		Shared: models.Shared{
+			ID:        primitive.NewObjectID(),
+			CreatedAt: &now,
+		},
+		Admin:     input.Admin,
+		Target:    account.GetAccountInfo(),
+		Reason:    input.Reason,
+		IpAddress: input.IpAddress,
+		ExpiresAt: now.Add(ttl),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionAccountImpersonated,
+		TargetType: audit.TargetAccount,
+		TargetId:   account.GetId(),
+		Metadata: map[string]string{
+			"reason":           input.Reason,
+			"impersonation_id": impersonation.GetId(),
+			"expires_at":       impersonation.ExpiresAt.Format(time.RFC3339),
+		},
+	})
+
+	token, err := s.signClaims(&Claims{
+		Authorization:  true,
+		SessionVersion: account.SessionVersion,
+		ImpersonatedBy: input.Admin.Id,
+		Content:        account.GetAccountInfo(),
+	}, ttl)
+	if err != nil {
+		return nil, errors.New("an error occurred: " + err.Error())
+	}
+
+	account.Token = token
+	account.ImpersonatedBy = input.Admin.Id
+
+	return account, nil
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tejiriaustin/narx_api/consumer"
+	"github.com/tejiriaustin/narx_api/database"
+	"github.com/tejiriaustin/narx_api/limiter"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+func TestSuspendAndReactivateAccount(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+	account := createTestAccount(t, repos.accounts, "ada@example.com")
+	admin := createTestAccount(t, repos.accounts, "admin@example.com")
+	admin.Kind = models.AdminAccountKind
+	if _, err := repos.accounts.Update(ctx, admin); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func() error {
+		_, err := s.LoginUser(ctx, LoginUserInput{Email: "ada@example.com", Password: testPassword, IpAddress: "203.0.113.7"},
+			repos.accounts, limiter.NewLoginLimiter(limiter.NewMemoryStore()), consumer.NewMemoryQueue())
+		return err
+	}
+
+	if _, err := s.ReactivateAccount(ctx, ReactivateAccountInput{Admin: admin.GetAccountInfo(), AccountId: account.GetId()}, repos.accounts); !errors.Is(err, ErrAccountNotSuspended) {
+		t.Errorf("ReactivateAccount() of an active account error = %v, want %v", err, ErrAccountNotSuspended)
+	}
+	if _, err := s.SuspendAccount(ctx, SuspendAccountInput{Admin: admin.GetAccountInfo(), AccountId: account.GetId()}, repos.accounts); err == nil {
+		t.Error("SuspendAccount() without a reason succeeded")
+	}
+	if _, err := s.SuspendAccount(ctx, SuspendAccountInput{Admin: admin.GetAccountInfo(), AccountId: admin.GetId(), Reason: "testing"}, repos.accounts); !errors.Is(err, ErrCannotTargetAdmin) {
+		t.Errorf("SuspendAccount() of an admin error = %v, want %v", err, ErrCannotTargetAdmin)
+	}
+
+	suspended, err := s.SuspendAccount(ctx, SuspendAccountInput{Admin: admin.GetAccountInfo(), AccountId: account.GetId(), Reason: "chargeback"}, repos.accounts)
+	if err != nil {
+		t.Fatalf("SuspendAccount() error = %v", err)
+	}
+	if !suspended.IsSuspended() || suspended.Suspension.Reason != "chargeback" || suspended.Suspension.SuspendedBy.Id != admin.GetId() {
+		t.Errorf("suspended account = %+v, want it suspended by the admin for a chargeback", suspended.Suspension)
+	}
+	if suspended.SessionVersion != account.SessionVersion+1 {
+		t.Errorf("session version = %d, want %d so existing sessions are revoked", suspended.SessionVersion, account.SessionVersion+1)
+	}
+	if err = login(); !errors.Is(err, ErrAccountSuspended) {
+		t.Errorf("LoginUser() while suspended error = %v, want %v", err, ErrAccountSuspended)
+	}
+
+	reactivated, err := s.ReactivateAccount(ctx, ReactivateAccountInput{Admin: admin.GetAccountInfo(), AccountId: account.GetId()}, repos.accounts)
+	if err != nil {
+		t.Fatalf("ReactivateAccount() error = %v", err)
+	}
+	if reactivated.Status != models.ActiveStatus || reactivated.Suspension != nil {
+		t.Errorf("reactivated account status = %s, suspension %+v, want active with no suspension", reactivated.Status, reactivated.Suspension)
+	}
+	if err = login(); err != nil {
+		t.Errorf("LoginUser() after reactivation error = %v", err)
+	}
+}
+
+func TestImpersonateAccount(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+	account := createTestAccount(t, repos.accounts, "ada@example.com")
+	admin := createTestAccount(t, repos.accounts, "admin@example.com")
+	admin.Kind = models.AdminAccountKind
+	if _, err := repos.accounts.Update(ctx, admin); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		accountId string
+		reason    string
+		duration  time.Duration
+		wantTTL   time.Duration
+		wantErr   bool
+	}{
+		{name: "default duration", accountId: account.GetId(), reason: "support ticket", wantTTL: defaultImpersonationTTL},
+		{name: "chosen duration", accountId: account.GetId(), reason: "support ticket", duration: time.Hour, wantTTL: time.Hour},
+		{name: "duration capped", accountId: account.GetId(), reason: "support ticket", duration: 24 * time.Hour, wantTTL: maxImpersonationTTL},
+		{name: "no reason", accountId: account.GetId(), wantErr: true},
+		{name: "another admin", accountId: admin.GetId(), reason: "support ticket", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			impersonationsRepo := repository.NewRepository[models.Impersonation](database.NewMemoryCollection())
+
+			impersonated, err := s.ImpersonateAccount(ctx, ImpersonateAccountInput{
+				Admin:     admin.GetAccountInfo(),
+				AccountId: tt.accountId,
+				Reason:    tt.reason,
+				Duration:  tt.duration,
+				IpAddress: "203.0.113.7",
+			}, repos.accounts, impersonationsRepo)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ImpersonateAccount() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			var info models.AccountInfo
+			claims, err := s.parseSignedToken(ctx, impersonated.Token, &info)
+			if err != nil {
+				t.Fatalf("impersonation token: %v", err)
+			}
+			if info.Id != account.GetId() || claims.ImpersonatedBy != admin.GetId() {
+				t.Errorf("token is for %s impersonated by %s, want %s impersonated by %s", info.Id, claims.ImpersonatedBy, account.GetId(), admin.GetId())
+			}
+			if ttl := time.Until(claims.Exp); ttl > tt.wantTTL || ttl < tt.wantTTL-time.Minute {
+				t.Errorf("token expires in %s, want %s", ttl, tt.wantTTL)
+			}
+
+			recorded, err := impersonationsRepo.Find(ctx, repository.NewQueryFilter(), nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(recorded) != 1 || recorded[0].Admin.Id != admin.GetId() || recorded[0].Reason != tt.reason {
+				t.Errorf("recorded impersonations = %+v, want one by the admin for %q", recorded, tt.reason)
+			}
+		})
+	}
+}
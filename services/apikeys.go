@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+	"github.com/tejiriaustin/narx_api/utils"
+)
+
+const (
+	apiKeyPrefix      = "narx_"
+	apiKeyPrefixBytes = 4
+	apiKeySecretBytes = 24
+)
+
+type (
+	ApiKeyService struct {
+		conf     *env.Environment
+		auditLog *audit.Log
+	}
+
+	CreateApiKeyInput struct {
+		Account     models.Account
+		Name        string
+		Permissions []models.ApiKeyPermission
+		ExpiresAt   *time.Time
+
+		// ForOrganisation creates a service key owned by the caller's organisation.
+		ForOrganisation bool
+	}
+
+	RevokeApiKeyInput struct {
+		Account models.Account
+		KeyId   string
+	}
+
+	ListApiKeysInput struct {
+		Pager
+		Account models.Account
+	}
+)
+
+func NewApiKeyService(conf *env.Environment, auditLog *audit.Log) *ApiKeyService {
+	return &ApiKeyService{
+		conf:     conf,
+		auditLog: auditLog,
+	}
+}
+
+var _ ApiKeyServiceInterface = (*ApiKeyService)(nil)
+
+// CreateApiKey generates a new key. The raw key is only returned here, only its hash is stored.
+func (s *ApiKeyService) CreateApiKey(ctx context.Context,
+	input CreateApiKeyInput,
+	apiKeysRepo *repository.Repository[models.ApiKey],
+) (*models.ApiKey, error) {
+	if input.Name == "" {
+		return nil, errors.New("api key name is required")
+	}
+	if len(input.Permissions) == 0 {
+		return nil, errors.New("at least one permission is required")
+	}
+	for _, p := range input.Permissions {
+		if !models.IsValidApiKeyPermission(p) {
+			return nil, errors.New("invalid permission: " + string(p))
+		}
+	}
+	if input.ExpiresAt != nil && input.ExpiresAt.Before(time.Now()) {
+		return nil, errors.New("expiry must be in the future")
+	}
+	if input.ForOrganisation {
+		if input.Account.OrganisationId == "" {
+			return nil, errors.New("account does not belong to an organisation")
+		}
+		if !input.Account.IsAdmin() {
+			return nil, errors.New("only admins can create organisation api keys")
+		}
+	}
+
+	prefix, err := utils.RandomToken(apiKeyPrefixBytes)
+	if err != nil {
+		return nil, errors.New("failed to generate api key")
+	}
+	secret, err := utils.RandomToken(apiKeySecretBytes)
+	if err != nil {
+		return nil, errors.New("failed to generate api key")
+	}
+	key := apiKeyPrefix + prefix + "_" + secret
+
+	now := time.Now().UTC()
+	apiKey := models.ApiKey{
+		Shared: models.Shared{
+			ID:        primitive.NewObjectID(),
+			CreatedAt: &now,
+		},
+		AccountInfo: input.Account.GetAccountInfo(),
+		Name:        input.Name,
+		Prefix:      apiKeyPrefix + prefix,
+		KeyHash:     utils.HashToken(key),
+		Permissions: input.Permissions,
+		ExpiresAt:   input.ExpiresAt,
+	}
+	if input.ForOrganisation {
+		apiKey.OrganisationId = input.Account.OrganisationId
+	}
+
+	apiKey, err = apiKeysRepo.Create(ctx, apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionApiKeyCreated,
+		TargetType: audit.TargetApiKey,
+		TargetId:   apiKey.GetId(),
+		After:      apiKey,
+	})
+
+	apiKey.Key = key
+	return &apiKey, nil
+}
+
+// ListApiKeys returns the account's own keys and, for admins, their organisation's.
+func (s *ApiKeyService) ListApiKeys(ctx context.Context,
+	input ListApiKeysInput,
+	apiKeysRepo *repository.Repository[models.ApiKey],
+) ([]models.ApiKey, *repository.Paginator, error) {
+
+	filter := repository.NewQueryFilter().AddFilter("$or", apiKeyOwners(input.Account))
+
+	keys, paginator, err := apiKeysRepo.Paginate(ctx, filter, input.Page, input.PerPage, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return keys, paginator, nil
+}
+
+func (s *ApiKeyService) RevokeApiKey(ctx context.Context,
+	input RevokeApiKeyInput,
+	apiKeysRepo *repository.Repository[models.ApiKey],
+) error {
+	id, err := primitive.ObjectIDFromHex(input.KeyId)
+	if err != nil {
+		return errors.New("invalid id")
+	}
+
+	filter := repository.NewQueryFilter().
+		AddFilter(models.FieldId, id).
+		AddFilter("$or", apiKeyOwners(input.Account))
+
+	apiKey, err := apiKeysRepo.FindOne(ctx, filter, nil, nil)
+	if err != nil {
+		if err == repository.NoDocumentsFound {
+			return errors.New("api key not found")
+		}
+		return err
+	}
+
+	if apiKey.RevokedAt != nil {
+		return nil
+	}
+
+	before := apiKey
+	now := time.Now().UTC()
+	apiKey.RevokedAt = &now
+
+	_, err = apiKeysRepo.Update(ctx, apiKey)
+	if err != nil {
+		return err
+	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionApiKeyRevoked,
+		TargetType: audit.TargetApiKey,
+		TargetId:   apiKey.GetId(),
+		Before:     before,
+		After:      apiKey,
+	})
+
+	return nil
+}
+
+// apiKeyOwners matches the keys an account manages: its own, and its organisation's if it is an admin.
+func apiKeyOwners(account models.Account) []map[string]interface{} {
+	owners := []map[string]interface{}{
+		{models.FieldAccountInfoId: account.GetId()},
+	}
+	if account.OrganisationId != "" && account.IsAdmin() {
+		owners = append(owners, map[string]interface{}{models.FieldApiKeyOrganisationId: account.OrganisationId})
+	}
+	return owners
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+	"github.com/tejiriaustin/narx_api/utils"
+)
+
+func TestCreateApiKey(t *testing.T) {
+	repos := newTestRepos()
+	s := NewApiKeyService(newTestConfig(), audit.NewLog(repos.auditLog))
+
+	member := createTestAccount(t, repos.accounts, "ada@example.com")
+	member.OrganisationId = "org"
+	admin := member
+	admin.Kind = models.AdminAccountKind
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name    string
+		input   CreateApiKeyInput
+		wantErr bool
+	}{
+		{name: "personal key", input: CreateApiKeyInput{Account: member, Name: "logger", Permissions: []models.ApiKeyPermission{models.PermissionIngest}}},
+		{name: "organisation key", input: CreateApiKeyInput{Account: admin, Name: "logger", Permissions: []models.ApiKeyPermission{models.PermissionIngest}, ForOrganisation: true}},
+		{name: "no name", input: CreateApiKeyInput{Account: member, Permissions: []models.ApiKeyPermission{models.PermissionIngest}}, wantErr: true},
+		{name: "no permissions", input: CreateApiKeyInput{Account: member, Name: "logger"}, wantErr: true},
+		{name: "unknown permission", input: CreateApiKeyInput{Account: member, Name: "logger", Permissions: []models.ApiKeyPermission{"accounts:delete"}}, wantErr: true},
+		{name: "already expired", input: CreateApiKeyInput{Account: member, Name: "logger", Permissions: []models.ApiKeyPermission{models.PermissionIngest}, ExpiresAt: &past}, wantErr: true},
+		{name: "organisation key by a member", input: CreateApiKeyInput{Account: member, Name: "logger", Permissions: []models.ApiKeyPermission{models.PermissionIngest}, ForOrganisation: true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := s.CreateApiKey(context.Background(), tt.input, repos.apiKeys)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateApiKey() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if !strings.HasPrefix(key.Key, key.Prefix+"_") {
+				t.Errorf("key %s doesn't start with its prefix %s", key.Key, key.Prefix)
+			}
+
+			// only the hash is stored, the raw key is handed out once
+			stored, err := repos.apiKeys.FindOne(context.Background(), repository.NewQueryFilter().AddFilter(models.FieldId, key.ID), nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Key != "" || stored.KeyHash != utils.HashToken(key.Key) {
+				t.Errorf("stored key = %q with hash %q, want only the hash of the key", stored.Key, stored.KeyHash)
+			}
+			if tt.input.ForOrganisation && stored.OrganisationId != "org" {
+				t.Errorf("organisation key owned by %q, want org", stored.OrganisationId)
+			}
+		})
+	}
+}
+
+func TestApiKeysAreScopedToTheirOwners(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := NewApiKeyService(newTestConfig(), audit.NewLog(repos.auditLog))
+
+	admin := createTestAccount(t, repos.accounts, "ada@example.com")
+	admin.OrganisationId, admin.Kind = "org", models.AdminAccountKind
+	otherAdmin := createTestAccount(t, repos.accounts, "eze@example.com")
+	otherAdmin.OrganisationId, otherAdmin.Kind = "org", models.AdminAccountKind
+	member := createTestAccount(t, repos.accounts, "obi@example.com")
+	member.OrganisationId = "org"
+
+	create := func(account models.Account, name string, forOrganisation bool) *models.ApiKey {
+		t.Helper()
+		key, err := s.CreateApiKey(ctx, CreateApiKeyInput{
+			Account:         account,
+			Name:            name,
+			Permissions:     []models.ApiKeyPermission{models.PermissionReadReadings},
+			ForOrganisation: forOrganisation,
+		}, repos.apiKeys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	adminKey := create(admin, "admin", false)
+	serviceKey := create(admin, "service", true)
+	memberKey := create(member, "member", false)
+
+	tests := []struct {
+		name       string
+		account    models.Account
+		wantListed []string
+	}{
+		{name: "member", account: member, wantListed: []string{"member"}},
+		{name: "another admin of the organisation", account: otherAdmin, wantListed: []string{"service"}},
+		{name: "creator", account: admin, wantListed: []string{"admin", "service"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, _, err := s.ListApiKeys(ctx, ListApiKeysInput{Account: tt.account, Pager: Pager{Page: 1, PerPage: 10}}, repos.apiKeys)
+			if err != nil {
+				t.Fatalf("ListApiKeys() error = %v", err)
+			}
+
+			var names []string
+			for _, key := range keys {
+				names = append(names, key.Name)
+			}
+			slices.Sort(names)
+			if !slices.Equal(names, tt.wantListed) {
+				t.Errorf("ListApiKeys() = %v, want %v", names, tt.wantListed)
+			}
+		})
+	}
+
+	// keys can only be revoked by the accounts that can list them
+	if err := s.RevokeApiKey(ctx, RevokeApiKeyInput{Account: member, KeyId: adminKey.GetId()}, repos.apiKeys); err == nil {
+		t.Error("RevokeApiKey() of another account's key succeeded")
+	}
+	if err := s.RevokeApiKey(ctx, RevokeApiKeyInput{Account: member, KeyId: serviceKey.GetId()}, repos.apiKeys); err == nil {
+		t.Error("RevokeApiKey() of the organisation's key by a member succeeded")
+	}
+	if err := s.RevokeApiKey(ctx, RevokeApiKeyInput{Account: otherAdmin, KeyId: serviceKey.GetId()}, repos.apiKeys); err != nil {
+		t.Errorf("RevokeApiKey() of the organisation's key by an admin error = %v", err)
+	}
+	if err := s.RevokeApiKey(ctx, RevokeApiKeyInput{Account: member, KeyId: memberKey.GetId()}, repos.apiKeys); err != nil {
+		t.Errorf("RevokeApiKey() of the account's own key error = %v", err)
+	}
+
+	for _, key := range []*models.ApiKey{adminKey, serviceKey, memberKey} {
+		stored, err := repos.apiKeys.FindOne(ctx, repository.NewQueryFilter().AddFilter(models.FieldId, key.ID), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wantActive := key == adminKey; stored.IsActive(time.Now()) != wantActive {
+			t.Errorf("key %s active = %v, want %v", key.Name, !wantActive, wantActive)
+		}
+	}
+}
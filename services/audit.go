@@ -0,0 +1,42 @@
+package services
+
+import (
+	"context"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+type (
+	AuditService struct {
+		conf *env.Environment
+	}
+
+	ListAuditEntriesInput struct {
+		Pager
+		Filters audit.Filters
+	}
+)
+
+func NewAuditService(conf *env.Environment) *AuditService {
+	return &AuditService{
+		conf: conf,
+	}
+}
+
+var _ AuditServiceInterface = (*AuditService)(nil)
+
+func (s *AuditService) ListAuditEntries(ctx context.Context,
+	input ListAuditEntriesInput,
+	auditLogRepo *repository.Repository[models.AuditEntry],
+) ([]models.AuditEntry, *repository.Paginator, error) {
+
+	entries, paginator, err := auditLogRepo.Paginate(ctx, input.Filters.QueryFilter(), input.Page, input.PerPage, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return entries, paginator, nil
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/consumer"
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+type (
+	DeadLetterService struct {
+		conf     *env.Environment
+		auditLog *audit.Log
+	}
+
+	ListDeadLettersInput struct {
+		Pager
+		EventKey string
+		Group    string
+
+		// IncludeRequeued lists dead letters that have already been requeued as well.
+		IncludeRequeued bool
+	}
+
+	RequeueDeadLetterInput struct {
+		// Actor is the admin requeueing the event, it is nil when requeued from the command line.
+		Actor        *models.AccountInfo
+		DeadLetterId string
+	}
+)
+
+func NewDeadLetterService(conf *env.Environment, auditLog *audit.Log) *DeadLetterService {
+	return &DeadLetterService{
+		conf:     conf,
+		auditLog: auditLog,
+	}
+}
+
+var _ DeadLetterServiceInterface = (*DeadLetterService)(nil)
+
+func (s *DeadLetterService) ListDeadLetters(ctx context.Context,
+	input ListDeadLettersInput,
+	deadLettersRepo *repository.Repository[models.DeadLetter],
+) ([]models.DeadLetter, *repository.Paginator, error) {
+
+	filter := repository.NewQueryFilter()
+	if input.EventKey != "" {
+		filter.AddFilter(models.FieldDeadLetterEventKey, input.EventKey)
+	}
+	if input.Group != "" {
+		filter.AddFilter(models.FieldDeadLetterGroup, input.Group)
+	}
+	if !input.IncludeRequeued {
+		filter.AddFilter(models.FieldDeadLetterRequeuedAt, map[string]interface{}{"$exists": false})
+	}
+
+	deadLetters, paginator, err := deadLettersRepo.Paginate(ctx, filter, input.Page, input.PerPage, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return deadLetters, paginator, nil
+}
+
+func (s *DeadLetterService) GetDeadLetter(ctx context.Context,
+	deadLetterId string,
+	deadLettersRepo *repository.Repository[models.DeadLetter],
+) (*models.DeadLetter, error) {
+	id, err := primitive.ObjectIDFromHex(deadLetterId)
+	if err != nil {
+		return nil, errors.New("invalid id")
+	}
+
+	deadLetter, err := deadLettersRepo.FindOne(ctx, repository.NewQueryFilter().AddFilter(models.FieldId, id), nil, nil)
+	if err != nil {
+		if err == repository.NoDocumentsFound {
+			return nil, errors.New("dead letter not found")
+		}
+		return nil, err
+	}
+
+	return &deadLetter, nil
+}
+
+// RequeueDeadLetter gives the event a fresh set of attempts in the group that gave up on it. The
+// dead letter is kept, marked as requeued, and a new one is written if the event fails again.
+func (s *DeadLetterService) RequeueDeadLetter(ctx context.Context,
+	input RequeueDeadLetterInput,
+	deadLettersRepo *repository.Repository[models.DeadLetter],
+	eventQueue consumer.Requeuer,
+) (*models.DeadLetter, error) {
+
+	if eventQueue == nil {
+		return nil, errors.New("dead letters can't be requeued on this event queue")
+	}
+
+	deadLetter, err := s.GetDeadLetter(ctx, input.DeadLetterId, deadLettersRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadLetter.IsRequeued() {
+		return nil, errors.New("dead letter has already been requeued")
+	}
+
+	err = eventQueue.Requeue(ctx, *deadLetter)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	deadLetter.RequeuedAt = &now
+	deadLetter.RequeuedBy = input.Actor
+
+	updated, err := deadLettersRepo.Update(ctx, *deadLetter)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionDeadLetterRequeued,
+		TargetType: audit.TargetDeadLetter,
+		TargetId:   updated.GetId(),
+		Metadata:   map[string]string{"event_id": updated.EventId, "event_key": updated.EventKey, "group": updated.Group},
+		Actor:      input.Actor,
+	})
+
+	return &updated, nil
+}
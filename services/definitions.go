@@ -2,7 +2,10 @@ package services
 
 import (
 	"context"
+	"time"
 
+	"github.com/tejiriaustin/narx_api/consumer"
+	"github.com/tejiriaustin/narx_api/limiter"
 	"github.com/tejiriaustin/narx_api/models"
 	"github.com/tejiriaustin/narx_api/publisher"
 	"github.com/tejiriaustin/narx_api/repository"
@@ -15,27 +18,142 @@ type (
 			input AddAccountInput,
 			passwordGen utils.StrGenFunc,
 			accountsRepo *repository.Repository[models.Account],
+			publisher publisher.PublishInterface,
+		) (*models.Account, error)
+
+		VerifyEmail(ctx context.Context,
+			input VerifyEmailInput,
+			accountsRepo *repository.Repository[models.Account],
 		) (*models.Account, error)
 
+		ResendVerification(ctx context.Context,
+			input ResendVerificationInput,
+			accountsRepo *repository.Repository[models.Account],
+			publisher publisher.PublishInterface,
+		) error
+
 		EditAccount(ctx context.Context,
 			input EditAccountInput,
 			accountsRepo *repository.Repository[models.Account],
 		) (*models.Account, error)
 
+		ChangePassword(ctx context.Context,
+			input ChangePasswordInput,
+			accountsRepo *repository.Repository[models.Account],
+		) (*models.Account, error)
+
+		ChangeEmail(ctx context.Context,
+			input ChangeEmailInput,
+			accountsRepo *repository.Repository[models.Account],
+			publisher publisher.PublishInterface,
+		) error
+
+		StartPhoneVerification(ctx context.Context,
+			input StartPhoneVerificationInput,
+			accountsRepo *repository.Repository[models.Account],
+			publisher publisher.PublishInterface,
+		) error
+
+		VerifyPhone(ctx context.Context,
+			input VerifyPhoneInput,
+			accountsRepo *repository.Repository[models.Account],
+		) (*models.Account, error)
+
+		RemovePhone(ctx context.Context,
+			input RemovePhoneInput,
+			accountsRepo *repository.Repository[models.Account],
+		) (*models.Account, error)
+
+		DeleteAccount(ctx context.Context,
+			input DeleteAccountInput,
+			accountsRepo *repository.Repository[models.Account],
+			sensorRepo *repository.Repository[models.Sensor],
+			devicesRepo *repository.Repository[models.Devices],
+			apiKeysRepo *repository.Repository[models.ApiKey],
+			preferencesRepo *repository.Repository[models.NotificationPreferences],
+			inboxRepo *repository.Repository[models.InboxNotification],
+			webhooksRepo *repository.Repository[models.WebhookSubscription],
+			deliveriesRepo *repository.Repository[models.WebhookDelivery],
+		) error
+
+		ExportAccountData(ctx context.Context,
+			input ExportAccountDataInput,
+			accountsRepo *repository.Repository[models.Account],
+			sensorRepo *repository.Repository[models.Sensor],
+			devicesRepo *repository.Repository[models.Devices],
+			apiKeysRepo *repository.Repository[models.ApiKey],
+			preferencesRepo *repository.Repository[models.NotificationPreferences],
+			inboxRepo *repository.Repository[models.InboxNotification],
+			webhooksRepo *repository.Repository[models.WebhookSubscription],
+			deliveriesRepo *repository.Repository[models.WebhookDelivery],
+		) ([]byte, error)
+
 		LoginUser(ctx context.Context,
 			input LoginUserInput,
 			accountsRepo *repository.Repository[models.Account],
+			loginLimiter *limiter.LoginLimiter,
+			publisher publisher.PublishInterface,
+		) (*models.Account, error)
+
+		VerifyTwoFactorLogin(ctx context.Context,
+			input VerifyTwoFactorLoginInput,
+			accountsRepo *repository.Repository[models.Account],
+			loginLimiter *limiter.LoginLimiter,
+		) (*models.Account, error)
+
+		StartOIDCLogin(ctx context.Context,
+			input StartOIDCLoginInput,
+			organisationsRepo *repository.Repository[models.Organisation],
+		) (*OIDCLoginRequest, error)
+
+		CompleteOIDCLogin(ctx context.Context,
+			input CompleteOIDCLoginInput,
+			organisationsRepo *repository.Repository[models.Organisation],
+			accountsRepo *repository.Repository[models.Account],
 		) (*models.Account, error)
 
+		EnrollTwoFactor(ctx context.Context,
+			input EnrollTwoFactorInput,
+			accountsRepo *repository.Repository[models.Account],
+		) (*TwoFactorEnrollment, error)
+
+		ConfirmTwoFactor(ctx context.Context,
+			input ConfirmTwoFactorInput,
+			accountsRepo *repository.Repository[models.Account],
+		) ([]string, error)
+
+		DisableTwoFactor(ctx context.Context,
+			input DisableTwoFactorInput,
+			accountsRepo *repository.Repository[models.Account],
+		) error
+
 		ForgotPassword(ctx context.Context,
 			input ForgotPasswordInput,
 			accountsRepo *repository.Repository[models.Account],
+			resetLimiter *limiter.RateLimiter,
 			publisher publisher.PublishInterface,
-		) (*models.Account, error)
+		) error
 
 		ResetPassword(ctx context.Context,
 			input ResetPasswordInput,
 			accountsRepo *repository.Repository[models.Account],
+			resetLimiter *limiter.RateLimiter,
+		) (*models.Account, error)
+
+		SuspendAccount(ctx context.Context,
+			input SuspendAccountInput,
+			accountsRepo *repository.Repository[models.Account],
+		) (*models.Account, error)
+
+		ReactivateAccount(ctx context.Context,
+			input ReactivateAccountInput,
+			accountsRepo *repository.Repository[models.Account],
+		) (*models.Account, error)
+
+		ImpersonateAccount(ctx context.Context,
+			input ImpersonateAccountInput,
+			accountsRepo *repository.Repository[models.Account],
+			impersonationsRepo *repository.Repository[models.Impersonation],
 		) (*models.Account, error)
 
 		ListAccounts(ctx context.Context,
@@ -58,6 +176,7 @@ type (
 
 		GetSensor(ctx context.Context,
 			sensorId string,
+			accountId string,
 			sensorRepo *repository.Repository[models.Sensor],
 		) (*models.Sensor, error)
 
@@ -68,6 +187,7 @@ type (
 
 		DeleteSensor(ctx context.Context,
 			sensorId string,
+			accountId string,
 			sensorRepo *repository.Repository[models.Sensor],
 		) error
 	}
@@ -77,6 +197,131 @@ type (
 			ctx context.Context,
 			input SaveDeviceTokenInput,
 			devicesRepo *repository.Repository[models.Devices],
+		) (*models.Devices, error)
+
+		ListDevices(ctx context.Context,
+			input ListDevicesInput,
+			devicesRepo *repository.Repository[models.Devices],
+		) ([]models.Devices, *repository.Paginator, error)
+
+		DeleteDevice(ctx context.Context,
+			input DeleteDeviceInput,
+			devicesRepo *repository.Repository[models.Devices],
+		) error
+
+		ExpireDevices(ctx context.Context,
+			olderThan time.Duration,
+			devicesRepo *repository.Repository[models.Devices],
+		) error
+	}
+
+	ApiKeyServiceInterface interface {
+		CreateApiKey(ctx context.Context,
+			input CreateApiKeyInput,
+			apiKeysRepo *repository.Repository[models.ApiKey],
+		) (*models.ApiKey, error)
+
+		ListApiKeys(ctx context.Context,
+			input ListApiKeysInput,
+			apiKeysRepo *repository.Repository[models.ApiKey],
+		) ([]models.ApiKey, *repository.Paginator, error)
+
+		RevokeApiKey(ctx context.Context,
+			input RevokeApiKeyInput,
+			apiKeysRepo *repository.Repository[models.ApiKey],
+		) error
+	}
+
+	NotificationServiceInterface interface {
+		GetNotificationPreferences(ctx context.Context,
+			account models.AccountInfo,
+			preferencesRepo *repository.Repository[models.NotificationPreferences],
+		) (*models.NotificationPreferences, error)
+
+		UpdateNotificationPreferences(ctx context.Context,
+			input UpdateNotificationPreferencesInput,
+			preferencesRepo *repository.Repository[models.NotificationPreferences],
+		) (*models.NotificationPreferences, error)
+
+		NotifyUser(ctx context.Context,
+			input NotifyUserInput,
+			accountsRepo *repository.Repository[models.Account],
+			publisher publisher.PublishInterface,
+		) error
+
+		ListNotifications(ctx context.Context,
+			input ListNotificationsInput,
+			inboxRepo *repository.Repository[models.InboxNotification],
+		) ([]models.InboxNotification, *repository.Paginator, error)
+
+		CountUnreadNotifications(ctx context.Context,
+			accountId string,
+			inboxRepo *repository.Repository[models.InboxNotification],
+		) (int64, error)
+
+		MarkNotificationRead(ctx context.Context,
+			input MarkNotificationReadInput,
+			inboxRepo *repository.Repository[models.InboxNotification],
+		) (*models.InboxNotification, error)
+
+		MarkAllNotificationsRead(ctx context.Context,
+			accountId string,
+			inboxRepo *repository.Repository[models.InboxNotification],
+		) error
+	}
+
+	AuditServiceInterface interface {
+		ListAuditEntries(ctx context.Context,
+			input ListAuditEntriesInput,
+			auditLogRepo *repository.Repository[models.AuditEntry],
+		) ([]models.AuditEntry, *repository.Paginator, error)
+	}
+
+	WebhookServiceInterface interface {
+		CreateWebhook(ctx context.Context,
+			input CreateWebhookInput,
+			webhooksRepo *repository.Repository[models.WebhookSubscription],
+		) (*models.WebhookSubscription, error)
+
+		ListWebhooks(ctx context.Context,
+			input ListWebhooksInput,
+			webhooksRepo *repository.Repository[models.WebhookSubscription],
+		) ([]models.WebhookSubscription, *repository.Paginator, error)
+
+		DeleteWebhook(ctx context.Context,
+			input DeleteWebhookInput,
+			webhooksRepo *repository.Repository[models.WebhookSubscription],
+			deliveriesRepo *repository.Repository[models.WebhookDelivery],
 		) error
+
+		ListWebhookDeliveries(ctx context.Context,
+			input ListWebhookDeliveriesInput,
+			webhooksRepo *repository.Repository[models.WebhookSubscription],
+			deliveriesRepo *repository.Repository[models.WebhookDelivery],
+		) ([]models.WebhookDelivery, *repository.Paginator, error)
+
+		RedeliverWebhook(ctx context.Context,
+			input RedeliverWebhookInput,
+			webhooksRepo *repository.Repository[models.WebhookSubscription],
+			deliveriesRepo *repository.Repository[models.WebhookDelivery],
+		) (*models.WebhookDelivery, error)
+	}
+
+	DeadLetterServiceInterface interface {
+		ListDeadLetters(ctx context.Context,
+			input ListDeadLettersInput,
+			deadLettersRepo *repository.Repository[models.DeadLetter],
+		) ([]models.DeadLetter, *repository.Paginator, error)
+
+		GetDeadLetter(ctx context.Context,
+			deadLetterId string,
+			deadLettersRepo *repository.Repository[models.DeadLetter],
+		) (*models.DeadLetter, error)
+
+		RequeueDeadLetter(ctx context.Context,
+			input RequeueDeadLetterInput,
+			deadLettersRepo *repository.Repository[models.DeadLetter],
+			eventQueue consumer.Requeuer,
+		) (*models.DeadLetter, error)
 	}
 )
@@ -3,53 +3,195 @@ package services
 import (
 	"context"
 	"errors"
+	"github.com/tejiriaustin/narx_api/audit"
 	"github.com/tejiriaustin/narx_api/env"
 	"github.com/tejiriaustin/narx_api/models"
 	"github.com/tejiriaustin/narx_api/repository"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"log"
+	"strings"
 	"time"
 )
 
 type DeviceService struct {
-	conf *env.Environment
+	conf     *env.Environment
+	auditLog *audit.Log
 }
 
-func NewDeviceService(conf *env.Environment) *DeviceService {
+func NewDeviceService(conf *env.Environment, auditLog *audit.Log) *DeviceService {
 	return &DeviceService{
-		conf: conf,
+		conf:     conf,
+		auditLog: auditLog,
 	}
 }
 
 type (
 	SaveDeviceTokenInput struct {
-		DeviceToken string             `json:"deviceToken" bson:"device_token"`
-		AccountInfo models.AccountInfo `json:"accountInfo" bson:"account_info"`
+		DeviceToken string                `json:"deviceToken" bson:"device_token"`
+		AccountInfo models.AccountInfo    `json:"accountInfo" bson:"account_info"`
+		Platform    models.DevicePlatform `json:"platform" bson:"platform"`
+		AppVersion  string                `json:"appVersion" bson:"app_version"`
+		Locale      string                `json:"locale" bson:"locale"`
+	}
+
+	ListDevicesInput struct {
+		Pager
+		AccountId string
+	}
+
+	DeleteDeviceInput struct {
+		AccountId string
+		DeviceId  string
 	}
 )
 
+var _ DeviceServiceInterface = (*DeviceService)(nil)
+
+// SaveDeviceToken registers the device, or refreshes it if the token is already known. A token
+// belongs to a single app install, so it is moved to whichever account registers it last.
 func (s *DeviceService) SaveDeviceToken(ctx context.Context,
 	input SaveDeviceTokenInput,
 	devicesRepo *repository.Repository[models.Devices],
-) error {
+) (*models.Devices, error) {
 	if input.DeviceToken == "" {
 		log.Println("device token is required")
-		return errors.New("device token is required")
+		return nil, errors.New("device token is required")
 	}
 
-	now := time.Now()
+	platform := models.DevicePlatform(strings.ToLower(string(input.Platform)))
+	if platform != "" && !models.IsValidDevicePlatform(platform) {
+		return nil, errors.New("invalid platform: " + string(input.Platform))
+	}
 
-	device := models.Devices{
-		Shared: models.Shared{
-			ID:        primitive.NewObjectID(),
-			CreatedAt: &now,
-		},
-		AccountInfo: input.AccountInfo,
-		DeviceToken: input.DeviceToken,
+	now := time.Now().UTC()
+	filter := repository.NewQueryFilter().AddFilter(models.FieldDeviceToken, input.DeviceToken)
+
+	device, err := devicesRepo.FindOne(ctx, filter, nil, nil)
+	if err != nil && err != repository.NoDocumentsFound {
+		return nil, err
+	}
+
+	if err == repository.NoDocumentsFound {
+		device = models.Devices{
+			Shared: models.Shared{
+				ID:        primitive.NewObjectID(),
+				CreatedAt: &now,
+			},
+			AccountInfo: input.AccountInfo,
+			DeviceToken: input.DeviceToken,
+			Platform:    platform,
+			AppVersion:  input.AppVersion,
+			Locale:      input.Locale,
+			LastSeenAt:  &now,
+		}
+		device, err = devicesRepo.Create(ctx, device)
+		if err != nil {
+			return nil, err
+		}
+
+		s.auditLog.Record(ctx, audit.Entry{
+			Action:     audit.ActionDeviceRegistered,
+			TargetType: audit.TargetDevice,
+			TargetId:   device.GetId(),
+			After:      device,
+		})
+		return &device, nil
+	}
+
+	device.AccountInfo = input.AccountInfo
+	if platform != "" {
+		device.Platform = platform
+	}
+	if input.AppVersion != "" {
+		device.AppVersion = input.AppVersion
 	}
-	_, err := devicesRepo.Create(ctx, device)
+	if input.Locale != "" {
+		device.Locale = input.Locale
+	}
+	device.LastSeenAt = &now
+
+	device, err = devicesRepo.Update(ctx, device)
 	if err != nil {
+		return nil, err
+	}
+
+	// earlier versions inserted a new document on every app start
+	duplicates := repository.NewQueryFilter().
+		AddFilter(models.FieldDeviceToken, input.DeviceToken).
+		AddFilter(models.FieldId, map[string]interface{}{"$ne": device.ID})
+	if err = devicesRepo.DeleteMany(ctx, duplicates); err != nil {
+		log.Printf("failed to remove duplicate devices: %s", err)
+	}
+
+	return &device, nil
+}
+
+func (s *DeviceService) ListDevices(ctx context.Context,
+	input ListDevicesInput,
+	devicesRepo *repository.Repository[models.Devices],
+) ([]models.Devices, *repository.Paginator, error) {
+
+	filter := repository.NewQueryFilter().AddFilter(models.FieldAccountInfoId, input.AccountId)
+
+	devices, paginator, err := devicesRepo.Paginate(ctx, filter, input.Page, input.PerPage, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return devices, paginator, nil
+}
+
+func (s *DeviceService) DeleteDevice(ctx context.Context,
+	input DeleteDeviceInput,
+	devicesRepo *repository.Repository[models.Devices],
+) error {
+	id, err := primitive.ObjectIDFromHex(input.DeviceId)
+	if err != nil {
+		return errors.New("invalid id")
+	}
+
+	filter := repository.NewQueryFilter().
+		AddFilter(models.FieldId, id).
+		AddFilter(models.FieldAccountInfoId, input.AccountId)
+
+	device, err := devicesRepo.FindOne(ctx, filter, nil, nil)
+	if err != nil {
+		if err == repository.NoDocumentsFound {
+			return errors.New("device not found")
+		}
 		return err
 	}
+
+	err = devicesRepo.DeleteMany(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionDeviceUnregistered,
+		TargetType: audit.TargetDevice,
+		TargetId:   device.GetId(),
+		Before:     device,
+	})
+
 	return nil
 }
+
+// ExpireDevices removes devices that haven't checked in since before the given period.
+func (s *DeviceService) ExpireDevices(ctx context.Context,
+	olderThan time.Duration,
+	devicesRepo *repository.Repository[models.Devices],
+) error {
+	cutoff := time.Now().UTC().Add(-olderThan)
+
+	filter := repository.NewQueryFilter().AddFilter("$or", []map[string]interface{}{
+		{models.FieldDeviceLastSeenAt: map[string]interface{}{"$lt": cutoff}},
+		// devices registered before check-ins were tracked
+		{
+			models.FieldDeviceLastSeenAt: map[string]interface{}{"$exists": false},
+			"created_at":                 map[string]interface{}{"$lt": cutoff},
+		},
+	})
+
+	return devicesRepo.DeleteMany(ctx, filter)
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/database"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+func TestSaveDeviceToken(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := NewDeviceService(newTestConfig(), audit.NewLog(repos.auditLog))
+	devicesRepo := repository.NewRepository[models.Devices](database.NewMemoryCollection())
+
+	ada := models.AccountInfo{Id: "ada"}
+	obi := models.AccountInfo{Id: "obi"}
+
+	first, err := s.SaveDeviceToken(ctx, SaveDeviceTokenInput{DeviceToken: "token", AccountInfo: ada, Platform: "iOS", AppVersion: "1.0"}, devicesRepo)
+	if err != nil {
+		t.Fatalf("SaveDeviceToken() error = %v", err)
+	}
+	if first.Platform != models.PlatformIOS {
+		t.Errorf("platform = %q, want %q", first.Platform, models.PlatformIOS)
+	}
+
+	// an app start reports the same token again, and then it is signed in to another account
+	again, err := s.SaveDeviceToken(ctx, SaveDeviceTokenInput{DeviceToken: "token", AccountInfo: ada, AppVersion: "1.1"}, devicesRepo)
+	if err != nil {
+		t.Fatalf("SaveDeviceToken() again error = %v", err)
+	}
+	moved, err := s.SaveDeviceToken(ctx, SaveDeviceTokenInput{DeviceToken: "token", AccountInfo: obi}, devicesRepo)
+	if err != nil {
+		t.Fatalf("SaveDeviceToken() for another account error = %v", err)
+	}
+	if again.ID != first.ID || moved.ID != first.ID {
+		t.Errorf("device ids %s, %s, %s, want the token to keep one device", first.GetId(), again.GetId(), moved.GetId())
+	}
+	if moved.AccountInfo.Id != obi.Id || moved.AppVersion != "1.1" || moved.Platform != models.PlatformIOS {
+		t.Errorf("device = %+v, want it moved to obi keeping its platform and app version", moved)
+	}
+
+	for _, input := range []SaveDeviceTokenInput{
+		{AccountInfo: ada},
+		{DeviceToken: "other", AccountInfo: ada, Platform: "blackberry"},
+	} {
+		if _, err = s.SaveDeviceToken(ctx, input, devicesRepo); err == nil {
+			t.Errorf("SaveDeviceToken(%+v) succeeded", input)
+		}
+	}
+
+	count, err := devicesRepo.Count(ctx, repository.NewQueryFilter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("%d devices stored, want 1", count)
+	}
+}
+
+func TestSaveDeviceTokenRemovesDuplicates(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := NewDeviceService(newTestConfig(), audit.NewLog(repos.auditLog))
+	devicesRepo := repository.NewRepository[models.Devices](database.NewMemoryCollection())
+
+	// earlier versions stored the token again on every app start
+	for i := 0; i < 3; i++ {
+		now := time.Now().UTC()
+		_, err := devicesRepo.Create(ctx, models.Devices{
+			Shared:      models.Shared{ID: primitive.NewObjectID(), CreatedAt: &now},
+			AccountInfo: models.AccountInfo{Id: "ada"},
+			DeviceToken: "token",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := s.SaveDeviceToken(ctx, SaveDeviceTokenInput{DeviceToken: "token", AccountInfo: models.AccountInfo{Id: "ada"}}, devicesRepo); err != nil {
+		t.Fatalf("SaveDeviceToken() error = %v", err)
+	}
+
+	count, err := devicesRepo.Count(ctx, repository.NewQueryFilter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("%d devices stored, want the duplicates removed", count)
+	}
+}
+
+func TestDeleteDevice(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := NewDeviceService(newTestConfig(), audit.NewLog(repos.auditLog))
+	devicesRepo := repository.NewRepository[models.Devices](database.NewMemoryCollection())
+
+	device, err := s.SaveDeviceToken(ctx, SaveDeviceTokenInput{DeviceToken: "token", AccountInfo: models.AccountInfo{Id: "ada"}}, devicesRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.DeleteDevice(ctx, DeleteDeviceInput{AccountId: "obi", DeviceId: device.GetId()}, devicesRepo); err == nil {
+		t.Error("DeleteDevice() of another account's device succeeded")
+	}
+	if err = s.DeleteDevice(ctx, DeleteDeviceInput{AccountId: "ada", DeviceId: device.GetId()}, devicesRepo); err != nil {
+		t.Fatalf("DeleteDevice() error = %v", err)
+	}
+
+	devices, _, err := s.ListDevices(ctx, ListDevicesInput{AccountId: "ada", Pager: Pager{Page: 1, PerPage: 10}}, devicesRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 0 {
+		t.Errorf("ListDevices() = %d devices after unregistering, want none", len(devices))
+	}
+}
+
+func TestExpireDevices(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := NewDeviceService(newTestConfig(), audit.NewLog(repos.auditLog))
+	devicesRepo := repository.NewRepository[models.Devices](database.NewMemoryCollection())
+
+	now := time.Now().UTC()
+	recently, longAgo := now.Add(-24*time.Hour), now.Add(-90*24*time.Hour)
+
+	for _, device := range []struct {
+		token      string
+		createdAt  time.Time
+		lastSeenAt *time.Time
+	}{
+		{token: "seen recently", createdAt: longAgo, lastSeenAt: &recently},
+		{token: "seen long ago", createdAt: longAgo, lastSeenAt: &longAgo},
+		{token: "never seen, registered recently", createdAt: recently},
+		{token: "never seen, registered long ago", createdAt: longAgo},
+	} {
+		_, err := devicesRepo.Create(ctx, models.Devices{
+			Shared:      models.Shared{ID: primitive.NewObjectID(), CreatedAt: &device.createdAt},
+			AccountInfo: models.AccountInfo{Id: "ada"},
+			DeviceToken: device.token,
+			LastSeenAt:  device.lastSeenAt,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.ExpireDevices(ctx, 60*24*time.Hour, devicesRepo); err != nil {
+		t.Fatalf("ExpireDevices() error = %v", err)
+	}
+
+	devices, err := devicesRepo.Find(ctx, repository.NewQueryFilter(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	for _, device := range devices {
+		kept = append(kept, device.DeviceToken)
+	}
+	slices.Sort(kept)
+	if want := []string{"never seen, registered recently", "seen recently"}; !slices.Equal(kept, want) {
+		t.Errorf("devices left %v, want %v", kept, want)
+	}
+}
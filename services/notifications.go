@@ -0,0 +1,276 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/events"
+	"github.com/tejiriaustin/narx_api/events/notifications"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/publisher"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+type (
+	NotificationService struct {
+		conf     *env.Environment
+		auditLog *audit.Log
+	}
+
+	UpdateNotificationPreferencesInput struct {
+		AccountInfo  models.AccountInfo
+		Rules        []models.NotificationRule
+		Timezone     string
+		QuietHours   *models.QuietHours
+		CriticalOnly bool
+		Digest       models.DigestFrequency
+	}
+
+	NotifyUserInput struct {
+		AccountId string
+		EventType models.NotificationEventType
+		Severity  models.Severity
+		Title     string
+		Body      string
+		Data      map[string]string
+	}
+
+	ListNotificationsInput struct {
+		Pager
+		AccountId  string
+		UnreadOnly bool
+	}
+
+	MarkNotificationReadInput struct {
+		AccountId      string
+		NotificationId string
+	}
+)
+
+func NewNotificationService(conf *env.Environment, auditLog *audit.Log) *NotificationService {
+	return &NotificationService{
+		conf:     conf,
+		auditLog: auditLog,
+	}
+}
+
+var _ NotificationServiceInterface = (*NotificationService)(nil)
+
+// GetNotificationPreferences returns the account's saved preferences, or the defaults if it has none.
+func (s *NotificationService) GetNotificationPreferences(ctx context.Context,
+	account models.AccountInfo,
+	preferencesRepo *repository.Repository[models.NotificationPreferences],
+) (*models.NotificationPreferences, error) {
+
+	filter := repository.NewQueryFilter().AddFilter(models.FieldAccountInfoId, account.Id)
+
+	preferences, err := preferencesRepo.FindOne(ctx, filter, nil, nil)
+	if err != nil {
+		if err == repository.NoDocumentsFound {
+			defaults := models.DefaultNotificationPreferences(account)
+			return &defaults, nil
+		}
+		return nil, err
+	}
+
+	return &preferences, nil
+}
+
+// UpdateNotificationPreferences replaces the account's preferences.
+func (s *NotificationService) UpdateNotificationPreferences(ctx context.Context,
+	input UpdateNotificationPreferencesInput,
+	preferencesRepo *repository.Repository[models.NotificationPreferences],
+) (*models.NotificationPreferences, error) {
+
+	current, err := s.GetNotificationPreferences(ctx, input.AccountInfo, preferencesRepo)
+	if err != nil {
+		return nil, err
+	}
+	before := *current
+
+	timezone := input.Timezone
+	if timezone == "" {
+		timezone = "UTC"
+	}
+
+	preferences := *current
+	preferences.AccountInfo = input.AccountInfo
+	preferences.Rules = input.Rules
+	preferences.Timezone = timezone
+	preferences.QuietHours = input.QuietHours
+	preferences.CriticalOnly = input.CriticalOnly
+	preferences.Digest = input.Digest
+
+	if err = preferences.Validate(); err != nil {
+		return nil, err
+	}
+
+	if preferences.ID.IsZero() {
+		now := time.Now().UTC()
+		preferences.Shared = models.Shared{
+			ID:        primitive.NewObjectID(),
+			CreatedAt: &now,
+		}
+		preferences, err = preferencesRepo.Create(ctx, preferences)
+	} else {
+		preferences, err = preferencesRepo.Update(ctx, preferences)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionPreferencesUpdated,
+		TargetType: audit.TargetAccount,
+		TargetId:   input.AccountInfo.Id,
+		Before:     before,
+		After:      preferences,
+	})
+
+	return &preferences, nil
+}
+
+// NotifyUser publishes a notification for the listener to add to the account's inbox and deliver
+// on the channels the account has chosen.
+func (s *NotificationService) NotifyUser(ctx context.Context,
+	input NotifyUserInput,
+	accountsRepo *repository.Repository[models.Account],
+	publisher publisher.PublishInterface,
+) error {
+	if input.AccountId == "" {
+		return errors.New("account is required")
+	}
+	if !models.IsValidNotificationEventType(input.EventType) {
+		return errors.New("invalid event type: " + string(input.EventType))
+	}
+	if !models.IsValidSeverity(input.Severity) {
+		return errors.New("invalid severity: " + string(input.Severity))
+	}
+	if input.Title == "" {
+		return errors.New("notification title is required")
+	}
+
+	account, err := findAccountById(ctx, input.AccountId, accountsRepo)
+	if err != nil {
+		return err
+	}
+
+	event, err := events.Encode(notifications.UserNotification, notifications.UserNotificationPayload{
+		AccountId: input.AccountId,
+		EventType: input.EventType,
+		Severity:  input.Severity,
+		Title:     input.Title,
+		Body:      input.Body,
+		Data:      input.Data,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = publisher.Publish(ctx, notifications.UserNotification, "notification", event)
+	if err != nil {
+		return err
+	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionNotificationSent,
+		TargetType: audit.TargetAccount,
+		TargetId:   account.GetId(),
+		Metadata: map[string]string{
+			"event_type": string(input.EventType),
+			"severity":   string(input.Severity),
+			"title":      input.Title,
+		},
+	})
+
+	return nil
+}
+
+func (s *NotificationService) ListNotifications(ctx context.Context,
+	input ListNotificationsInput,
+	inboxRepo *repository.Repository[models.InboxNotification],
+) ([]models.InboxNotification, *repository.Paginator, error) {
+
+	filter := repository.NewQueryFilter().AddFilter(models.FieldAccountInfoId, input.AccountId)
+	if input.UnreadOnly {
+		filter.AddFilter(models.FieldInboxReadAt, nil)
+	}
+
+	inbox, paginator, err := inboxRepo.Paginate(ctx, filter, input.Page, input.PerPage, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return inbox, paginator, nil
+}
+
+func (s *NotificationService) CountUnreadNotifications(ctx context.Context,
+	accountId string,
+	inboxRepo *repository.Repository[models.InboxNotification],
+) (int64, error) {
+
+	filter := repository.NewQueryFilter().
+		AddFilter(models.FieldAccountInfoId, accountId).
+		AddFilter(models.FieldInboxReadAt, nil)
+
+	return inboxRepo.Count(ctx, filter)
+}
+
+func (s *NotificationService) MarkNotificationRead(ctx context.Context,
+	input MarkNotificationReadInput,
+	inboxRepo *repository.Repository[models.InboxNotification],
+) (*models.InboxNotification, error) {
+	id, err := primitive.ObjectIDFromHex(input.NotificationId)
+	if err != nil {
+		return nil, errors.New("invalid id")
+	}
+
+	filter := repository.NewQueryFilter().
+		AddFilter(models.FieldId, id).
+		AddFilter(models.FieldAccountInfoId, input.AccountId)
+
+	notification, err := inboxRepo.FindOne(ctx, filter, nil, nil)
+	if err != nil {
+		if err == repository.NoDocumentsFound {
+			return nil, errors.New("notification not found")
+		}
+		return nil, err
+	}
+
+	if notification.IsRead() {
+		return &notification, nil
+	}
+
+	now := time.Now().UTC()
+	notification.ReadAt = &now
+
+	notification, err = inboxRepo.Update(ctx, notification)
+	if err != nil {
+		return nil, err
+	}
+
+	return &notification, nil
+}
+
+func (s *NotificationService) MarkAllNotificationsRead(ctx context.Context,
+	accountId string,
+	inboxRepo *repository.Repository[models.InboxNotification],
+) error {
+
+	filter := repository.NewQueryFilter().
+		AddFilter(models.FieldAccountInfoId, accountId).
+		AddFilter(models.FieldInboxReadAt, nil)
+
+	updates := map[string]interface{}{
+		"$set": map[string]interface{}{
+			models.FieldInboxReadAt: time.Now().UTC(),
+		},
+	}
+
+	return inboxRepo.UpdateMany(ctx, filter, updates)
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/consumer"
+	"github.com/tejiriaustin/narx_api/database"
+	"github.com/tejiriaustin/narx_api/events"
+	"github.com/tejiriaustin/narx_api/events/notifications"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+func TestUpdateNotificationPreferences(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := NewNotificationService(newTestConfig(), audit.NewLog(repos.auditLog))
+	preferencesRepo := repository.NewRepository[models.NotificationPreferences](database.NewMemoryCollection())
+	account := models.AccountInfo{Id: "ada"}
+
+	defaults, err := s.GetNotificationPreferences(ctx, account, preferencesRepo)
+	if err != nil {
+		t.Fatalf("GetNotificationPreferences() error = %v", err)
+	}
+	if !defaults.ID.IsZero() || len(defaults.Rules) == 0 {
+		t.Errorf("preferences before any are saved = %+v, want the unsaved defaults", defaults)
+	}
+
+	rules := []models.NotificationRule{{EventType: models.EventTypeFault, MinSeverity: models.SeverityCritical, Channels: []models.NotificationChannel{models.ChannelSMS}}}
+	for i := 0; i < 2; i++ {
+		if _, err = s.UpdateNotificationPreferences(ctx, UpdateNotificationPreferencesInput{AccountInfo: account, Rules: rules}, preferencesRepo); err != nil {
+			t.Fatalf("UpdateNotificationPreferences() error = %v", err)
+		}
+	}
+
+	_, err = s.UpdateNotificationPreferences(ctx, UpdateNotificationPreferencesInput{
+		AccountInfo: account,
+		Rules:       []models.NotificationRule{{EventType: models.EventTypeFault, MinSeverity: models.SeverityInfo, Channels: []models.NotificationChannel{"pager"}}},
+	}, preferencesRepo)
+	if err == nil {
+		t.Error("UpdateNotificationPreferences() with an unknown channel succeeded")
+	}
+
+	saved, err := preferencesRepo.Find(ctx, repository.NewQueryFilter(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 {
+		t.Fatalf("%d preferences saved, want the account's one updated in place", len(saved))
+	}
+	if saved[0].Timezone != "UTC" || len(saved[0].Rules) != 1 || saved[0].Rules[0].MinSeverity != models.SeverityCritical {
+		t.Errorf("saved preferences = %+v, want the valid update in utc", saved[0])
+	}
+}
+
+func TestNotificationInbox(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := NewNotificationService(newTestConfig(), audit.NewLog(repos.auditLog))
+	inboxRepo := repository.NewRepository[models.InboxNotification](database.NewMemoryCollection())
+
+	var adas []models.InboxNotification
+	for _, accountId := range []string{"ada", "ada", "ada", "obi"} {
+		now := time.Now().UTC()
+		notification, err := inboxRepo.Create(ctx, models.InboxNotification{
+			Shared:      models.Shared{ID: primitive.NewObjectID(), CreatedAt: &now},
+			AccountInfo: models.AccountInfo{Id: accountId},
+			EventType:   models.EventTypeFault,
+			Severity:    models.SeverityWarning,
+			Title:       "Inverter fault",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accountId == "ada" {
+			adas = append(adas, notification)
+		}
+	}
+
+	unread := func(accountId string) int64 {
+		t.Helper()
+		count, err := s.CountUnreadNotifications(ctx, accountId, inboxRepo)
+		if err != nil {
+			t.Fatalf("CountUnreadNotifications() error = %v", err)
+		}
+		return count
+	}
+	list := func(unreadOnly bool) int {
+		t.Helper()
+		inbox, _, err := s.ListNotifications(ctx, ListNotificationsInput{AccountId: "ada", UnreadOnly: unreadOnly, Pager: Pager{Page: 1, PerPage: 10}}, inboxRepo)
+		if err != nil {
+			t.Fatalf("ListNotifications() error = %v", err)
+		}
+		return len(inbox)
+	}
+
+	if got := unread("ada"); got != 3 {
+		t.Errorf("unread = %d, want 3", got)
+	}
+
+	if _, err := s.MarkNotificationRead(ctx, MarkNotificationReadInput{AccountId: "obi", NotificationId: adas[0].GetId()}, inboxRepo); err == nil {
+		t.Error("MarkNotificationRead() of another account's notification succeeded")
+	}
+	read, err := s.MarkNotificationRead(ctx, MarkNotificationReadInput{AccountId: "ada", NotificationId: adas[0].GetId()}, inboxRepo)
+	if err != nil {
+		t.Fatalf("MarkNotificationRead() error = %v", err)
+	}
+	again, err := s.MarkNotificationRead(ctx, MarkNotificationReadInput{AccountId: "ada", NotificationId: adas[0].GetId()}, inboxRepo)
+	if err != nil {
+		t.Fatalf("MarkNotificationRead() again error = %v", err)
+	}
+	// stored times keep millisecond precision
+	if !again.ReadAt.Equal(read.ReadAt.Truncate(time.Millisecond)) {
+		t.Errorf("reading again moved the read time from %s to %s", read.ReadAt, again.ReadAt)
+	}
+
+	if got, want := unread("ada"), int64(2); got != want {
+		t.Errorf("unread after reading one = %d, want %d", got, want)
+	}
+	if got, want := list(true), 2; got != want {
+		t.Errorf("ListNotifications() unread only = %d, want %d", got, want)
+	}
+	if got, want := list(false), 3; got != want {
+		t.Errorf("ListNotifications() = %d, want %d", got, want)
+	}
+
+	if err = s.MarkAllNotificationsRead(ctx, "ada", inboxRepo); err != nil {
+		t.Fatalf("MarkAllNotificationsRead() error = %v", err)
+	}
+	if got := unread("ada"); got != 0 {
+		t.Errorf("unread after reading all = %d, want 0", got)
+	}
+	if got := unread("obi"); got != 1 {
+		t.Errorf("another account's unread after reading all = %d, want 1", got)
+	}
+}
+
+func TestNotifyUser(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := NewNotificationService(newTestConfig(), audit.NewLog(repos.auditLog))
+	account := createTestAccount(t, repos.accounts, "ada@example.com")
+
+	tests := []struct {
+		name    string
+		input   NotifyUserInput
+		wantErr bool
+	}{
+		{name: "notification", input: NotifyUserInput{AccountId: account.GetId(), EventType: models.EventTypeFault, Severity: models.SeverityWarning, Title: "Inverter fault"}},
+		{name: "no title", input: NotifyUserInput{AccountId: account.GetId(), EventType: models.EventTypeFault, Severity: models.SeverityWarning}, wantErr: true},
+		{name: "unknown event type", input: NotifyUserInput{AccountId: account.GetId(), EventType: "weather", Severity: models.SeverityWarning, Title: "Rain"}, wantErr: true},
+		{name: "unknown severity", input: NotifyUserInput{AccountId: account.GetId(), EventType: models.EventTypeFault, Severity: "urgent", Title: "Inverter fault"}, wantErr: true},
+		{name: "unknown account", input: NotifyUserInput{AccountId: primitive.NewObjectID().Hex(), EventType: models.EventTypeFault, Severity: models.SeverityWarning, Title: "Inverter fault"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := consumer.NewMemoryQueue()
+
+			err := s.NotifyUser(ctx, tt.input, repos.accounts, queue)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NotifyUser() error = %v, want error %v", err, tt.wantErr)
+			}
+
+			published := queue.Published()
+			if tt.wantErr {
+				if len(published) != 0 {
+					t.Errorf("%d events published for a rejected notification", len(published))
+				}
+				return
+			}
+
+			if len(published) != 1 || published[0].EventKey != notifications.UserNotification {
+				t.Fatalf("published %+v, want one %s event", published, notifications.UserNotification)
+			}
+			var payload notifications.UserNotificationPayload
+			if err = events.Decode(published[0], &payload); err != nil {
+				t.Fatal(err)
+			}
+			if payload.AccountId != account.GetId() || payload.Title != tt.input.Title {
+				t.Errorf("published %+v, want the notification for %s", payload, account.GetId())
+			}
+		})
+	}
+}
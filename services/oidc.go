@@ -0,0 +1,374 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/oidc"
+	"github.com/tejiriaustin/narx_api/repository"
+	"github.com/tejiriaustin/narx_api/utils"
+)
+
+const (
+	oidcFlowTTL     = 10 * time.Minute
+	oidcFlowPurpose = "oidc_login"
+	oidcLinkPurpose = "oidc_link"
+)
+
+var (
+	ErrOIDCNotConfigured = errors.New("single sign-on is not configured for this organisation")
+	ErrInvalidOIDCFlow   = errors.New("invalid or expired single sign-on request")
+	ErrNoLinkedAccount   = errors.New("no account is linked to this identity")
+	ErrOIDCLinkRequired  = errors.New("an account with this email already exists, sign in to it and link this identity from your account")
+	ErrIdentityLinked    = errors.New("this identity is already linked to another account")
+)
+
+type (
+	StartOIDCLoginInput struct {
+		OrganisationSlug string
+
+		// LinkAccount is set when a signed in account starts the flow to link the identity to itself.
+		LinkAccount *models.Account
+
+		// Reauthenticate asks the provider to sign the user in again even if they have a session there.
+		Reauthenticate bool
+	}
+
+	// OIDCLoginRequest is where to send the user, and the flow token the caller has to keep
+	// (in a cookie) and hand back to CompleteOIDCLogin.
+	OIDCLoginRequest struct {
+		AuthURL   string
+		FlowToken string
+	}
+
+	CompleteOIDCLoginInput struct {
+		OrganisationSlug string
+		Code             string
+		State            string
+		FlowToken        string
+	}
+
+	oidcFlowClaims struct {
+		Organisation string `json:"organisation"`
+		State        string `json:"state"`
+		Nonce        string `json:"nonce"`
+		Verifier     string `json:"verifier"`
+		Purpose      string `json:"purpose"`
+		LinkAccount  string `json:"link_account,omitempty"`
+	}
+)
+
+func (s *AccountsService) StartOIDCLogin(ctx context.Context,
+	input StartOIDCLoginInput,
+	organisationsRepo *repository.Repository[models.Organisation],
+) (*OIDCLoginRequest, error) {
+
+	org, err := s.findOIDCOrganisation(ctx, input.OrganisationSlug, organisationsRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := oidc.Discover(ctx, org.OIDC.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	flow := oidcFlowClaims{
+		Organisation: org.Slug,
+		Purpose:      oidcFlowPurpose,
+	}
+	sessionVersion := 0
+	if input.LinkAccount != nil {
+		if input.LinkAccount.OrganisationId != "" && input.LinkAccount.OrganisationId != org.GetId() {
+			return nil, errors.New("account belongs to another organisation")
+		}
+		flow.Purpose = oidcLinkPurpose
+		flow.LinkAccount = input.LinkAccount.GetId()
+		// signing out everywhere before the flow completes cancels the link
+		sessionVersion = input.LinkAccount.SessionVersion
+	}
+	for _, v := range []*string{&flow.State, &flow.Nonce, &flow.Verifier} {
+		if *v, err = utils.RandomToken(32); err != nil {
+			return nil, errors.New("failed to start single sign-on")
+		}
+	}
+
+	flowToken, err := s.generateSignedToken(ctx, flow, sessionVersion, oidcFlowTTL)
+	if err != nil {
+		return nil, errors.New("failed to start single sign-on")
+	}
+
+	prompt := ""
+	if input.Reauthenticate {
+		prompt = "login"
+	}
+
+	return &OIDCLoginRequest{
+		AuthURL:   provider.AuthCodeURL(s.oidcClient(org), flow.State, flow.Nonce, flow.Verifier, prompt),
+		FlowToken: flowToken,
+	}, nil
+}
+
+// CompleteOIDCLogin redeems the authorization code, then signs in the account linked to the identity.
+// Unlinked identities are linked to an existing account with the same verified email when that account
+// is already in the organisation or on one of its verified domains, or provisioned just-in-time when the
+// organisation allows it. Flows started with a LinkAccount link the identity to that account instead.
+func (s *AccountsService) CompleteOIDCLogin(ctx context.Context,
+	input CompleteOIDCLoginInput,
+	organisationsRepo *repository.Repository[models.Organisation],
+	accountsRepo *repository.Repository[models.Account],
+) (*models.Account, error) {
+
+	flow := &oidcFlowClaims{}
+	flowClaims, err := s.parseSignedToken(ctx, input.FlowToken, flow)
+	if err != nil || flow.Organisation != input.OrganisationSlug {
+		return nil, ErrInvalidOIDCFlow
+	}
+	if flow.Purpose != oidcFlowPurpose && flow.Purpose != oidcLinkPurpose {
+		return nil, ErrInvalidOIDCFlow
+	}
+	if subtle.ConstantTimeCompare([]byte(flow.State), []byte(input.State)) != 1 {
+		return nil, ErrInvalidOIDCFlow
+	}
+
+	org, err := s.findOIDCOrganisation(ctx, flow.Organisation, organisationsRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := oidc.Discover(ctx, org.OIDC.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	client := s.oidcClient(org)
+
+	rawIdToken, err := provider.Exchange(ctx, client, input.Code, flow.Verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := provider.VerifyIdToken(ctx, client, rawIdToken, flow.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	var account *models.Account
+	if flow.Purpose == oidcLinkPurpose {
+		account, err = s.linkOIDCAccount(ctx, org, identity, flow.LinkAccount, flowClaims.SessionVersion, accountsRepo)
+	} else {
+		account, err = s.findOrLinkOIDCAccount(ctx, org, identity, accountsRepo)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if account.IsSuspended() {
+		return nil, ErrAccountSuspended
+	}
+
+	// the provider's auth time lets accounts without a password confirm sensitive changes by signing in again
+	token, err := s.signClaims(&Claims{
+		Authorization:  true,
+		SessionVersion: account.SessionVersion,
+		AuthTime:       identity.AuthTime.Unix(),
+		Content:        account.GetAccountInfo(),
+	}, sessionTokenTTL)
+	if err != nil {
+		return nil, errors.New("an error occurred: " + err.Error())
+	}
+
+	account.Token = token
+
+	return account, nil
+}
+
+func (s *AccountsService) findOrLinkOIDCAccount(ctx context.Context,
+	org *models.Organisation,
+	identity *oidc.IdentityClaims,
+	accountsRepo *repository.Repository[models.Account],
+) (*models.Account, error) {
+
+	link := models.ExternalIdentity{
+		Issuer:  identity.Issuer,
+		Subject: identity.Subject,
+	}
+
+	account, err := accountsRepo.FindOne(ctx, identityFilter(link), nil, nil)
+	if err == nil {
+		return &account, nil
+	}
+	if err != repository.NoDocumentsFound {
+		return nil, err
+	}
+
+	// an unverified email from the provider can't be trusted to take over an existing account
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, ErrNoLinkedAccount
+	}
+
+	filter := repository.NewQueryFilter().AddFilter(models.FieldAccountEmail, identity.Email)
+
+	account, err = accountsRepo.FindOne(ctx, filter, nil, nil)
+	if err != nil && err != repository.NoDocumentsFound {
+		return nil, err
+	}
+
+	if err == nil {
+		// an email match alone only proves control of the address at the provider, so other
+		// accounts have to be linked from a signed in session
+		inOrganisation := account.OrganisationId == org.GetId()
+		onVerifiedDomain := account.OrganisationId == "" && org.OIDC.IsVerifiedDomain(account.Email)
+		if !inOrganisation && !onVerifiedDomain {
+			return nil, ErrOIDCLinkRequired
+		}
+
+		return s.linkIdentity(ctx, account, org, link, accountsRepo)
+	}
+
+	if !org.OIDC.AllowJitProvisioning {
+		return nil, ErrNoLinkedAccount
+	}
+
+	now := time.Now()
+	account = models.Account{
+		Shared: models.Shared{
+			ID:        primitive.NewObjectID(),
+			CreatedAt: &now,
+		},
+		FirstName:      identity.GivenName,
+		LastName:       identity.FamilyName,
+		Email:          identity.Email,
+		Status:         models.ActiveStatus,
+		OrganisationId: org.GetId(),
+		Identities:     []models.ExternalIdentity{link},
+	}
+	account.FullName = account.GetFullName()
+
+	account, err = accountsRepo.Create(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	info := account.GetAccountInfo()
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionAccountCreated,
+		TargetType: audit.TargetAccount,
+		TargetId:   account.GetId(),
+		After:      account,
+		Actor:      &info,
+		Metadata:   map[string]string{"organisation_id": org.GetId()},
+	})
+
+	return &account, nil
+}
+
+// linkOIDCAccount links the identity to the account that started the flow.
+func (s *AccountsService) linkOIDCAccount(ctx context.Context,
+	org *models.Organisation,
+	identity *oidc.IdentityClaims,
+	accountId string,
+	sessionVersion int,
+	accountsRepo *repository.Repository[models.Account],
+) (*models.Account, error) {
+
+	account, err := findAccountById(ctx, accountId, accountsRepo)
+	if err != nil || account.SessionVersion != sessionVersion {
+		return nil, ErrInvalidOIDCFlow
+	}
+	if account.OrganisationId != "" && account.OrganisationId != org.GetId() {
+		return nil, errors.New("account belongs to another organisation")
+	}
+
+	link := models.ExternalIdentity{
+		Issuer:  identity.Issuer,
+		Subject: identity.Subject,
+	}
+
+	linked, err := accountsRepo.FindOne(ctx, identityFilter(link), nil, nil)
+	if err == nil {
+		if linked.ID != account.ID {
+			return nil, ErrIdentityLinked
+		}
+		return &linked, nil
+	}
+	if err != repository.NoDocumentsFound {
+		return nil, err
+	}
+
+	return s.linkIdentity(ctx, *account, org, link, accountsRepo)
+}
+
+func (s *AccountsService) linkIdentity(ctx context.Context,
+	account models.Account,
+	org *models.Organisation,
+	link models.ExternalIdentity,
+	accountsRepo *repository.Repository[models.Account],
+) (*models.Account, error) {
+
+	before := account
+	account.OrganisationId = org.GetId()
+	account.Identities = append(account.Identities, link)
+	if account.Status == models.PendingVerificationStatus {
+		account.Status = models.ActiveStatus
+	}
+
+	updatedAccount, err := accountsRepo.Update(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	info := updatedAccount.GetAccountInfo()
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionIdentityLinked,
+		TargetType: audit.TargetAccount,
+		TargetId:   updatedAccount.GetId(),
+		Before:     before,
+		After:      updatedAccount,
+		Actor:      &info,
+	})
+	return &updatedAccount, nil
+}
+
+func identityFilter(link models.ExternalIdentity) *repository.QueryFilter {
+	return repository.NewQueryFilter().AddFilter(models.FieldAccountIdentities, map[string]interface{}{
+		"$elemMatch": map[string]interface{}{"issuer": link.Issuer, "subject": link.Subject},
+	})
+}
+
+func (s *AccountsService) findOIDCOrganisation(ctx context.Context,
+	slug string,
+	organisationsRepo *repository.Repository[models.Organisation],
+) (*models.Organisation, error) {
+
+	filter := repository.NewQueryFilter().AddFilter(models.FieldOrganisationSlug, slug)
+
+	org, err := organisationsRepo.FindOne(ctx, filter, nil, nil)
+	if err != nil {
+		if err == repository.NoDocumentsFound {
+			return nil, ErrOIDCNotConfigured
+		}
+		return nil, err
+	}
+
+	if org.OIDC == nil || !org.OIDC.Enabled {
+		return nil, ErrOIDCNotConfigured
+	}
+
+	return &org, nil
+}
+
+func (s *AccountsService) oidcClient(org *models.Organisation) oidc.Client {
+	return oidc.Client{
+		ClientId:     org.OIDC.ClientId,
+		ClientSecret: org.OIDC.ClientSecret,
+		RedirectUri:  s.conf.GetAsString(env.ApiUrl) + "/v1/user/oidc/" + org.Slug + "/callback",
+		Scopes:       org.OIDC.Scopes,
+	}
+}
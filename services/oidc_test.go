@@ -0,0 +1,331 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/database"
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/oidc"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+const testOrganisationSlug = "acme"
+
+// oidcTest is an organisation signing in through a mock provider that answers as identity.
+type oidcTest struct {
+	s                 *AccountsService
+	repos             testRepos
+	organisation      models.Organisation
+	organisationsRepo *repository.Repository[models.Organisation]
+	provider          *oidc.MockProvider
+}
+
+func newOIDCTest(t *testing.T, config models.OIDCConfig, identity oidc.IdentityClaims) *oidcTest {
+	t.Helper()
+
+	var mock *oidc.MockProvider
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mock.Handler().ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	mock, err := oidc.NewMockProvider(srv.URL, "narx", identity)
+	if err != nil {
+		t.Fatalf("NewMockProvider() error = %v", err)
+	}
+
+	config.Enabled = true
+	config.Issuer = srv.URL
+	config.ClientId = "narx"
+
+	now := time.Now().UTC()
+	organisationsRepo := repository.NewRepository[models.Organisation](database.NewMemoryCollection())
+	organisation, err := organisationsRepo.Create(context.Background(), models.Organisation{
+		Shared: models.Shared{ID: primitive.NewObjectID(), CreatedAt: &now},
+		Name:   "Acme",
+		Slug:   testOrganisationSlug,
+		OIDC:   &config,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos := newTestRepos()
+	conf := newTestConfig().SetEnv(env.ApiUrl, "https://api.example.com")
+
+	return &oidcTest{
+		s:                 NewAccountsService(&conf, audit.NewLog(repos.auditLog)),
+		repos:             repos,
+		organisation:      organisation,
+		organisationsRepo: organisationsRepo,
+		provider:          mock,
+	}
+}
+
+// start begins a sign in and returns its flow token with the provider's redirect back.
+func (ot *oidcTest) start(t *testing.T) (*OIDCLoginRequest, url.Values) {
+	t.Helper()
+
+	request, err := ot.s.StartOIDCLogin(context.Background(), StartOIDCLoginInput{OrganisationSlug: testOrganisationSlug}, ot.organisationsRepo)
+	if err != nil {
+		t.Fatalf("StartOIDCLogin() error = %v", err)
+	}
+	return request, authorizeOIDC(t, request.AuthURL)
+}
+
+func (ot *oidcTest) complete(flowToken string, callback url.Values) (*models.Account, error) {
+	return ot.s.CompleteOIDCLogin(context.Background(), CompleteOIDCLoginInput{
+		OrganisationSlug: testOrganisationSlug,
+		Code:             callback.Get("code"),
+		State:            callback.Get("state"),
+		FlowToken:        flowToken,
+	}, ot.organisationsRepo, ot.repos.accounts)
+}
+
+// signIn runs a whole sign in as the provider's identity.
+func (ot *oidcTest) signIn(t *testing.T) (*models.Account, error) {
+	t.Helper()
+
+	request, callback := ot.start(t)
+	return ot.complete(request.FlowToken, callback)
+}
+
+// authorizeOIDC follows authUrl to the provider and returns the query of its redirect to the callback.
+func authorizeOIDC(t *testing.T, authUrl string) url.Values {
+	t.Helper()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	res, err := client.Get(authUrl)
+	if err != nil {
+		t.Fatalf("authorize request error = %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("authorize status = %d, want %d", res.StatusCode, http.StatusFound)
+	}
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid redirect: %v", err)
+	}
+	return location.Query()
+}
+
+func TestCompleteOIDCLoginLinksAccounts(t *testing.T) {
+	identity := oidc.IdentityClaims{Subject: "user-1", Email: "ada@acme.com", EmailVerified: true, GivenName: "Ada", FamilyName: "Obi"}
+
+	tests := []struct {
+		name   string
+		config models.OIDCConfig
+
+		// the identity the provider answers with, the default one when empty
+		identity oidc.IdentityClaims
+
+		// the account already stored for the identity's email, and the organisation it is in
+		existing       bool
+		inOrganisation bool
+		inOtherOrg     bool
+
+		wantErr error
+	}{
+		{
+			name:           "account in the organisation is linked",
+			existing:       true,
+			inOrganisation: true,
+		},
+		{
+			name:     "account without an organisation on a verified domain is linked",
+			config:   models.OIDCConfig{VerifiedDomains: []string{"acme.com"}},
+			existing: true,
+		},
+		{
+			name:     "account without an organisation on another domain has to link from a session",
+			config:   models.OIDCConfig{VerifiedDomains: []string{"acme.org"}},
+			existing: true,
+			wantErr:  ErrOIDCLinkRequired,
+		},
+		{
+			name:       "account in another organisation has to link from a session",
+			config:     models.OIDCConfig{VerifiedDomains: []string{"acme.com"}},
+			existing:   true,
+			inOtherOrg: true,
+			wantErr:    ErrOIDCLinkRequired,
+		},
+		{
+			name:           "unverified email never takes over an account",
+			config:         models.OIDCConfig{VerifiedDomains: []string{"acme.com"}, AllowJitProvisioning: true},
+			identity:       oidc.IdentityClaims{Subject: "user-1", Email: "ada@acme.com", EmailVerified: false},
+			existing:       true,
+			inOrganisation: true,
+			wantErr:        ErrNoLinkedAccount,
+		},
+		{
+			name:   "new identity is provisioned when the organisation allows it",
+			config: models.OIDCConfig{AllowJitProvisioning: true},
+		},
+		{
+			name:    "new identity is refused without provisioning",
+			wantErr: ErrNoLinkedAccount,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := identity
+			if tt.identity.Subject != "" {
+				claims = tt.identity
+			}
+			ot := newOIDCTest(t, tt.config, claims)
+
+			var existing models.Account
+			if tt.existing {
+				existing = createTestAccount(t, ot.repos.accounts, identity.Email)
+				switch {
+				case tt.inOrganisation:
+					existing.OrganisationId = ot.organisation.GetId()
+				case tt.inOtherOrg:
+					existing.OrganisationId = primitive.NewObjectID().Hex()
+				}
+				if _, err := ot.repos.accounts.Update(context.Background(), existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			account, err := ot.signIn(t)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CompleteOIDCLogin() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				accounts, findErr := ot.repos.accounts.Find(context.Background(), repository.NewQueryFilter(), nil, nil)
+				if findErr != nil {
+					t.Fatal(findErr)
+				}
+				if !tt.existing && len(accounts) != 0 {
+					t.Errorf("refused sign in provisioned %d accounts", len(accounts))
+				}
+				if tt.existing && (len(accounts[0].Identities) != 0 || accounts[0].OrganisationId != existing.OrganisationId) {
+					t.Errorf("refused sign in changed the account: identities %v, organisation %q", accounts[0].Identities, accounts[0].OrganisationId)
+				}
+				return
+			}
+
+			stored := findTestAccount(t, ot.repos.accounts, identity.Email)
+			if account.Token == "" {
+				t.Error("CompleteOIDCLogin() returned no session token")
+			}
+			if tt.existing && account.GetId() != existing.GetId() {
+				t.Errorf("signed in as %s, want the existing account %s", account.GetId(), existing.GetId())
+			}
+			if stored.OrganisationId != ot.organisation.GetId() {
+				t.Errorf("account is in organisation %q, want %q", stored.OrganisationId, ot.organisation.GetId())
+			}
+			want := models.ExternalIdentity{Issuer: ot.organisation.OIDC.Issuer, Subject: identity.Subject}
+			if len(stored.Identities) != 1 || stored.Identities[0] != want {
+				t.Errorf("identities = %v, want [%v]", stored.Identities, want)
+			}
+			if !tt.existing && (stored.FirstName != identity.GivenName || stored.LastName != identity.FamilyName || stored.Status != models.ActiveStatus) {
+				t.Errorf("provisioned %s %s as %s, want an active account for %s %s", stored.FirstName, stored.LastName, stored.Status, identity.GivenName, identity.FamilyName)
+			}
+		})
+	}
+}
+
+func TestCompleteOIDCLoginSignsInLinkedIdentity(t *testing.T) {
+	ot := newOIDCTest(t, models.OIDCConfig{}, oidc.IdentityClaims{Subject: "user-1", Email: "ada@acme.com", EmailVerified: true})
+
+	// linked identities sign in whatever the provider now says about the email
+	account := createTestAccount(t, ot.repos.accounts, "ada@example.com")
+	account.OrganisationId = ot.organisation.GetId()
+	account.Identities = []models.ExternalIdentity{{Issuer: ot.organisation.OIDC.Issuer, Subject: "user-1"}}
+	if _, err := ot.repos.accounts.Update(context.Background(), account); err != nil {
+		t.Fatal(err)
+	}
+	ot.provider.Identity.EmailVerified = false
+
+	for i := 0; i < 2; i++ {
+		signedIn, err := ot.signIn(t)
+		if err != nil {
+			t.Fatalf("CompleteOIDCLogin() error = %v", err)
+		}
+		if signedIn.GetId() != account.GetId() || signedIn.Token == "" {
+			t.Errorf("signed in as %s, want a session for %s", signedIn.GetId(), account.GetId())
+		}
+	}
+
+	stored := findTestAccount(t, ot.repos.accounts, "ada@example.com")
+	if len(stored.Identities) != 1 {
+		t.Errorf("account has %d identities after signing in again, want 1", len(stored.Identities))
+	}
+}
+
+func TestCompleteOIDCLoginChecksTheFlow(t *testing.T) {
+	ot := newOIDCTest(t, models.OIDCConfig{AllowJitProvisioning: true}, oidc.IdentityClaims{Subject: "user-1", Email: "ada@acme.com", EmailVerified: true})
+
+	t.Run("state from another flow", func(t *testing.T) {
+		request, _ := ot.start(t)
+		_, other := ot.start(t)
+
+		if _, err := ot.complete(request.FlowToken, other); !errors.Is(err, ErrInvalidOIDCFlow) {
+			t.Fatalf("CompleteOIDCLogin() error = %v, want %v", err, ErrInvalidOIDCFlow)
+		}
+	})
+
+	t.Run("id token for another nonce", func(t *testing.T) {
+		request, err := ot.s.StartOIDCLogin(context.Background(), StartOIDCLoginInput{OrganisationSlug: testOrganisationSlug}, ot.organisationsRepo)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the same request with someone else's nonce, so only the id token gives it away
+		authUrl, err := url.Parse(request.AuthURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := authUrl.Query()
+		query.Set("nonce", "not-the-nonce")
+		authUrl.RawQuery = query.Encode()
+
+		if _, err = ot.complete(request.FlowToken, authorizeOIDC(t, authUrl.String())); err == nil {
+			t.Fatal("CompleteOIDCLogin() accepted an id token for another nonce")
+		}
+	})
+
+	t.Run("flow for another organisation", func(t *testing.T) {
+		request, callback := ot.start(t)
+
+		_, err := ot.s.CompleteOIDCLogin(context.Background(), CompleteOIDCLoginInput{
+			OrganisationSlug: "globex",
+			Code:             callback.Get("code"),
+			State:            callback.Get("state"),
+			FlowToken:        request.FlowToken,
+		}, ot.organisationsRepo, ot.repos.accounts)
+		if !errors.Is(err, ErrInvalidOIDCFlow) {
+			t.Fatalf("CompleteOIDCLogin() error = %v, want %v", err, ErrInvalidOIDCFlow)
+		}
+	})
+
+	accounts, err := ot.repos.accounts.Find(context.Background(), repository.NewQueryFilter(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 0 {
+		t.Errorf("%d accounts provisioned by rejected flows, want none", len(accounts))
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
+	"github.com/tejiriaustin/narx_api/audit"
 	"github.com/tejiriaustin/narx_api/env"
 	"github.com/tejiriaustin/narx_api/models"
 	"github.com/tejiriaustin/narx_api/repository"
@@ -15,17 +16,20 @@ import (
 
 type (
 	SensorService struct {
-		conf *env.Environment
+		conf     *env.Environment
+		auditLog *audit.Log
 	}
 
 	CreateSensorInput struct {
-		Name        string              `json:"name" bson:"name"`
-		IpAddress   string              `json:"ipAddress" bson:"ip_address"`
-		AccountInfo *models.AccountInfo `json:"accountInfo" bson:"account_info"`
+		Name          string              `json:"name" bson:"name"`
+		IpAddress     string              `json:"ipAddress" bson:"ip_address"`
+		AccountInfo   *models.AccountInfo `json:"accountInfo" bson:"account_info"`
+		AccountStatus models.Status       `json:"accountStatus" bson:"account_status"`
 	}
 
 	UpdateSensorInput struct {
 		ID        string `json:"id" bson:"id"`
+		AccountId string `json:"-" bson:"-"`
 		Name      string `json:"name" bson:"name"`
 		IpAddress string `json:"ipAddress" bson:"ip_address"`
 	}
@@ -43,12 +47,18 @@ type (
 	}
 )
 
-func NewSensorService(conf *env.Environment) *SensorService {
+func NewSensorService(conf *env.Environment, auditLog *audit.Log) *SensorService {
 	return &SensorService{
-		conf: conf,
+		conf:     conf,
+		auditLog: auditLog,
 	}
 }
 
+var (
+	ErrAccountNotVerified = errors.New("please verify your email address before adding sensors")
+	ErrSensorNotFound     = errors.New("sensor not found")
+)
+
 var _ SensorServiceInterface = (*SensorService)(nil)
 
 func (s *SensorService) CreateSensor(ctx context.Context,
@@ -56,6 +66,9 @@ func (s *SensorService) CreateSensor(ctx context.Context,
 	passwordGen utils.StrGenFunc,
 	sensorRepo *repository.Repository[models.Sensor],
 ) (*models.Sensor, error) {
+	if input.AccountStatus == models.PendingVerificationStatus {
+		return nil, ErrAccountNotVerified
+	}
 	if input.Name == "" {
 		return nil, errors.New("sensor name cannot be empty")
 	}
@@ -79,6 +92,13 @@ func (s *SensorService) CreateSensor(ctx context.Context,
 	if err != nil {
 		return nil, err
 	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionSensorCreated,
+		TargetType: audit.TargetSensor,
+		TargetId:   sensor.GetId(),
+		After:      sensor,
+	})
 	return &sensor, nil
 }
 
@@ -99,32 +119,55 @@ func (s *SensorService) UpdateSensor(ctx context.Context,
 		"$set": fields,
 	}
 
-	id, err := primitive.ObjectIDFromHex(input.ID)
+	filter, err := ownedSensor(input.ID, input.AccountId)
 	if err != nil {
-		return nil, errors.New("invalid id")
+		return nil, err
+	}
+
+	before, err := sensorRepo.FindOne(ctx, filter, nil, nil)
+	if err != nil {
+		if err == repository.NoDocumentsFound {
+			return nil, ErrSensorNotFound
+		}
+		return nil, err
 	}
 
-	filter := repository.NewQueryFilter().AddFilter(models.FieldId, id)
 	err = sensorRepo.UpdateMany(ctx, filter, updates)
 	if err != nil {
 		return nil, err
 	}
 
-	return nil, nil
+	after, err := sensorRepo.FindOne(ctx, filter, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionSensorUpdated,
+		TargetType: audit.TargetSensor,
+		TargetId:   after.GetId(),
+		Before:     before,
+		After:      after,
+	})
+
+	return &after, nil
 }
 
 func (s *SensorService) GetSensor(ctx context.Context,
 	sensorId string,
+	accountId string,
 	sensorRepo *repository.Repository[models.Sensor],
 ) (*models.Sensor, error) {
-	id, err := primitive.ObjectIDFromHex(sensorId)
+	filter, err := ownedSensor(sensorId, accountId)
 	if err != nil {
-		return nil, errors.New("invalid id")
+		return nil, err
 	}
-	filter := repository.NewQueryFilter().AddFilter(models.FieldId, id)
 
 	sensor, err := sensorRepo.FindOne(ctx, filter, nil, nil)
 	if err != nil {
+		if err == repository.NoDocumentsFound {
+			return nil, ErrSensorNotFound
+		}
 		return nil, err
 	}
 
@@ -163,13 +206,48 @@ func (s *SensorService) ListSensors(ctx context.Context,
 
 func (s *SensorService) DeleteSensor(ctx context.Context,
 	sensorId string,
+	accountId string,
 	sensorRepo *repository.Repository[models.Sensor],
 ) error {
+	filter, err := ownedSensor(sensorId, accountId)
+	if err != nil {
+		return err
+	}
+
+	sensor, err := sensorRepo.FindOne(ctx, filter, nil, nil)
+	if err != nil {
+		if err == repository.NoDocumentsFound {
+			return ErrSensorNotFound
+		}
+		return err
+	}
+
+	err = sensorRepo.DeleteMany(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionSensorDeleted,
+		TargetType: audit.TargetSensor,
+		TargetId:   sensor.GetId(),
+		Before:     sensor,
+	})
+
+	return nil
+}
+
+// ownedSensor matches the sensor with the given id if it belongs to the account, so other accounts' sensors read as not found.
+func ownedSensor(sensorId, accountId string) (*repository.QueryFilter, error) {
 	id, err := primitive.ObjectIDFromHex(sensorId)
 	if err != nil {
-		return errors.New("invalid id")
+		return nil, errors.New("invalid id")
+	}
+	if accountId == "" {
+		return nil, ErrSensorNotFound
 	}
-	filter := repository.NewQueryFilter().AddFilter(models.FieldId, id)
 
-	return sensorRepo.DeleteMany(ctx, filter)
+	return repository.NewQueryFilter().
+		AddFilter(models.FieldId, id).
+		AddFilter(models.FieldAccountInfoId, accountId), nil
 }
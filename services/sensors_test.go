@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tejiriaustin/narx_api/audit"
+)
+
+func TestSensorsAreScopedToTheirAccount(t *testing.T) {
+	repos := newTestRepos()
+	s := NewSensorService(newTestConfig(), audit.NewLog(repos.auditLog))
+
+	owner := createTestAccount(t, repos.accounts, "ada@example.com")
+	other := createTestAccount(t, repos.accounts, "obi@example.com")
+
+	ownerInfo := owner.GetAccountInfo()
+	sensor, err := s.CreateSensor(context.Background(), CreateSensorInput{
+		Name:          "Inverter",
+		IpAddress:     "10.0.0.7",
+		AccountInfo:   &ownerInfo,
+		AccountStatus: owner.Status,
+	}, func() string { return "token" }, repos.sensors)
+	if err != nil {
+		t.Fatalf("CreateSensor() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		call func(accountId string) error
+	}{
+		{
+			name: "get",
+			call: func(accountId string) error {
+				_, err := s.GetSensor(context.Background(), sensor.GetId(), accountId, repos.sensors)
+				return err
+			},
+		},
+		{
+			name: "update",
+			call: func(accountId string) error {
+				_, err := s.UpdateSensor(context.Background(), UpdateSensorInput{ID: sensor.GetId(), AccountId: accountId, Name: "Renamed"}, repos.sensors)
+				return err
+			},
+		},
+		{
+			name: "delete",
+			call: func(accountId string) error {
+				return s.DeleteSensor(context.Background(), sensor.GetId(), accountId, repos.sensors)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(other.GetId()); !errors.Is(err, ErrSensorNotFound) {
+				t.Errorf("%s as another account error = %v, want %v", tt.name, err, ErrSensorNotFound)
+			}
+			if err := tt.call(""); !errors.Is(err, ErrSensorNotFound) {
+				t.Errorf("%s without an account error = %v, want %v", tt.name, err, ErrSensorNotFound)
+			}
+
+			found, err := s.GetSensor(context.Background(), sensor.GetId(), owner.GetId(), repos.sensors)
+			if err != nil {
+				t.Fatalf("sensor is gone after another account's %s: %v", tt.name, err)
+			}
+			if found.Name != "Inverter" {
+				t.Errorf("sensor renamed to %q by another account", found.Name)
+			}
+		})
+	}
+
+	if err := s.DeleteSensor(context.Background(), sensor.GetId(), owner.GetId(), repos.sensors); err != nil {
+		t.Fatalf("DeleteSensor() by its owner error = %v", err)
+	}
+	if _, err := s.GetSensor(context.Background(), sensor.GetId(), owner.GetId(), repos.sensors); !errors.Is(err, ErrSensorNotFound) {
+		t.Errorf("GetSensor() after deleting error = %v, want %v", err, ErrSensorNotFound)
+	}
+}
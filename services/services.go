@@ -4,19 +4,30 @@ import (
 	"context"
 	"log"
 
+	"github.com/tejiriaustin/narx_api/audit"
 	"github.com/tejiriaustin/narx_api/constants"
+	"github.com/tejiriaustin/narx_api/consumer"
 	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/limiter"
 	"github.com/tejiriaustin/narx_api/messaging"
 	"github.com/tejiriaustin/narx_api/publisher"
 )
 
 type (
 	Container struct {
-		AccountsService   AccountsServiceInterface
-		SensorService     SensorServiceInterface
-		DeviceService     DeviceServiceInterface
-		PushNotifications messaging.Messaging
-		Publisher         publisher.PublishInterface
+		AccountsService     AccountsServiceInterface
+		SensorService       SensorServiceInterface
+		DeviceService       DeviceServiceInterface
+		ApiKeyService       ApiKeyServiceInterface
+		AuditService        AuditServiceInterface
+		NotificationService NotificationServiceInterface
+		WebhookService      WebhookServiceInterface
+		DeadLetterService   DeadLetterServiceInterface
+		PushNotifications   messaging.Messaging
+		Publisher           publisher.PublishInterface
+		EventQueue          consumer.Requeuer
+		LoginLimiter        *limiter.LoginLimiter
+		ResetLimiter        *limiter.RateLimiter
 	}
 
 	Pager struct {
@@ -25,17 +36,22 @@ type (
 	}
 )
 
-func NewService(conf *env.Environment) *Container {
+func NewService(conf *env.Environment, auditLog *audit.Log) *Container {
 	log.Println("Creating Container...")
 	return &Container{
-		AccountsService: NewAccountsService(conf),
-		SensorService:   NewSensorService(conf),
-		DeviceService:   NewDeviceService(conf),
+		AccountsService:     NewAccountsService(conf, auditLog),
+		SensorService:       NewSensorService(conf, auditLog),
+		DeviceService:       NewDeviceService(conf, auditLog),
+		ApiKeyService:       NewApiKeyService(conf, auditLog),
+		AuditService:        NewAuditService(conf),
+		NotificationService: NewNotificationService(conf, auditLog),
+		WebhookService:      NewWebhookService(conf, auditLog),
+		DeadLetterService:   NewDeadLetterService(conf, auditLog),
 	}
 }
 
 func GetPageNumberFromContext(ctx context.Context) int64 {
-	n, ok := ctx.Value(constants.ContextKeyPageNumber).(int64)
+	n, ok := ctx.Value(string(constants.ContextKeyPageNumber)).(int64)
 	if !ok {
 		return 0
 	}
@@ -43,7 +59,7 @@ func GetPageNumberFromContext(ctx context.Context) int64 {
 }
 
 func GetPerPageLimitFromContext(ctx context.Context) int64 {
-	l, ok := ctx.Value(constants.ContextKeyPerPageLimit).(int64)
+	l, ok := ctx.Value(string(constants.ContextKeyPerPageLimit)).(int64)
 	if !ok {
 		return 0
 	}
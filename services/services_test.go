@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/database"
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/events"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+const testPassword = "correct horse battery staple"
+
+// testRepos are repositories kept in memory, one per collection a test touches.
+type testRepos struct {
+	accounts *repository.Repository[models.Account]
+	sensors  *repository.Repository[models.Sensor]
+	apiKeys  *repository.Repository[models.ApiKey]
+	auditLog *repository.Repository[models.AuditEntry]
+}
+
+func newTestRepos() testRepos {
+	return testRepos{
+		accounts: repository.NewRepository[models.Account](database.NewMemoryCollection()),
+		sensors:  repository.NewRepository[models.Sensor](database.NewMemoryCollection()),
+		apiKeys:  repository.NewRepository[models.ApiKey](database.NewMemoryCollection()),
+		auditLog: repository.NewRepository[models.AuditEntry](database.NewMemoryCollection()),
+	}
+}
+
+func newTestConfig() *env.Environment {
+	conf := env.NewEnvironment().
+		SetEnv(env.JwtSecret, "test-secret").
+		SetEnv(env.EventSecretKey, "test-event-secret").
+		SetEnv(env.FrontendUrl, "https://app.example.com")
+	return &conf
+}
+
+func newTestSealer(t *testing.T) *events.Sealer {
+	t.Helper()
+
+	sealer, err := events.NewSealer(newTestConfig().GetAsString(env.EventSecretKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealer
+}
+
+func newTestAccountsService(repos testRepos) *AccountsService {
+	return NewAccountsService(newTestConfig(), audit.NewLog(repos.auditLog))
+}
+
+// createTestAccount stores an active account with testPassword as its password.
+func createTestAccount(t *testing.T, accountsRepo *repository.Repository[models.Account], email string) models.Account {
+	t.Helper()
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	account, err := accountsRepo.Create(context.Background(), models.Account{
+		Shared: models.Shared{
+			ID:        primitive.NewObjectID(),
+			CreatedAt: &now,
+		},
+		FirstName: "Ada",
+		LastName:  "Obi",
+		FullName:  "Ada Obi",
+		Email:     email,
+		Status:    models.ActiveStatus,
+		Password:  string(passwordHash),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+func findTestAccount(t *testing.T, accountsRepo *repository.Repository[models.Account], email string) models.Account {
+	t.Helper()
+
+	account, err := accountsRepo.FindOne(context.Background(), repository.NewQueryFilter().AddFilter(models.FieldAccountEmail, email), nil)
+	if err != nil {
+		t.Fatalf("account %s: %v", email, err)
+	}
+	return account
+}
@@ -0,0 +1,331 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/limiter"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+	"github.com/tejiriaustin/narx_api/totp"
+	"github.com/tejiriaustin/narx_api/utils"
+)
+
+const (
+	// defaultTotpIssuer names the app in authenticator apps when TOTP_ISSUER isn't set.
+	defaultTotpIssuer = "Narx"
+
+	twoFactorChallengeTTL     = 5 * time.Minute
+	twoFactorChallengePurpose = "two_factor_challenge"
+
+	recoveryCodeCount = 10
+	recoveryCodeBytes = 5
+)
+
+var (
+	ErrTwoFactorAlreadyEnabled = errors.New("two factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled    = errors.New("two factor authentication has not been enrolled")
+	ErrTwoFactorNotEnabled     = errors.New("two factor authentication is not enabled")
+	ErrInvalidTwoFactorCode    = errors.New("invalid two factor code")
+	ErrInvalidChallenge        = errors.New("invalid or expired login challenge")
+)
+
+type (
+	EnrollTwoFactorInput struct {
+		AccountId string
+	}
+	TwoFactorEnrollment struct {
+		Secret string
+		URI    string
+	}
+	ConfirmTwoFactorInput struct {
+		AccountId string
+		Code      string
+	}
+	// DisableTwoFactorInput takes a two factor code on top of the account holder's password, or on
+	// top of a recent single sign-on for accounts without one.
+	DisableTwoFactorInput struct {
+		AccountId string
+		Code      string
+		Reauthentication
+	}
+	VerifyTwoFactorLoginInput struct {
+		ChallengeToken string
+		Code           string
+		IpAddress      string
+	}
+
+	// twoFactorChallengeClaims is the content of the short-lived token handed out by LoginUser
+	// when the account still has to present a second factor.
+	twoFactorChallengeClaims struct {
+		AccountId string `json:"account_id"`
+		Purpose   string `json:"purpose"`
+	}
+)
+
+func (s *AccountsService) EnrollTwoFactor(ctx context.Context,
+	input EnrollTwoFactorInput,
+	accountsRepo *repository.Repository[models.Account],
+) (*TwoFactorEnrollment, error) {
+
+	account, err := findAccountById(ctx, input.AccountId, accountsRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	if account.HasTwoFactor() {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, errors.New("failed to generate two factor secret")
+	}
+
+	account.TwoFactor = &models.TwoFactor{
+		PendingSecret: secret,
+	}
+
+	_, err = accountsRepo.Update(ctx, *account)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorEnrollment{
+		Secret: secret,
+		URI:    totp.URI(s.totpIssuer(), account.Email, secret),
+	}, nil
+}
+
+// ConfirmTwoFactor enables two factor authentication once the user proves their authenticator works,
+// and returns a fresh set of recovery codes. The codes are only stored hashed and can't be shown again.
+func (s *AccountsService) ConfirmTwoFactor(ctx context.Context,
+	input ConfirmTwoFactorInput,
+	accountsRepo *repository.Repository[models.Account],
+) ([]string, error) {
+
+	account, err := findAccountById(ctx, input.AccountId, accountsRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	if account.HasTwoFactor() {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if account.TwoFactor == nil || account.TwoFactor.PendingSecret == "" {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+
+	step, ok := totp.Validate(account.TwoFactor.PendingSecret, input.Code, time.Now(), 0)
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	before := *account
+	account.TwoFactor = &models.TwoFactor{
+		Enabled:       true,
+		Secret:        account.TwoFactor.PendingSecret,
+		LastUsedStep:  step,
+		RecoveryCodes: hashes,
+	}
+
+	_, err = accountsRepo.Update(ctx, *account)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionTwoFactorEnabled,
+		TargetType: audit.TargetAccount,
+		TargetId:   account.GetId(),
+		Before:     before,
+		After:      account,
+	})
+
+	return codes, nil
+}
+
+func (s *AccountsService) DisableTwoFactor(ctx context.Context,
+	input DisableTwoFactorInput,
+	accountsRepo *repository.Repository[models.Account],
+) error {
+
+	account, err := findAccountById(ctx, input.AccountId, accountsRepo)
+	if err != nil {
+		return err
+	}
+
+	if !account.HasTwoFactor() {
+		return ErrTwoFactorNotEnabled
+	}
+
+	// the code is the second factor here, so it can't also stand in for the first
+	reauth := input.Reauthentication
+	reauth.TwoFactorCode = ""
+	if err = confirmIdentity(account, reauth); err != nil {
+		return err
+	}
+
+	if !verifySecondFactor(account, input.Code) {
+		return ErrInvalidTwoFactorCode
+	}
+
+	before := *account
+	account.TwoFactor = nil
+
+	_, err = accountsRepo.Update(ctx, *account)
+	if err != nil {
+		return err
+	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionTwoFactorDisabled,
+		TargetType: audit.TargetAccount,
+		TargetId:   account.GetId(),
+		Before:     before,
+		After:      account,
+	})
+
+	return nil
+}
+
+// VerifyTwoFactorLogin completes a login started by LoginUser using either a TOTP code or a recovery code.
+func (s *AccountsService) VerifyTwoFactorLogin(ctx context.Context,
+	input VerifyTwoFactorLoginInput,
+	accountsRepo *repository.Repository[models.Account],
+	loginLimiter *limiter.LoginLimiter,
+) (*models.Account, error) {
+
+	claims := &twoFactorChallengeClaims{}
+	challenge, err := s.parseSignedToken(ctx, input.ChallengeToken, claims)
+	if err != nil || claims.Purpose != twoFactorChallengePurpose {
+		return nil, ErrInvalidChallenge
+	}
+
+	account, err := findAccountById(ctx, claims.AccountId, accountsRepo)
+	if err != nil {
+		return nil, ErrInvalidChallenge
+	}
+	// a password change or logout everywhere since the challenge was issued invalidates it
+	if account.SessionVersion != challenge.SessionVersion {
+		return nil, ErrInvalidChallenge
+	}
+	if account.IsSuspended() {
+		return nil, ErrAccountSuspended
+	}
+
+	retryAfter, err := loginLimiter.RetryAfter(ctx, account.Email, input.IpAddress)
+	if err != nil {
+		return nil, err
+	}
+	if retryAfter > 0 {
+		return nil, ErrTooManyLoginAttempts
+	}
+
+	if !account.HasTwoFactor() || !verifySecondFactor(account, input.Code) {
+		if _, err = loginLimiter.Failed(ctx, account.Email, input.IpAddress); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	// persists the used step or the consumed recovery code
+	updatedAccount, err := accountsRepo.Update(ctx, *account)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loginLimiter.Succeeded(ctx, account.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.generateSignedToken(ctx, updatedAccount.GetAccountInfo(), updatedAccount.SessionVersion, sessionTokenTTL)
+	if err != nil {
+		return nil, errors.New("an error occurred: " + err.Error())
+	}
+
+	updatedAccount.Token = token
+
+	return &updatedAccount, nil
+}
+
+func (s *AccountsService) generateTwoFactorChallenge(ctx context.Context, account models.Account) (string, error) {
+	return s.generateSignedToken(ctx, twoFactorChallengeClaims{
+		AccountId: account.ID.Hex(),
+		Purpose:   twoFactorChallengePurpose,
+	}, account.SessionVersion, twoFactorChallengeTTL)
+}
+
+// verifySecondFactor checks code as a TOTP code, then as a recovery code.
+// On success the account is modified to prevent the code being reused, the caller must save it.
+func verifySecondFactor(account *models.Account, code string) bool {
+	tf := account.TwoFactor
+
+	if step, ok := totp.Validate(tf.Secret, code, time.Now(), tf.LastUsedStep); ok {
+		tf.LastUsedStep = step
+		return true
+	}
+
+	code = strings.ToLower(strings.TrimSpace(code))
+	for i, hash := range tf.RecoveryCodes {
+		if utils.CompareTokenHash(hash, code) {
+			tf.RecoveryCodes = append(tf.RecoveryCodes[:i], tf.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := utils.RandomToken(recoveryCodeBytes)
+		if err != nil {
+			return nil, nil, errors.New("failed to generate recovery codes")
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, utils.HashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+func findAccountById(ctx context.Context,
+	accountId string,
+	accountsRepo *repository.Repository[models.Account],
+) (*models.Account, error) {
+	id, err := primitive.ObjectIDFromHex(accountId)
+	if err != nil {
+		return nil, errors.New("invalid id")
+	}
+
+	filter := repository.NewQueryFilter().AddFilter(models.FieldId, id)
+
+	account, err := accountsRepo.FindOne(ctx, filter, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+// totpIssuer is the name authenticator apps list the account under.
+func (s *AccountsService) totpIssuer() string {
+	if issuer := s.conf.GetAsString(env.TotpIssuer); issuer != "" {
+		return issuer
+	}
+	return defaultTotpIssuer
+}
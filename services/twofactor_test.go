@@ -0,0 +1,322 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/consumer"
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/limiter"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/totp"
+)
+
+func TestEnrollTwoFactorIssuer(t *testing.T) {
+	tests := []struct {
+		name   string
+		issuer string
+		want   string
+	}{
+		{name: "default", want: "Narx"},
+		{name: "configured", issuer: "Narx Staging", want: "Narx Staging"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repos := newTestRepos()
+			conf := newTestConfig().SetEnv(env.TotpIssuer, tt.issuer)
+			s := NewAccountsService(&conf, audit.NewLog(repos.auditLog))
+			account := createTestAccount(t, repos.accounts, "ada@example.com")
+
+			enrollment, err := s.EnrollTwoFactor(context.Background(), EnrollTwoFactorInput{AccountId: account.GetId()}, repos.accounts)
+			if err != nil {
+				t.Fatalf("EnrollTwoFactor() error = %v", err)
+			}
+
+			u, err := url.Parse(enrollment.URI)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := u.Query().Get("issuer"); got != tt.want {
+				t.Errorf("issuer = %q, want %q", got, tt.want)
+			}
+			if u.Path != "/"+tt.want+":ada@example.com" {
+				t.Errorf("label = %q, want %s:ada@example.com", u.Path, tt.want)
+			}
+
+			stored := findTestAccount(t, repos.accounts, "ada@example.com")
+			if stored.TwoFactor == nil || stored.TwoFactor.PendingSecret != enrollment.Secret || stored.HasTwoFactor() {
+				t.Errorf("stored two factor = %+v, want the secret pending confirmation", stored.TwoFactor)
+			}
+		})
+	}
+}
+
+func TestVerifyTwoFactorLogin(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+	loginLimiter := limiter.NewLoginLimiter(limiter.NewMemoryStore())
+
+	account := createTestAccount(t, repos.accounts, "ada@example.com")
+	secret, _ := enableTestTwoFactor(t, s, repos, account)
+
+	// confirming used the current step, so the authenticator's next code is the first a login accepts
+	code := totpCode(t, secret, time.Now().Add(30*time.Second))
+
+	challenge := twoFactorChallenge(t, s, repos, loginLimiter)
+	loggedIn, err := s.VerifyTwoFactorLogin(ctx, VerifyTwoFactorLoginInput{ChallengeToken: challenge, Code: code}, repos.accounts, loginLimiter)
+	if err != nil {
+		t.Fatalf("VerifyTwoFactorLogin() error = %v", err)
+	}
+	if loggedIn.Token == "" {
+		t.Error("VerifyTwoFactorLogin() returned no session token")
+	}
+
+	// the same code can't log in again, even with a new challenge
+	challenge = twoFactorChallenge(t, s, repos, loginLimiter)
+	_, err = s.VerifyTwoFactorLogin(ctx, VerifyTwoFactorLoginInput{ChallengeToken: challenge, Code: code}, repos.accounts, loginLimiter)
+	if !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Errorf("replayed code error = %v, want %v", err, ErrInvalidTwoFactorCode)
+	}
+}
+
+func TestVerifyTwoFactorLoginRecoveryCodeIsSingleUse(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+	loginLimiter := limiter.NewLoginLimiter(limiter.NewMemoryStore())
+
+	account := createTestAccount(t, repos.accounts, "ada@example.com")
+	_, recoveryCodes := enableTestTwoFactor(t, s, repos, account)
+
+	// recovery codes are accepted whatever their case and surrounding space
+	code := " " + strings.ToUpper(recoveryCodes[0]) + " "
+
+	challenge := twoFactorChallenge(t, s, repos, loginLimiter)
+	loggedIn, err := s.VerifyTwoFactorLogin(ctx, VerifyTwoFactorLoginInput{ChallengeToken: challenge, Code: code}, repos.accounts, loginLimiter)
+	if err != nil {
+		t.Fatalf("VerifyTwoFactorLogin() with a recovery code error = %v", err)
+	}
+	if loggedIn.Token == "" {
+		t.Error("VerifyTwoFactorLogin() returned no session token")
+	}
+
+	stored := findTestAccount(t, repos.accounts, "ada@example.com")
+	if got := len(stored.TwoFactor.RecoveryCodes); got != recoveryCodeCount-1 {
+		t.Errorf("%d recovery codes left, want %d", got, recoveryCodeCount-1)
+	}
+
+	challenge = twoFactorChallenge(t, s, repos, loginLimiter)
+	_, err = s.VerifyTwoFactorLogin(ctx, VerifyTwoFactorLoginInput{ChallengeToken: challenge, Code: code}, repos.accounts, loginLimiter)
+	if !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Errorf("reused recovery code error = %v, want %v", err, ErrInvalidTwoFactorCode)
+	}
+
+	// the rest still work
+	challenge = twoFactorChallenge(t, s, repos, loginLimiter)
+	_, err = s.VerifyTwoFactorLogin(ctx, VerifyTwoFactorLoginInput{ChallengeToken: challenge, Code: recoveryCodes[1]}, repos.accounts, loginLimiter)
+	if err != nil {
+		t.Errorf("VerifyTwoFactorLogin() with another recovery code error = %v", err)
+	}
+}
+
+func TestVerifyTwoFactorLoginRejectsRevokedChallenge(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+	loginLimiter := limiter.NewLoginLimiter(limiter.NewMemoryStore())
+
+	account := createTestAccount(t, repos.accounts, "ada@example.com")
+	secret, recoveryCodes := enableTestTwoFactor(t, s, repos, account)
+	challenge := twoFactorChallenge(t, s, repos, loginLimiter)
+
+	// a password change or logging out everywhere bumps the session version
+	stored := findTestAccount(t, repos.accounts, "ada@example.com")
+	stored.SessionVersion++
+	if _, err := repos.accounts.Update(ctx, stored); err != nil {
+		t.Fatal(err)
+	}
+
+	input := VerifyTwoFactorLoginInput{ChallengeToken: challenge, Code: totpCode(t, secret, time.Now().Add(30*time.Second))}
+	if _, err := s.VerifyTwoFactorLogin(ctx, input, repos.accounts, loginLimiter); !errors.Is(err, ErrInvalidChallenge) {
+		t.Errorf("VerifyTwoFactorLogin() after a session version bump error = %v, want %v", err, ErrInvalidChallenge)
+	}
+
+	// a session token isn't a challenge either
+	loggedIn, err := s.VerifyTwoFactorLogin(ctx, VerifyTwoFactorLoginInput{ChallengeToken: twoFactorChallenge(t, s, repos, loginLimiter), Code: input.Code}, repos.accounts, loginLimiter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input = VerifyTwoFactorLoginInput{ChallengeToken: loggedIn.Token, Code: recoveryCodes[0]}
+	if _, err = s.VerifyTwoFactorLogin(ctx, input, repos.accounts, loginLimiter); !errors.Is(err, ErrInvalidChallenge) {
+		t.Errorf("VerifyTwoFactorLogin() with a session token error = %v, want %v", err, ErrInvalidChallenge)
+	}
+}
+
+func TestVerifyTwoFactorLoginLocksOutBadCodes(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+	loginLimiter := limiter.NewLoginLimiter(limiter.NewMemoryStore(), limiter.WithMaxAttempts(3, 50))
+
+	account := createTestAccount(t, repos.accounts, "ada@example.com")
+	secret, _ := enableTestTwoFactor(t, s, repos, account)
+	challenge := twoFactorChallenge(t, s, repos, loginLimiter)
+
+	for i := 0; i < 3; i++ {
+		input := VerifyTwoFactorLoginInput{ChallengeToken: challenge, Code: "not a code", IpAddress: "203.0.113.7"}
+		if _, err := s.VerifyTwoFactorLogin(ctx, input, repos.accounts, loginLimiter); !errors.Is(err, ErrInvalidTwoFactorCode) {
+			t.Fatalf("bad code %d error = %v, want %v", i+1, err, ErrInvalidTwoFactorCode)
+		}
+	}
+
+	// once locked out even the right code is refused
+	input := VerifyTwoFactorLoginInput{ChallengeToken: challenge, Code: totpCode(t, secret, time.Now().Add(30*time.Second)), IpAddress: "203.0.113.7"}
+	if _, err := s.VerifyTwoFactorLogin(ctx, input, repos.accounts, loginLimiter); !errors.Is(err, ErrTooManyLoginAttempts) {
+		t.Errorf("VerifyTwoFactorLogin() when locked out error = %v, want %v", err, ErrTooManyLoginAttempts)
+	}
+}
+
+func TestDisableTwoFactor(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+
+	account := createTestAccount(t, repos.accounts, "ada@example.com")
+	secret, _ := enableTestTwoFactor(t, s, repos, account)
+	code := totpCode(t, secret, time.Now().Add(30*time.Second))
+
+	tests := []struct {
+		name     string
+		password string
+		code     string
+		want     error
+	}{
+		{name: "wrong password", password: "wrong password", code: code, want: ErrInvalidCredentials},
+		{name: "no code", password: testPassword, want: ErrInvalidTwoFactorCode},
+		{name: "wrong code", password: testPassword, code: "not a code", want: ErrInvalidTwoFactorCode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.DisableTwoFactor(ctx, DisableTwoFactorInput{AccountId: account.GetId(), Code: tt.code, Reauthentication: Reauthentication{Password: tt.password}}, repos.accounts)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("DisableTwoFactor() error = %v, want %v", err, tt.want)
+			}
+			if !findTestAccount(t, repos.accounts, "ada@example.com").HasTwoFactor() {
+				t.Error("two factor was disabled")
+			}
+		})
+	}
+
+	err := s.DisableTwoFactor(ctx, DisableTwoFactorInput{AccountId: account.GetId(), Code: code, Reauthentication: Reauthentication{Password: testPassword}}, repos.accounts)
+	if err != nil {
+		t.Fatalf("DisableTwoFactor() error = %v", err)
+	}
+	if stored := findTestAccount(t, repos.accounts, "ada@example.com"); stored.TwoFactor != nil {
+		t.Errorf("two factor after disabling = %+v, want none", stored.TwoFactor)
+	}
+	if err = s.DisableTwoFactor(ctx, DisableTwoFactorInput{AccountId: account.GetId(), Code: code, Reauthentication: Reauthentication{Password: testPassword}}, repos.accounts); !errors.Is(err, ErrTwoFactorNotEnabled) {
+		t.Errorf("DisableTwoFactor() again error = %v, want %v", err, ErrTwoFactorNotEnabled)
+	}
+}
+
+func TestDisableTwoFactorWithSingleSignOn(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos()
+	s := newTestAccountsService(repos)
+
+	account := createTestAccount(t, repos.accounts, "ada@example.com")
+	account.Password = ""
+	if _, err := repos.accounts.Update(ctx, account); err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := enableTestTwoFactor(t, s, repos, account)
+	code := totpCode(t, secret, time.Now().Add(30*time.Second))
+
+	recently := time.Now().Add(-time.Minute)
+	longAgo := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name   string
+		reauth Reauthentication
+		code   string
+		want   error
+	}{
+		{name: "no sign in", code: code, want: ErrReauthenticationNeeded},
+		{name: "sign in long ago", reauth: Reauthentication{SsoAuthenticatedAt: &longAgo}, code: code, want: ErrReauthenticationNeeded},
+		{name: "code given twice", reauth: Reauthentication{TwoFactorCode: code}, code: code, want: ErrReauthenticationNeeded},
+		{name: "a password", reauth: Reauthentication{Password: testPassword}, code: code, want: ErrReauthenticationNeeded},
+		{name: "no code", reauth: Reauthentication{SsoAuthenticatedAt: &recently}, want: ErrInvalidTwoFactorCode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.DisableTwoFactor(ctx, DisableTwoFactorInput{AccountId: account.GetId(), Code: tt.code, Reauthentication: tt.reauth}, repos.accounts)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("DisableTwoFactor() error = %v, want %v", err, tt.want)
+			}
+			if !findTestAccount(t, repos.accounts, "ada@example.com").HasTwoFactor() {
+				t.Error("two factor was disabled")
+			}
+		})
+	}
+
+	input := DisableTwoFactorInput{AccountId: account.GetId(), Code: code, Reauthentication: Reauthentication{SsoAuthenticatedAt: &recently}}
+	if err := s.DisableTwoFactor(ctx, input, repos.accounts); err != nil {
+		t.Fatalf("DisableTwoFactor() error = %v", err)
+	}
+	if stored := findTestAccount(t, repos.accounts, "ada@example.com"); stored.TwoFactor != nil {
+		t.Errorf("two factor after disabling = %+v, want none", stored.TwoFactor)
+	}
+}
+
+// enableTestTwoFactor enrolls and confirms two factor authentication for the account, and returns
+// its secret and recovery codes.
+func enableTestTwoFactor(t *testing.T, s *AccountsService, repos testRepos, account models.Account) (string, []string) {
+	t.Helper()
+
+	enrollment, err := s.EnrollTwoFactor(context.Background(), EnrollTwoFactorInput{AccountId: account.GetId()}, repos.accounts)
+	if err != nil {
+		t.Fatalf("EnrollTwoFactor() error = %v", err)
+	}
+
+	input := ConfirmTwoFactorInput{AccountId: account.GetId(), Code: totpCode(t, enrollment.Secret, time.Now())}
+	recoveryCodes, err := s.ConfirmTwoFactor(context.Background(), input, repos.accounts)
+	if err != nil {
+		t.Fatalf("ConfirmTwoFactor() error = %v", err)
+	}
+	return enrollment.Secret, recoveryCodes
+}
+
+// twoFactorChallenge logs in to the account createTestAccount made, which must have two factor
+// authentication enabled, and returns the challenge to complete the login with.
+func twoFactorChallenge(t *testing.T, s *AccountsService, repos testRepos, loginLimiter *limiter.LoginLimiter) string {
+	t.Helper()
+
+	input := LoginUserInput{Email: "ada@example.com", Password: testPassword, IpAddress: "203.0.113.7"}
+	account, err := s.LoginUser(context.Background(), input, repos.accounts, loginLimiter, consumer.NewMemoryQueue())
+	if err != nil {
+		t.Fatalf("LoginUser() error = %v", err)
+	}
+	if account.ChallengeToken == "" || account.Token != "" {
+		t.Fatalf("LoginUser() = challenge %q, token %q, want only a challenge", account.ChallengeToken, account.Token)
+	}
+	return account.ChallengeToken
+}
+
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+
+	code, err := totp.Code(secret, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
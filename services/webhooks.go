@@ -0,0 +1,322 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+	"github.com/tejiriaustin/narx_api/utils"
+	"github.com/tejiriaustin/narx_api/webhooks"
+)
+
+const (
+	webhookSecretPrefix = "whsec_"
+	webhookSecretBytes  = 32
+)
+
+type (
+	WebhookService struct {
+		conf     *env.Environment
+		auditLog *audit.Log
+	}
+
+	CreateWebhookInput struct {
+		Account     models.Account
+		Url         string
+		Description string
+		EventTypes  []models.NotificationEventType
+
+		// ForOrganisation subscribes to the events of every account in the caller's organisation.
+		ForOrganisation bool
+	}
+
+	ListWebhooksInput struct {
+		Pager
+		Account models.Account
+	}
+
+	DeleteWebhookInput struct {
+		Account   models.Account
+		WebhookId string
+	}
+
+	ListWebhookDeliveriesInput struct {
+		Pager
+		Account   models.Account
+		WebhookId string
+		Status    models.WebhookDeliveryStatus
+	}
+
+	RedeliverWebhookInput struct {
+		Account    models.Account
+		WebhookId  string
+		DeliveryId string
+	}
+)
+
+func NewWebhookService(conf *env.Environment, auditLog *audit.Log) *WebhookService {
+	return &WebhookService{
+		conf:     conf,
+		auditLog: auditLog,
+	}
+}
+
+var _ WebhookServiceInterface = (*WebhookService)(nil)
+
+// CreateWebhook adds a subscription. The signing secret is only returned here.
+func (s *WebhookService) CreateWebhook(ctx context.Context,
+	input CreateWebhookInput,
+	webhooksRepo *repository.Repository[models.WebhookSubscription],
+) (*models.WebhookSubscription, error) {
+	allowLocalhost := s.conf.GetAsString(env.WebhookAllowLocalhost) == "true"
+	if !models.IsValidWebhookUrl(input.Url, allowLocalhost) {
+		return nil, errors.New("webhook url must be an https url")
+	}
+	if err := webhooks.CheckDestination(ctx, input.Url, allowLocalhost); err != nil {
+		return nil, err
+	}
+	if len(input.EventTypes) == 0 {
+		return nil, errors.New("at least one event type is required")
+	}
+	for _, eventType := range input.EventTypes {
+		if !models.IsValidWebhookEventType(eventType) {
+			return nil, errors.New("invalid event type: " + string(eventType))
+		}
+	}
+	if input.ForOrganisation {
+		if input.Account.OrganisationId == "" {
+			return nil, errors.New("account does not belong to an organisation")
+		}
+		if !input.Account.IsAdmin() {
+			return nil, errors.New("only admins can create organisation webhooks")
+		}
+	}
+
+	secret, err := utils.RandomToken(webhookSecretBytes)
+	if err != nil {
+		return nil, errors.New("failed to generate webhook secret")
+	}
+
+	now := time.Now().UTC()
+	webhook := models.WebhookSubscription{
+		Shared: models.Shared{
+			ID:        primitive.NewObjectID(),
+			CreatedAt: &now,
+		},
+		AccountInfo: models.AccountInfo{
+			Id:        input.Account.GetId(),
+			FirstName: input.Account.FirstName,
+			LastName:  input.Account.LastName,
+			FullName:  input.Account.FullName,
+			Email:     input.Account.Email,
+		},
+		Url:         input.Url,
+		Description: input.Description,
+		EventTypes:  input.EventTypes,
+		Secret:      webhookSecretPrefix + secret,
+	}
+	if input.ForOrganisation {
+		webhook.OrganisationId = input.Account.OrganisationId
+	}
+
+	webhook, err = webhooksRepo.Create(ctx, webhook)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionWebhookCreated,
+		TargetType: audit.TargetWebhook,
+		TargetId:   webhook.GetId(),
+		After:      webhook,
+	})
+
+	return &webhook, nil
+}
+
+// ListWebhooks returns the account's own subscriptions and, for admins, its organisation's.
+func (s *WebhookService) ListWebhooks(ctx context.Context,
+	input ListWebhooksInput,
+	webhooksRepo *repository.Repository[models.WebhookSubscription],
+) ([]models.WebhookSubscription, *repository.Paginator, error) {
+
+	filter := repository.NewQueryFilter().AddFilter("$or", webhookOwners(input.Account))
+
+	webhooks, paginator, err := webhooksRepo.Paginate(ctx, filter, input.Page, input.PerPage, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return webhooks, paginator, nil
+}
+
+// DeleteWebhook removes a subscription and cancels its pending deliveries, so nothing more is
+// sent to its url.
+func (s *WebhookService) DeleteWebhook(ctx context.Context,
+	input DeleteWebhookInput,
+	webhooksRepo *repository.Repository[models.WebhookSubscription],
+	deliveriesRepo *repository.Repository[models.WebhookDelivery],
+) error {
+
+	webhook, err := findWebhook(ctx, input.Account, input.WebhookId, webhooksRepo)
+	if err != nil {
+		return err
+	}
+
+	err = webhooksRepo.DeleteMany(ctx, repository.NewQueryFilter().AddFilter(models.FieldId, webhook.ID))
+	if err != nil {
+		return err
+	}
+
+	pending := repository.NewQueryFilter().
+		AddFilter(models.FieldDeliverySubscriptionId, webhook.GetId()).
+		AddFilter(models.FieldDeliveryStatus, models.DeliveryPending)
+
+	err = deliveriesRepo.UpdateMany(ctx, pending, map[string]interface{}{
+		"$set": map[string]interface{}{
+			models.FieldDeliveryStatus:        models.DeliveryCancelled,
+			models.FieldDeliveryNextAttemptAt: nil,
+			"updated_at":                      time.Now().UTC(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionWebhookDeleted,
+		TargetType: audit.TargetWebhook,
+		TargetId:   webhook.GetId(),
+		Before:     webhook,
+	})
+
+	return nil
+}
+
+func (s *WebhookService) ListWebhookDeliveries(ctx context.Context,
+	input ListWebhookDeliveriesInput,
+	webhooksRepo *repository.Repository[models.WebhookSubscription],
+	deliveriesRepo *repository.Repository[models.WebhookDelivery],
+) ([]models.WebhookDelivery, *repository.Paginator, error) {
+
+	webhook, err := findWebhook(ctx, input.Account, input.WebhookId, webhooksRepo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filter := repository.NewQueryFilter().AddFilter(models.FieldDeliverySubscriptionId, webhook.GetId())
+	if input.Status != "" {
+		if !models.IsValidDeliveryStatus(input.Status) {
+			return nil, nil, errors.New("invalid delivery status")
+		}
+		filter.AddFilter(models.FieldDeliveryStatus, input.Status)
+	}
+
+	deliveries, paginator, err := deliveriesRepo.Paginate(ctx, filter, input.Page, input.PerPage, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return deliveries, paginator, nil
+}
+
+// RedeliverWebhook queues a delivery to be sent again with a fresh set of attempts. The listener
+// sends it on its next retry pass.
+func (s *WebhookService) RedeliverWebhook(ctx context.Context,
+	input RedeliverWebhookInput,
+	webhooksRepo *repository.Repository[models.WebhookSubscription],
+	deliveriesRepo *repository.Repository[models.WebhookDelivery],
+) (*models.WebhookDelivery, error) {
+
+	webhook, err := findWebhook(ctx, input.Account, input.WebhookId, webhooksRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := primitive.ObjectIDFromHex(input.DeliveryId)
+	if err != nil {
+		return nil, errors.New("invalid id")
+	}
+
+	filter := repository.NewQueryFilter().
+		AddFilter(models.FieldId, id).
+		AddFilter(models.FieldDeliverySubscriptionId, webhook.GetId())
+
+	delivery, err := deliveriesRepo.FindOne(ctx, filter, nil, nil)
+	if err != nil {
+		if err == repository.NoDocumentsFound {
+			return nil, errors.New("delivery not found")
+		}
+		return nil, err
+	}
+
+	if delivery.Status == models.DeliveryPending {
+		return nil, errors.New("delivery is already queued")
+	}
+
+	now := time.Now().UTC()
+	delivery.Status = models.DeliveryPending
+	delivery.AttemptCount = 0
+	delivery.NextAttemptAt = &now
+
+	delivery, err = deliveriesRepo.Update(ctx, delivery)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditLog.Record(ctx, audit.Entry{
+		Action:     audit.ActionWebhookRedelivered,
+		TargetType: audit.TargetWebhook,
+		TargetId:   webhook.GetId(),
+		Metadata:   map[string]string{"delivery_id": delivery.GetId(), "event_id": delivery.EventId},
+	})
+
+	return &delivery, nil
+}
+
+// findWebhook loads a subscription the account owns, or one of its organisation's if it is an admin.
+func findWebhook(ctx context.Context,
+	account models.Account,
+	webhookId string,
+	webhooksRepo *repository.Repository[models.WebhookSubscription],
+) (models.WebhookSubscription, error) {
+	id, err := primitive.ObjectIDFromHex(webhookId)
+	if err != nil {
+		return models.WebhookSubscription{}, errors.New("invalid id")
+	}
+
+	filter := repository.NewQueryFilter().
+		AddFilter(models.FieldId, id).
+		AddFilter("$or", webhookOwners(account))
+
+	webhook, err := webhooksRepo.FindOne(ctx, filter, nil, nil)
+	if err != nil {
+		if err == repository.NoDocumentsFound {
+			return webhook, errors.New("webhook not found")
+		}
+		return webhook, err
+	}
+	return webhook, nil
+}
+
+// webhookOwners matches the account's personal subscriptions, and its organisation's if it is an admin.
+// Organisation subscriptions are left out of the first branch so that a member who is no longer an
+// admin can't keep managing the ones they created.
+func webhookOwners(account models.Account) []map[string]interface{} {
+	owners := []map[string]interface{}{
+		{
+			models.FieldAccountInfoId:         account.GetId(),
+			models.FieldWebhookOrganisationId: map[string]interface{}{"$exists": false},
+		},
+	}
+	if account.OrganisationId != "" && account.IsAdmin() {
+		owners = append(owners, map[string]interface{}{models.FieldWebhookOrganisationId: account.OrganisationId})
+	}
+	return owners
+}
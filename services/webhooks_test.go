@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/tejiriaustin/narx_api/audit"
+	"github.com/tejiriaustin/narx_api/database"
+	"github.com/tejiriaustin/narx_api/env"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+	"github.com/tejiriaustin/narx_api/webhooks"
+)
+
+// webhookTest is an account subscribed to faults at a local receiver, with a dispatcher that gives
+// up on a delivery after its first failed attempt.
+type webhookTest struct {
+	s              *WebhookService
+	account        models.Account
+	webhook        *models.WebhookSubscription
+	webhooksRepo   *repository.Repository[models.WebhookSubscription]
+	deliveriesRepo *repository.Repository[models.WebhookDelivery]
+	dispatcher     *webhooks.Dispatcher
+
+	status   atomic.Int32
+	received atomic.Int32
+}
+
+func newWebhookTest(t *testing.T, status int) *webhookTest {
+	t.Helper()
+
+	repos := newTestRepos()
+	conf := newTestConfig().SetEnv(env.WebhookAllowLocalhost, "true")
+
+	wt := &webhookTest{
+		s:              NewWebhookService(&conf, audit.NewLog(repos.auditLog)),
+		account:        createTestAccount(t, repos.accounts, "ada@example.com"),
+		webhooksRepo:   repository.NewRepository[models.WebhookSubscription](database.NewMemoryCollection()),
+		deliveriesRepo: repository.NewRepository[models.WebhookDelivery](database.NewMemoryCollection()),
+	}
+	wt.status.Store(int32(status))
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wt.received.Add(1)
+		w.WriteHeader(int(wt.status.Load()))
+	}))
+	t.Cleanup(receiver.Close)
+
+	webhook, err := wt.s.CreateWebhook(context.Background(), CreateWebhookInput{
+		Account:    wt.account,
+		Url:        receiver.URL,
+		EventTypes: []models.NotificationEventType{models.EventTypeFault},
+	}, wt.webhooksRepo)
+	if err != nil {
+		t.Fatalf("CreateWebhook() error = %v", err)
+	}
+	wt.webhook = webhook
+
+	wt.dispatcher = webhooks.NewDispatcher(wt.webhooksRepo, wt.deliveriesRepo,
+		webhooks.WithLoopbackAllowed(true),
+		webhooks.WithMaxAttempts(1),
+	)
+	return wt
+}
+
+// publish sends a fault to the account's subscriptions, lets the listener's retry pass make the
+// first attempt and returns the delivery.
+func (wt *webhookTest) publish(t *testing.T) models.WebhookDelivery {
+	t.Helper()
+
+	err := wt.dispatcher.Publish(context.Background(), webhooks.Event{
+		Id:   "evt_1",
+		Type: models.EventTypeFault,
+		Data: webhooks.EventData{AccountId: wt.account.GetId(), Severity: models.SeverityWarning, Title: "Inverter fault"},
+	})
+	if err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if err = wt.dispatcher.RetryDue(context.Background()); err != nil {
+		t.Fatalf("RetryDue() error = %v", err)
+	}
+	return wt.delivery(t)
+}
+
+func (wt *webhookTest) delivery(t *testing.T) models.WebhookDelivery {
+	t.Helper()
+
+	delivery, err := wt.deliveriesRepo.FindOne(context.Background(), repository.NewQueryFilter().AddFilter(models.FieldDeliveryEventId, "evt_1"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return delivery
+}
+
+func TestRedeliverWebhook(t *testing.T) {
+	ctx := context.Background()
+	wt := newWebhookTest(t, http.StatusInternalServerError)
+
+	failed := wt.publish(t)
+	if failed.Status != models.DeliveryFailed {
+		t.Fatalf("delivery is %s, want failed", failed.Status)
+	}
+
+	redeliver := func(account models.Account) (*models.WebhookDelivery, error) {
+		return wt.s.RedeliverWebhook(ctx, RedeliverWebhookInput{
+			Account:    account,
+			WebhookId:  wt.webhook.GetId(),
+			DeliveryId: failed.GetId(),
+		}, wt.webhooksRepo, wt.deliveriesRepo)
+	}
+
+	other := createTestAccount(t, repository.NewRepository[models.Account](database.NewMemoryCollection()), "obi@example.com")
+	if _, err := redeliver(other); err == nil {
+		t.Error("RedeliverWebhook() of another account's webhook succeeded")
+	}
+
+	queued, err := redeliver(wt.account)
+	if err != nil {
+		t.Fatalf("RedeliverWebhook() error = %v", err)
+	}
+	if queued.Status != models.DeliveryPending || queued.AttemptCount != 0 || queued.NextAttemptAt == nil {
+		t.Errorf("redelivery is %s after %d attempts, next at %v, want pending with a fresh set of attempts", queued.Status, queued.AttemptCount, queued.NextAttemptAt)
+	}
+	if len(queued.Attempts) != 1 {
+		t.Errorf("redelivery has %d attempts recorded, want the earlier one kept", len(queued.Attempts))
+	}
+	if _, err = redeliver(wt.account); err == nil {
+		t.Error("RedeliverWebhook() of a queued delivery succeeded")
+	}
+
+	// the listener's next retry pass sends it
+	wt.status.Store(http.StatusOK)
+	if err = wt.dispatcher.RetryDue(ctx); err != nil {
+		t.Fatalf("RetryDue() error = %v", err)
+	}
+
+	delivered := wt.delivery(t)
+	if delivered.Status != models.DeliverySucceeded || delivered.AttemptCount != 1 || len(delivered.Attempts) != 2 {
+		t.Errorf("redelivery is %s after %d attempts with %d recorded, want succeeded after 1 with 2 recorded", delivered.Status, delivered.AttemptCount, len(delivered.Attempts))
+	}
+	if got := wt.received.Load(); got != 2 {
+		t.Errorf("receiver got %d requests, want 2", got)
+	}
+}
+
+func TestDeleteWebhookCancelsPendingDeliveries(t *testing.T) {
+	ctx := context.Background()
+	wt := newWebhookTest(t, http.StatusInternalServerError)
+
+	// a failed delivery is queued again, then the webhook is deleted before it is retried
+	failed := wt.publish(t)
+	_, err := wt.s.RedeliverWebhook(ctx, RedeliverWebhookInput{Account: wt.account, WebhookId: wt.webhook.GetId(), DeliveryId: failed.GetId()}, wt.webhooksRepo, wt.deliveriesRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wt.s.DeleteWebhook(ctx, DeleteWebhookInput{Account: wt.account, WebhookId: wt.webhook.GetId()}, wt.webhooksRepo, wt.deliveriesRepo)
+	if err != nil {
+		t.Fatalf("DeleteWebhook() error = %v", err)
+	}
+
+	cancelled := wt.delivery(t)
+	if cancelled.Status != models.DeliveryCancelled || cancelled.NextAttemptAt != nil {
+		t.Errorf("delivery is %s, next at %v, want cancelled", cancelled.Status, cancelled.NextAttemptAt)
+	}
+
+	if err = wt.dispatcher.RetryDue(ctx); err != nil {
+		t.Fatalf("RetryDue() error = %v", err)
+	}
+	after := wt.delivery(t)
+	if after.Status != models.DeliveryCancelled || len(after.Attempts) != len(cancelled.Attempts) {
+		t.Errorf("delivery is %s with %d attempts after a retry pass, want cancelled with %d", after.Status, len(after.Attempts), len(cancelled.Attempts))
+	}
+	if got := wt.received.Load(); got != 1 {
+		t.Errorf("receiver got %d requests, want only the first attempt", got)
+	}
+}
@@ -1,23 +1,305 @@
 package templates
 
 import (
+	"bytes"
+	"embed"
 	"errors"
 	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	texttemplate "text/template"
 )
 
 const (
 	FORGOT_PASSWORD = "FORGOT_PASSWORD"
 
 	ACCOUNT_CREATED = "ACCOUNT_CREATED"
+
+	ACCOUNT_LOCKED = "ACCOUNT_LOCKED"
+
+	USER_NOTIFICATION = "USER_NOTIFICATION"
+
+	DIGEST = "DIGEST"
+
+	PHONE_VERIFICATION = "PHONE_VERIFICATION"
+)
+
+// DefaultLocale is used when a recipient's language has no translation.
+const DefaultLocale = "en"
+
+var ErrUnknownTemplate = errors.New("invalid template key")
+
+//go:embed files
+var files embed.FS
+
+type (
+	ForgotPasswordData struct {
+		FullName string
+		Code     string
+	}
+
+	AccountCreatedData struct {
+		FullName         string
+		VerificationLink string
+	}
+
+	AccountLockedData struct {
+		FullName    string
+		LockedUntil string
+	}
+
+	UserNotificationData struct {
+		FullName string
+		Title    string
+		Body     string
+	}
+
+	// DigestData summarises an account's sites over a day or a week. From and To are the first and
+	// last days covered, in the account's timezone. SensorsOffline counts the sensors that sent no
+	// reading in the last hour of the period. Fault and sensor offline alerts are counted from the
+	// alerts sent in the period, the service doesn't track whether they have since been resolved.
+	DigestData struct {
+		FullName            string
+		Weekly              bool
+		From                string
+		To                  string
+		Production          *DigestProduction // nil when no sensor sent a reading in the period
+		Sensors             int
+		SensorsOffline      int
+		SensorOfflineAlerts int
+		FaultAlerts         int
+		TopAlerts           []DigestAlert
+	}
+
+	// DigestProduction is the energy produced over the period, and what was expected under the
+	// measured irradiance. ExpectedKWh is zero when no sensor measures irradiance.
+	DigestProduction struct {
+		EnergyKWh        float64
+		ExpectedKWh      float64
+		PerformanceRatio float64 // percent
+	}
+
+	DigestAlert struct {
+		Severity string
+		Title    string
+		Body     string
+		At       string
+	}
+
+	PhoneVerificationData struct {
+		Code             string
+		ExpiresInMinutes int
+	}
+
+	// Rendered is a template ready to send. Text messages only have Text.
+	Rendered struct {
+		Subject string
+		HTML    string
+		Text    string
+	}
+
+	definition struct {
+		file   string
+		sample any
+		// sms templates only define "text", which is sent as is without the email layout
+		sms bool
+	}
+
+	parsed struct {
+		html *htmltemplate.Template
+		text *texttemplate.Template
+	}
 )
 
-func NewTemplate(templateKey string, args ...any) (string, error) {
-	switch templateKey {
-	case FORGOT_PASSWORD:
-		return fmt.Sprintf(ForgotPasswordTemplate, args...), nil
-	case ACCOUNT_CREATED:
-		return fmt.Sprintf(AccountCreatedTemplate, args...), nil
-	default:
-		return "", errors.New("invalid template key")
+// definitions maps each template to its file under files/<locale>/ and sample data, which also
+// fixes the type of data the template is rendered with.
+var definitions = map[string]definition{
+	FORGOT_PASSWORD: {
+		file:   "forgot_password.tmpl",
+		sample: ForgotPasswordData{FullName: "Ada Obi", Code: "482913"},
+	},
+	ACCOUNT_CREATED: {
+		file:   "account_created.tmpl",
+		sample: AccountCreatedData{FullName: "Ada Obi", VerificationLink: "https://api.example.com/v1/user/verify?token=sample"},
+	},
+	ACCOUNT_LOCKED: {
+		file:   "account_locked.tmpl",
+		sample: AccountLockedData{FullName: "Ada Obi", LockedUntil: "Mon, 02 Jan 2006 15:04:05 UTC"},
+	},
+	USER_NOTIFICATION: {
+		file:   "user_notification.tmpl",
+		sample: UserNotificationData{FullName: "Ada Obi", Title: "Inverter fault", Body: "Sensor SN-1042 reported an inverter fault at 14:05."},
+	},
+	DIGEST: {
+		file: "digest.tmpl",
+		sample: DigestData{
+			FullName:            "Ada Obi",
+			Weekly:              true,
+			From:                "2006-01-02",
+			To:                  "2006-01-08",
+			Production:          &DigestProduction{EnergyKWh: 1843.2, ExpectedKWh: 2264.4, PerformanceRatio: 81.4},
+			Sensors:             12,
+			SensorsOffline:      1,
+			SensorOfflineAlerts: 3,
+			FaultAlerts:         2,
+			TopAlerts: []DigestAlert{
+				{Severity: "critical", Title: "Inverter fault", Body: "Sensor SN-1042 reported an inverter fault.", At: "2006-01-03 14:05"},
+				{Severity: "warning", Title: "Sensor offline", Body: "Sensor SN-0977 stopped reporting.", At: "2006-01-06 09:12"},
+			},
+		},
+	},
+	PHONE_VERIFICATION: {
+		file:   "phone_verification.tmpl",
+		sample: PhoneVerificationData{Code: "482913", ExpiresInMinutes: 10},
+		sms:    true,
+	},
+}
+
+// parsedTemplates holds every template by locale, then key. Templates are parsed when the
+// package loads, so a broken template stops the binary from starting rather than failing a send.
+var parsedTemplates = mustParse()
+
+// Render renders the template for key in the locale closest to the requested one. data must be
+// the template's data type, e.g. ForgotPasswordData for FORGOT_PASSWORD.
+func Render(key, locale string, data any) (*Rendered, error) {
+	def, ok := definitions[key]
+	if !ok {
+		return nil, ErrUnknownTemplate
+	}
+
+	value := reflect.ValueOf(data)
+	if value.Kind() == reflect.Pointer && !value.IsNil() {
+		value = value.Elem()
+	}
+	if want := reflect.TypeOf(def.sample); !value.IsValid() || value.Type() != want {
+		return nil, fmt.Errorf("template %s must be rendered with %s, got %T", key, want, data)
+	}
+	data = value.Interface()
+
+	t := parsedTemplates[ResolveLocale(locale)][key]
+
+	if def.sms {
+		var text bytes.Buffer
+		if err := t.text.ExecuteTemplate(&text, "text", data); err != nil {
+			return nil, err
+		}
+		return &Rendered{Text: strings.TrimSpace(text.String())}, nil
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := t.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := t.text.ExecuteTemplate(&text, "layout.txt", data); err != nil {
+		return nil, err
+	}
+	if err := t.html.ExecuteTemplate(&html, "layout.html", data); err != nil {
+		return nil, err
+	}
+
+	return &Rendered{
+		Subject: strings.TrimSpace(subject.String()),
+		HTML:    html.String(),
+		Text:    text.String(),
+	}, nil
+}
+
+// Preview renders the template for key with its sample data.
+func Preview(key, locale string) (*Rendered, error) {
+	def, ok := definitions[key]
+	if !ok {
+		return nil, ErrUnknownTemplate
+	}
+	return Render(key, locale, def.sample)
+}
+
+// ResolveLocale returns the translation to use for locale, trying the full tag (pt-br), then its
+// language (pt), then DefaultLocale.
+func ResolveLocale(locale string) string {
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+
+	if _, ok := parsedTemplates[locale]; ok {
+		return locale
+	}
+	if language, _, found := strings.Cut(locale, "-"); found {
+		if _, ok := parsedTemplates[language]; ok {
+			return language
+		}
+	}
+	return DefaultLocale
+}
+
+func Keys() []string {
+	keys := make([]string, 0, len(definitions))
+	for key := range definitions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func Locales() []string {
+	locales := make([]string, 0, len(parsedTemplates))
+	for locale := range parsedTemplates {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// mustParse parses each locale's templates together with the shared layout. Every email file defines
+// "subject", "html" and "text", and is parsed into both an html and a text template set, of which
+// only the matching layout is ever executed. Text message files only define "text" and are parsed
+// on their own.
+func mustParse() map[string]map[string]parsed {
+	entries, err := fs.ReadDir(files, "files")
+	if err != nil {
+		panic(err)
+	}
+
+	result := map[string]map[string]parsed{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		locale := entry.Name()
+
+		result[locale] = map[string]parsed{}
+		for key, def := range definitions {
+			file := path.Join("files", locale, def.file)
+			if _, err := fs.Stat(files, file); err != nil {
+				if locale == DefaultLocale {
+					panic(fmt.Sprintf("template %s has no %s version", key, DefaultLocale))
+				}
+				// untranslated templates fall back to the default locale
+				continue
+			}
+
+			if def.sms {
+				result[locale][key] = parsed{
+					text: texttemplate.Must(texttemplate.New(key).Option("missingkey=error").ParseFS(files, file)),
+				}
+				continue
+			}
+
+			result[locale][key] = parsed{
+				html: htmltemplate.Must(htmltemplate.New(key).Option("missingkey=error").ParseFS(files, "files/layout.tmpl", file)),
+				text: texttemplate.Must(texttemplate.New(key).Option("missingkey=error").ParseFS(files, "files/layout.tmpl", file)),
+			}
+		}
+	}
+
+	// fill the gaps in each translation with the default locale's template
+	for locale, byKey := range result {
+		for key := range definitions {
+			if _, ok := byKey[key]; !ok {
+				result[locale][key] = result[DefaultLocale][key]
+			}
+		}
 	}
+	return result
 }
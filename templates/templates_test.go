@@ -0,0 +1,196 @@
+package templates
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRenderPhoneVerification(t *testing.T) {
+	data := PhoneVerificationData{Code: "482913", ExpiresInMinutes: 10}
+
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{locale: "en", want: "Your Narx verification code is 482913. It expires in 10 minutes."},
+		{locale: "fr", want: "Votre code de vérification Narx est 482913. Il expire dans 10 minutes."},
+		{locale: "fr-CA", want: "Votre code de vérification Narx est 482913. Il expire dans 10 minutes."},
+		{locale: "de", want: "Your Narx verification code is 482913. It expires in 10 minutes."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			rendered, err := Render(PHONE_VERIFICATION, tt.locale, data)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if rendered.Text != tt.want {
+				t.Errorf("Text = %q, want %q", rendered.Text, tt.want)
+			}
+			if rendered.Subject != "" || rendered.HTML != "" {
+				t.Errorf("text message rendered with a subject or html: %+v", rendered)
+			}
+		})
+	}
+}
+
+func TestPreview(t *testing.T) {
+	for _, locale := range Locales() {
+		for _, key := range Keys() {
+			t.Run(locale+"/"+key, func(t *testing.T) {
+				rendered, err := Preview(key, locale)
+				if err != nil {
+					t.Fatalf("Preview() error = %v", err)
+				}
+				if rendered.Text == "" {
+					t.Error("Preview() has no text")
+				}
+				if definitions[key].sms {
+					return
+				}
+				if rendered.Subject == "" || !strings.Contains(rendered.HTML, "</html>") {
+					t.Errorf("Preview() = %+v, want a subject and a full html page", rendered)
+				}
+			})
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	data := ForgotPasswordData{FullName: "Ada Obi", Code: "482913"}
+
+	tests := []struct {
+		locale      string
+		wantSubject string
+	}{
+		{locale: "en", wantSubject: "Reset your password"},
+		{locale: "fr", wantSubject: "Réinitialisez votre mot de passe"},
+		{locale: "FR_be", wantSubject: "Réinitialisez votre mot de passe"},
+		{locale: "yo", wantSubject: "Reset your password"},
+		{locale: "", wantSubject: "Reset your password"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			rendered, err := Render(FORGOT_PASSWORD, tt.locale, &data)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if rendered.Subject != tt.wantSubject {
+				t.Errorf("Subject = %q, want %q", rendered.Subject, tt.wantSubject)
+			}
+			for part, content := range map[string]string{"html": rendered.HTML, "text": rendered.Text} {
+				if !strings.Contains(content, data.Code) || !strings.Contains(content, data.FullName) {
+					t.Errorf("%s doesn't contain the name and code:\n%s", part, content)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderDigestFigures(t *testing.T) {
+	tests := []struct {
+		name       string
+		production *DigestProduction
+		want       string
+	}{
+		{
+			name:       "with readings",
+			production: &DigestProduction{EnergyKWh: 1843.24, ExpectedKWh: 2264.4, PerformanceRatio: 81.44},
+			want:       "Energy produced: 1843.2 kWh\nPerformance ratio: 81.4%\n",
+		},
+		{
+			name:       "without irradiance readings",
+			production: &DigestProduction{EnergyKWh: 1843.24},
+			want:       "Energy produced: 1843.2 kWh\nPerformance ratio: no irradiance readings for this period\n",
+		},
+		{
+			name: "without readings",
+			want: "Energy produced: no readings for this period\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := DigestData{FullName: "Ada Obi", From: "2006-01-02", To: "2006-01-02", Production: tt.production, Sensors: 12, SensorsOffline: 1, SensorOfflineAlerts: 3, FaultAlerts: 2}
+
+			rendered, err := Render(DIGEST, "en", data)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+
+			want := ".\n\n" + tt.want + "Fault alerts: 2\nSensors offline at the end of the period: 1 of 12\nSensor offline alerts: 3\n"
+			if !strings.Contains(rendered.Text, want) {
+				t.Errorf("text doesn't list the figures straight after the introduction, want:\n%s\ngot:\n%s", want, rendered.Text)
+			}
+			for _, line := range strings.Split(strings.TrimSuffix(tt.want, "\n"), "\n") {
+				if !strings.Contains(rendered.HTML, "<li>"+line+"</li>") {
+					t.Errorf("html doesn't list %q:\n%s", line, rendered.HTML)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderEscapesOnlyHTML(t *testing.T) {
+	rendered, err := Render(USER_NOTIFICATION, "en", UserNotificationData{
+		FullName: "Ada Obi",
+		Title:    "Output < 50% & falling",
+		Body:     "<script>alert(1)</script>",
+	})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	if strings.Contains(rendered.HTML, "<script>") || !strings.Contains(rendered.HTML, "&lt;script&gt;") {
+		t.Errorf("html doesn't escape the body:\n%s", rendered.HTML)
+	}
+	if rendered.Subject != "Output < 50% & falling" || !strings.Contains(rendered.Text, "<script>alert(1)</script>") {
+		t.Errorf("subject %q and text are escaped, want them as written:\n%s", rendered.Subject, rendered.Text)
+	}
+}
+
+func TestRenderRejectsOtherData(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		data    any
+		wantErr error
+	}{
+		{name: "unknown template", key: "WELCOME", data: ForgotPasswordData{}, wantErr: ErrUnknownTemplate},
+		{name: "another template's data", key: FORGOT_PASSWORD, data: AccountCreatedData{}},
+		{name: "a map", key: FORGOT_PASSWORD, data: map[string]string{"Code": "482913"}},
+		{name: "nil", key: FORGOT_PASSWORD},
+		{name: "nil pointer", key: FORGOT_PASSWORD, data: (*ForgotPasswordData)(nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Render(tt.key, "en", tt.data)
+			if err == nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Render() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveLocale(t *testing.T) {
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{locale: "fr", want: "fr"},
+		{locale: "fr-CA", want: "fr"},
+		{locale: " FR_ca ", want: "fr"},
+		{locale: "en-GB", want: "en"},
+		{locale: "pt-BR", want: DefaultLocale},
+		{locale: "", want: DefaultLocale},
+	}
+
+	for _, tt := range tests {
+		if got := ResolveLocale(tt.locale); got != tt.want {
+			t.Errorf("ResolveLocale(%q) = %q, want %q", tt.locale, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed of RFC 6238 Appendix B, "12345678901234567890", base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestGenerateRFC6238(t *testing.T) {
+	// Appendix B lists 8 digit codes, ours are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	key, err := encoding.DecodeString(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(time.Unix(tt.unix, 0).UTC().Format(time.RFC3339), func(t *testing.T) {
+			if got := generate(key, tt.unix/period); got != tt.want {
+				t.Errorf("generate() = %s, want %s", got, tt.want)
+			}
+			if got, err := Code(rfcSecret, time.Unix(tt.unix, 0)); err != nil || got != tt.want {
+				t.Errorf("Code() = %s, %v, want %s", got, err, tt.want)
+			}
+
+			step, ok := Validate(rfcSecret, tt.want, time.Unix(tt.unix, 0), 0)
+			if !ok || step != tt.unix/period {
+				t.Errorf("Validate() = %d, %v, want %d, true", step, ok, tt.unix/period)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / period
+
+	key, err := encoding.DecodeString(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOk   bool
+	}{
+		{name: "current code", secret: rfcSecret, code: generate(key, current), wantStep: current, wantOk: true},
+		{name: "previous code within skew", secret: rfcSecret, code: generate(key, current-1), wantStep: current - 1, wantOk: true},
+		{name: "next code within skew", secret: rfcSecret, code: generate(key, current+1), wantStep: current + 1, wantOk: true},
+		{name: "code outside skew", secret: rfcSecret, code: generate(key, current-2)},
+		{name: "code from the far future", secret: rfcSecret, code: generate(key, current+2)},
+		{name: "replayed code", secret: rfcSecret, code: generate(key, current), lastStep: current},
+		{name: "earlier code after a later one was used", secret: rfcSecret, code: generate(key, current-1), lastStep: current},
+		{name: "later code after an earlier one was used", secret: rfcSecret, code: generate(key, current), lastStep: current - 1, wantStep: current, wantOk: true},
+		{name: "surrounding whitespace", secret: " " + rfcSecret + "\n", code: " " + generate(key, current) + " ", wantStep: current, wantOk: true},
+		{name: "lowercase secret", secret: "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code: generate(key, current), wantStep: current, wantOk: true},
+		{name: "too short", secret: rfcSecret, code: generate(key, current)[:5]},
+		{name: "too long", secret: rfcSecret, code: generate(key, current) + "0"},
+		{name: "malformed secret", secret: "not base32!", code: generate(key, current)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(tt.secret, tt.code, now, tt.lastStep)
+			if ok != tt.wantOk || step != tt.wantStep {
+				t.Errorf("Validate() = %d, %v, want %d, %v", step, ok, tt.wantStep, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestURI(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, err := encoding.DecodeString(secret); err != nil || len(key) != secretSize {
+		t.Fatalf("GenerateSecret() = %s, decodes to %d bytes (%v), want %d", secret, len(key), err, secretSize)
+	}
+
+	u, err := url.Parse(URI("Narx", "ada@example.com", secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Narx:ada@example.com" {
+		t.Errorf("URI() = %s, want otpauth://totp/Narx:ada@example.com", u)
+	}
+
+	query := u.Query()
+	for key, want := range map[string]string{"secret": secret, "issuer": "Narx", "algorithm": "SHA1", "digits": "6", "period": "30"} {
+		if got := query.Get(key); got != want {
+			t.Errorf("URI() %s = %q, want %q", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"math/big"
+)
+
+var digits = []rune("0123456789")
+
+// RandomNumericCode returns a cryptographically random code made up of n digits.
+func RandomNumericCode(n int) (string, error) {
+	b := make([]rune, n)
+	for i := range b {
+		idx, err := rand.Int(rand.Reader, big.NewInt(int64(len(digits))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = digits[idx.Int64()]
+	}
+	return string(b), nil
+}
+
+// RandomToken returns a cryptographically random, hex encoded token of n bytes.
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashToken returns the hex encoded sha256 digest of a token so it can be stored instead of the raw value.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CompareTokenHash reports whether token hashes to the stored hash, in constant time.
+func CompareTokenHash(hash, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(HashToken(token))) == 1
+}
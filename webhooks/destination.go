@@ -0,0 +1,125 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+var ErrPrivateDestination = errors.New("webhook url must resolve to a public address")
+
+// reservedPrefixes aren't reachable on the internet, or reach something other than the host they name.
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "this" network
+	netip.MustParsePrefix("10.0.0.0/8"),      // private
+	netip.MustParsePrefix("100.64.0.0/10"),   // carrier-grade NAT shared address space
+	netip.MustParsePrefix("127.0.0.0/8"),     // loopback
+	netip.MustParsePrefix("169.254.0.0/16"),  // link-local, including cloud metadata services
+	netip.MustParsePrefix("172.16.0.0/12"),   // private
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation
+	netip.MustParsePrefix("192.88.99.0/24"),  // 6to4 relay anycast
+	netip.MustParsePrefix("192.168.0.0/16"),  // private
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation
+	netip.MustParsePrefix("224.0.0.0/4"),     // multicast
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved, including broadcast
+	netip.MustParsePrefix("::/128"),          // unspecified
+	netip.MustParsePrefix("::1/128"),         // loopback
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64, which would reach any IPv4 address
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local NAT64
+	netip.MustParsePrefix("100::/64"),        // discard
+	netip.MustParsePrefix("2001::/23"),       // IETF protocol assignments, including Teredo
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+	netip.MustParsePrefix("2002::/16"),       // 6to4, which embeds an IPv4 address
+	netip.MustParsePrefix("fc00::/7"),        // unique local
+	netip.MustParsePrefix("fe80::/10"),       // link-local
+	netip.MustParsePrefix("ff00::/8"),        // multicast
+}
+
+// IsPublicIP reports whether ip is routable on the internet. Deliveries are never made to private,
+// loopback, link-local or otherwise reserved addresses, so a subscription can't probe the internal network.
+func IsPublicIP(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	// an IPv4 address written as IPv6 is checked as the IPv4 address it is
+	addr = addr.Unmap()
+
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckDestination resolves the host of a webhook url and fails unless every address it resolves to
+// is public. Loopback addresses are let through when allowLoopback is set, for local development.
+func CheckDestination(ctx context.Context, rawUrl string, allowLoopback bool) error {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Hostname() == "" {
+		return errors.New("invalid webhook url")
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return errors.New("webhook url host could not be resolved")
+	}
+
+	for _, addr := range addrs {
+		if !allowedIP(addr.IP, allowLoopback) {
+			return ErrPrivateDestination
+		}
+	}
+	return nil
+}
+
+func allowedIP(ip net.IP, allowLoopback bool) bool {
+	return IsPublicIP(ip) || (allowLoopback && ip.IsLoopback())
+}
+
+// dialControl refuses connections to addresses that aren't public. It runs on the resolved address,
+// so a host that was public when the subscription was made can't later be pointed at an internal one.
+func dialControl(allowLoopback bool) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || !allowedIP(ip, allowLoopback) {
+			return ErrPrivateDestination
+		}
+		return nil
+	}
+}
+
+func newHTTPClient(allowLoopback bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: requestTimeout,
+		Control: dialControl(allowLoopback),
+	}
+
+	return &http.Client{
+		Timeout: requestTimeout,
+		Transport: &http.Transport{
+			// deliveries never go through a proxy, the dialer has to see the real destination
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: requestTimeout,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		// a redirect could point a customer's webhook anywhere, treat it as a failed delivery
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,148 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		want bool
+	}{
+		{name: "public ipv4", ip: "93.184.216.34", want: true},
+		{name: "public ipv6", ip: "2606:2800:220:1:248:1893:25c8:1946", want: true},
+		{name: "this network", ip: "0.0.0.0"},
+		{name: "this network range", ip: "0.1.2.3"},
+		{name: "private 10/8", ip: "10.1.2.3"},
+		{name: "carrier-grade nat", ip: "100.64.0.1"},
+		{name: "carrier-grade nat end", ip: "100.127.255.254"},
+		{name: "just past carrier-grade nat", ip: "100.128.0.1", want: true},
+		{name: "loopback", ip: "127.0.0.1"},
+		{name: "loopback range", ip: "127.8.8.8"},
+		{name: "link-local metadata", ip: "169.254.169.254"},
+		{name: "private 172.16/12", ip: "172.16.0.1"},
+		{name: "private 172.16/12 end", ip: "172.31.255.255"},
+		{name: "just past 172.16/12", ip: "172.32.0.1", want: true},
+		{name: "ietf protocol assignments", ip: "192.0.0.8"},
+		{name: "documentation 192.0.2/24", ip: "192.0.2.10"},
+		{name: "6to4 relay anycast", ip: "192.88.99.1"},
+		{name: "private 192.168/16", ip: "192.168.1.1"},
+		{name: "benchmarking", ip: "198.18.0.1"},
+		{name: "benchmarking end", ip: "198.19.255.255"},
+		{name: "documentation 198.51.100/24", ip: "198.51.100.7"},
+		{name: "documentation 203.0.113/24", ip: "203.0.113.7"},
+		{name: "multicast", ip: "224.0.0.1"},
+		{name: "reserved 240/4", ip: "240.0.0.1"},
+		{name: "broadcast", ip: "255.255.255.255"},
+		{name: "unspecified ipv6", ip: "::"},
+		{name: "loopback ipv6", ip: "::1"},
+		{name: "ipv4-mapped private", ip: "::ffff:10.0.0.1"},
+		{name: "ipv4-mapped public", ip: "::ffff:93.184.216.34", want: true},
+		{name: "nat64", ip: "64:ff9b::a9fe:a9fe"},
+		{name: "local nat64", ip: "64:ff9b:1::1"},
+		{name: "discard", ip: "100::1"},
+		{name: "teredo", ip: "2001::1"},
+		{name: "documentation ipv6", ip: "2001:db8::1"},
+		{name: "6to4", ip: "2002:a00:1::1"},
+		{name: "unique local", ip: "fc00::1"},
+		{name: "unique local fd", ip: "fd12:3456::1"},
+		{name: "link-local ipv6", ip: "fe80::1"},
+		{name: "multicast ipv6", ip: "ff02::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPublicIP(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("IsPublicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+
+	if IsPublicIP(nil) {
+		t.Error("IsPublicIP(nil) = true, want false")
+	}
+}
+
+func TestCheckDestination(t *testing.T) {
+	tests := []struct {
+		name          string
+		url           string
+		allowLoopback bool
+		wantErr       bool
+	}{
+		{name: "public address", url: "https://93.184.216.34/hooks"},
+		{name: "private address", url: "https://10.0.0.5/hooks", wantErr: true},
+		{name: "metadata address", url: "http://169.254.169.254/latest/meta-data", wantErr: true},
+		{name: "loopback", url: "http://127.0.0.1:8080/hooks", wantErr: true},
+		{name: "loopback allowed for development", url: "http://127.0.0.1:8080/hooks", allowLoopback: true},
+		{name: "private address with loopback allowed", url: "http://192.168.0.10/hooks", allowLoopback: true, wantErr: true},
+		{name: "no host", url: "https:///hooks", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckDestination(context.Background(), tt.url, tt.allowLoopback)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckDestination(%q) error = %v, want error %v", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDialControl(t *testing.T) {
+	tests := []struct {
+		address       string
+		allowLoopback bool
+		wantErr       bool
+	}{
+		{address: "93.184.216.34:443"},
+		{address: "[2606:2800:220:1:248:1893:25c8:1946]:443"},
+		{address: "10.0.0.5:443", wantErr: true},
+		{address: "127.0.0.1:8080", wantErr: true},
+		{address: "127.0.0.1:8080", allowLoopback: true},
+		{address: "[::1]:8080", allowLoopback: true},
+		{address: "169.254.169.254:80", allowLoopback: true, wantErr: true},
+		{address: "not-an-address", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := dialControl(tt.allowLoopback)("tcp", tt.address, nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("dialControl(%v)(%q) error = %v, want error %v", tt.allowLoopback, tt.address, err, tt.wantErr)
+		}
+	}
+}
+
+func TestHTTPClientRefusesPrivateDestinations(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name          string
+		allowLoopback bool
+		wantErr       error
+	}{
+		{name: "loopback refused", allowLoopback: false, wantErr: ErrPrivateDestination},
+		{name: "loopback allowed", allowLoopback: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := newHTTPClient(tt.allowLoopback).Post(srv.URL, "application/json", nil)
+			if err == nil {
+				res.Body.Close()
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Post() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
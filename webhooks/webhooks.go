@@ -0,0 +1,374 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+// Headers sent with every delivery.
+const (
+	HeaderEvent     = "X-Narx-Event"
+	HeaderDelivery  = "X-Narx-Delivery"
+	HeaderTimestamp = "X-Narx-Timestamp"
+	HeaderSignature = "X-Narx-Signature"
+)
+
+const (
+	defaultMaxAttempts = 8
+	defaultBaseBackoff = 30 * time.Second
+	defaultMaxBackoff  = 6 * time.Hour
+	requestTimeout     = 10 * time.Second
+
+	// claimTimeout keeps other listeners off a delivery while it is being sent.
+	claimTimeout = 2 * time.Minute
+)
+
+type (
+	// Dispatcher creates deliveries for events and sends them, retrying failures with exponential backoff.
+	Dispatcher struct {
+		client            *http.Client
+		subscriptionsRepo *repository.Repository[models.WebhookSubscription]
+		deliveriesRepo    *repository.Repository[models.WebhookDelivery]
+		maxAttempts       int
+		baseBackoff       time.Duration
+		maxBackoff        time.Duration
+
+		// allowLoopback lets deliveries reach localhost, for local development only.
+		allowLoopback bool
+	}
+
+	Options func(d *Dispatcher)
+
+	// Event is what subscribers receive, as the JSON body of the request.
+	Event struct {
+		Id        string                       `json:"id"`
+		Type      models.NotificationEventType `json:"type"`
+		CreatedAt time.Time                    `json:"createdAt"`
+		Data      EventData                    `json:"data"`
+
+		// OrganisationId routes the event to organisation wide subscriptions, it isn't sent.
+		OrganisationId string `json:"-"`
+
+		// SkipAccountSubscriptions leaves out the account's own subscriptions, for accounts whose
+		// preferences don't pick the webhook channel. It isn't sent.
+		SkipAccountSubscriptions bool `json:"-"`
+	}
+
+	EventData struct {
+		AccountId string            `json:"accountId"`
+		Severity  models.Severity   `json:"severity"`
+		Title     string            `json:"title"`
+		Body      string            `json:"body"`
+		Data      map[string]string `json:"data,omitempty"`
+	}
+)
+
+func NewDispatcher(
+	subscriptionsRepo *repository.Repository[models.WebhookSubscription],
+	deliveriesRepo *repository.Repository[models.WebhookDelivery],
+	opts ...Options,
+) *Dispatcher {
+	d := &Dispatcher{
+		subscriptionsRepo: subscriptionsRepo,
+		deliveriesRepo:    deliveriesRepo,
+		maxAttempts:       defaultMaxAttempts,
+		baseBackoff:       defaultBaseBackoff,
+		maxBackoff:        defaultMaxBackoff,
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+	if d.client == nil {
+		d.client = newHTTPClient(d.allowLoopback)
+	}
+	return d
+}
+
+func WithMaxAttempts(n int) Options {
+	return func(d *Dispatcher) {
+		d.maxAttempts = n
+	}
+}
+
+func WithBackoff(base, max time.Duration) Options {
+	return func(d *Dispatcher) {
+		d.baseBackoff = base
+		d.maxBackoff = max
+	}
+}
+
+// WithLoopbackAllowed lets deliveries reach subscriptions on localhost, for local development.
+func WithLoopbackAllowed(allow bool) Options {
+	return func(d *Dispatcher) {
+		d.allowLoopback = allow
+	}
+}
+
+func WithHTTPClient(client *http.Client) Options {
+	return func(d *Dispatcher) {
+		d.client = client
+	}
+}
+
+// Sign returns the signature of body sent at timestamp, as "t=<unix seconds>,v1=<hex hmac-sha256>".
+// The HMAC covers "<unix seconds>.<body>", so a captured request can't be replayed with a new timestamp.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Publish creates a delivery of event for every subscription that wants it, due straight away.
+// Nothing is sent here: the first attempt goes out on the next RetryDue pass like any retry, so a
+// slow receiver doesn't hold up the event's handler. Publish only fails if the deliveries couldn't
+// be stored.
+func (d *Dispatcher) Publish(ctx context.Context, event Event) error {
+	subscriptions, err := d.subscriptionsFor(ctx, event)
+	if err != nil {
+		return err
+	}
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, subscription := range subscriptions {
+		if err := d.createDelivery(ctx, subscription, event, payload); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RetryDue sends every pending delivery whose next attempt is due.
+func (d *Dispatcher) RetryDue(ctx context.Context) error {
+	filter := repository.NewQueryFilter().
+		AddFilter(models.FieldDeliveryStatus, models.DeliveryPending).
+		AddFilter(models.FieldDeliveryNextAttemptAt, map[string]interface{}{"$lte": time.Now().UTC()})
+
+	deliveries, err := d.deliveriesRepo.Find(ctx, filter, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range deliveries {
+		d.attempt(ctx, delivery)
+	}
+	return nil
+}
+
+// Run retries due deliveries every interval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.RetryDue(ctx); err != nil {
+				log.Printf("failed to retry webhook deliveries: %s", err)
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) subscriptionsFor(ctx context.Context, event Event) ([]models.WebhookSubscription, error) {
+	var owners []map[string]interface{}
+	if !event.SkipAccountSubscriptions {
+		owners = append(owners, map[string]interface{}{models.FieldAccountInfoId: event.Data.AccountId})
+	}
+	if event.OrganisationId != "" {
+		owners = append(owners, map[string]interface{}{models.FieldWebhookOrganisationId: event.OrganisationId})
+	}
+	if len(owners) == 0 {
+		return nil, nil
+	}
+
+	filter := repository.NewQueryFilter().
+		AddFilter("$or", owners).
+		AddFilter(models.FieldWebhookEventTypes, event.Type)
+
+	return d.subscriptionsRepo.Find(ctx, filter, nil, nil)
+}
+
+// createDelivery stores the delivery of an event to a subscription once, so a redelivered event
+// doesn't post twice.
+func (d *Dispatcher) createDelivery(ctx context.Context,
+	subscription models.WebhookSubscription,
+	event Event,
+	payload []byte,
+) error {
+	filter := repository.NewQueryFilter().
+		AddFilter(models.FieldDeliverySubscriptionId, subscription.GetId()).
+		AddFilter(models.FieldDeliveryEventId, event.Id)
+
+	_, err := d.deliveriesRepo.FindOne(ctx, filter, nil, nil)
+	if err == nil {
+		return nil
+	}
+	if err != repository.NoDocumentsFound {
+		return err
+	}
+
+	now := time.Now().UTC()
+	_, err = d.deliveriesRepo.Create(ctx, models.WebhookDelivery{
+		Shared: models.Shared{
+			ID:        primitive.NewObjectID(),
+			CreatedAt: &now,
+		},
+		SubscriptionId: subscription.GetId(),
+		EventId:        event.Id,
+		EventType:      event.Type,
+		Payload:        string(payload),
+		Status:         models.DeliveryPending,
+		NextAttemptAt:  &now,
+		Attempts:       []models.WebhookAttempt{},
+	})
+	return err
+}
+
+// attempt claims the delivery, posts it to its subscription and records the outcome.
+func (d *Dispatcher) attempt(ctx context.Context, delivery models.WebhookDelivery) {
+	claimed, err := d.claim(ctx, delivery)
+	if err != nil {
+		log.Printf("failed to claim webhook delivery %s: %s", delivery.GetId(), err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	id, _ := primitive.ObjectIDFromHex(delivery.SubscriptionId)
+	subscription, err := d.subscriptionsRepo.FindOne(ctx, repository.NewQueryFilter().AddFilter(models.FieldId, id), nil, nil)
+	if err == repository.NoDocumentsFound {
+		d.record(ctx, delivery, models.WebhookAttempt{AttemptedAt: time.Now().UTC(), Error: "subscription was deleted"}, true)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to load webhook subscription %s: %s", delivery.SubscriptionId, err)
+		return
+	}
+
+	result := d.post(ctx, subscription, delivery)
+	d.record(ctx, delivery, result, false)
+}
+
+// claim moves the delivery's next attempt past the claim timeout, if nobody else has already,
+// so only one listener sends it.
+func (d *Dispatcher) claim(ctx context.Context, delivery models.WebhookDelivery) (bool, error) {
+	now := time.Now().UTC()
+
+	filter := repository.NewQueryFilter().
+		AddFilter(models.FieldId, delivery.ID).
+		AddFilter(models.FieldDeliveryStatus, models.DeliveryPending).
+		AddFilter(models.FieldDeliveryNextAttemptAt, map[string]interface{}{"$lte": now})
+
+	modified, err := d.deliveriesRepo.UpdateOne(ctx, filter, map[string]interface{}{
+		"$set": map[string]interface{}{models.FieldDeliveryNextAttemptAt: now.Add(claimTimeout)},
+	})
+	return modified == 1, err
+}
+
+func (d *Dispatcher) post(ctx context.Context, subscription models.WebhookSubscription, delivery models.WebhookDelivery) models.WebhookAttempt {
+	started := time.Now().UTC()
+	attempt := models.WebhookAttempt{AttemptedAt: started}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.Url, bytes.NewReader([]byte(delivery.Payload)))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Narx-Webhooks/1.0")
+	req.Header.Set(HeaderEvent, string(delivery.EventType))
+	req.Header.Set(HeaderDelivery, delivery.GetId())
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(started.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(subscription.Secret, started, []byte(delivery.Payload)))
+
+	resp, err := d.client.Do(req)
+	attempt.DurationMs = time.Since(started).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+
+	attempt.ResponseCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("unexpected response status %d", resp.StatusCode)
+	}
+	return attempt
+}
+
+// record adds the attempt to the delivery, and either completes it or schedules the next attempt.
+// A delivery cancelled while it was being sent stays cancelled.
+func (d *Dispatcher) record(ctx context.Context, delivery models.WebhookDelivery, attempt models.WebhookAttempt, giveUp bool) {
+	attemptCount := delivery.AttemptCount + 1
+
+	set := map[string]interface{}{
+		models.FieldDeliveryAttemptCount: attemptCount,
+		"updated_at":                     time.Now().UTC(),
+	}
+
+	switch {
+	case attempt.Error == "":
+		set[models.FieldDeliveryStatus] = models.DeliverySucceeded
+		set[models.FieldDeliveryNextAttemptAt] = nil
+	case giveUp || attemptCount >= d.maxAttempts:
+		set[models.FieldDeliveryStatus] = models.DeliveryFailed
+		set[models.FieldDeliveryNextAttemptAt] = nil
+	default:
+		set[models.FieldDeliveryNextAttemptAt] = time.Now().UTC().Add(d.backoff(attemptCount))
+	}
+
+	filter := repository.NewQueryFilter().
+		AddFilter(models.FieldId, delivery.ID).
+		AddFilter(models.FieldDeliveryStatus, models.DeliveryPending)
+
+	_, err := d.deliveriesRepo.UpdateOne(ctx, filter, map[string]interface{}{
+		"$set":  set,
+		"$push": map[string]interface{}{models.FieldDeliveryAttempts: attempt},
+	})
+	if err != nil {
+		log.Printf("failed to record webhook delivery attempt %s: %s", delivery.GetId(), err)
+	}
+}
+
+// backoff is the wait before the attempt after attemptCount failed ones: base, 2*base, 4*base... up to max.
+func (d *Dispatcher) backoff(attemptCount int) time.Duration {
+	wait := d.baseBackoff
+	for i := 1; i < attemptCount; i++ {
+		wait *= 2
+		if wait >= d.maxBackoff {
+			return d.maxBackoff
+		}
+	}
+	return wait
+}
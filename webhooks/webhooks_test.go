@@ -0,0 +1,480 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tejiriaustin/narx_api/database"
+	"github.com/tejiriaustin/narx_api/models"
+	"github.com/tejiriaustin/narx_api/repository"
+)
+
+func TestSign(t *testing.T) {
+	timestamp := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name   string
+		secret string
+		body   string
+		want   string
+	}{
+		{
+			name:   "body",
+			secret: "whsec_test",
+			body:   `{"id":"evt_1"}`,
+			want:   "t=1700000000,v1=c89214b5b5da833daed6f0b8c5bb6bd58cea9022bd80ccc78230f3942d632925",
+		},
+		{
+			name:   "empty body",
+			secret: "whsec_test",
+			body:   "",
+			want:   "t=1700000000,v1=5967f3c560522fa40cf2876ebc3c3a08551dd6959aaade3b413460591895bdcc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sign(tt.secret, timestamp, []byte(tt.body)); got != tt.want {
+				t.Errorf("Sign() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignCoversTimestamp(t *testing.T) {
+	body := []byte(`{"id":"evt_1"}`)
+
+	first := Sign("whsec_test", time.Unix(1700000000, 0), body)
+	replayed := Sign("whsec_test", time.Unix(1700000060, 0), body)
+
+	if first[len("t=1700000000,"):] == replayed[len("t=1700000060,"):] {
+		t.Error("signature doesn't change with the timestamp")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := NewDispatcher(nil, nil, WithBackoff(30*time.Second, 6*time.Hour))
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 0, want: 30 * time.Second},
+		{attempts: 1, want: 30 * time.Second},
+		{attempts: 2, want: time.Minute},
+		{attempts: 3, want: 2 * time.Minute},
+		{attempts: 10, want: 256 * time.Minute},
+		{attempts: 11, want: 6 * time.Hour},
+		{attempts: 100, want: 6 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := d.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestSubscriptionsFor(t *testing.T) {
+	subscriptionsRepo := repository.NewRepository[models.WebhookSubscription](database.NewMemoryCollection())
+
+	now := time.Now().UTC()
+	for _, subscription := range []models.WebhookSubscription{
+		{Description: "account faults", AccountInfo: models.AccountInfo{Id: "a"}, EventTypes: []models.NotificationEventType{models.EventTypeFault}},
+		{Description: "account alerts", AccountInfo: models.AccountInfo{Id: "a"}, EventTypes: []models.NotificationEventType{models.EventTypeAlert}},
+		{Description: "organisation faults", AccountInfo: models.AccountInfo{Id: "admin"}, OrganisationId: "org", EventTypes: []models.NotificationEventType{models.EventTypeFault}},
+		{Description: "someone else's faults", AccountInfo: models.AccountInfo{Id: "b"}, EventTypes: []models.NotificationEventType{models.EventTypeFault}},
+	} {
+		subscription.Shared = models.Shared{ID: primitive.NewObjectID(), CreatedAt: &now}
+		if _, err := subscriptionsRepo.Create(context.Background(), subscription); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		event Event
+		want  []string
+	}{
+		{
+			name:  "account subscriptions",
+			event: Event{Type: models.EventTypeFault, Data: EventData{AccountId: "a"}},
+			want:  []string{"account faults"},
+		},
+		{
+			name:  "account and organisation subscriptions",
+			event: Event{Type: models.EventTypeFault, Data: EventData{AccountId: "a"}, OrganisationId: "org"},
+			want:  []string{"account faults", "organisation faults"},
+		},
+		{
+			name:  "account turned webhooks off",
+			event: Event{Type: models.EventTypeFault, Data: EventData{AccountId: "a"}, OrganisationId: "org", SkipAccountSubscriptions: true},
+			want:  []string{"organisation faults"},
+		},
+		{
+			name:  "account turned webhooks off outside an organisation",
+			event: Event{Type: models.EventTypeFault, Data: EventData{AccountId: "a"}, SkipAccountSubscriptions: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDispatcher(subscriptionsRepo, nil)
+
+			subscriptions, err := d.subscriptionsFor(context.Background(), tt.event)
+			if err != nil {
+				t.Fatalf("subscriptionsFor() error = %v", err)
+			}
+
+			var got []string
+			for _, subscription := range subscriptions {
+				got = append(got, subscription.Description)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("subscriptionsFor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// receiver is a subscriber's endpoint, it answers with status and keeps what it is sent.
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	requests []receivedRequest
+}
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func newReceiver(t *testing.T, status int) *receiver {
+	t.Helper()
+
+	r := &receiver{status: status}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, receivedRequest{header: req.Header.Clone(), body: body})
+		w.WriteHeader(r.status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) respondWith(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+func (r *receiver) received() []receivedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.requests)
+}
+
+func newTestDispatcher(t *testing.T, rcv *receiver, opts ...Options) (*Dispatcher, models.WebhookSubscription, *repository.Repository[models.WebhookDelivery]) {
+	t.Helper()
+
+	subscriptionsRepo := repository.NewRepository[models.WebhookSubscription](database.NewMemoryCollection())
+	deliveriesRepo := repository.NewRepository[models.WebhookDelivery](database.NewMemoryCollection())
+
+	now := time.Now().UTC()
+	subscription, err := subscriptionsRepo.Create(context.Background(), models.WebhookSubscription{
+		Shared:      models.Shared{ID: primitive.NewObjectID(), CreatedAt: &now},
+		AccountInfo: models.AccountInfo{Id: "a"},
+		Url:         rcv.URL,
+		EventTypes:  []models.NotificationEventType{models.EventTypeFault},
+		Secret:      "whsec_test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts = append([]Options{WithLoopbackAllowed(true)}, opts...)
+	return NewDispatcher(subscriptionsRepo, deliveriesRepo, opts...), subscription, deliveriesRepo
+}
+
+func testEvent() Event {
+	return Event{
+		Id:        primitive.NewObjectID().Hex(),
+		Type:      models.EventTypeFault,
+		CreatedAt: time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC),
+		Data: EventData{
+			AccountId: "a",
+			Severity:  models.SeverityWarning,
+			Title:     "Inverter fault",
+			Body:      "Your inverter stopped reporting.",
+		},
+	}
+}
+
+func findDelivery(t *testing.T, deliveriesRepo *repository.Repository[models.WebhookDelivery]) models.WebhookDelivery {
+	t.Helper()
+
+	deliveries, err := deliveriesRepo.Find(context.Background(), repository.NewQueryFilter(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("%d deliveries, want 1", len(deliveries))
+	}
+	return deliveries[0]
+}
+
+func TestPublishQueuesDelivery(t *testing.T) {
+	rcv := newReceiver(t, http.StatusOK)
+	d, _, deliveriesRepo := newTestDispatcher(t, rcv)
+
+	event := testEvent()
+	if err := d.Publish(context.Background(), event); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	// the event being handled again doesn't queue it twice
+	if err := d.Publish(context.Background(), event); err != nil {
+		t.Fatalf("Publish() again error = %v", err)
+	}
+
+	if got := len(rcv.received()); got != 0 {
+		t.Errorf("received %d requests from Publish, want none", got)
+	}
+	delivery := findDelivery(t, deliveriesRepo)
+	if delivery.Status != models.DeliveryPending || delivery.AttemptCount != 0 || len(delivery.Attempts) != 0 {
+		t.Errorf("delivery is %s after %d attempts, want pending before the first", delivery.Status, delivery.AttemptCount)
+	}
+	if delivery.NextAttemptAt == nil || delivery.NextAttemptAt.After(time.Now()) {
+		t.Errorf("first attempt at %v, want due now", delivery.NextAttemptAt)
+	}
+}
+
+func TestRetryDuePostsSignedEvent(t *testing.T) {
+	ctx := context.Background()
+	rcv := newReceiver(t, http.StatusOK)
+	d, subscription, deliveriesRepo := newTestDispatcher(t, rcv)
+
+	event := testEvent()
+	if err := d.Publish(ctx, event); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if err := d.RetryDue(ctx); err != nil {
+		t.Fatalf("RetryDue() error = %v", err)
+	}
+	// a delivered event isn't due again
+	if err := d.RetryDue(ctx); err != nil {
+		t.Fatalf("RetryDue() again error = %v", err)
+	}
+
+	requests := rcv.received()
+	if len(requests) != 1 {
+		t.Fatalf("received %d requests, want 1", len(requests))
+	}
+	req := requests[0]
+	delivery := findDelivery(t, deliveriesRepo)
+
+	var body Event
+	if err := json.Unmarshal(req.body, &body); err != nil {
+		t.Fatalf("body isn't an event: %v", err)
+	}
+	if body.Id != event.Id || body.Type != event.Type || !reflect.DeepEqual(body.Data, event.Data) || !body.CreatedAt.Equal(event.CreatedAt) {
+		t.Errorf("received %+v, want %+v", body, event)
+	}
+
+	if got := req.header.Get(HeaderEvent); got != string(models.EventTypeFault) {
+		t.Errorf("%s = %q, want %q", HeaderEvent, got, models.EventTypeFault)
+	}
+	if got := req.header.Get(HeaderDelivery); got != delivery.GetId() {
+		t.Errorf("%s = %q, want %q", HeaderDelivery, got, delivery.GetId())
+	}
+	timestamp, err := strconv.ParseInt(req.header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		t.Fatalf("%s isn't unix seconds: %v", HeaderTimestamp, err)
+	}
+	if got, want := req.header.Get(HeaderSignature), Sign(subscription.Secret, time.Unix(timestamp, 0), req.body); got != want {
+		t.Errorf("%s = %q, want %q", HeaderSignature, got, want)
+	}
+
+	if delivery.Status != models.DeliverySucceeded || delivery.AttemptCount != 1 || delivery.NextAttemptAt != nil {
+		t.Errorf("delivery is %s after %d attempts, next at %v, want succeeded after 1", delivery.Status, delivery.AttemptCount, delivery.NextAttemptAt)
+	}
+	if len(delivery.Attempts) != 1 || delivery.Attempts[0].ResponseCode != http.StatusOK || delivery.Attempts[0].Error != "" {
+		t.Errorf("attempts = %+v, want one answered with %d", delivery.Attempts, http.StatusOK)
+	}
+	if delivery.Payload != string(req.body) {
+		t.Errorf("stored payload %s, posted %s", delivery.Payload, req.body)
+	}
+}
+
+func TestRetryDue(t *testing.T) {
+	ctx := context.Background()
+	rcv := newReceiver(t, http.StatusServiceUnavailable)
+	d, _, deliveriesRepo := newTestDispatcher(t, rcv, WithMaxAttempts(3), WithBackoff(time.Minute, time.Hour))
+
+	// makeDue moves the delivery's next attempt into the past, as if the backoff had passed
+	makeDue := func() {
+		t.Helper()
+		err := deliveriesRepo.UpdateMany(ctx, repository.NewQueryFilter(), map[string]interface{}{
+			"$set": map[string]interface{}{models.FieldDeliveryNextAttemptAt: time.Now().UTC().Add(-time.Second)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := d.Publish(ctx, testEvent()); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if err := d.RetryDue(ctx); err != nil {
+		t.Fatalf("RetryDue() error = %v", err)
+	}
+
+	tests := []struct {
+		attempts int
+		backoff  time.Duration
+	}{
+		{attempts: 1, backoff: time.Minute},
+		{attempts: 2, backoff: 2 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		delivery := findDelivery(t, deliveriesRepo)
+		if delivery.Status != models.DeliveryPending || delivery.AttemptCount != tt.attempts {
+			t.Fatalf("delivery is %s after %d attempts, want pending after %d", delivery.Status, delivery.AttemptCount, tt.attempts)
+		}
+		last := delivery.Attempts[len(delivery.Attempts)-1]
+		if last.ResponseCode != http.StatusServiceUnavailable || last.Error == "" {
+			t.Errorf("attempt %d = %+v, want a failed %d", tt.attempts, last, http.StatusServiceUnavailable)
+		}
+		if want := last.AttemptedAt.Add(tt.backoff); delivery.NextAttemptAt == nil || delivery.NextAttemptAt.Sub(want).Abs() > 5*time.Second {
+			t.Errorf("after %d attempts next attempt at %v, want about %v", tt.attempts, delivery.NextAttemptAt, want)
+		}
+
+		// nothing is sent before the backoff has passed
+		if err := d.RetryDue(ctx); err != nil {
+			t.Fatalf("RetryDue() error = %v", err)
+		}
+		if got := len(rcv.received()); got != tt.attempts {
+			t.Fatalf("received %d requests before the retry was due, want %d", got, tt.attempts)
+		}
+
+		makeDue()
+		if err := d.RetryDue(ctx); err != nil {
+			t.Fatalf("RetryDue() error = %v", err)
+		}
+		if got := len(rcv.received()); got != tt.attempts+1 {
+			t.Fatalf("received %d requests, want %d", got, tt.attempts+1)
+		}
+	}
+
+	// the third attempt was the last
+	delivery := findDelivery(t, deliveriesRepo)
+	if delivery.Status != models.DeliveryFailed || delivery.AttemptCount != 3 || delivery.NextAttemptAt != nil || len(delivery.Attempts) != 3 {
+		t.Errorf("delivery is %s after %d attempts, next at %v, want failed after 3", delivery.Status, delivery.AttemptCount, delivery.NextAttemptAt)
+	}
+
+	makeDue()
+	if err := d.RetryDue(ctx); err != nil {
+		t.Fatalf("RetryDue() error = %v", err)
+	}
+	if got := len(rcv.received()); got != 3 {
+		t.Errorf("received %d requests after giving up, want 3", got)
+	}
+}
+
+func TestRetryDueSucceedsOnceReceiverRecovers(t *testing.T) {
+	ctx := context.Background()
+	rcv := newReceiver(t, http.StatusInternalServerError)
+	d, _, deliveriesRepo := newTestDispatcher(t, rcv, WithBackoff(0, 0))
+
+	if err := d.Publish(ctx, testEvent()); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if err := d.RetryDue(ctx); err != nil {
+		t.Fatalf("RetryDue() error = %v", err)
+	}
+
+	rcv.respondWith(http.StatusNoContent)
+	if err := d.RetryDue(ctx); err != nil {
+		t.Fatalf("RetryDue() error = %v", err)
+	}
+
+	delivery := findDelivery(t, deliveriesRepo)
+	if delivery.Status != models.DeliverySucceeded || delivery.AttemptCount != 2 || delivery.NextAttemptAt != nil {
+		t.Errorf("delivery is %s after %d attempts, next at %v, want succeeded after 2", delivery.Status, delivery.AttemptCount, delivery.NextAttemptAt)
+	}
+	codes := []int{delivery.Attempts[0].ResponseCode, delivery.Attempts[1].ResponseCode}
+	if want := []int{http.StatusInternalServerError, http.StatusNoContent}; !slices.Equal(codes, want) {
+		t.Errorf("response codes = %v, want %v", codes, want)
+	}
+}
+
+func TestClaimSendsOnce(t *testing.T) {
+	ctx := context.Background()
+	rcv := newReceiver(t, http.StatusInternalServerError)
+	d, _, deliveriesRepo := newTestDispatcher(t, rcv, WithBackoff(0, 0))
+
+	if err := d.Publish(ctx, testEvent()); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	delivery := findDelivery(t, deliveriesRepo)
+
+	// two listeners found the delivery due, only the first to claim it sends it
+	claimed, err := d.claim(ctx, delivery)
+	if err != nil || !claimed {
+		t.Fatalf("first claim = %v, %v, want true", claimed, err)
+	}
+	claimed, err = d.claim(ctx, delivery)
+	if err != nil || claimed {
+		t.Fatalf("second claim = %v, %v, want false", claimed, err)
+	}
+
+	// a claimed delivery isn't due until the claim times out
+	if err = d.RetryDue(ctx); err != nil {
+		t.Fatalf("RetryDue() error = %v", err)
+	}
+	if got := len(rcv.received()); got != 0 {
+		t.Errorf("received %d requests while the delivery was claimed, want none", got)
+	}
+}
+
+func TestRetryDueGivesUpOnDeletedSubscription(t *testing.T) {
+	ctx := context.Background()
+	rcv := newReceiver(t, http.StatusInternalServerError)
+	d, subscription, deliveriesRepo := newTestDispatcher(t, rcv, WithBackoff(0, 0))
+
+	if err := d.Publish(ctx, testEvent()); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if err := d.subscriptionsRepo.DeleteMany(ctx, repository.NewQueryFilter().AddFilter(models.FieldId, subscription.ID)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.RetryDue(ctx); err != nil {
+		t.Fatalf("RetryDue() error = %v", err)
+	}
+
+	delivery := findDelivery(t, deliveriesRepo)
+	if delivery.Status != models.DeliveryFailed || delivery.NextAttemptAt != nil {
+		t.Errorf("delivery is %s, next at %v, want failed", delivery.Status, delivery.NextAttemptAt)
+	}
+	if got := len(rcv.received()); got != 0 {
+		t.Errorf("received %d requests, want none", got)
+	}
+}